@@ -3,9 +3,15 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/config"
 	limit "github.com/aviddiviner/gin-limit"
@@ -15,6 +21,14 @@ import (
 
 	"github.com/RTradeLtd/Temporal/api/middleware"
 	"github.com/RTradeLtd/Temporal/database"
+	"github.com/RTradeLtd/Temporal/health"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/mini"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/payments"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/ratelimit"
+	"github.com/RTradeLtd/Temporal/rtfs"
 	jwt "github.com/appleboy/gin-jwt"
 	helmet "github.com/danielkov/gin-helmet"
 	"github.com/jinzhu/gorm"
@@ -28,6 +42,9 @@ var xssMdlwr xss.XssMw
 // AdminAddress is the eth address of the admin account
 var AdminAddress = "0x7E4A2359c745A982a54653128085eAC69E446DE1"
 
+// ShutdownTimeout is how long in-flight requests are given to complete when shutting down
+var ShutdownTimeout = time.Second * 30
+
 // API is our API service
 type API struct {
 	Router  *gin.Engine
@@ -35,14 +52,49 @@ type API struct {
 	DBM     *database.DatabaseManager
 	Logger  *log.Logger
 	Service string
+	// ChannelManager is only set when a payment channel contract is configured
+	ChannelManager *payments.ChannelManager
+	// ChannelRequestCost is the minimum wei a voucher must add per request
+	ChannelRequestCost *big.Int
+	// Queues is used to publish to rabbitmq
+	Queues *queue.Publisher
+	// Outbox publishes messages written to the outbox table
+	Outbox *queue.OutboxRelay
+	// IPFS is the manager for our public ipfs node
+	IPFS *rtfs.IpfsManager
+	// Minio is the manager for our object storage
+	Minio *mini.MinioManager
+	// Health checks the dependencies reported by our readiness probe
+	Health *health.Checker
+	// RateLimits holds the token buckets of our rate limits, and is nil when they're disabled
+	RateLimits ratelimit.Store
+	// Audit records the actions taken through the api
+	Audit *models.AuditManager
+
+	clients *clients
+	shared  Shared
 }
 
 // Initialize is used ot initialize our API service
 func Initialize(cfg *config.TemporalConfig, logMode bool) (*API, error) {
+	return InitializeShared(cfg, logMode, Shared{})
+}
+
+// Shared holds clients owned by the caller, such as when queue consumers run in the same process.
+// The api uses them rather than connecting its own, and leaves them open when it is closed
+type Shared struct {
+	// Broker is published to rather than connecting to rabbitmq
+	Broker queue.Broker
+	// DB is used rather than opening a database connection
+	DB *database.DatabaseManager
+}
+
+// InitializeShared is used to initialize our API service with the clients in shared
+func InitializeShared(cfg *config.TemporalConfig, logMode bool, shared Shared) (*API, error) {
 	// initialize an empty api struct
-	api := API{Service: "api"}
+	api := API{Service: "api", shared: shared}
 	// setup logging
-	err := api.setupLogging()
+	err := api.setupLogging(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -52,16 +104,47 @@ func Initialize(cfg *config.TemporalConfig, logMode bool) (*API, error) {
 	listenAddress := cfg.API.Connection.ListenAddress
 	prometheusListenAddress := fmt.Sprintf("%s:6768", listenAddress)
 	jwtKey := cfg.API.JwtKey
+	if cfg.API.AuditKey == "" {
+		return nil, errors.New("api.audit_key is required to record our audit log")
+	}
+	if cfg.Accounts.SignInDomain == "" {
+		return nil, errors.New("accounts.sign_in_domain is required to sign users in with ethereum")
+	}
 	// setup our database connection
-	db, err := database.Initialize(cfg, false)
-	if err != nil {
-		return nil, err
+	db := shared.DB
+	if db == nil {
+		if db, err = database.Initialize(cfg, false); err != nil {
+			return nil, err
+		}
 	}
 	api.DBM = db
+	api.Audit = models.NewAuditManager(db.DB, []byte(cfg.API.AuditKey))
 	// set log mode to true, useful for debugging database issues
 	api.DBM.DB.LogMode(logMode)
-	// generate our default router
-	router := gin.Default()
+	// setup the clients our handlers share, rather than connecting on every request
+	if err = api.setupClients(cfg); err != nil {
+		return nil, err
+	}
+	// setup payment channels if we have a contract to accept them with
+	if cfg.Ethereum.Contracts.PaymentChannelContractAddress != "" {
+		if err = api.setupPaymentChannels(cfg); err != nil {
+			return nil, err
+		}
+	}
+	// generate our router, logging requests with their ids rather than using gin's logger
+	router := gin.New()
+	// we serve clients directly, so forwarding headers are set by the client and can't be trusted.
+	// Rate limits and the audit log use the address the request came from instead
+	router.ForwardedByClientIP = false
+	router.Use(gin.Recovery())
+	// the liveness probe is registered ahead of our other middleware, so it isn't logged, traced
+	// or rate limited. Readiness reports our dependencies' errors, so it's only served to operators
+	// on the metrics listener
+	api.setupHealth(cfg)
+	router.GET("/healthz", gin.WrapH(health.LiveHandler()))
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
+	router.Use(middleware.RequestLoggerMiddleware(api.Logger))
 	// load our global middlewares
 	p := ginprometheus.NewPrometheus("gin")
 	// set the address for prometheus to collect metrics
@@ -86,25 +169,97 @@ func Initialize(cfg *config.TemporalConfig, logMode bool) (*API, error) {
 	return &api, nil
 }
 
+// ListenAndServe is used to serve our api over tls until SIGINT or SIGTERM is received,
+// and then shut down as Serve does
+func (api *API) ListenAndServe(addr, certFile, keyFile string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			api.Logger.WithFields(log.Fields{
+				"service": api.Service,
+				"signal":  sig.String(),
+			}).Info("received signal")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return api.Serve(ctx, addr, certFile, keyFile)
+}
+
+// Serve is used to serve our api until ctx is done, over tls unless certFile is empty.
+// We then stop accepting connections, give in-flight requests ShutdownTimeout to complete,
+// and close our clients
+func (api *API) Serve(ctx context.Context, addr, certFile, keyFile string) error {
+	server := &http.Server{Addr: addr, Handler: api.Router}
+	errCh := make(chan error, 1)
+	go func() {
+		if certFile == "" {
+			errCh <- server.ListenAndServe()
+			return
+		}
+		errCh <- server.ListenAndServeTLS(certFile, keyFile)
+	}()
+	select {
+	case err := <-errCh:
+		api.Close()
+		return err
+	case <-ctx.Done():
+		api.Logger.WithFields(log.Fields{
+			"service": api.Service,
+		}).Info("shutting down, draining in-flight requests")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		api.Close()
+		return err
+	}
+	api.Logger.Info("API service stopped")
+	return api.Close()
+}
+
 // SetupLogging is used to setup our API logging system
-func (api *API) setupLogging() error {
-	logFile, err := os.OpenFile("/var/log/temporal/api_service.log", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0640)
+func (api *API) setupLogging(cfg *config.TemporalConfig) error {
+	logger, err := logging.New(logging.FromConfig(cfg), api.Service)
 	if err != nil {
 		return err
 	}
-	logger := log.New()
-	logger.Out = logFile
 	api.Logger = logger
 	api.Logger.Info("Logging initialized")
 	return nil
 }
 
+// setupPaymentChannels is used to connect to our payment channel contract
+func (api *API) setupPaymentChannels(cfg *config.TemporalConfig) error {
+	cost, ok := new(big.Int).SetString(cfg.API.ChannelRequestCostInWei, 10)
+	if !ok {
+		return errors.New("invalid channel_request_cost_in_wei")
+	}
+	cm, err := payments.GenerateChannelManager(api.DBM.DB, cfg, false)
+	if err != nil {
+		return err
+	}
+	api.ChannelManager = cm
+	api.ChannelRequestCost = cost
+	api.Logger.Info("Payment channels initialized")
+	return nil
+}
+
 // setupRoutes is used to setup all of our api routes
 func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *gorm.DB, cfg *config.TemporalConfig) {
+	// requests are audited once the user is authenticated, so anonymous requests aren't recorded
+	audit := middleware.AuditMiddleware(api.Audit, AdminAddress, api.Logger)
 
 	statsProtected := g.Group("/api/v1/statistics")
 	statsProtected.Use(authWare.MiddlewareFunc())
+	statsProtected.Use(audit)
 	statsProtected.Use(middleware.APIRestrictionMiddleware(db))
+	statsProtected.Use(api.rateLimit("statistics"))
 	statsProtected.Use(stats.RequestStats())
 	statsProtected.GET("/stats", func(c *gin.Context) { // admin locked
 		ethAddress := GetAuthenticatedUserFromContext(c)
@@ -118,21 +273,46 @@ func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *g
 	})
 
 	auth := g.Group("/api/v1/auth")
+	auth.Use(api.authRateLimit())
 	auth.POST("/register", api.registerUserAccount)
-	auth.POST("/login", authWare.LoginHandler)
+	auth.POST("/login", audit, authWare.LoginHandler)
+	auth.POST("/ethereum/challenge", api.ethereumChallenge)
+	auth.POST("/ethereum/login", audit, api.ethereumLogin(authWare))
+	auth.POST("/verify-email", api.verifyEmail)
+	// unverified users don't have api access yet, so only their token is checked
+	auth.POST("/verify-email/resend", authWare.MiddlewareFunc(), api.resendEmailVerification)
+	auth.POST("/password/forgot", api.forgotPassword)
+	auth.POST("/password/reset", api.resetPassword)
 
 	// PROTECTED ROUTES -- BEGIN
 	accountProtected := g.Group("/api/v1/account")
 	accountProtected.Use(authWare.MiddlewareFunc())
+	accountProtected.Use(audit)
 	accountProtected.Use(middleware.APIRestrictionMiddleware(db))
-	accountProtected.POST("password/change", api.changeAccountPassword)
+	accountProtected.Use(api.rateLimit("account"))
+	accountProtected.POST("password/change", middleware.TwoFactorMiddleware(db), api.changeAccountPassword)
 	accountProtected.GET("/key/ipfs/get", api.getIPFSKeyNamesForAuthUser)
-	accountProtected.POST("/key/ipfs/new", api.createIPFSKey)
-	accountProtected.POST("/ethereum/address/change", api.changeEthereumAddress)
+	accountProtected.POST("/key/ipfs/new", middleware.TwoFactorMiddleware(db), api.createIPFSKey)
+	accountProtected.GET("/key/ipfs", api.listIPFSKeys)
+	accountProtected.POST("/key/ipfs/export", middleware.TwoFactorMiddleware(db), api.exportIPFSKey)
+	accountProtected.POST("/key/ipfs/import", middleware.TwoFactorMiddleware(db), api.importIPFSKey)
+	accountProtected.POST("/key/ipfs/rename", middleware.TwoFactorMiddleware(db), api.renameIPFSKey)
+	accountProtected.DELETE("/key/ipfs/:name", middleware.TwoFactorMiddleware(db), api.deleteIPFSKey)
+	accountProtected.POST("/ethereum/address/change", middleware.TwoFactorMiddleware(db), api.changeEthereumAddress)
+	accountProtected.GET("/email/preferences", api.getEmailPreferences)
+	accountProtected.POST("/email/preferences", api.updateEmailPreferences)
+	accountProtected.POST("/delete", middleware.TwoFactorMiddleware(db), api.deleteAccount)
+	accountProtected.POST("/2fa/enroll", api.enrollTwoFactor)
+	accountProtected.POST("/2fa/enable", api.enableTwoFactor)
+	accountProtected.POST("/2fa/disable", middleware.TwoFactorMiddleware(db), api.disableTwoFactor)
+	accountProtected.POST("/2fa/recovery-codes", middleware.TwoFactorMiddleware(db), api.regenerateRecoveryCodes)
 
 	ipfsProtected := g.Group("/api/v1/ipfs")
 	ipfsProtected.Use(authWare.MiddlewareFunc())
+	ipfsProtected.Use(audit)
 	ipfsProtected.Use(middleware.APIRestrictionMiddleware(db))
+	ipfsProtected.Use(api.rateLimit("ipfs"))
+	api.usePaymentChannels(ipfsProtected, "ipfs")
 	ipfsProtected.POST("/pubsub/publish/:topic", api.ipfsPubSubPublish)
 	ipfsProtected.POST("/calculate-content-hash", api.calculateContentHashForFile)
 	ipfsProtected.GET("/pins", api.getLocalPins) // admin locked
@@ -147,7 +327,10 @@ func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *g
 
 	ipfsPrivateProtected := g.Group("/api/v1/ipfs-private")
 	ipfsPrivateProtected.Use(authWare.MiddlewareFunc())
+	ipfsPrivateProtected.Use(audit)
 	ipfsPrivateProtected.Use(middleware.APIRestrictionMiddleware(db))
+	ipfsPrivateProtected.Use(api.rateLimit("ipfs-private"))
+	api.usePaymentChannels(ipfsPrivateProtected, "ipfs-private")
 	ipfsPrivateProtected.POST("/new/network", api.createHostedIPFSNetworkEntryInDatabase)                // admin locked
 	ipfsPrivateProtected.GET("/network/:name", api.getIPFSPrivateNetworkByName)                          // admin locked
 	ipfsPrivateProtected.POST("/ipfs/check-for-pin/:hash", api.checkLocalNodeForPinForHostedIPFSNetwork) // admin locked
@@ -162,16 +345,23 @@ func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *g
 	ipfsPrivateProtected.POST("/ipfs/add-file/advanced", api.addFileToHostedIPFSNetworkAdvanced)
 	ipfsPrivateProtected.POST("/ipns/publish/details", api.publishDetailedIPNSToHostedIPFSNetwork)
 	ipfsPrivateProtected.DELETE("/ipfs/pin/remove/:hash", api.removePinFromLocalHostForHostedIPFSNetwork)
+	ipfsPrivateProtected.DELETE("/network/:name", middleware.TwoFactorMiddleware(db), api.deleteHostedIPFSNetwork) // admin locked
 
 	ipnsProtected := g.Group("/api/v1/ipns")
 	ipnsProtected.Use(authWare.MiddlewareFunc())
+	ipnsProtected.Use(audit)
 	ipnsProtected.Use(middleware.APIRestrictionMiddleware(db))
+	ipnsProtected.Use(api.rateLimit("ipns"))
+	api.usePaymentChannels(ipnsProtected, "ipns")
 	ipnsProtected.POST("/publish/details", api.publishToIPNSDetails)
 	ipnsProtected.POST("/dnslink/aws/add", api.generateDNSLinkEntry) // admin locked
 
 	clusterProtected := g.Group("/api/v1/ipfs-cluster")
 	clusterProtected.Use(authWare.MiddlewareFunc())
+	clusterProtected.Use(audit)
 	clusterProtected.Use(middleware.APIRestrictionMiddleware(db))
+	clusterProtected.Use(api.rateLimit("ipfs-cluster"))
+	api.usePaymentChannels(clusterProtected, "ipfs-cluster")
 	clusterProtected.POST("/sync-errors-local", api.syncClusterErrorsLocally)          // admin locked
 	clusterProtected.GET("/status-local-pin/:hash", api.getLocalStatusForClusterPin)   // admin locked
 	clusterProtected.GET("/status-global-pin/:hash", api.getGlobalStatusForClusterPin) // admin locked
@@ -181,12 +371,18 @@ func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *g
 
 	databaseProtected := g.Group("/api/v1/database")
 	databaseProtected.Use(authWare.MiddlewareFunc())
+	databaseProtected.Use(audit)
 	databaseProtected.Use(middleware.APIRestrictionMiddleware(db))
+	databaseProtected.Use(api.rateLimit("database"))
 	databaseProtected.GET("/uploads", api.getUploadsFromDatabase)     // admin locked
 	databaseProtected.GET("/uploads/:user", api.getUploadsForAddress) // partial admin locked
+	databaseProtected.GET("/payments", api.getPaymentsForAuthUser)
+	databaseProtected.GET("/ipns", api.getIPNSRecordsForAuthUser)
 
 	frontendProtected := g.Group("/api/v1/frontend/")
 	frontendProtected.Use(authWare.MiddlewareFunc())
+	frontendProtected.Use(audit)
+	frontendProtected.Use(api.rateLimit("frontend"))
 	frontendProtected.POST("/utils/ipfs/hash/calculate", api.calculateIPFSFileHash)
 	frontendProtected.GET("/cost/calculate/:hash/:holdtime", api.calculatePinCost)
 	frontendProtected.POST("/cost/calculate/file", api.calculateFileCost)
@@ -196,13 +392,54 @@ func (api *API) setupRoutes(g *gin.Engine, authWare *jwt.GinJWTMiddleware, db *g
 	frontendProtected.POST("/payment/pin/submit/:hash", api.submitPaymentToContract)
 	frontendProtected.POST("/payment/file/create", api.createFilePayment)
 
+	webhooksProtected := g.Group("/api/v1/webhooks")
+	webhooksProtected.Use(authWare.MiddlewareFunc())
+	webhooksProtected.Use(audit)
+	webhooksProtected.Use(middleware.APIRestrictionMiddleware(db))
+	webhooksProtected.Use(api.rateLimit("webhooks"))
+	webhooksProtected.POST("/create", api.createWebhook)
+	webhooksProtected.GET("", api.getWebhooks)
+	webhooksProtected.DELETE("/:id", api.deleteWebhook)
+	webhooksProtected.GET("/deliveries", api.getWebhookDeliveries)
+
+	if api.ChannelManager != nil {
+		channelsProtected := g.Group("/api/v1/payments/channels")
+		channelsProtected.Use(authWare.MiddlewareFunc())
+		channelsProtected.Use(audit)
+		channelsProtected.Use(middleware.APIRestrictionMiddleware(db))
+		channelsProtected.Use(api.rateLimit("payments"))
+		channelsProtected.POST("/open", api.openPaymentChannel)
+		channelsProtected.GET("", api.getPaymentChannels)
+	}
+
 	adminProtected := g.Group("/api/v1/admin")
 	adminProtected.Use(authWare.MiddlewareFunc())
+	adminProtected.Use(audit)
 	adminProtected.Use(middleware.APIRestrictionMiddleware(db))
+	adminProtected.Use(api.rateLimit("admin"))
 	adminProtected.POST("/utils/file-size-check", CalculateFileSize)
+	adminProtected.POST("/users/:user/account", api.setAccountEnabled)
+	adminProtected.POST("/users/:user/api-access", api.setAPIAccess)
+	adminProtected.GET("/audit", api.getAuditEvents)
+	adminProtected.GET("/audit/verify", api.verifyAuditChain)
 	mini := adminProtected.Group("/mini")
 	mini.POST("/create/bucket", api.makeBucket)
 	// PROTECTED ROUTES -- END
 
 	api.Logger.Info("Routes initialized")
 }
+
+// usePaymentChannels is used to accept payment channel vouchers on a route group, named by its
+// path under /api/v1. Vouchers are required on the groups our configuration lists
+func (api *API) usePaymentChannels(g *gin.RouterGroup, group string) {
+	if api.ChannelManager == nil {
+		return
+	}
+	required := false
+	for _, name := range api.TConfig.API.ChannelRequiredGroups {
+		if name == group {
+			required = true
+		}
+	}
+	g.Use(middleware.PaymentChannelMiddleware(api.ChannelManager, api.ChannelRequestCost, required, AdminAddress))
+}
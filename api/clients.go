@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/health"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/mini"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/ratelimit"
+	"github.com/RTradeLtd/Temporal/rtfs"
+	log "github.com/sirupsen/logrus"
+)
+
+// publisherPoolSize is the number of idle rabbitmq channels kept open for publishing
+var publisherPoolSize = 20
+
+// clients holds the ipfs managers for private networks, which are created on first use
+// since networks can be added while the api is running
+type clients struct {
+	mux     sync.Mutex
+	private map[string]*rtfs.IpfsManager
+	// keystore is our node's keystore, which is only opened once keys are exported
+	keystore  *rtfs.KeystoreManager
+	stopRelay context.CancelFunc
+}
+
+// setupClients is used to create the long lived rabbitmq, ipfs and minio clients shared by our handlers
+func (api *API) setupClients(cfg *config.TemporalConfig) error {
+	var (
+		publisher *queue.Publisher
+		err       error
+	)
+	if api.shared.Broker != nil {
+		publisher = queue.NewBrokerPublisher(api.shared.Broker)
+	} else if publisher, err = queue.NewPublisher(cfg.RabbitMQ.URL, publisherPoolSize); err != nil {
+		return err
+	}
+	api.Queues = publisher
+	if api.IPFS, err = rtfs.Initialize("", ""); err != nil {
+		return err
+	}
+	api.Minio, err = mini.NewMinioManager(
+		fmt.Sprintf("%s:%s", cfg.MINIO.Connection.IP, cfg.MINIO.Connection.Port),
+		cfg.MINIO.AccessKey,
+		cfg.MINIO.SecretKey,
+		false)
+	if err != nil {
+		return err
+	}
+	if cfg.RateLimits.Store != "" {
+		if api.RateLimits, err = ratelimit.New(cfg.RateLimits.Store, cfg.RateLimits.RedisURL); err != nil {
+			return err
+		}
+	}
+	api.Outbox = queue.NewOutboxRelay(api.DBM.DB, publisher)
+	api.Outbox.OnError = func(msg models.OutboxMessage, err error) {
+		api.Logger.WithFields(log.Fields{
+			"service":              api.Service,
+			logging.RequestIDField: msg.RequestID,
+			"error":                err.Error(),
+		}).Error(OutboxPublishError)
+	}
+	api.Outbox.OnRelayError = func(err error) {
+		api.Logger.WithFields(log.Fields{
+			"service": api.Service,
+			"error":   err.Error(),
+		}).Error(OutboxRelayError)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go api.Outbox.Run(ctx)
+	api.clients = &clients{
+		private:   make(map[string]*rtfs.IpfsManager),
+		stopRelay: cancel,
+	}
+	api.Logger.Info("Clients initialized")
+	return nil
+}
+
+// setupHealth is used to check the dependencies our handlers use. Private networks are
+// created by users, so only our own ipfs node is checked
+func (api *API) setupHealth(cfg *config.TemporalConfig) {
+	api.Health = health.NewCheckerFromConfig(cfg)
+	api.Health.Add(health.Postgres, health.Database(api.DBM.DB))
+	api.Health.Add(health.RabbitMQ, health.Broker(api.Queues.Broker))
+	dependencies := []string{health.IPFS, health.Cluster, health.Minio}
+	if cfg.Ethereum.Connection.INFURA.URL != "" {
+		dependencies = append(dependencies, health.Ethereum)
+	}
+	api.Health.AddDependencies(cfg, dependencies...)
+	// requests are let through when redis is down, but replicas stop sharing their limits
+	if cfg.RateLimits.Store == "redis" {
+		api.Health.Add(health.Redis, health.Broker(api.RateLimits))
+	}
+}
+
+// privateIPFS is used to get the ipfs manager for a private network's api url
+func (api *API) privateIPFS(apiURL string) (*rtfs.IpfsManager, error) {
+	api.clients.mux.Lock()
+	defer api.clients.mux.Unlock()
+	if manager, ok := api.clients.private[apiURL]; ok {
+		return manager, nil
+	}
+	manager, err := rtfs.Initialize("", apiURL)
+	if err != nil {
+		return nil, err
+	}
+	api.clients.private[apiURL] = manager
+	return manager, nil
+}
+
+// keystore is used to read keys from our node's keystore, which the key queues keep in step with
+// every other node. Keys are only changed through those queues, never through this keystore
+func (api *API) keystore() (*rtfs.KeystoreManager, error) {
+	api.clients.mux.Lock()
+	defer api.clients.mux.Unlock()
+	if api.clients.keystore != nil {
+		return api.clients.keystore, nil
+	}
+	km, err := rtfs.GenerateKeystoreManager()
+	if err != nil {
+		return nil, err
+	}
+	api.clients.keystore = km
+	return km, nil
+}
+
+// Close is used to release our long lived clients once the api has stopped serving requests
+func (api *API) Close() error {
+	if api.clients != nil {
+		api.clients.stopRelay()
+	}
+	if api.RateLimits != nil {
+		api.RateLimits.Close()
+	}
+	if api.Queues != nil && api.shared.Broker == nil {
+		if err := api.Queues.Close(); err != nil {
+			return err
+		}
+	}
+	if api.shared.DB != nil {
+		return nil
+	}
+	return api.DBM.DB.Close()
+}
@@ -23,6 +23,12 @@ const (
 	QueueInitializationError = "failed to initialize queue"
 	// QueuePublishError is a message used when failing to publish to queue
 	QueuePublishError = "failed to publish message to queue"
+	// OutboxPublishError is a message used when the outbox relay fails to publish a message
+	OutboxPublishError = "failed to publish outbox message"
+	// OutboxRelayError is a message used when the outbox relay fails to claim or commit a batch of messages
+	OutboxRelayError = "failed to relay outbox messages"
+	// OutboxEnqueueError is a message used when failing to write a message to the outbox
+	OutboxEnqueueError = "failed to store message in outbox"
 	// KeySearchError is an error used when failing to search for a key
 	KeySearchError = "failed to search for key"
 	// KeyUseError is an error used when attempting to use a key the user down ot own
@@ -75,4 +81,42 @@ const (
 	NoKeyError = "no keys"
 	// FileTooBigError is an error message given to a user when attempting to upload a file larger than our limit
 	FileTooBigError = "attempting to upload too big of a file"
+	// UserSearchError is an error used when searching for a user fails
+	UserSearchError = "failed to search for user"
+	// EmailPreferenceChangeError is an error used when changing email preferences fails
+	EmailPreferenceChangeError = "failed to change email preferences"
+	// PaymentChannelOpenError is an error used when registering a payment channel fails
+	PaymentChannelOpenError = "failed to open payment channel"
+	// PaymentChannelSearchError is an error used when searching for payment channels
+	PaymentChannelSearchError = "failed to search for payment channels"
+	// WebhookCreationError is an error used when registering a webhook fails
+	WebhookCreationError = "failed to create webhook"
+	// WebhookSearchError is an error used when searching for webhooks or their deliveries
+	WebhookSearchError = "failed to search for webhooks"
+	// WebhookDeletionError is an error used when deleting a webhook fails
+	WebhookDeletionError = "failed to delete webhook"
+	// IPNSSearchError is an error used when searching for ipns records fails
+	IPNSSearchError = "failed to search for ipns records"
+	// EmailVerificationError is an error used when sending or redeeming an email verification fails
+	EmailVerificationError = "failed to verify email address"
+	// SignInChallengeError is an error used when issuing or redeeming an ethereum sign in challenge fails
+	SignInChallengeError = "failed to sign in with ethereum address"
+	// TwoFactorError is an error used when enrolling in, enabling or disabling two factor authentication fails
+	TwoFactorError = "failed to update two factor authentication"
+	// NetworkDeletionError is an error used when deleting a private network fails
+	NetworkDeletionError = "failed to delete private network"
+	// PasswordResetError is an error used when sending or redeeming a password reset fails
+	PasswordResetError = "failed to reset password"
+	// AccountUpdateError is an error used when enabling or disabling an account, or its api access, fails
+	AccountUpdateError = "failed to update account"
+	// AccountDeletionError is an error used when deleting an account fails
+	AccountDeletionError = "failed to delete account"
+	// KeyExportError is an error used when exporting a key fails
+	KeyExportError = "failed to export key"
+	// KeyImportError is an error used when importing a key fails
+	KeyImportError = "failed to import key"
+	// KeyUpdateError is an error used when renaming or deleting a key fails
+	KeyUpdateError = "failed to update key"
+	// AuditSearchError is an error used when searching for, or verifying, audit events fails
+	AuditSearchError = "failed to search for audit events"
 )
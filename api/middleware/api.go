@@ -15,22 +15,30 @@ import (
 api middleware is used to secure access to the api
 */
 
-var user models.User
 var nilTime time.Time
 
+// AccountTierKey is the context key holding the authenticated user's account tier
+const AccountTierKey = "account_tier"
+
 func APIRestrictionMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		claims := jwt.ExtractClaims(c)
 		username := claims["id"]
+		user := models.User{}
 		db.Where("user_name = ?", username).First(&user)
 		if user.CreatedAt == nilTime {
 			c.AbortWithError(http.StatusBadRequest, errors.New("invalid user account"))
 			return
 		}
+		if !user.AccountEnabled {
+			c.AbortWithError(http.StatusForbidden, errors.New("account is disabled"))
+			return
+		}
 		if !user.APIAccess {
 			c.AbortWithError(http.StatusForbidden, errors.New("unauthorized api access"))
 			return
 		}
+		c.Set(AccountTierKey, user.Tier())
 		c.Next()
 	}
 }
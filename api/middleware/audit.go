@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/models"
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditActorKey is the context key holding the user acting on a request before they're
+// authenticated, such as the user name given when logging in
+const AuditActorKey = "audit_actor"
+
+// AuditTargetKey and AuditNetworkKey are the context keys handlers use to name what a request
+// acts on, and the private network it acts in, for the audit log
+const (
+	AuditTargetKey  = "audit_target"
+	AuditNetworkKey = "audit_network"
+)
+
+// AuditMiddleware is used to record requests which change something, and every request made by
+// the admin, in our audit log once they have been handled. It is used on routes which authenticate
+// the user or log them in, so anonymous requests aren't recorded, and neither are requests refused
+// by our rate limits. Failing to record an event is logged, since the response has already been sent
+func AuditMiddleware(am *models.AuditManager, adminAddress string, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		actor, _ := jwt.ExtractClaims(c)["id"].(string)
+		if actor == "" {
+			actor = c.GetString(AuditActorKey)
+		}
+		mutating := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead && c.Request.Method != http.MethodOptions
+		if actor == "" || (!mutating && actor != adminAddress) || c.Writer.Status() == http.StatusTooManyRequests {
+			return
+		}
+		event := &models.AuditEvent{
+			Actor:       actor,
+			Action:      auditAction(c.HandlerName()),
+			Target:      auditTarget(c),
+			NetworkName: c.GetString(AuditNetworkKey),
+			IP:          c.ClientIP(),
+			RequestID:   logging.RequestID(c.Request.Context()),
+			Outcome:     auditOutcome(c.Writer.Status()),
+			Status:      c.Writer.Status(),
+		}
+		if err := am.Record(event); err != nil {
+			logging.Entry(c.Request.Context(), logger).WithFields(log.Fields{
+				"service": "api",
+				"action":  event.Action,
+				"error":   err.Error(),
+			}).Error("failed to record audit event")
+		}
+	}
+}
+
+// auditAction is used to name an action after the handler which performed it, such
+// as removePinFromLocalHost for github.com/RTradeLtd/Temporal/api.(*API).removePinFromLocalHost-fm
+func auditAction(handler string) string {
+	parts := strings.Split(strings.TrimSuffix(handler, "-fm"), ".")
+	// handlers returned by functions, such as ethereumLogin, are named after that function
+	for i := len(parts) - 1; i > 0; i-- {
+		if !strings.HasPrefix(parts[i], "func") {
+			return parts[i]
+		}
+	}
+	return handler
+}
+
+// auditTarget is used to describe what a request acted on, as named by its handler or else by its route parameters
+func auditTarget(c *gin.Context) string {
+	if target := c.GetString(AuditTargetKey); target != "" {
+		return target
+	}
+	if len(c.Params) > 0 {
+		targets := make([]string, len(c.Params))
+		for i, p := range c.Params {
+			targets[i] = p.Key + "=" + p.Value
+		}
+		return strings.Join(targets, " ")
+	}
+	return ""
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return models.AuditDenied
+	case status >= http.StatusBadRequest:
+		return models.AuditFailure
+	default:
+		return models.AuditSuccess
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/payments"
+	"github.com/RTradeLtd/Temporal/signer"
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+channel middleware is used to accept payment channel vouchers presented with api requests
+*/
+
+const (
+	// ChannelIDHeader is the header holding the id of the payment channel being paid from
+	ChannelIDHeader = "X-Payment-Channel"
+	// ChannelAmountHeader is the header holding the cumulative voucher amount in wei
+	ChannelAmountHeader = "X-Payment-Amount"
+	// ChannelSignatureHeader is the header holding the hex encoded voucher signature
+	ChannelSignatureHeader = "X-Payment-Signature"
+)
+
+// ErrVoucherRequired is returned for requests to routes which must be paid for, made without a voucher
+var ErrVoucherRequired = errors.New("requests to this route must be paid for with a payment channel voucher")
+
+// PaymentChannelMiddleware is used to validate a payment channel voucher sent with a request.
+// When required, requests without a voucher are refused, except those made by the admin.
+// Otherwise they are passed through untouched
+func PaymentChannelMiddleware(cm *payments.ChannelManager, cost *big.Int, required bool, adminAddress string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		username, _ := claims["id"].(string)
+		if c.GetHeader(ChannelIDHeader) == "" {
+			if required && username != adminAddress {
+				c.AbortWithError(http.StatusPaymentRequired, ErrVoucherRequired)
+				return
+			}
+			c.Next()
+			return
+		}
+		v, err := parseVoucher(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if err = cm.AcceptVoucher(username, v, cost); err != nil {
+			c.AbortWithError(http.StatusPaymentRequired, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+func parseVoucher(c *gin.Context) (*signer.Voucher, error) {
+	channelID, err := payments.ParseChannelID(c.GetHeader(ChannelIDHeader))
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := new(big.Int).SetString(c.GetHeader(ChannelAmountHeader), 10)
+	if !ok {
+		return nil, errors.New("invalid payment amount")
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader(ChannelSignatureHeader), "0x"))
+	if err != nil {
+		return nil, err
+	}
+	return &signer.Voucher{
+		ChannelID: channelID,
+		Amount:    amount,
+		Sig:       sig,
+	}, nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"github.com/RTradeLtd/Temporal/tracing"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -12,6 +13,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
 	corsConfig.AllowCredentials = false
-	corsConfig.AddAllowHeaders("cache-control", "Access-Control-Allow-Headers", "Authorization", "Content-Type", "Access-Control-Allow-Origin", "Access-Control-Request-Headers")
+	corsConfig.AddAllowHeaders("cache-control", "Access-Control-Allow-Headers", "Authorization", "Content-Type", "Access-Control-Allow-Origin", "Access-Control-Request-Headers", RequestIDHeader, tracing.TraceParentHeader)
+	corsConfig.AddExposeHeaders(RequestIDHeader)
 	return cors.New(corsConfig)
 }
@@ -20,14 +20,15 @@ type Login struct {
 // JwtConfigGenerate is used to generate our JWT configuration
 func JwtConfigGenerate(jwtKey string, db *gorm.DB, logger *log.Logger) *jwt.GinJWTMiddleware {
 
-	// will implement metamaks/msg signing with ethereum accounts
-	// as the authentication metho
+	// users with a linked ethereum address can also sign in without a password, by signing
+	// a challenge with their wallet, which is handled outside of this middleware by the api
 	authMiddleware := &jwt.GinJWTMiddleware{
 		Realm:      realmName,
 		Key:        []byte(jwtKey),
 		Timeout:    time.Hour * 24,
 		MaxRefresh: time.Hour * 24,
 		Authenticator: func(userId string, password string, c *gin.Context) (string, bool) { // userId = username
+			c.Set(AuditActorKey, userId)
 			userManager := models.NewUserManager(db)
 			validLogin, err := userManager.SignIn(userId, password)
 			if err != nil {
@@ -40,6 +41,13 @@ func JwtConfigGenerate(jwtKey string, db *gorm.DB, logger *log.Logger) *jwt.GinJ
 				}).Error("bad login")
 				return userId, false
 			}
+			if err = userManager.CheckSecondFactor(userId, c.GetHeader(TwoFactorHeader)); err != nil {
+				logger.WithFields(log.Fields{
+					"service": "api",
+					"user":    userId,
+				}).Error("bad second factor at login")
+				return userId, false
+			}
 			logger.WithFields(log.Fields{
 				"service": "api",
 				"user":    userId,
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/ratelimit"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Headers describing the rate limit applied to a request
+const (
+	// RateLimitHeader is the number of requests which can be made in a burst
+	RateLimitHeader = "X-RateLimit-Limit"
+	// RateLimitRemainingHeader is the number of requests which can be made immediately
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	// RateLimitResetHeader is the number of seconds until the full burst can be made again
+	RateLimitResetHeader = "X-RateLimit-Reset"
+)
+
+// RateLimitMiddleware is used to limit requests with the token bucket named by key, whose size
+// and refill rate are given by limit. Requests are let through when the store fails, so an
+// outage of a shared store doesn't take the api down with it
+func RateLimitMiddleware(store ratelimit.Store, key func(c *gin.Context) string, limit func(c *gin.Context) ratelimit.Limit, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := store.Take(key(c), limit(c))
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"service": "api",
+				"error":   err.Error(),
+			}).Error("failed to check rate limit")
+			c.Next()
+			return
+		}
+		c.Header(RateLimitHeader, fmt.Sprint(result.Limit))
+		c.Header(RateLimitRemainingHeader, fmt.Sprint(result.Remaining))
+		c.Header(RateLimitResetHeader, fmt.Sprint(wholeSeconds(result.Reset)))
+		if !result.Allowed {
+			c.Header("Retry-After", fmt.Sprint(wholeSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"code":     http.StatusTooManyRequests,
+				"response": "rate limit exceeded",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func wholeSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
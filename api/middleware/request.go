@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is the header request ids are read from and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware is used to tag each request with an id, which is attached to the request's
+// context and returned to the client. Valid ids sent by clients are kept, so callers can trace their
+// own requests, and the id is recorded in the queue messages published while handling the request
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// RequestLoggerMiddleware is used to log each request once it has been handled
+func RequestLoggerMiddleware(logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		logging.Entry(c.Request.Context(), logger).WithFields(log.Fields{
+			"service": "api",
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"status":  c.Writer.Status(),
+			"latency": time.Since(start).String(),
+			"client":  c.ClientIP(),
+		}).Info("handled request")
+	}
+}
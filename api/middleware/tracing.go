@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// TracingMiddleware is used to record a span around each request. Requests carrying a
+// traceparent header join the caller's trace, and the span is attached to the request's
+// context so the queue messages and storage calls made while handling it are its children
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), c.GetHeader(tracing.TraceParentHeader))
+		// our gin version can't report the matched route, so spans are named after the
+		// handler, keeping paths containing hashes and usernames out of span names
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, handlerName(c)), tracing.Server)
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		span.SetAttribute(logging.RequestIDField, logging.RequestID(ctx))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", status)
+		var err error
+		if status >= 500 {
+			err = fmt.Errorf("request failed with status %v", status)
+		}
+		span.End(err)
+	}
+}
+
+// handlerName is used to trim the package path and method value suffix from the name of c's handler
+func handlerName(c *gin.Context) string {
+	name := c.HandlerName()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/models"
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// TwoFactorHeader is the header holding a one-time password, or recovery code, for users with
+// two factor authentication enabled. It is required at login and by sensitive routes
+const TwoFactorHeader = "X-Two-Factor-Code"
+
+// TwoFactorMiddleware is used to protect sensitive routes with the user's second factor.
+// Users without two factor authentication enabled are passed through untouched
+func TwoFactorMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		username, _ := claims["id"].(string)
+		err := models.NewUserManager(db).CheckSecondFactor(username, c.GetHeader(TwoFactorHeader))
+		if err == models.ErrInvalidSecondFactor {
+			c.AbortWithError(http.StatusUnauthorized, err)
+			return
+		} else if err == models.ErrSecondFactorLocked {
+			c.AbortWithError(http.StatusTooManyRequests, err)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimit is used to limit the requests each user makes to a route group, by their account tier.
+// It must follow the middleware authenticating users, and users whose tier hasn't been loaded by
+// the api restriction middleware are given the standard tier's limits
+func (api *API) rateLimit(group string) gin.HandlerFunc {
+	if api.RateLimits == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	cfg := api.TConfig.RateLimits
+	limits := map[string]ratelimit.Limit{
+		models.TierStandard:   groupLimit(cfg.Standard, group),
+		models.TierEnterprise: groupLimit(cfg.Enterprise, group),
+	}
+	return middleware.RateLimitMiddleware(api.RateLimits,
+		func(c *gin.Context) string {
+			return fmt.Sprintf("user:%s:%s", GetAuthenticatedUserFromContext(c), group)
+		},
+		func(c *gin.Context) ratelimit.Limit {
+			if limit, ok := limits[c.GetString(middleware.AccountTierKey)]; ok {
+				return limit
+			}
+			return limits[models.TierStandard]
+		},
+		api.Logger)
+}
+
+// authRateLimit is used to limit the requests each ip address makes to the authentication routes,
+// which are used before a user is known. Our router ignores forwarding headers, so the address
+// can't be spoofed to dodge the limit
+func (api *API) authRateLimit() gin.HandlerFunc {
+	if api.RateLimits == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	limit := ratelimit.PerMinute(api.TConfig.RateLimits.Auth.RequestsPerMinute, api.TConfig.RateLimits.Auth.Burst)
+	return middleware.RateLimitMiddleware(api.RateLimits,
+		func(c *gin.Context) string {
+			return fmt.Sprintf("ip:%s:auth", c.ClientIP())
+		},
+		func(c *gin.Context) ratelimit.Limit {
+			return limit
+		},
+		api.Logger)
+}
+
+// groupLimit is used to find a tier's limit for a route group
+func groupLimit(tier config.RateLimitTier, group string) ratelimit.Limit {
+	limit, ok := tier.Groups[group]
+	if !ok {
+		limit = tier.Default
+	}
+	return ratelimit.PerMinute(limit.RequestsPerMinute, limit.Burst)
+}
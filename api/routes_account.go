@@ -4,11 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/mail"
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/RTradeLtd/Temporal/totp"
+	jwt "github.com/appleboy/gin-jwt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -28,7 +39,7 @@ func (api *API) changeAccountPassword(c *gin.Context) {
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("password change requested")
@@ -36,18 +47,18 @@ func (api *API) changeAccountPassword(c *gin.Context) {
 	um := models.NewUserManager(api.DBM.DB)
 	suceeded, err := um.ChangePassword(username, oldPassword, newPassword)
 	if err != nil {
-		api.LogError(err, PasswordChangeError)
+		api.LogError(c, err, PasswordChangeError)
 		FailOnError(c, err)
 		return
 	}
 	if !suceeded {
 		err = fmt.Errorf("password changed failed for user %s to due an unspecified error", username)
-		api.LogError(err, PasswordChangeError)
+		api.LogError(c, err, PasswordChangeError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": api,
 		"user":    username,
 	}).Info("password changed")
@@ -77,27 +88,155 @@ func (api *API) registerUserAccount(c *gin.Context) {
 	if ethAddress == "" {
 		ethAddress = username
 	}
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 	}).Info("user account registration detected")
 
 	userManager := models.NewUserManager(api.DBM.DB)
 	userModel, err := userManager.NewUserAccount(ethAddress, username, password, email, false)
 	if err != nil {
-		api.LogError(err, UserAccountCreationError)
+		api.LogError(c, err, UserAccountCreationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("user account registered")
 
+	// api access is granted once the email address is verified. The account exists
+	// either way, so a failure here is left to the user to resend
+	if err = api.sendAccountEmail(c, username, models.TokenEmailVerification); err != nil {
+		api.LogError(c, err, EmailVerificationError)
+	}
+
 	userModel.HashedPassword = "scrubbed"
 	Respond(c, http.StatusOK, gin.H{"response": userModel})
 }
 
+// ethereumChallenge is used to issue a sign in challenge to an ethereum address, which is signed
+// with the address's wallet and given to ethereumLogin. Challenges are issued whether or not the
+// address is linked to an account, so they can't be used to find which addresses are
+func (api *API) ethereumChallenge(c *gin.Context) {
+	ethAddress, exists := c.GetPostForm("eth_address")
+	if !exists {
+		FailNoExistPostForm(c, "eth_address")
+		return
+	}
+	if !common.IsHexAddress(ethAddress) {
+		FailOnError(c, errors.New("invalid ethereum address"))
+		return
+	}
+	nonce, err := signer.NewNonce()
+	if err != nil {
+		api.LogError(c, err, SignInChallengeError)
+		FailOnServerError(c, err)
+		return
+	}
+	domain := api.TConfig.Accounts.SignInDomain
+	now := time.Now()
+	message := &signer.SignInMessage{
+		Domain:    domain,
+		Address:   common.HexToAddress(ethAddress),
+		URI:       fmt.Sprintf("https://%s", domain),
+		Nonce:     nonce,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Duration(api.TConfig.Accounts.SignInChallengeTTLMinutes) * time.Minute),
+	}
+	if err = models.NewUserManager(api.DBM.DB).NewLoginChallenge(&models.LoginChallenge{
+		EthAddress: message.Address.Hex(),
+		Nonce:      nonce,
+		Message:    message.String(),
+		ExpiresAt:  message.ExpiresAt,
+	}); err != nil {
+		api.LogError(c, err, SignInChallengeError)
+		FailOnServerError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"message":    message.String(),
+		"nonce":      nonce,
+		"expires_at": message.ExpiresAt.UTC().Format(time.RFC3339),
+	}})
+}
+
+// ethereumLogin is used to sign in with a signed challenge, issuing the same token as a password login
+func (api *API) ethereumLogin(authWare *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ethAddress, exists := c.GetPostForm("eth_address")
+		if !exists {
+			FailNoExistPostForm(c, "eth_address")
+			return
+		}
+		c.Set(middleware.AuditActorKey, ethAddress)
+		nonce, exists := c.GetPostForm("nonce")
+		if !exists {
+			FailNoExistPostForm(c, "nonce")
+			return
+		}
+		signature, exists := c.GetPostForm("signature")
+		if !exists {
+			FailNoExistPostForm(c, "signature")
+			return
+		}
+		sig, err := hexutil.Decode(signature)
+		if err != nil {
+			FailOnError(c, errors.New("signature must be 0x prefixed hex"))
+			return
+		}
+		um := models.NewUserManager(api.DBM.DB)
+		challenge, err := um.FindLoginChallenge(ethAddress, nonce)
+		if err == models.ErrInvalidChallenge {
+			FailNotAuthorized(c, err.Error())
+			return
+		} else if err != nil {
+			api.LogError(c, err, SignInChallengeError)
+			FailOnServerError(c, err)
+			return
+		}
+		recovered, err := signer.RecoverPersonalSigner([]byte(challenge.Message), sig)
+		if err != nil || recovered != common.HexToAddress(challenge.EthAddress) {
+			api.logger(c).WithFields(log.Fields{
+				"service": "api",
+				"address": challenge.EthAddress,
+			}).Error("bad ethereum login")
+			FailNotAuthorized(c, "signature doesn't match the challenge")
+			return
+		}
+		user, err := um.RedeemLoginChallenge(challenge.EthAddress, challenge.Nonce)
+		if err == models.ErrInvalidChallenge {
+			FailNotAuthorized(c, err.Error())
+			return
+		} else if err != nil {
+			api.LogError(c, err, SignInChallengeError)
+			FailOnServerError(c, err)
+			return
+		}
+		err = um.CheckSecondFactor(user.UserName, c.GetHeader(middleware.TwoFactorHeader))
+		if err == models.ErrInvalidSecondFactor || err == models.ErrSecondFactorLocked {
+			FailNotAuthorized(c, err.Error())
+			return
+		} else if err != nil {
+			api.LogError(c, err, SignInChallengeError)
+			FailOnServerError(c, err)
+			return
+		}
+
+		c.Set(middleware.AuditActorKey, user.UserName)
+		api.logger(c).WithFields(log.Fields{
+			"service": "api",
+			"user":    user.UserName,
+		}).Info("successful ethereum login")
+
+		// this mirrors the response of the password login handler
+		c.JSON(http.StatusOK, gin.H{
+			"token":  authWare.TokenGenerator(user.UserName),
+			"expire": authWare.TimeFunc().Add(authWare.Timeout).Format(time.RFC3339),
+		})
+	}
+}
+
 // CreateIPFSKey is used to create an IPFS key
 func (api *API) createIPFSKey(c *gin.Context) {
 	username := GetAuthenticatedUserFromContext(c)
@@ -131,10 +270,11 @@ func (api *API) createIPFSKey(c *gin.Context) {
 		FailNoExistPostForm(c, "key_name")
 		return
 	}
+	auditTarget(c, "key_name", keyName)
 	um := models.NewUserManager(api.DBM.DB)
 	keys, err := um.GetKeysForUser(username)
 	if err != nil {
-		api.LogError(err, KeySearchError)
+		api.LogError(c, err, KeySearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -142,7 +282,7 @@ func (api *API) createIPFSKey(c *gin.Context) {
 	for _, v := range keys["key_names"] {
 		if v == keyNamePrefixed {
 			err = fmt.Errorf("key with name already exists")
-			api.LogError(err, DuplicateKeyCreationError)
+			api.LogError(c, err, DuplicateKeyCreationError)
 			FailOnError(c, err)
 			return
 		}
@@ -161,22 +301,13 @@ func (api *API) createIPFSKey(c *gin.Context) {
 		NetworkName: "public",
 	}
 
-	mqConnectionURL := api.TConfig.RabbitMQ.URL
-
-	qm, err := queue.Initialize(queue.IpfsKeyCreationQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-
-	if err = qm.PublishMessageWithExchange(key, queue.IpfsKeyExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsKeyCreationQueue, key); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("key creation request sent to backend")
@@ -191,7 +322,7 @@ func (api *API) getIPFSKeyNamesForAuthUser(c *gin.Context) {
 	um := models.NewUserManager(api.DBM.DB)
 	keys, err := um.GetKeysForUser(ethAddress)
 	if err != nil {
-		api.LogError(err, KeySearchError)
+		api.LogError(c, err, KeySearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -200,7 +331,7 @@ func (api *API) getIPFSKeyNamesForAuthUser(c *gin.Context) {
 		FailOnError(c, errors.New(NoKeyError))
 		return
 	}
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("key name list requested")
@@ -216,16 +347,402 @@ func (api *API) changeEthereumAddress(c *gin.Context) {
 		FailNoExistPostForm(c, "eth_address")
 		return
 	}
+	auditTarget(c, "eth_address", ethAddress)
 	um := models.NewUserManager(api.DBM.DB)
 	if _, err := um.ChangeEthereumAddress(username, ethAddress); err != nil {
-		api.LogError(err, EthAddressChangeError)
+		api.LogError(c, err, EthAddressChangeError)
 		FailOnError(c, err)
 		return
 	}
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ethereum address changed")
 
 	Respond(c, http.StatusOK, gin.H{"response": "address change successful"})
 }
+
+// getEmailPreferences is used to retrieve the email preferences of the authenticated user
+func (api *API) getEmailPreferences(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	um := models.NewUserManager(api.DBM.DB)
+	user, err := um.FindByUserName(username)
+	if err != nil {
+		api.LogError(c, err, UserSearchError)
+		FailOnError(c, err)
+		return
+	}
+	notifications := gin.H{}
+	for _, v := range mail.Notifications() {
+		notifications[v] = user.NotificationEnabled(v)
+	}
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"email_enabled": user.EmailEnabled,
+		"notifications": notifications,
+	}})
+}
+
+// updateEmailPreferences is used to turn all emails on or off, or opt in to or out of
+// a single notification type when the notification form value is given
+func (api *API) updateEmailPreferences(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	enabledString, exists := c.GetPostForm("enabled")
+	if !exists {
+		FailNoExistPostForm(c, "enabled")
+		return
+	}
+	enabled, err := strconv.ParseBool(enabledString)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	um := models.NewUserManager(api.DBM.DB)
+	notification := c.PostForm("notification")
+	if notification == "" {
+		err = um.SetEmailEnabled(username, enabled)
+	} else {
+		if !mail.IsNotification(notification) {
+			FailOnError(c, fmt.Errorf("unknown notification %s", notification))
+			return
+		}
+		err = um.SetNotificationEnabled(username, notification, enabled)
+	}
+	if err != nil {
+		api.LogError(c, err, EmailPreferenceChangeError)
+		FailOnError(c, err)
+		return
+	}
+	api.logger(c).WithFields(log.Fields{
+		"service":      api.Service,
+		"user":         username,
+		"notification": notification,
+		"enabled":      enabled,
+	}).Info("email preferences updated")
+
+	Respond(c, http.StatusOK, gin.H{"response": "email preferences updated"})
+}
+
+// verifyEmail is used to redeem an emailed verification token, which grants the user api access
+func (api *API) verifyEmail(c *gin.Context) {
+	token, exists := c.GetPostForm("token")
+	if !exists {
+		FailNoExistPostForm(c, "token")
+		return
+	}
+	user, err := models.NewUserManager(api.DBM.DB).VerifyEmail(token)
+	if err == models.ErrInvalidToken {
+		FailOnError(c, err)
+		return
+	} else if err != nil {
+		api.LogError(c, err, EmailVerificationError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    user.UserName,
+	}).Info("email address verified")
+
+	Respond(c, http.StatusOK, gin.H{"response": "email address verified"})
+}
+
+// resendEmailVerification is used to send the authenticated user a new email verification token
+func (api *API) resendEmailVerification(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	user, err := models.NewUserManager(api.DBM.DB).FindByUserName(username)
+	if err != nil {
+		api.LogError(c, err, UserSearchError)
+		FailOnError(c, err)
+		return
+	}
+	if user.EmailVerified {
+		FailOnError(c, errors.New("email address is already verified"))
+		return
+	}
+	if err = api.sendAccountEmail(c, username, models.TokenEmailVerification); err != nil {
+		api.LogError(c, err, EmailVerificationError)
+		FailOnServerError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "verification email sent"})
+}
+
+// forgotPassword is used to email a password reset token to the account with the given email address.
+// The response doesn't reveal whether an account exists
+func (api *API) forgotPassword(c *gin.Context) {
+	email, exists := c.GetPostForm("email_address")
+	if !exists {
+		FailNoExistPostForm(c, "email_address")
+		return
+	}
+	user, err := models.NewUserManager(api.DBM.DB).FindByEmailAddress(email)
+	if err == nil {
+		err = api.sendAccountEmail(c, user.UserName, models.TokenPasswordReset)
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		api.LogError(c, err, PasswordResetError)
+		FailOnServerError(c, errors.New(PasswordResetError))
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": "if an account with that email address exists, a password reset email has been sent"})
+}
+
+// resetPassword is used to redeem an emailed password reset token, changing the user's password
+func (api *API) resetPassword(c *gin.Context) {
+	token, exists := c.GetPostForm("token")
+	if !exists {
+		FailNoExistPostForm(c, "token")
+		return
+	}
+	newPassword, exists := c.GetPostForm("new_password")
+	if !exists {
+		FailNoExistPostForm(c, "new_password")
+		return
+	}
+	user, err := models.NewUserManager(api.DBM.DB).ResetPassword(token, newPassword)
+	if err == models.ErrInvalidToken {
+		FailOnError(c, err)
+		return
+	} else if err != nil {
+		api.LogError(c, err, PasswordResetError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    user.UserName,
+	}).Info("password reset")
+
+	Respond(c, http.StatusOK, gin.H{"response": "password reset"})
+}
+
+// deleteAccount is used to delete the authenticated user's account, once they confirm their password.
+// Content nobody else holds is scheduled for removal from ipfs, along with the deletion
+func (api *API) deleteAccount(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	password, exists := c.GetPostForm("password")
+	if !exists {
+		FailNoExistPostForm(c, "password")
+		return
+	}
+	if valid, err := models.NewUserManager(api.DBM.DB).ComparePlaintextPasswordToHash(username, password); err != nil || !valid {
+		FailOnError(c, errors.New("invalid password supplied"))
+		return
+	}
+
+	tx := api.DBM.DB.Begin()
+	uploads, err := models.NewUserManager(tx).DeleteAccount(username)
+	if err != nil {
+		tx.Rollback()
+		api.LogError(c, err, AccountDeletionError)
+		FailOnServerError(c, err)
+		return
+	}
+	outbox := models.NewOutboxManager(tx)
+	requestID := logging.RequestID(c.Request.Context())
+	for _, upload := range *uploads {
+		// content pinned to our cluster is also unpinned from our node, but stays pinned to the cluster
+		if _, err = outbox.Enqueue(queue.IpfsPinRemovalQueue, requestID, queue.IPFSPinRemoval{
+			ContentHash:    upload.Hash,
+			NetworkName:    upload.NetworkName,
+			UserName:       username,
+			AccountDeleted: true,
+		}); err != nil {
+			tx.Rollback()
+			api.LogError(c, err, OutboxEnqueueError)
+			FailOnServerError(c, err)
+			return
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		api.LogError(c, err, AccountDeletionError)
+		FailOnServerError(c, err)
+		return
+	}
+	api.Outbox.Wake()
+
+	api.logger(c).WithFields(log.Fields{
+		"service":  "api",
+		"user":     username,
+		"removals": len(*uploads),
+	}).Info("account deleted")
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"deleted": username, "pins_scheduled_for_removal": len(*uploads)}})
+}
+
+// setAccountEnabled is used by the admin to enable or disable a user's account
+func (api *API) setAccountEnabled(c *gin.Context) {
+	api.updateAccount(c, "account", func(um *models.UserManager, username string, enabled bool) error {
+		return um.SetAccountEnabled(username, enabled)
+	})
+}
+
+// setAPIAccess is used by the admin to grant or revoke a user's api access
+func (api *API) setAPIAccess(c *gin.Context) {
+	api.updateAccount(c, "api access", func(um *models.UserManager, username string, enabled bool) error {
+		return um.SetAPIAccess(username, enabled)
+	})
+}
+
+// updateAccount is used to handle the admin's requests to enable or disable part of a user's account
+func (api *API) updateAccount(c *gin.Context, setting string, update func(um *models.UserManager, username string, enabled bool) error) {
+	adminUser := GetAuthenticatedUserFromContext(c)
+	if adminUser != AdminAddress {
+		FailNotAuthorized(c, "unauthorized access to admin route")
+		return
+	}
+	enabledString, exists := c.GetPostForm("enabled")
+	if !exists {
+		FailNoExistPostForm(c, "enabled")
+		return
+	}
+	enabled, err := strconv.ParseBool(enabledString)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	username := c.Param("user")
+	if err = update(models.NewUserManager(api.DBM.DB), username, enabled); err == gorm.ErrRecordNotFound {
+		FailOnError(c, fmt.Errorf("user %s does not exist", username))
+		return
+	} else if err != nil {
+		api.LogError(c, err, AccountUpdateError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    adminUser,
+		"account": username,
+		"enabled": enabled,
+	}).Infof("%s updated", setting)
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"user": username, setting: enabled}})
+}
+
+// sendAccountEmail is used to issue an account token to a user, and email it to them through the outbox
+func (api *API) sendAccountEmail(c *gin.Context, username, purpose string) error {
+	cfg := api.TConfig.Accounts
+	var (
+		notification string
+		link         string
+		ttl          time.Duration
+	)
+	switch purpose {
+	case models.TokenEmailVerification:
+		notification = mail.NotificationEmailVerification
+		link = cfg.VerifyEmailURL
+		ttl = time.Duration(cfg.VerificationTTLHours) * time.Hour
+	case models.TokenPasswordReset:
+		notification = mail.NotificationPasswordReset
+		link = cfg.ResetPasswordURL
+		ttl = time.Duration(cfg.PasswordResetTTLMinutes) * time.Minute
+	default:
+		return fmt.Errorf("unknown account token purpose %s", purpose)
+	}
+	tx := api.DBM.DB.Begin()
+	token, err := models.NewUserManager(tx).NewAccountToken(username, purpose, ttl)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if link != "" {
+		link = fmt.Sprintf("%s?token=%s", link, url.QueryEscape(token))
+	}
+	if _, err = models.NewOutboxManager(tx).Enqueue(queue.EmailSendQueue, logging.RequestID(c.Request.Context()), queue.EmailSend{
+		Notification: notification,
+		Data:         map[string]string{"user": username, "token": token, "link": link},
+		UserNames:    []string{username},
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+	api.Outbox.Wake()
+	return nil
+}
+
+// enrollTwoFactor is used to generate a secret for the authenticated user's authenticator app.
+// Two factor authentication isn't required until it is confirmed with enableTwoFactor
+func (api *API) enrollTwoFactor(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	secret, err := models.NewUserManager(api.DBM.DB).EnrollTwoFactor(username)
+	if err == models.ErrTwoFactorEnabled {
+		FailOnError(c, err)
+		return
+	} else if err != nil {
+		api.LogError(c, err, TwoFactorError)
+		FailOnServerError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"secret": secret,
+		"uri":    totp.URI("Temporal", username, secret),
+	}})
+}
+
+// enableTwoFactor is used to confirm the authenticated user's enrollment with a code from their
+// authenticator, after which it is required. Their recovery codes are returned, and only shown once
+func (api *API) enableTwoFactor(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	code, exists := c.GetPostForm("code")
+	if !exists {
+		FailNoExistPostForm(c, "code")
+		return
+	}
+	codes, err := models.NewUserManager(api.DBM.DB).EnableTwoFactor(username, code)
+	switch err {
+	case nil:
+	case models.ErrTwoFactorEnabled, models.ErrTwoFactorNotEnrolled, models.ErrInvalidSecondFactor:
+		FailOnError(c, err)
+		return
+	default:
+		api.LogError(c, err, TwoFactorError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+	}).Info("two factor authentication enabled")
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"recovery_codes": codes}})
+}
+
+// disableTwoFactor is used to stop requiring a second factor for the authenticated user
+func (api *API) disableTwoFactor(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	if err := models.NewUserManager(api.DBM.DB).DisableTwoFactor(username); err != nil {
+		api.LogError(c, err, TwoFactorError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+	}).Info("two factor authentication disabled")
+
+	Respond(c, http.StatusOK, gin.H{"response": "two factor authentication disabled"})
+}
+
+// regenerateRecoveryCodes is used to replace the authenticated user's recovery codes
+func (api *API) regenerateRecoveryCodes(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	codes, err := models.NewUserManager(api.DBM.DB).RegenerateRecoveryCodes(username)
+	if err == models.ErrTwoFactorNotEnrolled {
+		FailOnError(c, err)
+		return
+	} else if err != nil {
+		api.LogError(c, err, TwoFactorError)
+		FailOnServerError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"recovery_codes": codes}})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// getAuditEvents is used by the admin to read a page of the audit log
+func (api *API) getAuditEvents(c *gin.Context) {
+	adminUser := GetAuthenticatedUserFromContext(c)
+	if adminUser != AdminAddress {
+		FailNotAuthorized(c, "unauthorized access to admin route")
+		return
+	}
+	page, err := parsePage(c)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	filter := models.AuditFilter{
+		Actor:       c.Query("actor"),
+		Action:      c.Query("action"),
+		Target:      c.Query("target"),
+		NetworkName: c.Query("network"),
+		Outcome:     c.Query("outcome"),
+	}
+	if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(c); err != nil {
+		FailOnError(c, err)
+		return
+	}
+	events, info, err := api.Audit.ListEvents(filter, page)
+	if err != nil {
+		api.LogError(c, err, AuditSearchError)
+		FailOnError(c, err)
+		return
+	}
+	RespondPage(c, events, info)
+}
+
+// verifyAuditChain is used by the admin to check that no audit events have been changed or removed
+func (api *API) verifyAuditChain(c *gin.Context) {
+	adminUser := GetAuthenticatedUserFromContext(c)
+	if adminUser != AdminAddress {
+		FailNotAuthorized(c, "unauthorized access to admin route")
+		return
+	}
+	checked, err := api.Audit.VerifyChain()
+	response := gin.H{"valid": err == nil, "events_checked": checked}
+	if ce, ok := err.(*models.ChainError); ok {
+		response["broken_at"] = ce.ID
+		api.logger(c).WithFields(log.Fields{
+			"service":   "api",
+			"user":      adminUser,
+			"broken_at": ce.ID,
+		}).Error("audit log hash chain is broken")
+	} else if err != nil {
+		api.LogError(c, err, AuditSearchError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, gin.H{"response": response})
+}
@@ -1,8 +1,6 @@
 package api
 
 import (
-	"net/http"
-
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -10,30 +8,35 @@ import (
 
 var dev = false
 
-// GetUploadsFromDatabase is used to read a list of uploads from our database
+// GetUploadsFromDatabase is used to read a page of uploads from our database
 func (api *API) getUploadsFromDatabase(c *gin.Context) {
 	authenticatedUser := GetAuthenticatedUserFromContext(c)
 	if authenticatedUser != AdminAddress {
 		FailNotAuthorized(c, "unauthorized access to admin route")
 		return
 	}
+	filter, page, err := parseUploadListing(c)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
 	um := models.NewUploadManager(api.DBM.DB)
 	// fetch the uplaods
-	uploads, err := um.GetUploads()
+	uploads, info, err := um.ListUploads(filter, page)
 	if err != nil {
-		api.LogError(err, UploadSearchError)
+		api.LogError(c, err, UploadSearchError)
 		FailOnError(c, err)
 		return
 	}
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    authenticatedUser,
 	}).Info("all uploads from database requested")
-	Respond(c, http.StatusOK, gin.H{"response": uploads})
+	RespondPage(c, uploads, info)
 }
 
-// GetUploadsForAddress is used to read a list of uploads from a particular eth address
-// If not called by admin  admin, will retrieve all uploads for the current authenticated user
+// GetUploadsForAddress is used to read a page of uploads from a particular eth address
+// If not called by admin  admin, will retrieve uploads for the current authenticated user
 func (api *API) getUploadsForAddress(c *gin.Context) {
 	var queryUser string
 	um := models.NewUploadManager(api.DBM.DB)
@@ -43,18 +46,87 @@ func (api *API) getUploadsForAddress(c *gin.Context) {
 	} else {
 		queryUser = user
 	}
-	// fetch all uploads for that address
-	uploads, err := um.GetUploadsForUser(queryUser)
+	filter, page, err := parseUploadListing(c)
 	if err != nil {
-		api.LogError(err, UploadSearchError)
+		FailOnError(c, err)
+		return
+	}
+	// fetch the uploads for that address
+	uploads, info, err := um.ListUploadsForUser(queryUser, filter, page)
+	if err != nil {
+		api.LogError(c, err, UploadSearchError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    user,
 	}).Info("specific uploads from database requested")
 
-	Respond(c, http.StatusOK, gin.H{"response": uploads})
+	RespondPage(c, uploads, info)
+}
+
+// getPaymentsForAuthUser is used to read a page of the authenticated user's payments
+func (api *API) getPaymentsForAuthUser(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	page, err := parsePage(c)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	filter := models.PaymentFilter{
+		NetworkName: c.Query("network"),
+		Type:        c.Query("type"),
+	}
+	if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(c); err != nil {
+		FailOnError(c, err)
+		return
+	}
+	payments, info, err := models.NewPaymentManager(api.DBM.DB).ListPaymentsForUser(username, filter, page)
+	if err != nil {
+		api.LogError(c, err, PaymentSearchError)
+		FailOnError(c, err)
+		return
+	}
+	RespondPage(c, payments, info)
+}
+
+// getIPNSRecordsForAuthUser is used to read a page of the ipns records published with the authenticated user's keys
+func (api *API) getIPNSRecordsForAuthUser(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	page, err := parsePage(c)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	filter := models.IPNSFilter{NetworkName: c.Query("network")}
+	if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(c); err != nil {
+		FailOnError(c, err)
+		return
+	}
+	entries, info, err := models.NewIPNSManager(api.DBM.DB).ListEntriesForUser(username, filter, page)
+	if err != nil {
+		api.LogError(c, err, IPNSSearchError)
+		FailOnError(c, err)
+		return
+	}
+	RespondPage(c, entries, info)
+}
+
+// parseUploadListing is used to read the filters and page of an upload listing
+func parseUploadListing(c *gin.Context) (models.UploadFilter, models.Page, error) {
+	filter := models.UploadFilter{
+		NetworkName: c.Query("network"),
+		Type:        c.Query("type"),
+	}
+	page, err := parsePage(c)
+	if err != nil {
+		return filter, page, err
+	}
+	if filter.CreatedAfter, filter.CreatedBefore, err = parseCreatedRange(c); err != nil {
+		return filter, page, err
+	}
+	filter.ExpiringBefore, err = parseTimeQuery(c, "expiring_before")
+	return filter, page, err
 }
@@ -9,10 +9,9 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/RTradeLtd/Temporal/mini"
+	"github.com/RTradeLtd/Temporal/logging"
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/RTradeLtd/Temporal/queue"
-	"github.com/RTradeLtd/Temporal/rtfs"
 	"github.com/RTradeLtd/Temporal/signer"
 	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
@@ -34,18 +33,18 @@ func (api *API) calculateIPFSFileHash(c *gin.Context) {
 	}
 	fh, err := file.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
 	hash, err := utils.GenerateIpfsMultiHashForFile(fh)
 	if err != nil {
-		api.LogError(err, IPFSMultiHashGenerationError)
+		api.LogError(c, err, IPFSMultiHashGenerationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": api,
 		"user":    username,
 	}).Info("ipfs file hash calculation requested")
@@ -62,12 +61,7 @@ func (api *API) calculatePinCost(c *gin.Context) {
 		return
 	}
 	holdTime := c.Param("holdtime")
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	holdTimeInt, err := strconv.ParseInt(holdTime, 10, 64)
 	if err != nil {
 		FailOnError(c, err)
@@ -75,12 +69,12 @@ func (api *API) calculatePinCost(c *gin.Context) {
 	}
 	totalCost, err := utils.CalculatePinCost(hash, holdTimeInt, manager.Shell)
 	if err != nil {
-		api.LogError(err, PinCostCalculationError)
+		api.LogError(c, err, PinCostCalculationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("pin cost calculation requested")
@@ -111,7 +105,7 @@ func (api *API) calculateFileCost(c *gin.Context) {
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("file cost calculation requested")
@@ -153,15 +147,10 @@ func (api *API) createPinPayment(c *gin.Context) {
 		return
 	}
 
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	totalCost, err := utils.CalculatePinCost(contentHash, holdTimeInt, manager.Shell)
 	if err != nil {
-		api.LogError(err, PinCostCalculationError)
+		api.LogError(c, err, PinCostCalculationError)
 		FailOnError(c, err)
 		return
 	}
@@ -170,14 +159,14 @@ func (api *API) createPinPayment(c *gin.Context) {
 	keyPass := api.TConfig.Ethereum.Account.KeyPass
 	ps, err := signer.GeneratePaymentSigner(keyFile, keyPass)
 	if err != nil {
-		api.LogError(err, PaymentSignerGenerationError)
+		api.LogError(c, err, PaymentSignerGenerationError)
 		FailOnError(c, err)
 		return
 	}
 	um := models.NewUserManager(api.DBM.DB)
 	ethAddress, err := um.FindEthAddressByUserName(username)
 	if err != nil {
-		api.LogError(err, EthAddressSearchError)
+		api.LogError(c, err, EthAddressSearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -185,7 +174,7 @@ func (api *API) createPinPayment(c *gin.Context) {
 	var num *big.Int
 	num, err = ppm.RetrieveLatestPaymentNumberForUser(username)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		api.LogError(err, PaymentSearchError)
+		api.LogError(c, err, PaymentSearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -199,18 +188,18 @@ func (api *API) createPinPayment(c *gin.Context) {
 
 	sm, err := ps.GenerateSignedPaymentMessagePrefixed(addressTyped, uint8(methodUint), num, costBig)
 	if err != nil {
-		api.LogError(err, PaymentMessageSignError)
+		api.LogError(c, err, PaymentMessageSignError)
 		FailOnError(c, err)
 		return
 	}
 
 	if _, err = ppm.NewPayment(uint8(methodUint), sm.PaymentNumber, sm.ChargeAmount, ethAddress, contentHash, username, "pin", "public", holdTimeInt); err != nil {
-		api.LogError(err, PaymentCreationError)
+		api.LogError(c, err, PaymentCreationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service":        "api",
 		"user":           username,
 		"payment_number": sm.PaymentNumber.String(),
@@ -234,6 +223,7 @@ func (api *API) createFilePayment(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	holdTimeInMonths, exists := c.GetPostForm("hold_time")
 	if !exists {
 		FailNoExistPostForm(c, "hold_time")
@@ -264,32 +254,24 @@ func (api *API) createFilePayment(c *gin.Context) {
 		return
 	}
 
-	accessKey := api.TConfig.MINIO.AccessKey
-	secretKey := api.TConfig.MINIO.SecretKey
-	endpoint := fmt.Sprintf("%s:%s", api.TConfig.MINIO.Connection.IP, api.TConfig.MINIO.Connection.Port)
 	keyFile := api.TConfig.Ethereum.Account.KeyFile
 	keyPass := api.TConfig.Ethereum.Account.KeyPass
 	ps, err := signer.GeneratePaymentSigner(keyFile, keyPass)
 	if err != nil {
-		api.LogError(err, PaymentSignerGenerationError)
-		FailOnError(c, err)
-		return
-	}
-	miniManager, err := mini.NewMinioManager(endpoint, accessKey, secretKey, false)
-	if err != nil {
-		api.LogError(err, MinioConnectionError)
+		api.LogError(c, err, PaymentSignerGenerationError)
 		FailOnError(c, err)
 		return
 	}
+	miniManager := api.Minio
 
-	fmt.Println("opening file")
+	api.logger(c).Debug("opening file")
 	openFile, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file opened")
+	api.logger(c).Debug("file opened")
 	username := GetAuthenticatedUserFromContext(c)
 
 	holdTimeInMonthsInt, err := strconv.ParseInt(holdTimeInMonths, 10, 64)
@@ -302,26 +284,26 @@ func (api *API) createFilePayment(c *gin.Context) {
 	randUtils := utils.GenerateRandomUtils()
 	randString := randUtils.GenerateString(32, utils.LetterBytes)
 	objectName := fmt.Sprintf("%s%s", username, randString)
-	fmt.Println("storing file in minio")
-	if _, err = miniManager.PutObject(FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
-		api.LogError(err, MinioPutError)
+	api.logger(c).Debug("storing file in minio")
+	if _, err = miniManager.PutObjectWithContext(c.Request.Context(), FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
+		api.LogError(c, err, MinioPutError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file stored in minio")
+	api.logger(c).Debug("file stored in minio")
 
 	pm := models.NewPaymentManager(api.DBM.DB)
 	um := models.NewUserManager(api.DBM.DB)
 	ethAddress, err := um.FindEthAddressByUserName(username)
 	if err != nil {
-		api.LogError(err, EthAddressSearchError)
+		api.LogError(c, err, EthAddressSearchError)
 		FailOnError(c, err)
 		return
 	}
 	var num *big.Int
 	num, err = pm.RetrieveLatestPaymentNumberForUser(username)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		api.LogError(err, PaymentSearchError)
+		api.LogError(c, err, PaymentSearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -337,17 +319,17 @@ func (api *API) createFilePayment(c *gin.Context) {
 	addressTyped := common.HexToAddress(ethAddress)
 	sm, err := ps.GenerateSignedPaymentMessagePrefixed(addressTyped, uint8(methodUint), num, costBig)
 	if err != nil {
-		api.LogError(err, PaymentMessageSignError)
+		api.LogError(c, err, PaymentMessageSignError)
 		FailOnError(c, err)
 		return
 	}
 	if _, err = pm.NewPayment(uint8(methodUint), sm.PaymentNumber, sm.ChargeAmount, ethAddress, objectName, username, "file", networkName, holdTimeInMonthsInt); err != nil {
-		api.LogError(err, PaymentCreationError)
+		api.LogError(c, err, PaymentCreationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service":        "api",
 		"user":           username,
 		"payment_number": sm.PaymentNumber.String(),
@@ -382,17 +364,16 @@ func (api *API) submitPinPaymentConfirmation(c *gin.Context) {
 	um := models.NewUserManager(api.DBM.DB)
 	ethAddress, err := um.FindEthAddressByUserName(username)
 	if err != nil {
-		api.LogError(err, EthAddressSearchError)
+		api.LogError(c, err, EthAddressSearchError)
 		FailOnError(c, err)
 		return
 	}
 	pp, err := ppm.FindPaymentByNumberAndAddress(paymentNumber, ethAddress)
 	if err != nil {
-		api.LogError(err, PaymentSearchError)
+		api.LogError(c, err, PaymentSearchError)
 		FailOnError(c, err)
 		return
 	}
-	mqURL := api.TConfig.RabbitMQ.URL
 
 	ppc := queue.PinPaymentConfirmation{
 		TxHash:        txHash,
@@ -400,19 +381,13 @@ func (api *API) submitPinPaymentConfirmation(c *gin.Context) {
 		PaymentNumber: paymentNumber,
 		ContentHash:   pp.ObjectName,
 	}
-	qm, err := queue.Initialize(queue.PinPaymentConfirmationQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-	fmt.Println("publishing message")
-	if err = qm.PublishMessage(ppc); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	api.logger(c).Debug("publishing message")
+	if err = api.publish(c, queue.PinPaymentConfirmationQueue, ppc); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service":        "api",
 		"user":           username,
 		"payment_number": paymentNumber,
@@ -452,7 +427,7 @@ func (api *API) submitPaymentToContract(c *gin.Context) {
 	}
 	keyFileHandler, err := keyFile.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
@@ -481,34 +456,27 @@ func (api *API) submitPaymentToContract(c *gin.Context) {
 		return
 	}
 
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	totalCost, err := utils.CalculatePinCost(contentHash, holdTimeInt, manager.Shell)
 	if err != nil {
-		api.LogError(err, PinCostCalculationError)
+		api.LogError(c, err, PinCostCalculationError)
 		FailOnError(c, err)
 		return
 	}
 	costBig := utils.FloatToBigInt(totalCost)
 
-	mqURL := api.TConfig.RabbitMQ.URL
-
 	ppm := models.NewPaymentManager(api.DBM.DB)
 	um := models.NewUserManager(api.DBM.DB)
 	ethAddress, err := um.FindEthAddressByUserName(username)
 	if err != nil {
-		api.LogError(err, EthAddressSearchError)
+		api.LogError(c, err, EthAddressSearchError)
 		FailOnError(c, err)
 		return
 	}
 	var number *big.Int
 	num, err := ppm.RetrieveLatestPaymentNumberForUser(username)
 	if err != nil {
-		api.LogError(err, PaymentSearchError)
+		api.LogError(c, err, PaymentSearchError)
 		FailOnError(c, err)
 		return
 	}
@@ -522,13 +490,13 @@ func (api *API) submitPaymentToContract(c *gin.Context) {
 		api.TConfig.Ethereum.Account.KeyFile,
 		api.TConfig.Ethereum.Account.KeyPass)
 	if err != nil {
-		api.LogError(err, PaymentSignerGenerationError)
+		api.LogError(c, err, PaymentSignerGenerationError)
 		FailOnError(c, err)
 		return
 	}
 	sm, err := ps.GenerateSignedPaymentMessagePrefixed(addressTyped, uint8(methodUint), number, costBig)
 	if err != nil {
-		api.LogError(err, PaymentMessageSignError)
+		api.LogError(c, err, PaymentMessageSignError)
 		FailOnError(c, err)
 		return
 	}
@@ -566,24 +534,27 @@ func (api *API) submitPaymentToContract(c *gin.Context) {
 		Sig:          sm.Sig,
 	}
 
-	if _, err = ppm.NewPayment(uint8(methodUint), number, costBig, ethAddress, contentHash, username, "pin", "public", holdTimeInt); err != nil {
-		api.LogError(err, PaymentCreationError)
+	// store the payment and its submission in one transaction, so neither exists without the other
+	tx := api.DBM.DB.Begin()
+	if _, err = models.NewPaymentManager(tx).NewPayment(uint8(methodUint), number, costBig, ethAddress, contentHash, username, "pin", "public", holdTimeInt); err != nil {
+		tx.Rollback()
+		api.LogError(c, err, PaymentCreationError)
 		FailOnError(c, err)
 		return
 	}
-	qm, err := queue.Initialize(queue.PinPaymentSubmissionQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
+	if _, err = models.NewOutboxManager(tx).Enqueue(queue.PinPaymentSubmissionQueue, logging.RequestID(c.Request.Context()), pps); err != nil {
+		tx.Rollback()
+		api.LogError(c, err, OutboxEnqueueError)
+		FailOnServerError(c, err)
 		return
 	}
-
-	if err = qm.PublishMessage(pps); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = tx.Commit().Error; err != nil {
+		api.LogError(c, err, PaymentCreationError)
+		FailOnServerError(c, err)
 		return
 	}
-	api.Logger.WithFields(log.Fields{
+	api.Outbox.Wake()
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("payment submitted to contract, user clearly ignored the warnings")
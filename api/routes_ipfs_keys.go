@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/rtfs"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	peer "github.com/libp2p/go-libp2p-peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// listIPFSKeys is used to read a page of the authenticated user's keys
+func (api *API) listIPFSKeys(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	page, err := parsePage(c)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	keys, info, err := models.NewUserManager(api.DBM.DB).ListKeysForUser(username, page)
+	if err != nil {
+		api.LogError(c, err, KeySearchError)
+		FailOnError(c, err)
+		return
+	}
+	RespondPage(c, keys, info)
+}
+
+// minKeyExportPasswordLength is the shortest password keys may be exported with, since
+// exported keys are held outside of our systems where the password is their only protection
+const minKeyExportPasswordLength = 12
+
+// exportIPFSKey is used to give a user one of their keys, encrypted with a password of their choosing
+func (api *API) exportIPFSKey(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	name, exists := c.GetPostForm("key_name")
+	if !exists {
+		FailNoExistPostForm(c, "key_name")
+		return
+	}
+	auditTarget(c, "key_name", name)
+	password, exists := c.GetPostForm("password")
+	if !exists {
+		FailNoExistPostForm(c, "password")
+		return
+	}
+	if len(password) < minKeyExportPasswordLength {
+		FailOnError(c, fmt.Errorf("password must be at least %v characters", minKeyExportPasswordLength))
+		return
+	}
+	keyName := fmt.Sprintf("%s-%s", username, name)
+	if !api.checkKeyOwnership(c, username, keyName) {
+		return
+	}
+	km, err := api.keystore()
+	if err != nil {
+		api.LogError(c, err, KeyExportError)
+		FailOnServerError(c, err)
+		return
+	}
+	exported, err := km.ExportKey(keyName, password)
+	if err != nil {
+		api.LogError(c, err, KeyExportError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+		"key":     keyName,
+	}).Info("ipfs key exported")
+
+	Respond(c, http.StatusOK, gin.H{"response": json.RawMessage(exported)})
+}
+
+// importIPFSKey is used to add a key exported by exportIPFSKey, or encrypted the same way, to a user's keys.
+// The key is saved to the keystore of every node, and recorded, by the key update queue
+func (api *API) importIPFSKey(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	name, exists := c.GetPostForm("key_name")
+	if !exists {
+		FailNoExistPostForm(c, "key_name")
+		return
+	}
+	auditTarget(c, "key_name", name)
+	password, exists := c.GetPostForm("password")
+	if !exists {
+		FailNoExistPostForm(c, "password")
+		return
+	}
+	key, exists := c.GetPostForm("key")
+	if !exists {
+		FailNoExistPostForm(c, "key")
+		return
+	}
+	keyName := fmt.Sprintf("%s-%s", username, name)
+	owned, err := models.NewUserManager(api.DBM.DB).CheckIfKeyOwnedByUser(username, keyName)
+	if err != nil {
+		api.LogError(c, err, KeySearchError)
+		FailOnError(c, err)
+		return
+	}
+	if owned {
+		err = fmt.Errorf("key with name already exists")
+		api.LogError(c, err, DuplicateKeyCreationError)
+		FailOnError(c, err)
+		return
+	}
+	// the key is decrypted before it's sent to our nodes, so a wrong password is the user's error
+	pk, err := rtfs.DecryptKey([]byte(key), password)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	id, err := peer.IDFromPrivateKey(pk)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	// the key is published directly rather than through the outbox, so its password isn't stored
+	if err = api.publish(c, queue.IpfsKeyUpdateQueue, queue.IPFSKeyUpdate{
+		Operation: queue.KeyImport,
+		UserName:  username,
+		Name:      keyName,
+		Key:       key,
+		Password:  password,
+	}); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+		"key":     keyName,
+	}).Info("key import request sent to backend")
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"name": keyName, "id": id.Pretty()}})
+}
+
+// renameIPFSKey is used to rename one of a user's keys. Our records are updated
+// straight away, while the key update queue renames the key in every node's keystore
+func (api *API) renameIPFSKey(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	name, exists := c.GetPostForm("key_name")
+	if !exists {
+		FailNoExistPostForm(c, "key_name")
+		return
+	}
+	auditTarget(c, "key_name", name)
+	newName, exists := c.GetPostForm("new_key_name")
+	if !exists {
+		FailNoExistPostForm(c, "new_key_name")
+		return
+	}
+	keyName := fmt.Sprintf("%s-%s", username, name)
+	newKeyName := fmt.Sprintf("%s-%s", username, newName)
+	if !api.checkKeyOwnership(c, username, keyName) {
+		return
+	}
+	tx := api.DBM.DB.Begin()
+	if err := models.NewUserManager(tx).RenameIPFSKeyForUser(username, keyName, newKeyName); err != nil {
+		tx.Rollback()
+		api.LogError(c, err, KeyUpdateError)
+		FailOnError(c, err)
+		return
+	}
+	if err := api.enqueueKeyUpdate(c, tx, queue.IPFSKeyUpdate{
+		Operation: queue.KeyRename,
+		UserName:  username,
+		Name:      keyName,
+		NewName:   newKeyName,
+	}); err != nil {
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+		"key":     keyName,
+	}).Infof("ipfs key renamed to %s", newKeyName)
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{"name": newKeyName}})
+}
+
+// deleteIPFSKey is used to delete one of a user's keys, which no active ipns record may be published with.
+// Our records are updated straight away, while the key update queue removes the key from every node's keystore
+func (api *API) deleteIPFSKey(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	keyName := fmt.Sprintf("%s-%s", username, c.Param("name"))
+	if !api.checkKeyOwnership(c, username, keyName) {
+		return
+	}
+	tx := api.DBM.DB.Begin()
+	if err := models.NewUserManager(tx).RemoveIPFSKeyForUser(username, keyName); err != nil {
+		tx.Rollback()
+		api.LogError(c, err, KeyUpdateError)
+		FailOnError(c, err)
+		return
+	}
+	if err := api.enqueueKeyUpdate(c, tx, queue.IPFSKeyUpdate{
+		Operation: queue.KeyDelete,
+		UserName:  username,
+		Name:      keyName,
+	}); err != nil {
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    username,
+		"key":     keyName,
+	}).Info("ipfs key deleted")
+
+	Respond(c, http.StatusOK, gin.H{"response": "key deleted"})
+}
+
+// enqueueKeyUpdate is used to write a key update to the outbox and commit tx, so the update
+// reaches our nodes only if our records changed. Failures are responded to before returning
+func (api *API) enqueueKeyUpdate(c *gin.Context, tx *gorm.DB, update queue.IPFSKeyUpdate) error {
+	if _, err := models.NewOutboxManager(tx).Enqueue(queue.IpfsKeyUpdateQueue, logging.RequestID(c.Request.Context()), update); err != nil {
+		tx.Rollback()
+		api.LogError(c, err, OutboxEnqueueError)
+		FailOnServerError(c, err)
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		api.LogError(c, err, KeyUpdateError)
+		FailOnServerError(c, err)
+		return err
+	}
+	api.Outbox.Wake()
+	return nil
+}
+
+// checkKeyOwnership is used to fail requests for keys the user doesn't own, returning whether they do
+func (api *API) checkKeyOwnership(c *gin.Context, username, keyName string) bool {
+	owned, err := models.NewUserManager(api.DBM.DB).CheckIfKeyOwnedByUser(username, keyName)
+	if err != nil {
+		api.LogError(c, err, KeySearchError)
+		FailOnError(c, err)
+		return false
+	}
+	if !owned {
+		err = fmt.Errorf("user %s attempted to use unowned key", username)
+		api.LogError(c, err, KeyUseError)
+		FailOnError(c, err)
+		return false
+	}
+	return true
+}
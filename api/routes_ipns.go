@@ -25,6 +25,7 @@ func (api *API) publishToIPNSDetails(c *gin.Context) {
 		FailNoExistPostForm(c, "hash")
 		return
 	}
+	auditTarget(c, "hash", hash)
 	if _, err := gocid.Decode(hash); err != nil {
 		FailOnError(c, err)
 		return
@@ -50,20 +51,18 @@ func (api *API) publishToIPNSDetails(c *gin.Context) {
 		return
 	}
 
-	mqURL := api.TConfig.RabbitMQ.URL
-
 	um := models.NewUserManager(api.DBM.DB)
 
 	ownsKey, err := um.CheckIfKeyOwnedByUser(ethAddress, key)
 	if err != nil {
-		api.LogError(err, KeySearchError)
+		api.LogError(c, err, KeySearchError)
 		FailOnError(c, err)
 		return
 	}
 
 	if !ownsKey {
 		err = fmt.Errorf("user %s attempted to generate IPFS entry with unowned key", ethAddress)
-		api.LogError(err, KeyUseError)
+		api.LogError(c, err, KeyUseError)
 		FailOnError(c, err)
 		return
 	}
@@ -93,23 +92,21 @@ func (api *API) publishToIPNSDetails(c *gin.Context) {
 		NetworkName: "public",
 	}
 
-	fmt.Printf("IPNS Entry struct %+v\n", ie)
+	api.logger(c).WithFields(log.Fields{
+		"service": api.Service,
+		"user":    ethAddress,
+		"key":     key,
+	}).Debug("publishing ipns entry")
 
-	qm, err := queue.Initialize(queue.IpnsEntryQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
 	// in order to avoid generating too much IPFS dht traffic, we publish round-robin style
 	// as we announce the records to the swarm, we will eventually achieve consistency across nodes automatically
-	if err = qm.PublishMessage(ie); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpnsEntryQueue, ie); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("ipns entry creation request sent to backend")
@@ -164,19 +161,19 @@ func (api *API) generateDNSLinkEntry(c *gin.Context) {
 
 	awsManager, err := dlink.GenerateAwsLinkManager("get", aKey, aSecret, awsZone, region)
 	if err != nil {
-		api.LogError(err, DNSLinkManagerError)
+		api.LogError(c, err, DNSLinkManagerError)
 		FailOnError(c, err)
 		return
 	}
 
 	resp, err := awsManager.AddDNSLinkEntry(recordName, recordValue)
 	if err != nil {
-		api.LogError(err, DNSLinkEntryError)
+		api.LogError(c, err, DNSLinkEntryError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    authUser,
 	}).Info("dnslink entry created")
@@ -1,10 +1,8 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
-	"github.com/RTradeLtd/Temporal/mini"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -21,25 +19,17 @@ func (api *API) makeBucket(c *gin.Context) {
 		FailNoExistPostForm(c, "bucket_name")
 		return
 	}
-	accessKey := api.TConfig.MINIO.AccessKey
-	secretKey := api.TConfig.MINIO.SecretKey
-	endpoint := fmt.Sprintf("%s:%s", api.TConfig.MINIO.Connection.IP, api.TConfig.MINIO.Connection.Port)
-	manager, err := mini.NewMinioManager(endpoint, accessKey, secretKey, true)
-	if err != nil {
-		api.LogError(err, MinioConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.Minio
 
 	args := make(map[string]string)
 	args["name"] = bucketName
-	if err = manager.MakeBucket(args); err != nil {
-		api.LogError(err, MinioBucketCreationError)
+	if err := manager.MakeBucket(args); err != nil {
+		api.LogError(c, err, MinioBucketCreationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("minio bucket created")
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/payments"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// openPaymentChannel is used to register a payment channel the user has opened on-chain
+func (api *API) openPaymentChannel(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	channelIDString, exists := c.GetPostForm("channel_id")
+	if !exists {
+		FailNoExistPostForm(c, "channel_id")
+		return
+	}
+	auditTarget(c, "channel_id", channelIDString)
+	channelID, err := payments.ParseChannelID(channelIDString)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	um := models.NewUserManager(api.DBM.DB)
+	ethAddress, err := um.FindEthAddressByUserName(username)
+	if err != nil {
+		api.LogError(c, err, EthAddressSearchError)
+		FailOnError(c, err)
+		return
+	}
+	channel, err := api.ChannelManager.OpenChannel(context.Background(), username, ethAddress, channelID)
+	if err != nil {
+		api.LogError(c, err, PaymentChannelOpenError)
+		FailOnError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service":    api.Service,
+		"user":       username,
+		"channel_id": channel.ChannelID,
+	}).Info("payment channel opened")
+
+	Respond(c, http.StatusOK, gin.H{"response": channel})
+}
+
+// getPaymentChannels is used to list the payment channels registered by the user
+func (api *API) getPaymentChannels(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	channels, err := api.ChannelManager.Channels.FindChannelsByUserName(username)
+	if err != nil {
+		api.LogError(c, err, PaymentChannelSearchError)
+		FailOnError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": channels})
+}
@@ -4,16 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 
-	"github.com/RTradeLtd/Temporal/mini"
+	"github.com/RTradeLtd/Temporal/models"
 	"github.com/RTradeLtd/Temporal/utils"
+	ipfsapi "github.com/RTradeLtd/go-ipfs-api"
 	gocid "github.com/ipfs/go-cid"
 	"github.com/minio/minio-go"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/RTradeLtd/Temporal/queue"
-	"github.com/RTradeLtd/Temporal/rtfs"
 	"github.com/gin-gonic/gin"
 )
 
@@ -29,19 +30,19 @@ func (api *API) calculateContentHashForFile(c *gin.Context) {
 
 	reader, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
 	defer reader.Close()
 	hash, err := utils.GenerateIpfsMultiHashForFile(reader)
 	if err != nil {
-		api.LogError(err, IPFSMultiHashGenerationError)
+		api.LogError(c, err, IPFSMultiHashGenerationError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("content hash calculation for file requested")
@@ -75,22 +76,13 @@ func (api *API) pinHashLocally(c *gin.Context) {
 		HoldTimeInMonths: holdTimeInt,
 	}
 
-	mqConnectionURL := api.TConfig.RabbitMQ.URL
-
-	qm, err := queue.Initialize(queue.IpfsPinQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-
-	if err = qm.PublishMessageWithExchange(ip, queue.PinExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsPinQueue, ip); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs pin request sent to backend")
@@ -106,20 +98,15 @@ func (api *API) getFileSizeInBytesForObject(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnServerError(c, err)
-		return
-	}
-	sizeInBytes, err := manager.GetObjectFileSizeInBytes(key)
+	manager := api.IPFS
+	sizeInBytes, err := manager.GetObjectFileSizeInBytesWithContext(c.Request.Context(), key)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs object file size requested")
@@ -137,19 +124,13 @@ func (api *API) addFileLocallyAdvanced(c *gin.Context) {
 		FailNoExistPostForm(c, "hold_time")
 		return
 	}
-
-	accessKey := api.TConfig.MINIO.AccessKey
-	secretKey := api.TConfig.MINIO.SecretKey
-	endpoint := fmt.Sprintf("%s:%s", api.TConfig.MINIO.Connection.IP, api.TConfig.MINIO.Connection.Port)
-
-	mqURL := api.TConfig.RabbitMQ.URL
-
-	miniManager, err := mini.NewMinioManager(endpoint, accessKey, secretKey, false)
+	holdTimeInt, err := strconv.ParseInt(holdTimeInMonths, 10, 64)
 	if err != nil {
-		api.LogError(err, MinioConnectionError)
 		FailOnError(c, err)
 		return
 	}
+
+	miniManager := api.Minio
 	fileHandler, err := c.FormFile("file")
 	if err != nil {
 		FailOnError(c, err)
@@ -159,47 +140,40 @@ func (api *API) addFileLocallyAdvanced(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("opening file")
+	api.logger(c).Debug("opening file")
 	openFile, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file opened")
+	api.logger(c).Debug("file opened")
 	username := GetAuthenticatedUserFromContext(c)
 
 	randUtils := utils.GenerateRandomUtils()
 	randString := randUtils.GenerateString(32, utils.LetterBytes)
 	objectName := fmt.Sprintf("%s%s", username, randString)
-	fmt.Println("storing file in minio")
-	if _, err = miniManager.PutObject(FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
-		api.LogError(err, MinioPutError)
+	api.logger(c).Debug("storing file in minio")
+	if _, err = miniManager.PutObjectWithContext(c.Request.Context(), FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
+		api.LogError(c, err, MinioPutError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file stored in minio")
+	api.logger(c).Debug("file stored in minio")
 	ifp := queue.IPFSFile{
 		BucketName:       FilesUploadBucket,
 		ObjectName:       objectName,
 		UserName:         username,
 		NetworkName:      "public",
-		HoldTimeInMonths: holdTimeInMonths,
-	}
-	qm, err := queue.Initialize(queue.IpfsFileQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
+		HoldTimeInMonths: holdTimeInt,
 	}
-
-	if err = qm.PublishMessage(ifp); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsFileQueue, ifp); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("advanced ipfs file upload requested")
@@ -210,7 +184,7 @@ func (api *API) addFileLocallyAdvanced(c *gin.Context) {
 // AddFileLocally is used to add a file to our local ipfs node in a simple manner
 // this route gives the user back a content hash for their file immedaitely
 func (api *API) addFileLocally(c *gin.Context) {
-	fmt.Println("fetching file")
+	api.logger(c).Debug("fetching file")
 	// fetch the file, and create a handler to interact with it
 	fileHandler, err := c.FormFile("file")
 	if err != nil {
@@ -233,32 +207,27 @@ func (api *API) addFileLocally(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("opening file")
+	api.logger(c).Debug("opening file")
 	// open the file
 	openFile, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file opened")
-	fmt.Println("initializing manager")
+	api.logger(c).Debug("file opened")
+	api.logger(c).Debug("initializing manager")
 	// initialize a connection to the local ipfs node
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	// pin the file
-	fmt.Println("adding file")
-	resp, err := manager.Add(openFile)
+	api.logger(c).Debug("adding file")
+	resp, err := manager.AddWithContext(c.Request.Context(), openFile)
 	if err != nil {
-		api.LogError(err, IPFSAddError)
+		api.LogError(c, err, IPFSAddError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file added")
+	api.logger(c).Debug("file added")
 	// construct a message to rabbitmq to upad the database
 	dfa := queue.DatabaseFileAdd{
 		Hash:             resp,
@@ -266,19 +235,11 @@ func (api *API) addFileLocally(c *gin.Context) {
 		UserName:         username,
 		NetworkName:      "public",
 	}
-	mqConnectionURL := api.TConfig.RabbitMQ.URL
 	// initialize a connectino to rabbitmq
-	qm, err := queue.Initialize(queue.DatabaseFileAddQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-
 	// publish the database file add message
-	if err = qm.PublishMessage(dfa); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.DatabaseFileAddQueue, dfa); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
@@ -289,19 +250,13 @@ func (api *API) addFileLocally(c *gin.Context) {
 		HoldTimeInMonths: holdTimeinMonthsInt,
 	}
 
-	qm, err = queue.Initialize(queue.IpfsPinQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-	if err = qm.PublishMessageWithExchange(pin, queue.PinExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsPinQueue, pin); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("simple ipfs file upload processed")
@@ -318,19 +273,14 @@ func (api *API) ipfsPubSubPublish(c *gin.Context) {
 		FailNoExistPostForm(c, "message")
 		return
 	}
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
-	if err = manager.PublishPubSubMessage(topic, message); err != nil {
-		api.LogError(err, IPFSPubSubPublishError)
+	manager := api.IPFS
+	if err := manager.PublishPubSubMessage(topic, message); err != nil {
+		api.LogError(c, err, IPFSPubSubPublishError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs pub sub message published")
@@ -350,26 +300,19 @@ func (api *API) removePinFromLocalHost(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	mqURL := api.TConfig.RabbitMQ.URL
 
-	qm, err := queue.Initialize(queue.IpfsPinRemovalQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
 	rm := queue.IPFSPinRemoval{
 		ContentHash: hash,
 		NetworkName: "public",
 		UserName:    username,
 	}
-	if err = qm.PublishMessageWithExchange(rm, queue.PinRemovalExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err := api.publish(c, queue.IpfsPinRemovalQueue, rm); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs pin removal request sent to backend")
@@ -377,7 +320,7 @@ func (api *API) removePinFromLocalHost(c *gin.Context) {
 	Respond(c, http.StatusOK, gin.H{"response": "pin removal sent to backend"})
 }
 
-// GetLocalPins is used to get the pins tracked by the serving ipfs node
+// GetLocalPins is used to get a page of the pins tracked by the serving ipfs node
 // This is admin locked to avoid peformance penalties from looking up the pinset
 func (api *API) getLocalPins(c *gin.Context) {
 	ethAddress := GetAuthenticatedUserFromContext(c)
@@ -385,28 +328,74 @@ func (api *API) getLocalPins(c *gin.Context) {
 		FailNotAuthorized(c, "unauthorized access to admin route")
 		return
 	}
-	// initialize a connection toe the local ipfs node
-	manager, err := rtfs.Initialize("", "")
+	page, err := parsePage(c)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
-	// get all the known local pins
-	// WARNING: THIS COULD BE A VERY LARGE LIST
-	pinInfo, err := manager.Shell.Pins()
+	pinType := c.DefaultQuery("type", "all")
+	switch pinType {
+	case "all", "direct", "indirect", "recursive":
+	default:
+		FailOnError(c, errors.New("type must be one of all, direct, indirect or recursive"))
+		return
+	}
+	// the node can only list its whole pinset, so the page is selected from that
+	pinInfo, err := api.IPFS.PinsWithContext(c.Request.Context(), pinType)
+	if err != nil {
+		api.LogError(c, err, IPFSPinParseError)
+		FailOnError(c, err)
+		return
+	}
+	pins, info, err := paginatePins(pinInfo, page)
 	if err != nil {
-		api.LogError(err, IPFSPinParseError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("ipfs pin list requested")
 
-	Respond(c, http.StatusOK, gin.H{"response": pinInfo})
+	RespondPage(c, pins, info)
+}
+
+// paginatePins is used to select a page of pins sorted by hash, where
+// the cursor is the last hash of the previous page
+func paginatePins(pins map[string]ipfsapi.PinInfo, page models.Page) (map[string]ipfsapi.PinInfo, *models.PageInfo, error) {
+	if page.SortBy != "" && page.SortBy != "hash" {
+		return nil, nil, fmt.Errorf("can't sort by %s", page.SortBy)
+	}
+	if page.Limit == 0 {
+		page.Limit = models.DefaultPageLimit
+	}
+	if page.Limit < 1 || page.Limit > models.MaxPageLimit {
+		return nil, nil, fmt.Errorf("limit must be between 1 and %v", models.MaxPageLimit)
+	}
+	hashes := make([]string, 0, len(pins))
+	for hash := range pins {
+		if page.Cursor == "" ||
+			(page.Ascending && hash > page.Cursor) ||
+			(!page.Ascending && hash < page.Cursor) {
+			hashes = append(hashes, hash)
+		}
+	}
+	if page.Ascending {
+		sort.Strings(hashes)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(hashes)))
+	}
+	info := &models.PageInfo{Limit: page.Limit, SortBy: "hash", Ascending: page.Ascending}
+	if len(hashes) > page.Limit {
+		hashes = hashes[:page.Limit]
+		info.NextCursor = hashes[page.Limit-1]
+	}
+	selected := make(map[string]ipfsapi.PinInfo, len(hashes))
+	for _, hash := range hashes {
+		selected[hash] = pins[hash]
+	}
+	return selected, info, nil
 }
 
 // GetObjectStatForIpfs is used to get the object stats for the particular cid
@@ -417,20 +406,15 @@ func (api *API) getObjectStatForIpfs(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
-	stats, err := manager.ObjectStat(key)
+	manager := api.IPFS
+	stats, err := manager.ObjectStatWithContext(c.Request.Context(), key)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs object stat requested")
@@ -450,20 +434,15 @@ func (api *API) checkLocalNodeForPin(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	present, err := manager.ParseLocalPinsForHash(hash)
 	if err != nil {
-		api.LogError(err, IPFSPinParseError)
+		api.LogError(c, err, IPFSPinParseError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("ipfs pin check requested")
@@ -492,23 +471,18 @@ func (api *API) downloadContentHash(c *gin.Context) {
 		return
 	}
 	// initialize our connection to IPFS
-	manager, err := rtfs.Initialize("", "")
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
+	manager := api.IPFS
 	// read the contents of the file
 	reader, err := manager.Shell.Cat(contentHash)
 	if err != nil {
-		api.LogError(err, IPFSCatError)
+		api.LogError(c, err, IPFSCatError)
 		FailOnError(c, err)
 		return
 	}
 	// get the size of hte file in bytes
-	sizeInBytes, err := manager.GetObjectFileSizeInBytes(contentHash)
+	sizeInBytes, err := manager.GetObjectFileSizeInBytesWithContext(c.Request.Context(), contentHash)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
@@ -539,7 +513,7 @@ func (api *API) downloadContentHash(c *gin.Context) {
 		}
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs content download requested")
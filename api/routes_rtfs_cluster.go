@@ -31,15 +31,6 @@ func (api *API) pinHashToCluster(c *gin.Context) {
 		return
 	}
 
-	mqURL := api.TConfig.RabbitMQ.URL
-
-	qm, err := queue.Initialize(queue.IpfsClusterPinQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-
 	ipfsClusterPin := queue.IPFSClusterPin{
 		CID:              hash,
 		NetworkName:      "public",
@@ -47,13 +38,13 @@ func (api *API) pinHashToCluster(c *gin.Context) {
 		HoldTimeInMonths: holdTimeInt,
 	}
 
-	if err = qm.PublishMessage(ipfsClusterPin); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsClusterPinQueue, ipfsClusterPin); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("cluster pin request sent to backend")
@@ -71,19 +62,19 @@ func (api *API) syncClusterErrorsLocally(c *gin.Context) {
 	// initialize a conection to the cluster
 	manager, err := rtfs_cluster.Initialize("", "")
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	// parse the local cluster status, and sync any errors, retunring the cids that were in an error state
 	syncedCids, err := manager.ParseLocalStatusAllAndSync()
 	if err != nil {
-		api.LogError(err, IPFSClusterStatusError)
+		api.LogError(c, err, IPFSClusterStatusError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("local cluster errors parsed")
@@ -107,17 +98,17 @@ func (api *API) removePinFromCluster(c *gin.Context) {
 	}
 	manager, err := rtfs_cluster.Initialize("", "")
 	if err != nil {
-		api.LogError(err, IPFSClusterConnectionError)
+		api.LogError(c, err, IPFSClusterConnectionError)
 		FailOnError(c, err)
 		return
 	}
-	if err = manager.RemovePinFromCluster(hash); err != nil {
-		api.LogError(err, IPFSClusterPinRemovalError)
+	if err = manager.RemovePinFromClusterWithContext(c.Request.Context(), hash); err != nil {
+		api.LogError(c, err, IPFSClusterPinRemovalError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("pin removal request sent to cluster")
@@ -140,19 +131,19 @@ func (api *API) getLocalStatusForClusterPin(c *gin.Context) {
 	// initialize a connection to the cluster
 	manager, err := rtfs_cluster.Initialize("", "")
 	if err != nil {
-		api.LogError(err, IPFSClusterConnectionError)
+		api.LogError(c, err, IPFSClusterConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	// get the cluster status for the cid only asking the local cluster node
 	status, err := manager.GetStatusForCidLocally(hash)
 	if err != nil {
-		api.LogError(err, IPFSClusterStatusError)
+		api.LogError(c, err, IPFSClusterStatusError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("local cluster status for pin requested")
@@ -175,19 +166,19 @@ func (api *API) getGlobalStatusForClusterPin(c *gin.Context) {
 	// initialize a connection to the cluster
 	manager, err := rtfs_cluster.Initialize("", "")
 	if err != nil {
-		api.LogError(err, IPFSClusterConnectionError)
+		api.LogError(c, err, IPFSClusterConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	// get teh cluster wide status for this particular pin
 	status, err := manager.GetStatusForCidGlobally(hash)
 	if err != nil {
-		api.LogError(err, IPFSClusterStatusError)
+		api.LogError(c, err, IPFSClusterStatusError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("global cluster status for pin requested")
@@ -209,14 +200,14 @@ func (api *API) fetchLocalClusterStatus(c *gin.Context) {
 	// initialize a connection to the cluster
 	manager, err := rtfs_cluster.Initialize("", "")
 	if err != nil {
-		api.LogError(err, IPFSClusterConnectionError)
+		api.LogError(c, err, IPFSClusterConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	// fetch a map of all the statuses
 	maps, err := manager.FetchLocalStatus()
 	if err != nil {
-		api.LogError(err, IPFSClusterStatusError)
+		api.LogError(c, err, IPFSClusterStatusError)
 		FailOnError(c, err)
 		return
 	}
@@ -226,7 +217,7 @@ func (api *API) fetchLocalClusterStatus(c *gin.Context) {
 		statuses = append(statuses, v)
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("local cluster state fetched")
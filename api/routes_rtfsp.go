@@ -7,10 +7,9 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/RTradeLtd/Temporal/mini"
 	"github.com/RTradeLtd/Temporal/queue"
-	"github.com/RTradeLtd/Temporal/rtfs"
 	gocid "github.com/ipfs/go-cid"
+	"github.com/jinzhu/gorm"
 	minio "github.com/minio/minio-go"
 	log "github.com/sirupsen/logrus"
 
@@ -28,10 +27,11 @@ func (api *API) pinToHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB)
 	if err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -58,22 +58,13 @@ func (api *API) pinToHostedIPFSNetwork(c *gin.Context) {
 		HoldTimeInMonths: holdTimeInt,
 	}
 
-	mqConnectionURL := api.TConfig.RabbitMQ.URL
-
-	qm, err := queue.Initialize(queue.IpfsPinQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnServerError(c, err)
+	if err = api.publish(c, queue.IpfsPinQueue, ip); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	if err = qm.PublishMessageWithExchange(ip, queue.PinExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnServerError(c, err)
-		return
-	}
-
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("ipfs pin request for private network sent to backend")
@@ -89,8 +80,9 @@ func (api *API) getFileSizeInBytesForObjectForHostedIPFSNetwork(c *gin.Context)
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	if err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -98,7 +90,7 @@ func (api *API) getFileSizeInBytesForObjectForHostedIPFSNetwork(c *gin.Context)
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
@@ -107,20 +99,20 @@ func (api *API) getFileSizeInBytesForObjectForHostedIPFSNetwork(c *gin.Context)
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
-	sizeInBytes, err := manager.GetObjectFileSizeInBytes(key)
+	sizeInBytes, err := manager.GetObjectFileSizeInBytesWithContext(c.Request.Context(), key)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("private ipfs object file size requested")
@@ -139,9 +131,10 @@ func (api *API) addFileToHostedIPFSNetworkAdvanced(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	if err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -151,19 +144,13 @@ func (api *API) addFileToHostedIPFSNetworkAdvanced(c *gin.Context) {
 		FailNoExistPostForm(c, "hold_time")
 		return
 	}
-
-	accessKey := api.TConfig.MINIO.AccessKey
-	secretKey := api.TConfig.MINIO.SecretKey
-	endpoint := fmt.Sprintf("%s:%s", api.TConfig.MINIO.Connection.IP, api.TConfig.MINIO.Connection.Port)
-
-	mqURL := api.TConfig.RabbitMQ.URL
-
-	miniManager, err := mini.NewMinioManager(endpoint, accessKey, secretKey, false)
+	holdTimeInt, err := strconv.ParseInt(holdTimeInMonths, 10, 64)
 	if err != nil {
-		api.LogError(err, MinioConnectionError)
 		FailOnError(c, err)
 		return
 	}
+
+	miniManager := api.Minio
 	fileHandler, err := c.FormFile("file")
 	if err != nil {
 		// user error, do not log
@@ -174,45 +161,39 @@ func (api *API) addFileToHostedIPFSNetworkAdvanced(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("opening file")
+	api.logger(c).Debug("opening file")
 	openFile, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file opened")
+	api.logger(c).Debug("file opened")
 	randUtils := utils.GenerateRandomUtils()
 	randString := randUtils.GenerateString(32, utils.LetterBytes)
 	objectName := fmt.Sprintf("%s%s", username, randString)
-	fmt.Println("storing file in minio")
-	if _, err = miniManager.PutObject(FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
-		api.LogError(err, MinioPutError)
+	api.logger(c).Debug("storing file in minio")
+	if _, err = miniManager.PutObjectWithContext(c.Request.Context(), FilesUploadBucket, objectName, openFile, fileHandler.Size, minio.PutObjectOptions{}); err != nil {
+		api.LogError(c, err, MinioPutError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file stored in minio")
+	api.logger(c).Debug("file stored in minio")
 	ifp := queue.IPFSFile{
 		BucketName:       FilesUploadBucket,
 		ObjectName:       objectName,
 		UserName:         username,
 		NetworkName:      networkName,
-		HoldTimeInMonths: holdTimeInMonths,
-	}
-	qm, err := queue.Initialize(queue.IpfsFileQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
+		HoldTimeInMonths: holdTimeInt,
 	}
 	// we don't use an exchange for file publishes so that rabbitmq distributes round robin
-	if err = qm.PublishMessage(ifp); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsFileQueue, ifp); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("advanced private ipfs file upload requested")
@@ -229,15 +210,14 @@ func (api *API) addFileToHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	if err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
 
-	mqURL := api.TConfig.RabbitMQ.URL
-
 	holdTimeinMonths, exists := c.GetPostForm("hold_time")
 	if !exists {
 		FailNoExistPostForm(c, "hold_time")
@@ -251,25 +231,18 @@ func (api *API) addFileToHostedIPFSNetwork(c *gin.Context) {
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
 
-	ipfsManager, err := rtfs.Initialize("", apiURL)
-	if err != nil {
-		api.LogError(err, IPFSConnectionError)
-		FailOnError(c, err)
-		return
-	}
-	qm, err := queue.Initialize(queue.DatabaseFileAddQueue, mqURL, true, false)
+	ipfsManager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, QueueInitializationError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
-
-	fmt.Println("fetching file")
+	api.logger(c).Debug("fetching file")
 	// fetch the file, and create a handler to interact with it
 	fileHandler, err := c.FormFile("file")
 	if err != nil {
@@ -283,26 +256,26 @@ func (api *API) addFileToHostedIPFSNetwork(c *gin.Context) {
 	}
 	file, err := fileHandler.Open()
 	if err != nil {
-		api.LogError(err, FileOpenError)
+		api.LogError(c, err, FileOpenError)
 		FailOnError(c, err)
 		return
 	}
-	resp, err := ipfsManager.Add(file)
+	resp, err := ipfsManager.AddWithContext(c.Request.Context(), file)
 	if err != nil {
-		api.LogError(err, IPFSAddError)
+		api.LogError(c, err, IPFSAddError)
 		FailOnError(c, err)
 		return
 	}
-	fmt.Println("file uploaded")
+	api.logger(c).Debug("file uploaded")
 	dfa := queue.DatabaseFileAdd{
 		Hash:             resp,
 		HoldTimeInMonths: holdTimeInt,
 		UserName:         username,
 		NetworkName:      networkName,
 	}
-	if err = qm.PublishMessage(dfa); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.DatabaseFileAddQueue, dfa); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
@@ -313,19 +286,13 @@ func (api *API) addFileToHostedIPFSNetwork(c *gin.Context) {
 		HoldTimeInMonths: holdTimeInt,
 	}
 
-	qm, err = queue.Initialize(queue.IpfsPinQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-	if err = qm.PublishMessageWithExchange(pin, queue.PinExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err = api.publish(c, queue.IpfsPinQueue, pin); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("simple private ipfs file upload processed")
@@ -341,8 +308,9 @@ func (api *API) ipfsPubSubPublishToHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	if err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -350,7 +318,7 @@ func (api *API) ipfsPubSubPublishToHostedIPFSNetwork(c *gin.Context) {
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
@@ -360,19 +328,19 @@ func (api *API) ipfsPubSubPublishToHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "message")
 		return
 	}
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	if err = manager.PublishPubSubMessage(topic, message); err != nil {
-		api.LogError(err, IPFSPubSubPublishError)
+		api.LogError(c, err, IPFSPubSubPublishError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("private ipfs pub sub message published")
@@ -393,8 +361,9 @@ func (api *API) removePinFromLocalHostForHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	if err := CheckAccessForPrivateNetwork(username, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -403,20 +372,13 @@ func (api *API) removePinFromLocalHostForHostedIPFSNetwork(c *gin.Context) {
 		NetworkName: networkName,
 		UserName:    username,
 	}
-	mqConnectionURL := api.TConfig.RabbitMQ.URL
-	qm, err := queue.Initialize(queue.IpfsPinRemovalQueue, mqConnectionURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
-	if err = qm.PublishMessageWithExchange(rm, queue.PinRemovalExchange); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err := api.publish(c, queue.IpfsPinRemovalQueue, rm); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    username,
 	}).Info("private ipfs pin removal request sent to backend")
@@ -436,22 +398,23 @@ func (api *API) getLocalPinsForHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
 	// initialize a connection toe the local ipfs node
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
@@ -459,12 +422,12 @@ func (api *API) getLocalPinsForHostedIPFSNetwork(c *gin.Context) {
 	// WARNING: THIS COULD BE A VERY LARGE LIST
 	pinInfo, err := manager.Shell.Pins()
 	if err != nil {
-		api.LogError(err, IPFSPinParseError)
+		api.LogError(c, err, IPFSPinParseError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs pin list requested")
@@ -480,8 +443,9 @@ func (api *API) getObjectStatForIpfsForHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -489,7 +453,7 @@ func (api *API) getObjectStatForIpfsForHostedIPFSNetwork(c *gin.Context) {
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
@@ -498,20 +462,20 @@ func (api *API) getObjectStatForIpfsForHostedIPFSNetwork(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
-	stats, err := manager.ObjectStat(key)
+	stats, err := manager.ObjectStatWithContext(c.Request.Context(), key)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs object stat requested")
@@ -531,16 +495,17 @@ func (api *API) checkLocalNodeForPinForHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
@@ -549,20 +514,20 @@ func (api *API) checkLocalNodeForPinForHostedIPFSNetwork(c *gin.Context) {
 		FailOnError(c, err)
 		return
 	}
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	present, err := manager.ParseLocalPinsForHash(hash)
 	if err != nil {
-		api.LogError(err, IPFSPinParseError)
+		api.LogError(c, err, IPFSPinParseError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs pin check requested")
@@ -578,29 +543,23 @@ func (api *API) publishDetailedIPNSToHostedIPFSNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	ethAddress := GetAuthenticatedUserFromContext(c)
 
-	mqURL := api.TConfig.RabbitMQ.URL
-
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
 
 	um := models.NewUserManager(api.DBM.DB)
-	qm, err := queue.Initialize(queue.IpnsEntryQueue, mqURL, true, false)
-	if err != nil {
-		api.LogError(err, QueueInitializationError)
-		FailOnError(c, err)
-		return
-	}
 	hash, present := c.GetPostForm("hash")
 	if !present {
 		FailNoExistPostForm(c, "hash")
 		return
 	}
+	auditTarget(c, "hash", hash)
 	if _, err := gocid.Decode(hash); err != nil {
 		FailOnError(c, err)
 		return
@@ -628,14 +587,14 @@ func (api *API) publishDetailedIPNSToHostedIPFSNetwork(c *gin.Context) {
 
 	ownsKey, err := um.CheckIfKeyOwnedByUser(ethAddress, key)
 	if err != nil {
-		api.LogError(err, KeySearchError)
+		api.LogError(c, err, KeySearchError)
 		FailOnError(c, err)
 		return
 	}
 
 	if !ownsKey {
 		err = fmt.Errorf("unauthorized access to key by user %s", ethAddress)
-		api.LogError(err, KeyUseError)
+		api.LogError(c, err, KeyUseError)
 		FailOnError(c, err)
 		return
 	}
@@ -667,13 +626,13 @@ func (api *API) publishDetailedIPNSToHostedIPFSNetwork(c *gin.Context) {
 		NetworkName: networkName,
 		UserName:    ethAddress,
 	}
-	if err := qm.PublishMessage(ipnsUpdate); err != nil {
-		api.LogError(err, QueuePublishError)
-		FailOnError(c, err)
+	if err := api.publish(c, queue.IpnsEntryQueue, ipnsUpdate); err != nil {
+		api.LogError(c, err, QueuePublishError)
+		FailOnPublishError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipns entry creation request sent to backend")
@@ -696,6 +655,7 @@ func (api *API) createHostedIPFSNetworkEntryInDatabase(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	apiURL, exists := c.GetPostForm("api_url")
 	if !exists {
@@ -741,7 +701,7 @@ func (api *API) createHostedIPFSNetworkEntryInDatabase(c *gin.Context) {
 			return
 		}
 		if !valid {
-			api.Logger.Errorf("provided peer %s is not a valid bootstrap peer", addr)
+			api.logger(c).Errorf("provided peer %s is not a valid bootstrap peer", addr)
 			FailOnError(c, fmt.Errorf("provided peer %s is not a valid bootstrap peer", addr))
 			return
 		}
@@ -771,32 +731,16 @@ func (api *API) createHostedIPFSNetworkEntryInDatabase(c *gin.Context) {
 	if len(bootstrapPeerAddresses) > 0 {
 		args["bootstrap_peer_addresses"] = bootstrapPeerAddresses
 	}
+	// the network's members are added along with it
 	manager := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	network, err := manager.CreateHostedPrivateNetwork(networkName, apiURL, swarmKey, args, users)
 	if err != nil {
-		api.LogError(err, NetworkCreationError)
+		api.LogError(c, err, NetworkCreationError)
 		FailOnError(c, err)
 		return
 	}
-	um := models.NewUserManager(api.DBM.DB)
 
-	if len(users) > 0 {
-		for _, v := range users {
-			if err := um.AddIPFSNetworkForUser(v, networkName); err != nil {
-				api.LogError(err, NetworkCreationError)
-				FailOnError(c, err)
-				return
-			}
-		}
-	} else {
-		if err := um.AddIPFSNetworkForUser(AdminAddress, networkName); err != nil {
-			api.LogError(err, NetworkCreationError)
-			FailOnError(c, err)
-			return
-		}
-	}
-
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs network created")
@@ -805,6 +749,34 @@ func (api *API) createHostedIPFSNetworkEntryInDatabase(c *gin.Context) {
 
 }
 
+// deleteHostedIPFSNetwork is used to delete a private ipfs network from our database
+func (api *API) deleteHostedIPFSNetwork(c *gin.Context) {
+	// lock down as admin route for now
+	ethAddress := GetAuthenticatedUserFromContext(c)
+	if ethAddress != AdminAddress {
+		FailNotAuthorized(c, "unauthorized access")
+		return
+	}
+	netName := c.Param("name")
+	manager := models.NewHostedIPFSNetworkManager(api.DBM.DB)
+	if err := manager.DeleteHostedPrivateNetwork(netName); err == gorm.ErrRecordNotFound {
+		FailOnError(c, fmt.Errorf("private network %s does not exist", netName))
+		return
+	} else if err != nil {
+		api.LogError(c, err, NetworkDeletionError)
+		FailOnServerError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": "api",
+		"user":    ethAddress,
+		"network": netName,
+	}).Info("private ipfs network deleted")
+
+	Respond(c, http.StatusOK, gin.H{"response": "private network deleted"})
+}
+
 // GetIPFSPrivateNetworkByName is used to get connection information for a priavate ipfs network
 func (api *API) getIPFSPrivateNetworkByName(c *gin.Context) {
 	ethAddress := GetAuthenticatedUserFromContext(c)
@@ -817,12 +789,12 @@ func (api *API) getIPFSPrivateNetworkByName(c *gin.Context) {
 	manager := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	net, err := manager.GetNetworkByName(netName)
 	if err != nil {
-		api.LogError(err, NetworkSearchError)
+		api.LogError(c, err, NetworkSearchError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs network by name requested")
@@ -838,12 +810,12 @@ func (api *API) getAuthorizedPrivateNetworks(c *gin.Context) {
 	um := models.NewUserManager(api.DBM.DB)
 	networks, err := um.GetPrivateIPFSNetworksForUser(ethAddress)
 	if err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("authorized private ipfs network listing requested")
@@ -860,9 +832,10 @@ func (api *API) getUploadsByNetworkName(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -870,12 +843,12 @@ func (api *API) getUploadsByNetworkName(c *gin.Context) {
 	um := models.NewUploadManager(api.DBM.DB)
 	uploads, err := um.FindUploadsByNetwork(networkName)
 	if err != nil {
-		api.LogError(err, UploadSearchError)
+		api.LogError(c, err, UploadSearchError)
 		FailOnError(c, err)
 		return
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("uploads forprivate ifps network requested")
@@ -890,11 +863,12 @@ func (api *API) downloadContentHashForPrivateNetwork(c *gin.Context) {
 		FailNoExistPostForm(c, "network_name")
 		return
 	}
+	auditNetwork(c, networkName)
 
 	ethAddress := GetAuthenticatedUserFromContext(c)
 
 	if err := CheckAccessForPrivateNetwork(ethAddress, networkName, api.DBM.DB); err != nil {
-		api.LogError(err, PrivateNetworkAccessError)
+		api.LogError(c, err, PrivateNetworkAccessError)
 		FailOnError(c, err)
 		return
 	}
@@ -913,7 +887,7 @@ func (api *API) downloadContentHashForPrivateNetwork(c *gin.Context) {
 	im := models.NewHostedIPFSNetworkManager(api.DBM.DB)
 	apiURL, err := im.GetAPIURLByName(networkName)
 	if err != nil {
-		api.LogError(err, APIURLCheckError)
+		api.LogError(c, err, APIURLCheckError)
 		FailOnError(c, err)
 		return
 	}
@@ -924,23 +898,23 @@ func (api *API) downloadContentHashForPrivateNetwork(c *gin.Context) {
 		return
 	}
 	// initialize our connection to IPFS
-	manager, err := rtfs.Initialize("", apiURL)
+	manager, err := api.privateIPFS(apiURL)
 	if err != nil {
-		api.LogError(err, IPFSConnectionError)
+		api.LogError(c, err, IPFSConnectionError)
 		FailOnError(c, err)
 		return
 	}
 	// read the contents of the file
 	reader, err := manager.Shell.Cat(contentHash)
 	if err != nil {
-		api.LogError(err, IPFSCatError)
+		api.LogError(c, err, IPFSCatError)
 		FailOnError(c, err)
 		return
 	}
 	// get the size of hte file in bytes
-	sizeInBytes, err := manager.GetObjectFileSizeInBytes(contentHash)
+	sizeInBytes, err := manager.GetObjectFileSizeInBytesWithContext(c.Request.Context(), contentHash)
 	if err != nil {
-		api.LogError(err, IPFSObjectStatError)
+		api.LogError(c, err, IPFSObjectStatError)
 		FailOnError(c, err)
 		return
 	}
@@ -971,7 +945,7 @@ func (api *API) downloadContentHashForPrivateNetwork(c *gin.Context) {
 		}
 	}
 
-	api.Logger.WithFields(log.Fields{
+	api.logger(c).WithFields(log.Fields{
 		"service": "api",
 		"user":    ethAddress,
 	}).Info("private ipfs content download served")
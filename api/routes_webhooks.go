@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/webhooks"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// createWebhook is used to register a webhook endpoint. The signing secret is
+// only returned here, so the user must store it
+func (api *API) createWebhook(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	url, exists := c.GetPostForm("url")
+	if !exists {
+		FailNoExistPostForm(c, "url")
+		return
+	}
+	eventsString, exists := c.GetPostForm("events")
+	if !exists {
+		FailNoExistPostForm(c, "events")
+		return
+	}
+	if err := webhooks.ValidateURL(url); err != nil {
+		FailOnError(c, err)
+		return
+	}
+	events := []string{}
+	for _, v := range strings.Split(eventsString, ",") {
+		event := strings.TrimSpace(v)
+		if !webhooks.IsEvent(event) {
+			FailOnError(c, fmt.Errorf("unknown event %s", event))
+			return
+		}
+		events = append(events, event)
+	}
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		api.LogError(c, err, WebhookCreationError)
+		FailOnServerError(c, err)
+		return
+	}
+	wm := models.NewWebhookManager(api.DBM.DB)
+	webhook, err := wm.NewWebhook(username, url, secret, events)
+	if err != nil {
+		api.LogError(c, err, WebhookCreationError)
+		FailOnError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": api.Service,
+		"user":    username,
+		"webhook": webhook.ID,
+	}).Info("webhook created")
+
+	Respond(c, http.StatusOK, gin.H{"response": gin.H{
+		"webhook": webhook,
+		"secret":  secret,
+	}})
+}
+
+// getWebhooks is used to list the webhooks registered by the user
+func (api *API) getWebhooks(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	wm := models.NewWebhookManager(api.DBM.DB)
+	hooks, err := wm.FindWebhooksByUserName(username)
+	if err != nil {
+		api.LogError(c, err, WebhookSearchError)
+		FailOnError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": hooks})
+}
+
+// deleteWebhook is used to remove one of the user's webhooks
+func (api *API) deleteWebhook(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		FailOnError(c, err)
+		return
+	}
+	wm := models.NewWebhookManager(api.DBM.DB)
+	if err = wm.DeleteWebhook(username, uint(id)); err != nil {
+		api.LogError(c, err, WebhookDeletionError)
+		FailOnError(c, err)
+		return
+	}
+
+	api.logger(c).WithFields(log.Fields{
+		"service": api.Service,
+		"user":    username,
+		"webhook": id,
+	}).Info("webhook deleted")
+
+	Respond(c, http.StatusOK, gin.H{"response": "webhook deleted"})
+}
+
+// getWebhookDeliveries is used to retrieve the delivery log of the user's webhooks,
+// optionally filtered to one webhook with the webhook_id query parameter
+func (api *API) getWebhookDeliveries(c *gin.Context) {
+	username := GetAuthenticatedUserFromContext(c)
+	var webhookID uint64
+	if id := c.Query("webhook_id"); id != "" {
+		parsed, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			FailOnError(c, err)
+			return
+		}
+		webhookID = parsed
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		FailOnError(c, fmt.Errorf("limit must be between 1 and 200"))
+		return
+	}
+	wm := models.NewWebhookManager(api.DBM.DB)
+	deliveries, err := wm.FindDeliveriesByUserName(username, uint(webhookID), limit)
+	if err != nil {
+		api.LogError(c, err, WebhookSearchError)
+		FailOnError(c, err)
+		return
+	}
+	Respond(c, http.StatusOK, gin.H{"response": deliveries})
+}
@@ -7,7 +7,10 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/RTradeLtd/Temporal/api/middleware"
+	"github.com/RTradeLtd/Temporal/logging"
 	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/queue"
 	"github.com/RTradeLtd/Temporal/utils"
 	jwt "github.com/appleboy/gin-jwt"
 	"github.com/c2h5oh/datasize"
@@ -64,6 +67,20 @@ func FailOnServerError(c *gin.Context, err error) {
 	})
 }
 
+// FailOnPublishError is a failure used when publishing to the queue fails. When rabbitmq
+// did not take responsibility for the message, the request could not be processed and
+// the client may retry later
+func FailOnPublishError(c *gin.Context, err error) {
+	if _, ok := err.(*queue.PublishError); ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"code":     http.StatusServiceUnavailable,
+			"response": err.Error(),
+		})
+		return
+	}
+	FailOnServerError(c, err)
+}
+
 // CheckAccessForPrivateNetwork checks if a user has access to a private network
 func CheckAccessForPrivateNetwork(ethAddress, networkName string, db *gorm.DB) error {
 	um := models.NewUserManager(db)
@@ -85,20 +102,40 @@ func GetAuthenticatedUserFromContext(c *gin.Context) string {
 	return claims["id"].(string)
 }
 
+// auditTarget is used to name what a request acts on in the audit log, by the form naming it
+func auditTarget(c *gin.Context, form, value string) {
+	c.Set(middleware.AuditTargetKey, form+"="+value)
+}
+
+// auditNetwork is used to record the private network a request acts in, in the audit log
+func auditNetwork(c *gin.Context, networkName string) {
+	c.Set(middleware.AuditNetworkKey, networkName)
+}
+
 // Respond is a wrapper used to handle API responses
 func Respond(c *gin.Context, status int, body gin.H) {
 	body["code"] = status
 	c.JSON(status, body)
 }
 
-// LogError is a wrapper used by the API to handle logging of errors
-func (api *API) LogError(err error, message string) {
-	api.Logger.WithFields(log.Fields{
+// LogError is a wrapper used by the API to handle logging of errors, recording the request they occurred in
+func (api *API) LogError(c *gin.Context, err error, message string) {
+	api.logger(c).WithFields(log.Fields{
 		"service": api.Service,
 		"error":   err.Error(),
 	}).Error(message)
 }
 
+// logger is used to generate a log entry recording the id of the request being handled
+func (api *API) logger(c *gin.Context) *log.Entry {
+	return logging.Entry(c.Request.Context(), api.Logger)
+}
+
+// publish is used to send a message to a queue, recording the id of the request being handled
+func (api *API) publish(c *gin.Context, queueName string, body interface{}) error {
+	return api.Queues.PublishContext(c.Request.Context(), queueName, body)
+}
+
 // FileSizeCheck is used to check and validate the size of the uploaded file
 func (api *API) FileSizeCheck(size int64) error {
 	sizeInt, err := strconv.ParseInt(
@@ -115,3 +152,53 @@ func (api *API) FileSizeCheck(size int64) error {
 	}
 	return nil
 }
+
+// parsePage is used to read the cursor, limit, sort and order query parameters of a listing
+func parsePage(c *gin.Context) (models.Page, error) {
+	page := models.Page{
+		Cursor: c.Query("cursor"),
+		SortBy: c.Query("sort"),
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil {
+			return page, fmt.Errorf("limit must be between 1 and %v", models.MaxPageLimit)
+		}
+		page.Limit = parsed
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		page.Ascending = true
+	case "desc":
+	default:
+		return page, errors.New("order must be asc or desc")
+	}
+	return page, nil
+}
+
+// parseTimeQuery is used to read an optional RFC3339 timestamp query parameter
+func parseTimeQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+	}
+	return parsed, nil
+}
+
+// parseCreatedRange is used to read the created_after and created_before query parameters
+func parseCreatedRange(c *gin.Context) (after, before time.Time, err error) {
+	if after, err = parseTimeQuery(c, "created_after"); err != nil {
+		return
+	}
+	before, err = parseTimeQuery(c, "created_before")
+	return
+}
+
+// RespondPage is a wrapper used to respond with a page of a listing
+func RespondPage(c *gin.Context, rows interface{}, info *models.PageInfo) {
+	Respond(c, http.StatusOK, gin.H{"response": rows, "page": info})
+}
@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	//_ "./docs"
 	"github.com/RTradeLtd/Temporal/api"
 	"github.com/RTradeLtd/Temporal/cmd/temporal/app"
 	"github.com/RTradeLtd/Temporal/config"
 	"github.com/RTradeLtd/Temporal/database"
+	"github.com/RTradeLtd/Temporal/health"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/payments"
 	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/rtfs"
+	"github.com/RTradeLtd/Temporal/tracing"
 	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/RTradeLtd/Temporal/webhooks"
 )
 
 var (
@@ -22,6 +37,14 @@ var (
 	certFile = "/home/solidity/certificates/api.pem"
 	keyFile  = "/home/solidity/certificates/api.key"
 	tCfg     config.TemporalConfig
+
+	// configOverrides holds the --key=value config flags we were invoked with
+	configOverrides map[string]string
+
+	// reload is closed once a new configuration is published, which shuts us down like a signal
+	// so that we're restarted with it once our requests and messages have drained
+	reload     = make(chan struct{})
+	reloadOnce sync.Once
 )
 
 var commands = map[string]app.Cmd{
@@ -34,7 +57,10 @@ var commands = map[string]app.Cmd{
 				log.Fatal(err)
 			}
 			api.Logger.Info("API service initialized")
-			err = api.Router.RunTLS(
+			defer startTracing(&cfg, "api")()
+			ctx := shutdownContext()
+			serveMetrics(ctx, &cfg, api.Health)
+			err = api.Serve(ctx,
 				fmt.Sprintf("%s:6767", args["listenAddress"]),
 				args["certFilePath"],
 				args["keyFilePath"])
@@ -45,207 +71,459 @@ var commands = map[string]app.Cmd{
 			}
 		},
 	},
-	"queue": app.Cmd{
-		Blurb:         "execute commands for various queues",
-		Description:   "Interact with Temporal's various queue APIs",
+	"calculate-config-checksum": app.Cmd{
+		Blurb:       "Calculate config file checksum",
+		Description: "Used to calculate the checksum of the config file",
+		Action: func(cfg config.TemporalConfig, args map[string]string) {
+			fileBytes, err := ioutil.ReadFile(args["configDag"])
+			if err != nil {
+				log.Fatal(err)
+			}
+			hash, err := utils.CalculateConfigFileChecksum(fileBytes)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("calculated config file checksum is %s\n", hash)
+			if pinned := os.Getenv(config.ChecksumEnv); pinned != "" {
+				if err = config.VerifyChecksum(fileBytes, pinned); err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("config file matches %s\n", config.ChecksumEnv)
+			}
+		},
+	},
+	"channels": app.Cmd{
+		Blurb:         "payment channel commands",
+		Description:   "Used to manage the payment channels users pay for api requests with",
 		ChildRequired: true,
 		Children: map[string]app.Cmd{
-			"ipfs": app.Cmd{
-				Blurb:         "IPFS queue sub commands",
-				Description:   "Used to launch the various queues that interact with IPFS",
-				ChildRequired: true,
-				Children: map[string]app.Cmd{
-					"ipns-entry": app.Cmd{
-						Blurb:       "IPNS entry creation queue",
-						Description: "Listens to requests to create IPNS records",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpnsEntryQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"pin": app.Cmd{
-						Blurb:       "Pin addition queue",
-						Description: "Listens to pin requests",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpfsPinQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"pin-removal": app.Cmd{
-						Blurb:       "Pin removal queue",
-						Description: "Listens to pin removal requests",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpfsPinRemovalQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"file": app.Cmd{
-						Blurb:       "File upload queue",
-						Description: "Listens to file upload requests. Only applies to advanced uploads",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpfsFileQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"key-creation": app.Cmd{
-						Blurb:       "Key creation queue",
-						Description: fmt.Sprintf("Listen to key creation requests.\nMessages to this queue are broadcasted to all nodes"),
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpfsKeyCreationQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"cluster": app.Cmd{
-						Blurb:       "Cluster pin queue",
-						Description: "Listens to requests to pin content to the cluster",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.IpfsClusterPinQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
+			"settle": app.Cmd{
+				Blurb:       "settle expiring payment channels",
+				Description: "Submits the latest voucher of channels within their settlement window to the payment channel contract",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					dbm, err := database.Initialize(&cfg, false)
+					if err != nil {
+						log.Fatal(err)
+					}
+					cm, err := payments.GenerateChannelManager(dbm.DB, &cfg, true)
+					if err != nil {
+						log.Fatal(err)
+					}
+					// channels which fail are skipped by later batches, so one bad channel doesn't stop the run
+					var skip []string
+					for {
+						settled, err := cm.Settle(context.Background(), payments.SettlementWindow, 50, skip...)
+						for _, v := range settled {
+							fmt.Printf("settled channel %s in transaction %s\n", v.ChannelID, v.SettlementTx)
+						}
+						settleErr, ok := err.(*payments.SettlementError)
+						if err != nil && !ok {
+							log.Fatal(err)
+						}
+						if settleErr != nil {
+							for channelID, err := range settleErr.Failed {
+								log.Printf("failed to settle channel %s: %s", channelID, err)
+								skip = append(skip, channelID)
 							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
+						}
+						if len(settled) == 0 && settleErr == nil {
+							break
+						}
+					}
+					if len(skip) > 0 {
+						fmt.Printf("%v channels failed to settle\n", len(skip))
+					}
+				},
+			},
+		},
+	},
+	"webhooks": app.Cmd{
+		Blurb:         "webhook commands",
+		Description:   "Used to generate webhook events which aren't triggered by a queue",
+		ChildRequired: true,
+		Children: map[string]app.Cmd{
+			"expiring": app.Cmd{
+				Blurb:       "send content expiring events",
+				Description: "Sends content.expiring events for uploads garbage collected in 7 days. Intended to be run once a day",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					dbm, err := database.Initialize(&cfg, false)
+					if err != nil {
+						log.Fatal(err)
+					}
+					qm, err := queue.Initialize(queue.WebhookDeliveryQueue, cfg.RabbitMQ.URL, false)
+					if err != nil {
+						log.Fatal(err)
+					}
+					uploadManager := models.NewUploadManager(dbm.DB)
+					// only look at a one day window so daily runs notify once per upload
+					to := time.Now().Add(7 * 24 * time.Hour)
+					uploads, err := uploadManager.FindUploadsExpiringBetween(to.Add(-24*time.Hour), to)
+					if err != nil {
+						log.Fatal(err)
+					}
+					for _, upload := range *uploads {
+						usernames, err := uploadManager.GetUserNamesForUpload(upload.ID)
+						if err != nil {
+							log.Fatal(err)
+						}
+						for _, username := range usernames {
+							err = qm.PublishMessage(queue.WebhookEvent{
+								UserName: username,
+								Event: webhooks.NewEvent(webhooks.EventContentExpiring, map[string]string{
+									"cid":                  upload.Hash,
+									"network":              upload.NetworkName,
+									"garbage_collect_date": upload.GarbageCollectDate.UTC().Format(time.RFC3339),
+								}),
+							})
 							if err != nil {
 								log.Fatal(err)
 							}
-						},
-					},
+						}
+					}
+					fmt.Printf("queued content expiring events for %v uploads\n", len(*uploads))
 				},
 			},
-			"dfa": app.Cmd{
-				Blurb:       "Database file add queue",
-				Description: "Listens to file uploads requests. Only applies to simple upload route",
+		},
+	},
+	"config": app.Cmd{
+		Blurb:         "configuration commands",
+		Description:   "Used to inspect and check Temporal's configuration",
+		ChildRequired: true,
+		PreRun:        true,
+		Children: map[string]app.Cmd{
+			"validate": app.Cmd{
+				Blurb:       "validate configuration",
+				Description: "Loads our configuration the same way other commands do, and reports any problems without starting anything",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					tCfg, err := loadConfig()
+					if err != nil {
+						log.Fatal(err)
+					}
+					if err = tCfg.Validate(); err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					fmt.Println("configuration is valid")
+				},
+			},
+			"keys": app.Cmd{
+				Blurb:       "list configuration keys",
+				Description: "Lists every configuration key, along with the environment variable which overrides it. Keys can also be set with --key=value",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					for _, key := range config.Keys() {
+						fmt.Printf("%s\t%s\n", key, config.EnvName(key))
+					}
+				},
+			},
+		},
+	},
+	"dev": app.Cmd{
+		Blurb: "run the api and every queue in one process",
+		Description: "Runs the API and a consumer for every queue in a single process, for local development. " +
+			"Set rabbitmq.url to memory:// to use an in-process broker. Postgres is still required, since our schema relies on its features. " +
+			"The API is served without tls when its certificate isn't found",
+		Action: runDev,
+	},
+	"status": app.Cmd{
+		Blurb:       "check dependencies",
+		Description: "Checks that postgres, rabbitmq, ipfs, ipfs cluster, minio and the ethereum rpc can be reached, printing a report and exiting non-zero if any of them can't",
+		Action:      runStatus,
+	},
+	"migrate": app.Cmd{
+		Blurb:         "database migration commands",
+		Description:   "Used to apply, revert and list our versioned database migrations",
+		ChildRequired: true,
+		Children: map[string]app.Cmd{
+			"up": app.Cmd{
+				Blurb:       "apply pending migrations",
+				Description: "Applies every migration which hasn't been applied yet, in order",
 				Action: func(cfg config.TemporalConfig, args map[string]string) {
-					mqConnectionURL := cfg.RabbitMQ.URL
-					qm, err := queue.Initialize(queue.DatabaseFileAddQueue, mqConnectionURL, false, true)
+					dbm, err := database.Initialize(&cfg, false)
 					if err != nil {
 						log.Fatal(err)
 					}
-					err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
+					defer dbm.DB.Close()
+					applied, err := dbm.MigrateUp()
+					for _, m := range applied {
+						fmt.Printf("applied migration %v %s\n", m.Version, m.Name)
+					}
 					if err != nil {
 						log.Fatal(err)
 					}
+					if len(applied) == 0 {
+						fmt.Println("no pending migrations")
+					}
 				},
 			},
-			"payment": app.Cmd{
-				Blurb:         "Payment queue sub commands",
-				Description:   "Used to launch the various queues that interact with our payment backend",
-				ChildRequired: true,
-				Children: map[string]app.Cmd{
-					"pin-confirmation": app.Cmd{
-						Blurb:       "Pin payment confirmation queue",
-						Description: "Listens to pin payment confirmations and stores the pins in our system",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.PinPaymentConfirmationQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
-					"pin-submission": app.Cmd{
-						Blurb:       "Pin payment submission queue",
-						Description: "Listen to pin payment submissions and stores the information in our database",
-						Action: func(cfg config.TemporalConfig, args map[string]string) {
-							mqConnectionURL := cfg.RabbitMQ.URL
-							qm, err := queue.Initialize(queue.PinPaymentSubmissionQueue, mqConnectionURL, false, true)
-							if err != nil {
-								log.Fatal(err)
-							}
-							err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
-							if err != nil {
-								log.Fatal(err)
-							}
-						},
-					},
+			"down": app.Cmd{
+				Blurb:       "revert the latest migration",
+				Description: "Reverts the most recently applied migration. Run it again to revert the one before",
+				Action: func(cfg config.TemporalConfig, args map[string]string) {
+					dbm, err := database.Initialize(&cfg, false)
+					if err != nil {
+						log.Fatal(err)
+					}
+					defer dbm.DB.Close()
+					reverted, err := dbm.MigrateDown()
+					if err != nil {
+						log.Fatal(err)
+					}
+					if reverted == nil {
+						fmt.Println("no migrations have been applied")
+						return
+					}
+					fmt.Printf("reverted migration %v %s\n", reverted.Version, reverted.Name)
 				},
 			},
-			"email-send": app.Cmd{
-				Blurb:       "Email send queue",
-				Description: "Listens to requests to send emails",
+			"status": app.Cmd{
+				Blurb:       "list migrations",
+				Description: "Lists every migration, and when it was applied",
 				Action: func(cfg config.TemporalConfig, args map[string]string) {
-					mqConnectionURL := cfg.RabbitMQ.URL
-					qm, err := queue.Initialize(queue.EmailSendQueue, mqConnectionURL, false, true)
+					dbm, err := database.Initialize(&cfg, false)
 					if err != nil {
 						log.Fatal(err)
 					}
-					err = qm.ConsumeMessage("", args["dbPass"], args["dbURL"], args["dbUser"], &cfg)
+					defer dbm.DB.Close()
+					statuses, err := dbm.MigrationStatuses()
 					if err != nil {
 						log.Fatal(err)
 					}
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+					for _, s := range statuses {
+						applied := "pending"
+						if s.AppliedAt != nil {
+							applied = s.AppliedAt.Format(time.RFC3339)
+						}
+						fmt.Fprintf(w, "%v\t%s\t%s\n", s.Version, s.Name, applied)
+					}
+					w.Flush()
 				},
 			},
 		},
 	},
-	"calculate-config-checksum": app.Cmd{
-		Blurb:       "Calculate config file checksum",
-		Description: "Used to calculate the checksum of the config file",
-		Action: func(cfg config.TemporalConfig, args map[string]string) {
-			fileBytes, err := ioutil.ReadFile(args["configDag"])
-			if err != nil {
-				log.Fatal(err)
-			}
-			hash, err := utils.CalculateConfigFileChecksum(fileBytes)
-			if err != nil {
-				log.Fatal(err)
-			}
-			fmt.Printf("calculated config file checksum is %s\n", hash)
-			// TODO: hardcode the checksum value so we can do a check here
-		},
+}
+
+// queueGroups are the queue sub commands which group several queues together
+var queueGroups = map[string]app.Cmd{
+	"ipfs": app.Cmd{
+		Blurb:         "IPFS queue sub commands",
+		Description:   "Used to launch the various queues that interact with IPFS",
+		ChildRequired: true,
 	},
-	"migrate": app.Cmd{
-		Blurb:       "run database migrations",
-		Description: "Runs our initial database migrations, creating missing tables, etc..",
-		Action: func(cfg config.TemporalConfig, args map[string]string) {
-			dbm, err := database.Initialize(&cfg, false)
-			if err != nil {
-				log.Fatal(err)
-			}
-			dbm.RunMigrations()
-		},
+	"payment": app.Cmd{
+		Blurb:         "Payment queue sub commands",
+		Description:   "Used to launch the various queues that interact with our payment backend",
+		ChildRequired: true,
 	},
 }
 
+func init() {
+	commands["queue"] = app.Cmd{
+		Blurb:         "execute commands for various queues",
+		Description:   "Interact with Temporal's various queue APIs",
+		ChildRequired: true,
+		Children:      queueCommands(),
+	}
+}
+
+// queueCommands is used to generate a sub command consuming each of our registered queues
+func queueCommands() map[string]app.Cmd {
+	children := make(map[string]app.Cmd)
+	for _, route := range queue.Routes() {
+		cmd := app.Cmd{
+			Blurb:       route.Blurb,
+			Description: route.Description,
+			Action:      consumeQueue(route.Queue),
+		}
+		name := route.Command[len(route.Command)-1]
+		if len(route.Command) == 1 {
+			children[name] = cmd
+			continue
+		}
+		group, ok := children[route.Command[0]]
+		if !ok {
+			group = queueGroups[route.Command[0]]
+			group.Children = make(map[string]app.Cmd)
+		}
+		group.Children[name] = cmd
+		children[route.Command[0]] = group
+	}
+	return children
+}
+
+// consumeQueue is used to generate the action which consumes messages from queueName until we are shut down
+func consumeQueue(queueName string) func(cfg config.TemporalConfig, args map[string]string) {
+	return func(cfg config.TemporalConfig, args map[string]string) {
+		qm, err := queue.Initialize(queueName, cfg.RabbitMQ.URL, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbm, err := database.Initialize(&cfg, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer dbm.DB.Close()
+		route, _ := queue.Lookup(queueName)
+		checker := health.NewCheckerFromConfig(&cfg)
+		checker.Add(health.Postgres, health.Database(dbm.DB))
+		checker.Add(health.RabbitMQ, health.Broker(qm.Broker))
+		checker.AddDependencies(&cfg, route.Dependencies...)
+		ctx := shutdownContext()
+		serveMetrics(ctx, &cfg, checker)
+		defer startTracing(&cfg, qm.Service)()
+		if err = qm.Consume(ctx, "", dbm.DB, &cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// runDev is used to run the api and a consumer for every registered queue until we are shut down.
+// If any of them fails everything is stopped: the api drains its requests first, so the messages
+// they publish are still consumed, and then each consumer finishes its in-flight messages
+func runDev(cfg config.TemporalConfig, args map[string]string) {
+	var broker queue.Broker
+	if cfg.RabbitMQ.URL == queue.MemoryURL {
+		broker = queue.NewMemoryBroker()
+	} else {
+		rabbit, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, 20)
+		if err != nil {
+			log.Fatal(err)
+		}
+		broker = rabbit
+	}
+	defer broker.Close()
+	// a development database may be brand new, so we make sure our tables exist
+	dbm, err := database.Initialize(&cfg, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dbm.DB.Close()
+	service, err := api.InitializeShared(&cfg, false, api.Shared{Broker: broker, DB: dbm})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := context.WithCancel(shutdownContext())
+	defer stop()
+	// every queue runs here, so the api's checks cover each of their dependencies
+	serveMetrics(ctx, &cfg, service.Health)
+	defer startTracing(&cfg, "dev")()
+	consumerCtx, stopConsumers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, route := range queue.Routes() {
+		qm, err := queue.NewQueueManager(route.Queue, broker, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wg.Add(1)
+		go func(qm *queue.QueueManager) {
+			defer wg.Done()
+			if err := qm.Consume(consumerCtx, "", dbm.DB, &cfg); err != nil {
+				log.Printf("%s consumer failed: %s", qm.Service, err)
+				stop()
+			}
+		}(qm)
+	}
+	log.Printf("running api and %v queues", len(queue.Routes()))
+
+	certFile := args["certFilePath"]
+	if _, err := os.Stat(certFile); err != nil {
+		certFile = ""
+	}
+	err = service.Serve(ctx, fmt.Sprintf("%s:6767", args["listenAddress"]), certFile, args["keyFilePath"])
+	if err != nil {
+		log.Printf("api failed: %s", err)
+	}
+	stopConsumers()
+	wg.Wait()
+	log.Print("all services stopped")
+}
+
+// serveMetrics is used to serve our prometheus metrics and the health probes of checker
+// until ctx is done, if an address is configured
+func serveMetrics(ctx context.Context, cfg *config.TemporalConfig, checker *health.Checker) {
+	addr := cfg.Metrics.ListenAddress
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	checker.Register(mux)
+	go func() {
+		if err := metrics.Serve(ctx, addr, mux); err != nil {
+			log.Printf("failed to serve metrics on %s: %s", addr, err)
+		}
+	}()
+}
+
+// runStatus is used to check every dependency in our configuration, printing a report
+// and exiting with a non-zero status if any of them can't be reached
+func runStatus(cfg config.TemporalConfig, args map[string]string) {
+	checker := health.NewCheckerFromConfig(&cfg)
+	if dbm, err := database.Initialize(&cfg, false); err != nil {
+		checker.Failed(health.Postgres, err)
+	} else {
+		defer dbm.DB.Close()
+		checker.Add(health.Postgres, health.Database(dbm.DB))
+	}
+	// an in-memory broker only exists within the process running it, so there is nothing to check
+	if cfg.RabbitMQ.URL != queue.MemoryURL {
+		if broker, err := queue.NewRabbitMQ(cfg.RabbitMQ.URL, 1); err != nil {
+			checker.Failed(health.RabbitMQ, err)
+		} else {
+			defer broker.Close()
+			checker.Add(health.RabbitMQ, health.Broker(broker))
+		}
+	}
+	dependencies := []string{health.IPFS, health.Cluster, health.Minio}
+	if cfg.Ethereum.Connection.INFURA.URL != "" {
+		dependencies = append(dependencies, health.Ethereum)
+	}
+	checker.AddDependencies(&cfg, dependencies...)
+	report := checker.Run(context.Background())
+	if err := report.Print(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	if !report.Healthy {
+		os.Exit(1)
+	}
+}
+
+// startTracing is used to export the spans we record, if an exporter is configured.
+// The returned func exports any spans still buffered, and must be called before exiting
+func startTracing(cfg *config.TemporalConfig, service string) func() {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint, service)
+	case "stdout":
+		exporter = tracing.NewStdoutExporter(os.Stdout)
+	default:
+		return func() {}
+	}
+	tracer := tracing.NewTracer(service, exporter)
+	tracer.OnError = func(err error) {
+		log.Printf("failed to export spans: %s", err)
+	}
+	tracer.Start()
+	tracing.SetTracer(tracer)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Printf("failed to export remaining spans: %s", err)
+		}
+	}
+}
+
 func main() {
+	// separate config overrides from our commands
+	overrides, args, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	configOverrides = overrides
+
 	// create app
 	temporal := app.New(commands, app.Config{
 		Name:     "Temporal",
@@ -255,20 +533,22 @@ func main() {
 	})
 
 	// run no-config commands
-	exit := temporal.PreRun(os.Args[1:])
+	exit := temporal.PreRun(args)
 	if exit == app.CodeOK {
 		os.Exit(0)
 	}
 
 	// load config
 	configDag := os.Getenv("CONFIG_DAG")
-	if configDag == "" {
-		log.Fatal("CONFIG_DAG is not set")
-	}
-	tCfg, err := config.LoadConfig(configDag)
+	tCfg, err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err = tCfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	rtfs.DefaultURL = tCfg.IPFSAPIURL()
+	queue.LogOptions = logging.FromConfig(tCfg)
 
 	// load arguments
 	flags := map[string]string{
@@ -282,6 +562,67 @@ func main() {
 		"dbUser": tCfg.Database.Username,
 	}
 
+	// long running processes reload when their ipns config changes
+	if config.IsIPNSPath(configDag) && len(args) > 0 && (args[0] == "api" || args[0] == "queue" || args[0] == "dev") {
+		go watchConfig(tCfg, configDag)
+	}
+
 	// execute
-	os.Exit(temporal.Run(*tCfg, flags, os.Args[1:]))
+	code := temporal.Run(*tCfg, flags, args)
+	select {
+	case <-reload:
+		restart()
+	default:
+	}
+	os.Exit(code)
+}
+
+// shutdownContext returns a context which is cancelled when we receive SIGINT or SIGTERM,
+// or a new configuration is published
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-reload:
+		}
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
+// watchConfig is used to poll our ipns config, and shut down gracefully when a new valid
+// configuration is published, so that main restarts us with it
+func watchConfig(cfg *config.TemporalConfig, path string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := config.NewWatcher(cfg, path, configOverrides, time.Minute)
+	w.OnError = func(err error) {
+		log.Printf("failed to check for config update: %s", err)
+	}
+	w.OnChange = func(*config.TemporalConfig) {
+		log.Printf("config at %s has changed, draining before reloading", path)
+		reloadOnce.Do(func() { close(reload) })
+		cancel()
+	}
+	w.Run(ctx)
+}
+
+// restart is used to replace our process with a new one, run with the same arguments
+func restart() {
+	bin, err := os.Executable()
+	if err != nil {
+		log.Fatalf("failed to reload config: %s", err)
+	}
+	if err = syscall.Exec(bin, os.Args, os.Environ()); err != nil {
+		log.Fatalf("failed to reload config: %s", err)
+	}
+}
+
+// loadConfig is used to load our configuration from defaults, the file at CONFIG_DAG if set,
+// TEMPORAL_ environment variables and --key=value flags
+func loadConfig() (*config.TemporalConfig, error) {
+	return config.Load(os.Getenv("CONFIG_DAG"), configOverrides)
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -11,6 +12,7 @@ type TemporalConfig struct {
 	Database struct {
 		Name     string `json:"name"`
 		URL      string `json:"url"`
+		Port     string `json:"port"`
 		Username string `json:"username"`
 		Password string `json:"password"`
 	} `json:"database"`
@@ -30,6 +32,14 @@ type TemporalConfig struct {
 		RollbarToken         string `json:"rollbar_token"`
 		JwtKey               string `json:"jwt_key"`
 		SizeLimitInGigaBytes string `json:"size_limit_in_giga_bytes"`
+		// AuditKey is the secret our audit log is chained with. It should be kept out of our
+		// database, so those with access to it can't rewrite the log
+		AuditKey string `json:"audit_key"`
+		// ChannelRequestCostInWei is the amount every request paid through a payment channel voucher must cover
+		ChannelRequestCostInWei string `json:"channel_request_cost_in_wei"`
+		// ChannelRequiredGroups are the route groups, keyed by their path under /api/v1 such as ipfs,
+		// which only accept requests paid with a voucher. Other groups accept vouchers without requiring them
+		ChannelRequiredGroups []string `json:"channel_required_groups"`
 	} `json:"api"`
 	IPFS struct {
 		APIConnection struct {
@@ -62,11 +72,21 @@ type TemporalConfig struct {
 			} `json:"infura"`
 		} `json:"connection"`
 		Contracts struct {
-			PaymentContractAddress string `json:"payment_contract_address"`
+			PaymentContractAddress        string `json:"payment_contract_address"`
+			PaymentChannelContractAddress string `json:"payment_channel_contract_address"`
 		} `json:"contracts"`
 	} `json:"ethereum"`
 	RabbitMQ struct {
+		// URL is the amqp url of our broker. memory:// runs an in-process broker,
+		// which is only usable when every service runs in one process
 		URL string `json:"url"`
+		// Consumer controls how queue processes handle deliveries. Each queue runs in
+		// its own process, so these can be set per queue with flags or the environment
+		Consumer struct {
+			Workers               int `json:"workers"`
+			Prefetch              int `json:"prefetch"`
+			MessageTimeoutSeconds int `json:"message_timeout_seconds"`
+		} `json:"consumer"`
 	} `json:"rabbitmq"`
 	AWS struct {
 		KeyID  string `json:"key_id"`
@@ -85,31 +105,175 @@ type TemporalConfig struct {
 		EmailAddress string `json:"email_address"`
 		EmailName    string `json:"email_name"`
 	} `json:"sendgrid"`
+	Mail struct {
+		// Transport is one of sendgrid, smtp or sink, defaulting to sendgrid
+		Transport     string `json:"transport"`
+		FromAddress   string `json:"from_address"`
+		FromName      string `json:"from_name"`
+		SinkDirectory string `json:"sink_directory"`
+		SMTP          struct {
+			Host     string `json:"host"`
+			Port     string `json:"port"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"smtp"`
+	} `json:"mail"`
+	// Accounts controls the links sent in account emails, and how long their tokens remain valid
+	Accounts struct {
+		// VerifyEmailURL and ResetPasswordURL are the pages of our web app which accept
+		// emailed tokens, given as a token query parameter. Emails only hold the token when empty
+		VerifyEmailURL   string `json:"verify_email_url"`
+		ResetPasswordURL string `json:"reset_password_url"`
+		// VerificationTTLHours is how long email verification tokens remain valid
+		VerificationTTLHours int `json:"verification_ttl_hours"`
+		// PasswordResetTTLMinutes is how long password reset tokens remain valid
+		PasswordResetTTLMinutes int `json:"password_reset_ttl_minutes"`
+		// SignInDomain is the domain users are told they're signing in to when signing in
+		// with their ethereum address. The api requires it, since the host a request names can't be trusted
+		SignInDomain string `json:"sign_in_domain"`
+		// SignInChallengeTTLMinutes is how long sign in challenges remain valid
+		SignInChallengeTTLMinutes int `json:"sign_in_challenge_ttl_minutes"`
+	} `json:"accounts"`
+	// RateLimits controls the token buckets limiting how often each user, and each ip address
+	// signing in, can make requests to the api
+	RateLimits struct {
+		// Store is memory, keeping buckets in each api replica, or redis, sharing them between
+		// replicas. Rate limiting is disabled when empty
+		Store string `json:"store"`
+		// RedisURL is the redis:// or rediss:// url of a redis compatible server, used by the redis store
+		RedisURL string `json:"redis_url"`
+		// Auth limits the requests from each ip address to the authentication routes
+		Auth RateLimit `json:"auth"`
+		// Standard and Enterprise are the limits for users of each account tier
+		Standard   RateLimitTier `json:"standard"`
+		Enterprise RateLimitTier `json:"enterprise"`
+	} `json:"rate_limits"`
+	// Metrics controls the prometheus endpoint served by the api and queue workers. Each queue
+	// runs in its own process, so the address is set per queue with flags or the environment
+	Metrics struct {
+		// ListenAddress is the host:port the api and workers serve /metrics and their health
+		// probes on, which is disabled when empty. It shouldn't be reachable by users
+		ListenAddress string `json:"listen_address"`
+	} `json:"metrics"`
+	// Health controls the dependency checks behind our readiness probes and the status command
+	Health struct {
+		// TimeoutSeconds is how long each dependency is given to respond
+		TimeoutSeconds int `json:"timeout_seconds"`
+	} `json:"health"`
+	// Tracing controls where the spans recorded by the api and queue workers are exported
+	Tracing struct {
+		// Exporter is otlp or stdout, and tracing is disabled when empty
+		Exporter string `json:"exporter"`
+		// OTLPEndpoint is the traces url of an OpenTelemetry collector accepting otlp over http
+		OTLPEndpoint string `json:"otlp_endpoint"`
+	} `json:"tracing"`
+	// LogDir is the directory service log files are written to
+	LogDir string `json:"log_dir"`
+	Log    struct {
+		// Output is stdout, file or syslog, defaulting to a file in LogDir for each service
+		Output string `json:"output"`
+		// Format is text or json
+		Format string `json:"format"`
+		// Level is one of debug, info, warn or error
+		Level string `json:"level"`
+		// SyslogAddress is the host:port of a remote syslog daemon, using the local daemon when empty
+		SyslogAddress string `json:"syslog_address"`
+	} `json:"log"`
 }
 
+// RateLimit is a token bucket allowing bursts of up to Burst requests, refilled at RequestsPerMinute
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+// RateLimitTier is the limits for an account tier
+type RateLimitTier struct {
+	// Default applies to every route group not listed in Groups
+	Default RateLimit `json:"default"`
+	// Groups are limits for route groups, keyed by their path under /api/v1, such as ipfs or database
+	Groups map[string]RateLimit `json:"groups"`
+}
+
+// Defaults returns the configuration values used when they aren't set by any other layer
+func Defaults() *TemporalConfig {
+	tCfg := TemporalConfig{}
+	tCfg.Database.Name = "temporal"
+	tCfg.Database.Port = "5433"
+	tCfg.Database.Username = "postgres"
+	tCfg.IPFS.APIConnection.Host = "localhost"
+	tCfg.IPFS.APIConnection.Port = "5001"
+	tCfg.IPFSCluster.APIConnection.Host = "localhost"
+	tCfg.IPFSCluster.APIConnection.Port = "9094"
+	tCfg.Mail.Transport = "sendgrid"
+	tCfg.Mail.SMTP.Port = "587"
+	tCfg.RabbitMQ.Consumer.Workers = 4
+	tCfg.RabbitMQ.Consumer.Prefetch = 8
+	tCfg.RabbitMQ.Consumer.MessageTimeoutSeconds = 600
+	tCfg.LogDir = "/var/log/temporal"
+	tCfg.Log.Output = "file"
+	tCfg.Log.Format = "text"
+	tCfg.Log.Level = "info"
+	tCfg.Accounts.VerificationTTLHours = 48
+	tCfg.Accounts.PasswordResetTTLMinutes = 60
+	tCfg.Accounts.SignInChallengeTTLMinutes = 5
+	tCfg.RateLimits.Store = "memory"
+	tCfg.RateLimits.Auth = RateLimit{RequestsPerMinute: 30, Burst: 10}
+	tCfg.RateLimits.Standard.Default = RateLimit{RequestsPerMinute: 120, Burst: 60}
+	tCfg.RateLimits.Enterprise.Default = RateLimit{RequestsPerMinute: 600, Burst: 300}
+	tCfg.Health.TimeoutSeconds = 5
+	tCfg.Tracing.OTLPEndpoint = "http://localhost:4318/v1/traces"
+	return &tCfg
+}
+
+// LoadConfig is used to load our configuration from a json file, layered on top
+// of our defaults and overridden by any TEMPORAL_ environment variables
 func LoadConfig(configPath string) (*TemporalConfig, error) {
-	var tCfg TemporalConfig
-	raw, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return nil, err
+	return Load(configPath, nil)
+}
+
+// Load is used to build our configuration from, in order of increasing precedence,
+// our defaults, the json file at configPath (skipped if empty, and fetched from ipfs
+// if it is an /ipfs/ or /ipns/ path), TEMPORAL_ environment
+// variables, and overrides keyed by the dotted json path of each value, typically
+// parsed from command line flags. Secret references are resolved last
+func Load(configPath string, overrides map[string]string) (*TemporalConfig, error) {
+	tCfg := Defaults()
+	switch {
+	case IsIPFSPath(configPath):
+		if err := tCfg.loadFromIPFS(configPath, overrides); err != nil {
+			return nil, err
+		}
+	case configPath != "":
+		raw, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, tCfg); err != nil {
+			return nil, err
+		}
 	}
-	err = json.Unmarshal(raw, &tCfg)
-	if err != nil {
+	if err := tCfg.applyLayers(overrides); err != nil {
 		return nil, err
 	}
-	return &tCfg, nil
+	return tCfg, nil
 }
 
-/*
-// LoadConfig is used to load a config object, from the cid
-func LoadConfig(cfgCid string) *TemporalConfig {
-	var tCfg TemporalConfig
-	configManager := ipdcfg.Initialize("")
-	config := configManager.LoadConfig(cfgCid)
-	err := json.Unmarshal(config, &tCfg)
-	if err != nil {
-		log.Fatal(err)
+// applyLayers is used to apply environment and flag overrides on top of the config file,
+// and then resolve secret references
+func (tCfg *TemporalConfig) applyLayers(overrides map[string]string) error {
+	if err := tCfg.applyEnv(); err != nil {
+		return err
 	}
-	return &tCfg
+	for k, v := range overrides {
+		if err := tCfg.Set(k, v); err != nil {
+			return err
+		}
+	}
+	return tCfg.resolveSecrets()
+}
+
+// IPFSAPIURL returns the address of our ipfs node's api
+func (tCfg *TemporalConfig) IPFSAPIURL() string {
+	return fmt.Sprintf("%s:%s", tCfg.IPFS.APIConnection.Host, tCfg.IPFS.APIConnection.Port)
 }
-*/
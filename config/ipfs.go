@@ -0,0 +1,216 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	ipfsapi "github.com/RTradeLtd/go-ipfs-api"
+)
+
+/*
+Configuration can be loaded from ipfs by setting CONFIG_DAG to an /ipfs/ or /ipns/ path.
+The path must point to a directory containing the config file and its checksum, as
+calculated by temporal calculate-config-checksum:
+
+	config.json
+	config.checksum
+
+Every load verifies config.json against config.checksum. If CONFIG_CHECKSUM is set, the
+checksum must also match it, which pins the configuration to a known version.
+
+When CONFIG_DAG is an /ipns/ path, long running processes poll the name and restart
+with the new configuration whenever it is republished, so a fleet can be reconfigured
+with a single publish. Pinning a checksum disables this, since any new version would
+fail verification.
+*/
+
+const (
+	// ConfigFileName is the name of the config file within an ipfs config directory
+	ConfigFileName = "config.json"
+	// ChecksumFileName is the name of the checksum file within an ipfs config directory
+	ChecksumFileName = "config.checksum"
+	// ChecksumEnv is the environment variable used to pin the expected config checksum
+	ChecksumEnv = "CONFIG_CHECKSUM"
+)
+
+// Fetcher is used to retrieve configuration from ipfs
+type Fetcher interface {
+	// ResolvePath resolves an /ipfs/ or /ipns/ path to the /ipfs/ path it currently points to
+	ResolvePath(path string) (string, error)
+	// Cat returns the contents of an /ipfs/ path
+	Cat(path string) ([]byte, error)
+}
+
+// IsIPFSPath is used to check whether a config location refers to ipfs rather than a local file
+func IsIPFSPath(path string) bool {
+	return strings.HasPrefix(path, "/ipfs/") || strings.HasPrefix(path, "/ipns/")
+}
+
+// IsIPNSPath is used to check whether a config location is a mutable ipns name
+func IsIPNSPath(path string) bool {
+	return strings.HasPrefix(path, "/ipns/")
+}
+
+type shellFetcher struct {
+	shell *ipfsapi.Shell
+}
+
+// NewShellFetcher is used to fetch configuration through the api of an ipfs node
+func NewShellFetcher(url string) Fetcher {
+	shell := ipfsapi.NewShell(url)
+	shell.SetTimeout(time.Minute)
+	return &shellFetcher{shell: shell}
+}
+
+func (sf *shellFetcher) ResolvePath(path string) (string, error) {
+	resolved, err := sf.shell.ResolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	return "/ipfs/" + resolved, nil
+}
+
+func (sf *shellFetcher) Cat(path string) ([]byte, error) {
+	reader, err := sf.shell.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// FetchFromIPFS is used to retrieve and verify the config file in the ipfs directory at path.
+// It returns the verified file contents, and the /ipfs/ path the directory resolved to
+func FetchFromIPFS(fetcher Fetcher, path string) ([]byte, string, error) {
+	resolved, err := fetcher.ResolvePath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := fetcher.Cat(resolved + "/" + ConfigFileName)
+	if err != nil {
+		return nil, "", err
+	}
+	checksum, err := fetcher.Cat(resolved + "/" + ChecksumFileName)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = VerifyChecksum(raw, strings.TrimSpace(string(checksum))); err != nil {
+		return nil, "", err
+	}
+	if pinned := os.Getenv(ChecksumEnv); pinned != "" {
+		if err = VerifyChecksum(raw, pinned); err != nil {
+			return nil, "", fmt.Errorf("config does not match %s: %s", ChecksumEnv, err)
+		}
+	}
+	return raw, resolved, nil
+}
+
+// VerifyChecksum is used to check a config file against its expected checksum
+func VerifyChecksum(raw []byte, expected string) error {
+	actual, err := utils.CalculateConfigFileChecksum(raw)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(strings.TrimPrefix(expected, "0x"), actual) {
+		return fmt.Errorf("config checksum mismatch, expected %s got %s", expected, actual)
+	}
+	return nil
+}
+
+// loadFromIPFS is used to read the config file layer from ipfs. The ipfs node is located
+// using our defaults, environment and overrides, since the config file can't tell us where it is
+func (tCfg *TemporalConfig) loadFromIPFS(path string, overrides map[string]string) error {
+	locator := Defaults()
+	if err := locator.applyLayers(overrides); err != nil {
+		return err
+	}
+	raw, _, err := FetchFromIPFS(NewShellFetcher(locator.IPFSAPIURL()), path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, tCfg)
+}
+
+// Watcher polls an ipns config location, and reports each new verified configuration
+type Watcher struct {
+	Path      string
+	Interval  time.Duration
+	Overrides map[string]string
+	Fetcher   Fetcher
+	// OnChange is called with each new valid configuration
+	OnChange func(cfg *TemporalConfig)
+	// OnError is called when polling or validating a new configuration fails.
+	// The current configuration remains in use
+	OnError func(err error)
+
+	current string
+}
+
+// NewWatcher is used to watch the ipns config at path, using the ipfs node in cfg
+func NewWatcher(cfg *TemporalConfig, path string, overrides map[string]string, interval time.Duration) *Watcher {
+	return &Watcher{
+		Path:      path,
+		Interval:  interval,
+		Overrides: overrides,
+		Fetcher:   NewShellFetcher(cfg.IPFSAPIURL()),
+		OnChange:  func(*TemporalConfig) {},
+		OnError:   func(error) {},
+	}
+}
+
+// Run polls until ctx is cancelled. The first poll records the current version
+// without calling OnChange
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	w.Poll()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Poll()
+		}
+	}
+}
+
+// Poll checks the ipns name once, calling OnChange if it points to a new valid configuration
+func (w *Watcher) Poll() {
+	resolved, err := w.Fetcher.ResolvePath(w.Path)
+	if err != nil {
+		w.OnError(err)
+		return
+	}
+	if resolved == w.current {
+		return
+	}
+	raw, resolved, err := FetchFromIPFS(w.Fetcher, resolved)
+	if err != nil {
+		w.OnError(err)
+		return
+	}
+	cfg := Defaults()
+	if err = json.Unmarshal(raw, cfg); err != nil {
+		w.OnError(err)
+		return
+	}
+	if err = cfg.applyLayers(w.Overrides); err != nil {
+		w.OnError(err)
+		return
+	}
+	if err = cfg.Validate(); err != nil {
+		w.OnError(err)
+		return
+	}
+	first := w.current == ""
+	w.current = resolved
+	if !first {
+		w.OnChange(cfg)
+	}
+}
@@ -0,0 +1,99 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/utils"
+)
+
+// fakeFetcher serves ipfs config directories from memory
+type fakeFetcher struct {
+	names map[string]string
+	files map[string][]byte
+}
+
+func (ff *fakeFetcher) ResolvePath(path string) (string, error) {
+	if strings.HasPrefix(path, "/ipfs/") {
+		return path, nil
+	}
+	resolved, ok := ff.names[path]
+	if !ok {
+		return "", errors.New("name not found")
+	}
+	return resolved, nil
+}
+
+func (ff *fakeFetcher) Cat(path string) ([]byte, error) {
+	b, ok := ff.files[path]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	return b, nil
+}
+
+func (ff *fakeFetcher) publish(t *testing.T, dir, contents string, corrupt bool) {
+	checksum, err := utils.CalculateConfigFileChecksum([]byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrupt {
+		contents += " "
+	}
+	ff.files[dir+"/"+config.ConfigFileName] = []byte(contents)
+	ff.files[dir+"/"+config.ChecksumFileName] = []byte(checksum + "\n")
+	ff.names["/ipns/temporal"] = dir
+}
+
+func TestFetchFromIPFS(t *testing.T) {
+	ff := &fakeFetcher{names: map[string]string{}, files: map[string][]byte{}}
+	ff.publish(t, "/ipfs/QmOne", `{"log_dir": "/tmp"}`, false)
+	raw, resolved, err := config.FetchFromIPFS(ff, "/ipns/temporal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != "/ipfs/QmOne" || string(raw) != `{"log_dir": "/tmp"}` {
+		t.Fatalf("unexpected config %s from %s", raw, resolved)
+	}
+	ff.publish(t, "/ipfs/QmTwo", `{"log_dir": "/tmp"}`, true)
+	if _, _, err = config.FetchFromIPFS(ff, "/ipns/temporal"); err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	ff := &fakeFetcher{names: map[string]string{}, files: map[string][]byte{}}
+	valid := `{"database": {"url": "127.0.0.1"}, "rabbitmq": {"url": "amqp://localhost"},
+		"api": {"audit_key": "test"}, "log_dir": "/one"}`
+	ff.publish(t, "/ipfs/QmOne", valid, false)
+
+	var (
+		changes []*config.TemporalConfig
+		errs    []error
+	)
+	w := &config.Watcher{
+		Path:     "/ipns/temporal",
+		Fetcher:  ff,
+		OnChange: func(cfg *config.TemporalConfig) { changes = append(changes, cfg) },
+		OnError:  func(err error) { errs = append(errs, err) },
+	}
+	// the first poll only records the current version
+	w.Poll()
+	w.Poll()
+	if len(changes) != 0 || len(errs) != 0 {
+		t.Fatal("expected no changes before republishing")
+	}
+	// an invalid config is reported and ignored
+	ff.publish(t, "/ipfs/QmTwo", `{"log_dir": ""}`, false)
+	w.Poll()
+	if len(changes) != 0 || len(errs) != 1 {
+		t.Fatal("expected invalid config to be rejected")
+	}
+	ff.publish(t, "/ipfs/QmThree", strings.Replace(valid, "/one", "/three", 1), false)
+	w.Poll()
+	if len(changes) != 1 || changes[0].LogDir != "/three" {
+		t.Fatal("expected new config to be reported")
+	}
+}
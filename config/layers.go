@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// EnvPrefix is prepended to the upper cased, underscore separated key of a
+	// value to form the environment variable which overrides it
+	EnvPrefix = "TEMPORAL_"
+	// FileSecretPrefix marks a value which should be read from a file
+	FileSecretPrefix = "file:"
+	// EnvSecretPrefix marks a value which should be read from an environment variable
+	EnvSecretPrefix = "env:"
+)
+
+// Keys returns the dotted key of every value in our configuration, such as database.password
+func Keys() []string {
+	keys := []string{}
+	walk(reflect.ValueOf(Defaults()).Elem(), "", func(key string, _ reflect.Value) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
+
+// EnvName returns the environment variable which overrides the value at key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// Set is used to set the value at a dotted key, such as database.password
+func (tCfg *TemporalConfig) Set(key, value string) error {
+	found := false
+	err := walk(reflect.ValueOf(tCfg).Elem(), "", func(k string, field reflect.Value) error {
+		if k != key {
+			return nil
+		}
+		found = true
+		return setValue(field, value)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", key, err)
+	}
+	if !found {
+		return fmt.Errorf("unknown config key %s", key)
+	}
+	return nil
+}
+
+// ParseFlags is used to separate --key=value config overrides from the rest of
+// our command line arguments. Arguments after a bare -- are never treated as overrides
+func ParseFlags(args []string) (map[string]string, []string, error) {
+	overrides := make(map[string]string)
+	rest := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("config flag %s must be of the form --key=value", arg)
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides, rest, nil
+}
+
+// applyEnv is used to override values with any matching TEMPORAL_ environment variables
+func (tCfg *TemporalConfig) applyEnv() error {
+	return walk(reflect.ValueOf(tCfg).Elem(), "", func(key string, field reflect.Value) error {
+		value, ok := os.LookupEnv(EnvName(key))
+		if !ok {
+			return nil
+		}
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", EnvName(key), err)
+		}
+		return nil
+	})
+}
+
+// resolveSecrets is used to replace file: and env: references with the value they point to,
+// so secrets don't need to be stored in the config file itself
+func (tCfg *TemporalConfig) resolveSecrets() error {
+	return walk(reflect.ValueOf(tCfg).Elem(), "", func(key string, field reflect.Value) error {
+		if field.Kind() != reflect.String {
+			return nil
+		}
+		value := field.String()
+		switch {
+		case strings.HasPrefix(value, FileSecretPrefix):
+			contents, err := ioutil.ReadFile(strings.TrimPrefix(value, FileSecretPrefix))
+			if err != nil {
+				return fmt.Errorf("failed to resolve secret for %s: %s", key, err)
+			}
+			field.SetString(strings.TrimSpace(string(contents)))
+		case strings.HasPrefix(value, EnvSecretPrefix):
+			name := strings.TrimPrefix(value, EnvSecretPrefix)
+			secret, ok := os.LookupEnv(name)
+			if !ok {
+				return fmt.Errorf("failed to resolve secret for %s: %s is not set", key, name)
+			}
+			field.SetString(secret)
+		}
+		return nil
+	})
+}
+
+// walk calls fn with the dotted key and value of every leaf field in v
+func walk(v reflect.Value, prefix string, fn func(key string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walk(field, key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(key, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldKey returns the json name of a field, falling back to the snake cased field name
+func fieldKey(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	var b strings.Builder
+	for i, r := range f.Name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"math/big"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ValidationError holds every problem found when validating a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	return "invalid configuration:\n\t" + strings.Join(ve.Problems, "\n\t")
+}
+
+func (ve *ValidationError) add(problem string) {
+	ve.Problems = append(ve.Problems, problem)
+}
+
+// Validate is used to check our configuration for missing or malformed values,
+// returning a *ValidationError listing all of them
+func (tCfg *TemporalConfig) Validate() error {
+	ve := &ValidationError{}
+	required := map[string]string{
+		"database.url": tCfg.Database.URL,
+		"rabbitmq.url": tCfg.RabbitMQ.URL,
+		"log_dir":      tCfg.LogDir,
+	}
+	for k, v := range required {
+		if v == "" {
+			ve.add(k + " is required")
+		}
+	}
+	ports := map[string]string{
+		"database.port":                    tCfg.Database.Port,
+		"ipfs.api_connection.port":         tCfg.IPFS.APIConnection.Port,
+		"ipfs_cluster.api_connection.port": tCfg.IPFSCluster.APIConnection.Port,
+		"minio.connection.port":            tCfg.MINIO.Connection.Port,
+		"mail.smtp.port":                   tCfg.Mail.SMTP.Port,
+	}
+	for k, v := range ports {
+		if v == "" {
+			continue
+		}
+		if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+			ve.add(k + " must be a port number")
+		}
+	}
+	if tCfg.RabbitMQ.URL != "" {
+		if u, err := url.Parse(tCfg.RabbitMQ.URL); err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps" && u.Scheme != "memory") {
+			ve.add("rabbitmq.url must be an amqp://, amqps:// or memory:// url")
+		}
+	}
+	if tCfg.RabbitMQ.Consumer.Workers < 1 {
+		ve.add("rabbitmq.consumer.workers must be at least 1")
+	}
+	if tCfg.RabbitMQ.Consumer.Prefetch < tCfg.RabbitMQ.Consumer.Workers {
+		ve.add("rabbitmq.consumer.prefetch must be at least rabbitmq.consumer.workers")
+	}
+	if tCfg.RabbitMQ.Consumer.MessageTimeoutSeconds < 1 {
+		ve.add("rabbitmq.consumer.message_timeout_seconds must be at least 1")
+	}
+	if tCfg.Accounts.VerificationTTLHours < 1 {
+		ve.add("accounts.verification_ttl_hours must be at least 1")
+	}
+	if tCfg.Accounts.PasswordResetTTLMinutes < 1 {
+		ve.add("accounts.password_reset_ttl_minutes must be at least 1")
+	}
+	if tCfg.Accounts.SignInChallengeTTLMinutes < 1 {
+		ve.add("accounts.sign_in_challenge_ttl_minutes must be at least 1")
+	}
+	if strings.Contains(tCfg.Accounts.SignInDomain, "/") {
+		ve.add("accounts.sign_in_domain must be a domain, without a scheme or path")
+	}
+	for k, v := range map[string]string{
+		"accounts.verify_email_url":   tCfg.Accounts.VerifyEmailURL,
+		"accounts.reset_password_url": tCfg.Accounts.ResetPasswordURL,
+	} {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			ve.add(k + " must be an http:// or https:// url")
+		}
+	}
+	switch tCfg.RateLimits.Store {
+	case "", "memory":
+	case "redis":
+		if u, err := url.Parse(tCfg.RateLimits.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			ve.add("rate_limits.redis_url must be a redis:// or rediss:// url when using the redis store")
+		}
+	default:
+		ve.add("rate_limits.store must be one of memory or redis")
+	}
+	if tCfg.RateLimits.Store != "" {
+		limits := map[string]RateLimit{
+			"rate_limits.auth":               tCfg.RateLimits.Auth,
+			"rate_limits.standard.default":   tCfg.RateLimits.Standard.Default,
+			"rate_limits.enterprise.default": tCfg.RateLimits.Enterprise.Default,
+		}
+		for group, limit := range tCfg.RateLimits.Standard.Groups {
+			limits["rate_limits.standard.groups."+group] = limit
+		}
+		for group, limit := range tCfg.RateLimits.Enterprise.Groups {
+			limits["rate_limits.enterprise.groups."+group] = limit
+		}
+		for k, limit := range limits {
+			if limit.RequestsPerMinute < 1 || limit.Burst < 1 {
+				ve.add(k + " must allow at least 1 request per minute and a burst of at least 1")
+			}
+		}
+	}
+	if tCfg.Health.TimeoutSeconds < 1 {
+		ve.add("health.timeout_seconds must be at least 1")
+	}
+	switch tCfg.Mail.Transport {
+	case "", "sendgrid", "smtp", "sink":
+	default:
+		ve.add("mail.transport must be one of sendgrid, smtp or sink")
+	}
+	switch tCfg.Log.Output {
+	case "", "stdout", "file", "syslog":
+	default:
+		ve.add("log.output must be one of stdout, file or syslog")
+	}
+	switch tCfg.Log.Format {
+	case "", "text", "json":
+	default:
+		ve.add("log.format must be one of text or json")
+	}
+	switch tCfg.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		ve.add("log.level must be one of debug, info, warn or error")
+	}
+	switch tCfg.Tracing.Exporter {
+	case "", "stdout":
+	case "otlp":
+		if u, err := url.Parse(tCfg.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			ve.add("tracing.otlp_endpoint must be an http:// or https:// url when using the otlp exporter")
+		}
+	default:
+		ve.add("tracing.exporter must be one of otlp or stdout")
+	}
+	if tCfg.Mail.Transport == "smtp" && tCfg.Mail.SMTP.Host == "" {
+		ve.add("mail.smtp.host is required when using the smtp transport")
+	}
+	addresses := map[string]string{
+		"ethereum.account.address":                            tCfg.Ethereum.Account.Address,
+		"ethereum.contracts.payment_contract_address":         tCfg.Ethereum.Contracts.PaymentContractAddress,
+		"ethereum.contracts.payment_channel_contract_address": tCfg.Ethereum.Contracts.PaymentChannelContractAddress,
+	}
+	for k, v := range addresses {
+		if v != "" && !common.IsHexAddress(v) {
+			ve.add(k + " must be a hex encoded ethereum address")
+		}
+	}
+	if tCfg.Ethereum.Contracts.PaymentChannelContractAddress != "" {
+		if _, ok := new(big.Int).SetString(tCfg.API.ChannelRequestCostInWei, 10); !ok {
+			ve.add("api.channel_request_cost_in_wei must be an integer when payment channels are enabled")
+		}
+	} else if len(tCfg.API.ChannelRequiredGroups) > 0 {
+		ve.add("api.channel_required_groups requires ethereum.contracts.payment_channel_contract_address")
+	}
+	for _, group := range tCfg.API.ChannelRequiredGroups {
+		switch group {
+		case "ipfs", "ipfs-private", "ipns", "ipfs-cluster":
+		default:
+			ve.add("api.channel_required_groups must only hold ipfs, ipfs-private, ipns or ipfs-cluster")
+		}
+	}
+	if tCfg.API.AuditKey == "" {
+		ve.add("api.audit_key is required")
+	}
+	if tCfg.API.SizeLimitInGigaBytes != "" {
+		if _, err := strconv.ParseFloat(tCfg.API.SizeLimitInGigaBytes, 64); err != nil {
+			ve.add("api.size_limit_in_giga_bytes must be a number")
+		}
+	}
+	if len(ve.Problems) == 0 {
+		return nil
+	}
+	sort.Strings(ve.Problems)
+	return ve
+}
@@ -10,14 +10,6 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
-var (
-	UploadObj        *models.Upload
-	UserObj          *models.User
-	PaymentObj       *models.Payment
-	IpnsObj          *models.IPNS
-	HostedIpfsNetObj *models.HostedIPFSPrivateNetwork
-)
-
 type DatabaseManager struct {
 	DB     *gorm.DB
 	Upload *models.UploadManager
@@ -29,9 +21,11 @@ func Initialize(cfg *config.TemporalConfig, runMigrations bool) (*DatabaseManage
 	}
 
 	db, err := OpenDBConnection(DBOptions{
-		User:     cfg.Database.Name,
+		User:     cfg.Database.Username,
 		Password: cfg.Database.Password,
 		Address:  cfg.Database.URL,
+		Port:     cfg.Database.Port,
+		Name:     cfg.Database.Name,
 	})
 	if err != nil {
 		return nil, err
@@ -39,20 +33,18 @@ func Initialize(cfg *config.TemporalConfig, runMigrations bool) (*DatabaseManage
 
 	dbm := DatabaseManager{DB: db}
 	if runMigrations {
-		dbm.RunMigrations()
+		if err = dbm.RunMigrations(); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 	return &dbm, nil
 }
 
-func (dbm *DatabaseManager) RunMigrations() {
-	dbm.DB.AutoMigrate(UploadObj)
-	dbm.DB.AutoMigrate(UserObj)
-	dbm.DB.AutoMigrate(PaymentObj)
-	// gorm will default table to name of ip_ns
-	// so we will override with ipns
-	dbm.DB.AutoMigrate(IpnsObj)
-	dbm.DB.AutoMigrate(HostedIpfsNetObj)
-	//dbm.DB.Model(userObj).Related(uploadObj.Users)
+// RunMigrations is used to apply any pending migrations
+func (dbm *DatabaseManager) RunMigrations() error {
+	_, err := dbm.MigrateUp()
+	return err
 }
 
 // DBOptions declares options for opening a database connection
@@ -60,6 +52,8 @@ type DBOptions struct {
 	User           string
 	Password       string
 	Address        string
+	Port           string
+	Name           string
 	SSLModeDisable bool
 }
 
@@ -68,9 +62,15 @@ func OpenDBConnection(opts DBOptions) (*gorm.DB, error) {
 	if opts.User == "" {
 		opts.User = "postgres"
 	}
+	if opts.Port == "" {
+		opts.Port = "5433"
+	}
+	if opts.Name == "" {
+		opts.Name = "temporal"
+	}
 	// look into whether or not we wil disable sslmode
-	dbConnURL := fmt.Sprintf("host=%s port=5433 user=%s dbname=temporal password=%s",
-		opts.Address, opts.User, opts.Password)
+	dbConnURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s",
+		opts.Address, opts.Port, opts.User, opts.Name, opts.Password)
 	if opts.SSLModeDisable {
 		dbConnURL = "sslmode=disable " + dbConnURL
 	}
@@ -32,8 +32,42 @@ func TestDatabaseMigrations(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	db.AutoMigrate(database.UploadObj)
-	db.AutoMigrate(database.UserObj)
-	db.AutoMigrate(database.PaymentObj)
-	db.Close()
+	defer db.Close()
+	dbm := &database.DatabaseManager{DB: db}
+	if _, err = dbm.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	// applying again is a no-op
+	if applied, err := dbm.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Fatalf("expected no pending migrations, got %v %v", applied, err)
+	}
+	latest := database.Migrations[len(database.Migrations)-1]
+	reverted, err := dbm.MigrateDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted == nil || reverted.Version != latest.Version {
+		t.Fatalf("expected migration %v to be reverted, got %v", latest.Version, reverted)
+	}
+	statuses, err := dbm.MigrationStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[len(statuses)-1].AppliedAt != nil {
+		t.Fatal("expected reverted migration to be pending")
+	}
+	if applied, err := dbm.MigrateUp(); err != nil || len(applied) != 1 {
+		t.Fatalf("expected reverted migration to be applied again, got %v %v", applied, err)
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range database.Migrations {
+		if m.Version != i+1 {
+			t.Fatalf("expected migration %s to be version %v, got %v", m.Name, i+1, m.Version)
+		}
+		if m.Name == "" || len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Fatalf("migration %v must have a name, up and down statements", m.Version)
+		}
+	}
 }
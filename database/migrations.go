@@ -0,0 +1,594 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migrationLock is the postgres advisory lock held while migrating, so
+// services starting at the same time don't apply a migration twice
+const migrationLock = 7281952
+
+// Migration is a numbered change to our schema, along with the statements reverting it
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// MigrationStatus describes whether a migration has been applied, and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the table recording which migrations have been applied
+type schemaMigration struct {
+	Version   int       `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"type:varchar(255);not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName is used to name our table after the convention of other migration tools
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// modelTable is used to create a table for a model embedding gorm.Model, with the
+// primary key and soft delete index gorm's AutoMigrate used to create
+func modelTable(table, columns string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id serial,
+			created_at timestamp with time zone,
+			updated_at timestamp with time zone,
+			deleted_at timestamp with time zone,
+			%s,
+			PRIMARY KEY (id)
+		)`, table, columns),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_deleted_at ON %s (deleted_at)", table, table),
+	}
+}
+
+// Migrations are applied in order of version, and must never be edited once released.
+// Schema changes are made by appending a new migration
+var Migrations = []Migration{
+	{
+		// the tables AutoMigrate created before we versioned our schema, so existing databases
+		// are left as they are while new databases are created the same way. Tables and columns
+		// added since are separate migrations, so existing databases get them too
+		Version: 1,
+		Name:    "create_initial_tables",
+		Up: concat(
+			modelTable("uploads", `
+			hash varchar(255) NOT NULL,
+			type varchar(255) NOT NULL,
+			network_name varchar(255),
+			hold_time_in_months integer NOT NULL,
+			user_name varchar(255) NOT NULL,
+			garbage_collect_date timestamp with time zone,
+			user_names text[] NOT NULL`),
+			modelTable("users", `
+			eth_address varchar(255) UNIQUE,
+			user_name varchar(255) UNIQUE,
+			email_address varchar(255) UNIQUE,
+			enterprise_enabled boolean,
+			account_enabled boolean,
+			api_access boolean,
+			email_enabled boolean,
+			hashed_password varchar(255),
+			ipfs_key_names text[],
+			ipfs_key_ids text[],
+			ipfs_network_names text[]`),
+			modelTable("payments", `
+			method integer,
+			number text,
+			charge_amount text,
+			eth_address text,
+			user_name text,
+			network_name text,
+			object_name text,
+			type text,
+			hold_time_in_months bigint`),
+			modelTable("ip_ns", `
+			sequence integer NOT NULL DEFAULT 0,
+			ipns_hash varchar(255) UNIQUE,
+			ipfs_hash text[],
+			current_ipfs_hash varchar(255),
+			life_time varchar(255),
+			ttl varchar(255),
+			"key" varchar(255),
+			network_name varchar(255)`),
+			modelTable("hosted_ip_fs_private_networks", `
+			name varchar(255),
+			api_url varchar(255),
+			swarm_key varchar(255),
+			users text[],
+			local_node_peer_addresses text[],
+			local_node_peer_ids text[],
+			bootstrap_peer_addresses text[],
+			bootstrap_peer_ids text[]`),
+		),
+		Down: []string{
+			"DROP TABLE IF EXISTS hosted_ip_fs_private_networks",
+			"DROP TABLE IF EXISTS ip_ns",
+			"DROP TABLE IF EXISTS payments",
+			"DROP TABLE IF EXISTS users",
+			"DROP TABLE IF EXISTS uploads",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_enterprise_uploads",
+		Up: modelTable("enterprise_uploads", `
+			company_name varchar(255),
+			hash varchar(255),
+			garbage_collect_date timestamp with time zone`),
+		Down: []string{"DROP TABLE IF EXISTS enterprise_uploads"},
+	},
+	{
+		Version: 3,
+		Name:    "create_payment_channels",
+		Up: modelTable("payment_channels", `
+			channel_id varchar(255) UNIQUE,
+			user_name varchar(255),
+			sender_address varchar(255),
+			deposit varchar(255),
+			latest_amount varchar(255),
+			latest_signature varchar(255),
+			expiration timestamp with time zone,
+			state varchar(255),
+			settlement_tx varchar(255)`),
+		Down: []string{"DROP TABLE IF EXISTS payment_channels"},
+	},
+	{
+		// columns are added if missing, since databases created by AutoMigrate may already have them.
+		// Existing users keep the email setting they have
+		Version: 4,
+		Name:    "add_email_preferences",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_notifications text[]",
+			"ALTER TABLE users ALTER COLUMN email_enabled SET DEFAULT true",
+		},
+		Down: []string{
+			"ALTER TABLE users ALTER COLUMN email_enabled DROP DEFAULT",
+			"ALTER TABLE users DROP COLUMN IF EXISTS disabled_notifications",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_webhooks",
+		Up: concat(
+			modelTable("webhooks", `
+			user_name varchar(255),
+			url varchar(2048),
+			secret varchar(255),
+			events text[]`),
+			modelTable("webhook_deliveries", `
+			webhook_id integer,
+			user_name varchar(255),
+			event_id varchar(255),
+			event varchar(255),
+			payload text,
+			attempts integer,
+			status_code integer,
+			error text,
+			delivered boolean`),
+		),
+		Down: []string{
+			"DROP TABLE IF EXISTS webhook_deliveries",
+			"DROP TABLE IF EXISTS webhooks",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_outbox_messages",
+		Up: modelTable("outbox_messages", `
+			queue_name varchar(255),
+			body text,
+			request_id varchar(255),
+			attempts integer,
+			last_error text,
+			published_at timestamp with time zone`),
+		Down: []string{"DROP TABLE IF EXISTS outbox_messages"},
+	},
+	{
+		// users are looked up by user_name, eth_address and email_address constantly,
+		// but those are already indexed by their unique constraints
+		Version: 7,
+		Name:    "add_lookup_indexes",
+		Up: []string{
+			"CREATE INDEX idx_uploads_hash_network_name ON uploads (hash, network_name)",
+			"CREATE INDEX idx_uploads_user_name ON uploads (user_name)",
+			"CREATE INDEX idx_uploads_garbage_collect_date ON uploads (garbage_collect_date)",
+			"CREATE INDEX idx_payments_user_name ON payments (user_name)",
+			"CREATE INDEX idx_ip_ns_network_name ON ip_ns (network_name)",
+			"CREATE INDEX idx_hosted_ip_fs_private_networks_name ON hosted_ip_fs_private_networks (name)",
+			"CREATE INDEX idx_webhooks_user_name ON webhooks (user_name)",
+			"CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id)",
+			// the relay only ever reads unpublished messages, which are a tiny fraction of the table
+			"CREATE INDEX idx_outbox_messages_unpublished ON outbox_messages (id) WHERE published_at IS NULL",
+		},
+		Down: []string{
+			"DROP INDEX idx_outbox_messages_unpublished",
+			"DROP INDEX idx_webhook_deliveries_webhook_id",
+			"DROP INDEX idx_webhooks_user_name",
+			"DROP INDEX idx_hosted_ip_fs_private_networks_name",
+			"DROP INDEX idx_ip_ns_network_name",
+			"DROP INDEX idx_payments_user_name",
+			"DROP INDEX idx_uploads_garbage_collect_date",
+			"DROP INDEX idx_uploads_user_name",
+			"DROP INDEX idx_uploads_hash_network_name",
+		},
+	},
+	{
+		// ownership was kept in arrays of names on users, uploads and networks, which couldn't
+		// be joined or constrained. The arrays are copied into join tables and then dropped
+		Version: 8,
+		Name:    "create_ownership_tables",
+		Up: []string{
+			`CREATE TABLE user_uploads (
+				user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				upload_id integer NOT NULL REFERENCES uploads (id) ON DELETE CASCADE,
+				created_at timestamp with time zone,
+				PRIMARY KEY (user_id, upload_id)
+			)`,
+			"CREATE INDEX idx_user_uploads_upload_id ON user_uploads (upload_id)",
+			`CREATE TABLE ipfs_keys (
+				id serial,
+				created_at timestamp with time zone,
+				user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				name varchar(255) NOT NULL UNIQUE,
+				key_id varchar(255) NOT NULL,
+				PRIMARY KEY (id)
+			)`,
+			"CREATE INDEX idx_ipfs_keys_user_id ON ipfs_keys (user_id)",
+			`CREATE TABLE network_members (
+				network_id integer NOT NULL REFERENCES hosted_ip_fs_private_networks (id) ON DELETE CASCADE,
+				user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				created_at timestamp with time zone,
+				PRIMARY KEY (network_id, user_id)
+			)`,
+			"CREATE INDEX idx_network_members_user_id ON network_members (user_id)",
+			`INSERT INTO user_uploads (user_id, upload_id, created_at)
+				SELECT DISTINCT users.id, uploads.id, uploads.created_at
+				FROM uploads CROSS JOIN LATERAL unnest(uploads.user_names) AS holder(name)
+				JOIN users ON users.user_name = holder.name`,
+			`INSERT INTO ipfs_keys (created_at, user_id, name, key_id)
+				SELECT now(), users.id, key.name, key.key_id
+				FROM users CROSS JOIN LATERAL unnest(users.ipfs_key_names, users.ipfs_key_ids) AS key(name, key_id)
+				WHERE key.name IS NOT NULL AND key.key_id IS NOT NULL
+				ON CONFLICT (name) DO NOTHING`,
+			// membership was recorded on both sides, which didn't always agree
+			`INSERT INTO network_members (network_id, user_id, created_at)
+				SELECT networks.id, users.id, now()
+				FROM hosted_ip_fs_private_networks AS networks CROSS JOIN LATERAL unnest(networks.users) AS member(name)
+				JOIN users ON users.user_name = member.name
+				UNION
+				SELECT networks.id, users.id, now()
+				FROM users CROSS JOIN LATERAL unnest(users.ipfs_network_names) AS network(name)
+				JOIN hosted_ip_fs_private_networks AS networks ON networks.name = network.name
+				ON CONFLICT DO NOTHING`,
+			"ALTER TABLE uploads DROP COLUMN user_names",
+			"ALTER TABLE users DROP COLUMN ipfs_key_names, DROP COLUMN ipfs_key_ids, DROP COLUMN ipfs_network_names",
+			"ALTER TABLE hosted_ip_fs_private_networks DROP COLUMN users",
+		},
+		Down: []string{
+			"ALTER TABLE hosted_ip_fs_private_networks ADD COLUMN users text[]",
+			"ALTER TABLE users ADD COLUMN ipfs_key_names text[], ADD COLUMN ipfs_key_ids text[], ADD COLUMN ipfs_network_names text[]",
+			"ALTER TABLE uploads ADD COLUMN user_names text[] NOT NULL DEFAULT '{}'",
+			"ALTER TABLE uploads ALTER COLUMN user_names DROP DEFAULT",
+			`UPDATE uploads SET user_names = holders.names FROM (
+				SELECT user_uploads.upload_id, array_agg(users.user_name ORDER BY user_uploads.created_at) AS names
+				FROM user_uploads JOIN users ON users.id = user_uploads.user_id
+				GROUP BY user_uploads.upload_id
+			) AS holders WHERE holders.upload_id = uploads.id`,
+			`UPDATE users SET ipfs_key_names = keys.names, ipfs_key_ids = keys.ids FROM (
+				SELECT user_id, array_agg(name ORDER BY id) AS names, array_agg(key_id ORDER BY id) AS ids
+				FROM ipfs_keys GROUP BY user_id
+			) AS keys WHERE keys.user_id = users.id`,
+			`UPDATE users SET ipfs_network_names = memberships.names FROM (
+				SELECT network_members.user_id, array_agg(networks.name) AS names
+				FROM network_members JOIN hosted_ip_fs_private_networks AS networks ON networks.id = network_members.network_id
+				GROUP BY network_members.user_id
+			) AS memberships WHERE memberships.user_id = users.id`,
+			`UPDATE hosted_ip_fs_private_networks SET users = members.names FROM (
+				SELECT network_members.network_id, array_agg(users.user_name) AS names
+				FROM network_members JOIN users ON users.id = network_members.user_id
+				GROUP BY network_members.network_id
+			) AS members WHERE members.network_id = hosted_ip_fs_private_networks.id`,
+			"DROP TABLE network_members",
+			"DROP TABLE ipfs_keys",
+			"DROP TABLE user_uploads",
+		},
+	},
+	{
+		// listings are paginated by (sort column, id), newest first by default
+		Version: 9,
+		Name:    "add_listing_indexes",
+		Up: []string{
+			"CREATE INDEX idx_uploads_created_at_id ON uploads (created_at, id)",
+			"CREATE INDEX idx_payments_user_name_created_at_id ON payments (user_name, created_at, id)",
+			"CREATE INDEX idx_ip_ns_created_at_id ON ip_ns (created_at, id)",
+		},
+		Down: []string{
+			"DROP INDEX idx_ip_ns_created_at_id",
+			"DROP INDEX idx_payments_user_name_created_at_id",
+			"DROP INDEX idx_uploads_created_at_id",
+		},
+	},
+	{
+		// existing users were granted api access by hand, so they're treated as verified
+		Version: 10,
+		Name:    "create_account_tokens",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT false",
+			"UPDATE users SET email_verified = true WHERE api_access",
+			`CREATE TABLE account_tokens (
+				id serial,
+				created_at timestamp with time zone,
+				user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				purpose varchar(255) NOT NULL,
+				token_hash varchar(64) NOT NULL UNIQUE,
+				expires_at timestamp with time zone NOT NULL,
+				used_at timestamp with time zone,
+				PRIMARY KEY (id)
+			)`,
+			"CREATE INDEX idx_account_tokens_user_id ON account_tokens (user_id)",
+		},
+		Down: []string{
+			"DROP TABLE account_tokens",
+			"ALTER TABLE users DROP COLUMN email_verified",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_login_challenges",
+		Up: []string{
+			`CREATE TABLE login_challenges (
+				id serial,
+				created_at timestamp with time zone,
+				eth_address varchar(42) NOT NULL,
+				nonce varchar(64) NOT NULL UNIQUE,
+				message text NOT NULL,
+				expires_at timestamp with time zone NOT NULL,
+				used_at timestamp with time zone,
+				PRIMARY KEY (id)
+			)`,
+			// users are found by address case insensitively, since addresses may be stored checksummed or not
+			"CREATE INDEX idx_users_lower_eth_address ON users (lower(eth_address))",
+		},
+		Down: []string{
+			"DROP INDEX idx_users_lower_eth_address",
+			"DROP TABLE login_challenges",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_two_factor_authentication",
+		Up: []string{
+			`ALTER TABLE users
+				ADD COLUMN two_factor_enabled boolean NOT NULL DEFAULT false,
+				ADD COLUMN two_factor_secret varchar(255),
+				ADD COLUMN two_factor_last_step bigint NOT NULL DEFAULT 0`,
+			`CREATE TABLE recovery_codes (
+				id serial,
+				created_at timestamp with time zone,
+				user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				code_hash varchar(64) NOT NULL UNIQUE,
+				used_at timestamp with time zone,
+				PRIMARY KEY (id)
+			)`,
+			"CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id)",
+		},
+		Down: []string{
+			"DROP TABLE recovery_codes",
+			"ALTER TABLE users DROP COLUMN two_factor_enabled, DROP COLUMN two_factor_secret, DROP COLUMN two_factor_last_step",
+		},
+	},
+	{
+		// audit events are append-only, which is enforced by triggers as well as the hash chain
+		Version: 13,
+		Name:    "create_audit_events",
+		Up: []string{
+			`CREATE TABLE audit_events (
+				id serial,
+				created_at timestamp with time zone,
+				actor varchar(255),
+				action varchar(255) NOT NULL,
+				target varchar(255),
+				network_name varchar(255),
+				ip varchar(64),
+				request_id varchar(255),
+				outcome varchar(16) NOT NULL,
+				status integer,
+				prev_hash varchar(64),
+				hash varchar(64) NOT NULL UNIQUE,
+				PRIMARY KEY (id)
+			)`,
+			"CREATE INDEX idx_audit_events_created_at_id ON audit_events (created_at, id)",
+			// each actor's events are chained, so their latest event is found on every insert
+			"CREATE INDEX idx_audit_events_actor_id ON audit_events (actor, id)",
+			`CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER audit_events_no_update_or_delete BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only()`,
+			`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+				FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only()`,
+		},
+		Down: []string{
+			"DROP TABLE audit_events",
+			"DROP FUNCTION audit_events_append_only()",
+		},
+	},
+	{
+		// webhook retries are scheduled in the database rather than slept through by workers,
+		// so they survive restarts. Deliveries which were pending are left as they were
+		Version: 14,
+		Name:    "add_webhook_delivery_retries",
+		Up: []string{
+			"ALTER TABLE webhook_deliveries ADD COLUMN next_attempt_at timestamp with time zone",
+			"CREATE INDEX idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at) WHERE next_attempt_at IS NOT NULL",
+		},
+		Down: []string{"ALTER TABLE webhook_deliveries DROP COLUMN next_attempt_at"},
+	},
+	{
+		// outbox messages which fail are retried later, and eventually dead-lettered,
+		// rather than blocking every message after them
+		Version: 15,
+		Name:    "add_outbox_retries",
+		Up: []string{
+			"ALTER TABLE outbox_messages ADD COLUMN next_attempt_at timestamp with time zone, ADD COLUMN dead_lettered_at timestamp with time zone",
+			"DROP INDEX idx_outbox_messages_unpublished",
+			"CREATE INDEX idx_outbox_messages_unpublished ON outbox_messages (id) WHERE published_at IS NULL AND dead_lettered_at IS NULL",
+		},
+		Down: []string{
+			"DROP INDEX idx_outbox_messages_unpublished",
+			"CREATE INDEX idx_outbox_messages_unpublished ON outbox_messages (id) WHERE published_at IS NULL",
+			"ALTER TABLE outbox_messages DROP COLUMN next_attempt_at, DROP COLUMN dead_lettered_at",
+		},
+	},
+	{
+		// verified users can only be without api access if the admin revoked it
+		Version: 16,
+		Name:    "add_user_api_access_revoked",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN api_access_revoked boolean NOT NULL DEFAULT false",
+			"UPDATE users SET api_access_revoked = true WHERE email_verified AND NOT api_access",
+		},
+		Down: []string{"ALTER TABLE users DROP COLUMN api_access_revoked"},
+	},
+	{
+		Version: 17,
+		Name:    "add_two_factor_lockout",
+		Up: []string{
+			`ALTER TABLE users
+				ADD COLUMN two_factor_failures integer NOT NULL DEFAULT 0,
+				ADD COLUMN two_factor_locked_until timestamp with time zone`,
+		},
+		Down: []string{"ALTER TABLE users DROP COLUMN two_factor_failures, DROP COLUMN two_factor_locked_until"},
+	},
+}
+
+func concat(statements ...[]string) []string {
+	var all []string
+	for _, s := range statements {
+		all = append(all, s...)
+	}
+	return all
+}
+
+// MigrateUp is used to apply every pending migration in order, each in its own transaction,
+// returning the migrations applied. It stops at the first migration which fails
+func (dbm *DatabaseManager) MigrateUp() ([]Migration, error) {
+	if err := dbm.DB.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range Migrations {
+		ok, err := dbm.migrate(m, true)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown is used to revert the latest applied migration, returning it.
+// Nil is returned when no migrations have been applied
+func (dbm *DatabaseManager) MigrateDown() (*Migration, error) {
+	if err := dbm.DB.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	latest := schemaMigration{}
+	if check := dbm.DB.Order("version desc").First(&latest); check.RecordNotFound() {
+		return nil, nil
+	} else if check.Error != nil {
+		return nil, check.Error
+	}
+	for _, m := range Migrations {
+		if m.Version != latest.Version {
+			continue
+		}
+		if _, err := dbm.migrate(m, false); err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+	return nil, fmt.Errorf("applied migration %v is unknown to this release", latest.Version)
+}
+
+// MigrationStatuses is used to list every migration, and when each was applied
+func (dbm *DatabaseManager) MigrationStatuses() ([]MigrationStatus, error) {
+	if err := dbm.DB.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := dbm.DB.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, m := range Migrations {
+		status := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// migrate is used to apply or revert a migration, reporting whether it did anything.
+// The migration is checked again once our lock is held, since another service may have run it
+func (dbm *DatabaseManager) migrate(m Migration, up bool) (bool, error) {
+	tx := dbm.DB.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLock).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	var count int
+	if err := tx.Model(&schemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if (count > 0) == up {
+		tx.Rollback()
+		return false, nil
+	}
+	statements := m.Up
+	if !up {
+		statements = m.Down
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("migration %v %s failed: %s", m.Version, m.Name, strings.TrimSpace(err.Error()))
+		}
+	}
+	var err error
+	if up {
+		err = tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+	} else {
+		err = tx.Where("version = ?", m.Version).Delete(&schemaMigration{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit().Error
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/mini"
+	"github.com/RTradeLtd/Temporal/rtfs"
+	"github.com/RTradeLtd/Temporal/rtfs_cluster"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/jinzhu/gorm"
+)
+
+// Pinger is implemented by clients able to check their own connection, such as our queue brokers
+type Pinger interface {
+	Ping() error
+}
+
+// Database is used to check a database connection
+func Database(db *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		return db.DB().PingContext(ctx)
+	}
+}
+
+// Broker is used to check a message broker, or any other client which can ping itself
+func Broker(p Pinger) Check {
+	return func(context.Context) error {
+		return p.Ping()
+	}
+}
+
+// IPFSNode is used to check the ipfs node whose api is at url
+func IPFSNode(url string) Check {
+	shell := rtfs.EstablishShellWithNode(url)
+	return func(ctx context.Context) error {
+		out := struct{ Version string }{}
+		return shell.Request("version").Exec(ctx, &out)
+	}
+}
+
+// ClusterNode is used to check the ipfs cluster node whose api is at host:port
+func ClusterNode(host, port string) Check {
+	return func(context.Context) error {
+		cm, err := rtfs_cluster.Initialize(host, port)
+		if err != nil {
+			return err
+		}
+		_, err = cm.Client.ID()
+		return err
+	}
+}
+
+// MinioServer is used to check a minio server, by listing its buckets with our credentials
+func MinioServer(endpoint, accessKey, secretKey string) Check {
+	return func(context.Context) error {
+		mm, err := mini.NewMinioManager(endpoint, accessKey, secretKey, false)
+		if err != nil {
+			return err
+		}
+		_, err = mm.ListBuckets()
+		return err
+	}
+}
+
+// EthereumRPC is used to check an ethereum json rpc endpoint, by fetching the latest block header
+func EthereumRPC(url string) Check {
+	return func(ctx context.Context) error {
+		if url == "" {
+			return errors.New("no ethereum rpc url is configured")
+		}
+		client, err := ethclient.DialContext(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		_, err = client.HeaderByNumber(ctx, nil)
+		return err
+	}
+}
+
+// NewCheckerFromConfig is used to generate a checker using our configured timeout
+func NewCheckerFromConfig(cfg *config.TemporalConfig) *Checker {
+	timeout := time.Duration(cfg.Health.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+	checker := NewChecker(timeout)
+	// probes may be frequent, so dependencies are checked at most once per timeout
+	checker.MaxAge = timeout
+	return checker
+}
+
+// AddDependencies is used to check the ipfs, ipfs cluster, minio and ethereum dependencies
+// named, at the addresses in our configuration. Postgres and rabbitmq are checked through
+// connections we already hold, and are added with Database and Broker instead
+func (c *Checker) AddDependencies(cfg *config.TemporalConfig, names ...string) {
+	for _, name := range names {
+		switch name {
+		case IPFS:
+			c.Add(name, IPFSNode(cfg.IPFSAPIURL()))
+		case Cluster:
+			c.Add(name, ClusterNode(cfg.IPFSCluster.APIConnection.Host, cfg.IPFSCluster.APIConnection.Port))
+		case Minio:
+			c.Add(name, MinioServer(
+				fmt.Sprintf("%s:%s", cfg.MINIO.Connection.IP, cfg.MINIO.Connection.Port),
+				cfg.MINIO.AccessKey,
+				cfg.MINIO.SecretKey))
+		case Ethereum:
+			c.Add(name, EthereumRPC(cfg.Ethereum.Connection.INFURA.URL))
+		default:
+			c.Failed(name, fmt.Errorf("%s is not a dependency we can check", name))
+		}
+	}
+}
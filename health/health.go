@@ -0,0 +1,219 @@
+// Package health is used to check that the services Temporal depends on can be reached.
+// The api and queue workers serve the results as liveness and readiness probes, and
+// the status command prints them
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Dependencies we check
+const (
+	Postgres = "postgres"
+	RabbitMQ = "rabbitmq"
+	IPFS     = "ipfs"
+	Cluster  = "ipfs_cluster"
+	Minio    = "minio"
+	Ethereum = "ethereum"
+	Redis    = "redis"
+)
+
+// Check is used to test a dependency, returning an error when it can't be reached.
+// Checks should give up once ctx expires. When they don't they're left to finish, and
+// the dependency isn't checked again until they have
+type Check func(ctx context.Context) error
+
+// Checker runs the checks for each of a service's dependencies
+type Checker struct {
+	// Timeout is how long each check is given to complete
+	Timeout time.Duration
+	// MaxAge is how long ReadyHandler serves a report before checking again
+	MaxAge time.Duration
+
+	names  []string
+	checks map[string]Check
+
+	mux     sync.Mutex
+	running map[string]*pendingCheck
+	report  *Report
+	checked time.Time
+}
+
+// pendingCheck is a check which is still running, and whose result may be waited on by several runs
+type pendingCheck struct {
+	done chan struct{}
+	err  error
+}
+
+// Status is the result of checking a dependency
+type Status struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Latency is how long the check took, in milliseconds
+	Latency int64  `json:"latency_ms"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the result of checking every dependency, which is healthy when all of them are
+type Report struct {
+	Healthy      bool      `json:"healthy"`
+	Dependencies []*Status `json:"dependencies"`
+}
+
+// NewChecker is used to generate a checker giving each check timeout to complete
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		Timeout: timeout,
+		checks:  make(map[string]Check),
+		running: make(map[string]*pendingCheck),
+	}
+}
+
+// Add is used to check a dependency, replacing any check already added with the same name
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Failed is used to add a dependency we couldn't set up a check for, which always reports err
+func (c *Checker) Failed(name string, err error) {
+	c.Add(name, func(context.Context) error { return err })
+}
+
+// Run is used to check every dependency concurrently, reporting them in the order they were added
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{Healthy: true, Dependencies: make([]*Status, len(c.names))}
+	wg := &sync.WaitGroup{}
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			report.Dependencies[i] = c.run(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+	for _, status := range report.Dependencies {
+		if !status.Healthy {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// run is used to check a single dependency, giving up once our timeout expires
+func (c *Checker) run(ctx context.Context, name string) *Status {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	start := time.Now()
+	pending := c.start(name)
+	var err error
+	select {
+	case <-pending.done:
+		err = pending.err
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	status := &Status{
+		Name:    name,
+		Healthy: err == nil,
+		Latency: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// start is used to run a dependency's check, or to join the run already in progress. Some of
+// our clients can't be cancelled, so checks run apart from the caller, who can stop waiting
+func (c *Checker) start(name string) *pendingCheck {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if pending, ok := c.running[name]; ok {
+		return pending
+	}
+	pending := &pendingCheck{done: make(chan struct{})}
+	c.running[name] = pending
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer cancel()
+		pending.err = c.checks[name](ctx)
+		c.mux.Lock()
+		delete(c.running, name)
+		c.mux.Unlock()
+		close(pending.done)
+	}()
+	return pending
+}
+
+// latest is used to get the most recent report, checking again once it's older than MaxAge
+func (c *Checker) latest(ctx context.Context) *Report {
+	c.mux.Lock()
+	report, checked := c.report, c.checked
+	c.mux.Unlock()
+	if report != nil && time.Since(checked) < c.MaxAge {
+		return report
+	}
+	report = c.Run(ctx)
+	c.mux.Lock()
+	c.report, c.checked = report, time.Now()
+	c.mux.Unlock()
+	return report
+}
+
+// Print is used to write a report as a table
+func (r *Report) Print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPENDENCY\tSTATUS\tLATENCY\tERROR")
+	for _, status := range r.Dependencies {
+		state := "ok"
+		if !status.Healthy {
+			state = "failing"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%vms\t%s\n", status.Name, state, status.Latency, status.Error)
+	}
+	return w.Flush()
+}
+
+// LiveHandler is used to serve a liveness probe, which succeeds while we are able to serve requests
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]bool{"healthy": true})
+	})
+}
+
+// ReadyHandler is used to serve a readiness probe, which reports each dependency and
+// fails with 503 Service Unavailable while any of them can't be reached. The report
+// includes the errors of failing checks, so it should only be served to operators
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.latest(r.Context())
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+// Register is used to serve our probes at /healthz and /readyz on mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", LiveHandler())
+	mux.Handle("/readyz", c.ReadyHandler())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,126 @@
+package health_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/health"
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestChecker(t *testing.T) {
+	checker := health.NewChecker(time.Millisecond * 100)
+	broker := queue.NewMemoryBroker()
+	checker.Add(health.RabbitMQ, health.Broker(broker))
+	var stalled int32
+	checker.Add("stalled", func(ctx context.Context) error {
+		atomic.AddInt32(&stalled, 1)
+		// ignores ctx, like our clients which can't be cancelled
+		time.Sleep(time.Second)
+		return nil
+	})
+	checker.Failed(health.Postgres, errors.New("connection refused"))
+
+	report := checker.Run(context.Background())
+	if report.Healthy {
+		t.Fatal("expected report to be unhealthy")
+	}
+	if len(report.Dependencies) != 3 || report.Dependencies[0].Name != health.RabbitMQ {
+		t.Fatalf("expected dependencies in the order added, got %v", report.Dependencies)
+	}
+	if !report.Dependencies[0].Healthy {
+		t.Fatalf("expected broker to be healthy, got %s", report.Dependencies[0].Error)
+	}
+	if !strings.HasPrefix(report.Dependencies[1].Error, "timed out") {
+		t.Fatalf("expected stalled check to time out, got %q", report.Dependencies[1].Error)
+	}
+	if report.Dependencies[2].Error != "connection refused" {
+		t.Fatalf("unexpected error %q", report.Dependencies[2].Error)
+	}
+	out := &bytes.Buffer{}
+	if err := report.Print(out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "failing") {
+		t.Fatalf("expected failing checks to be printed, got %s", out.String())
+	}
+
+	broker.Close()
+	if report = checker.Run(context.Background()); report.Dependencies[0].Healthy {
+		t.Fatal("expected closed broker to be unhealthy")
+	}
+	// the stalled check is still running, so it isn't started again
+	if n := atomic.LoadInt32(&stalled); n != 1 {
+		t.Fatalf("expected stalled check to run once, ran %v times", n)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	healthy := true
+	checker.Add("dependency", func(context.Context) error {
+		if !healthy {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	for _, test := range []struct {
+		path    string
+		healthy bool
+		status  int
+	}{
+		{"/healthz", false, http.StatusOK},
+		{"/readyz", true, http.StatusOK},
+		{"/readyz", false, http.StatusServiceUnavailable},
+	} {
+		healthy = test.healthy
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status {
+			t.Fatalf("expected %s to respond %v, got %v", test.path, test.status, rec.Code)
+		}
+		if test.path != "/readyz" {
+			continue
+		}
+		var report health.Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Healthy != test.healthy {
+			t.Fatalf("expected healthy to be %v", test.healthy)
+		}
+	}
+}
+
+func TestProbesMaxAge(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.MaxAge = time.Minute
+	healthy := true
+	checker.Add("dependency", func(context.Context) error {
+		if !healthy {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	mux := http.NewServeMux()
+	checker.Register(mux)
+	for _, healthy = range []bool{true, false} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		// the first report is served until it's older than MaxAge
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected cached report to be served, got %v", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package logging is used to configure the loggers of our services, and to
+// trace a user's request from the api through the queues it publishes to
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+
+	"github.com/RTradeLtd/Temporal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options controls where our services log to, and what they log
+type Options struct {
+	// Output is stdout, file or syslog, defaulting to file
+	Output string
+	// Format is text or json, defaulting to text
+	Format string
+	// Level is one of debug, info, warn or error, defaulting to info
+	Level string
+	// Dir is the directory log files are written to
+	Dir string
+	// SyslogAddress is the host:port of a remote syslog daemon, using the local daemon when empty
+	SyslogAddress string
+}
+
+// FromConfig is used to read our logging options from our configuration
+func FromConfig(cfg *config.TemporalConfig) Options {
+	return Options{
+		Output:        cfg.Log.Output,
+		Format:        cfg.Log.Format,
+		Level:         cfg.Log.Level,
+		Dir:           cfg.LogDir,
+		SyslogAddress: cfg.Log.SyslogAddress,
+	}
+}
+
+// New is used to generate the logger for service. Log files are named after the service
+func New(opts Options, service string) (*log.Logger, error) {
+	logger := log.New()
+	out, err := output(opts, service)
+	if err != nil {
+		return nil, err
+	}
+	logger.Out = out
+	switch opts.Format {
+	case "", "text":
+		logger.Formatter = &log.TextFormatter{}
+	case "json":
+		logger.Formatter = &log.JSONFormatter{}
+	default:
+		return nil, fmt.Errorf("unknown log format %s", opts.Format)
+	}
+	if opts.Level == "" {
+		opts.Level = "info"
+	}
+	level, err := log.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	logger.Level = level
+	return logger, nil
+}
+
+// output is used to open the writer our logs are sent to
+func output(opts Options, service string) (io.Writer, error) {
+	switch opts.Output {
+	case "stdout":
+		return os.Stdout, nil
+	case "", "file":
+		return os.OpenFile(fmt.Sprintf("%s/%s_service.log", opts.Dir, service), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0640)
+	case "syslog":
+		network := ""
+		if opts.SyslogAddress != "" {
+			network = "udp"
+		}
+		return syslog.Dial(network, opts.SyslogAddress, syslog.LOG_INFO|syslog.LOG_DAEMON, "temporal-"+service)
+	default:
+		return nil, fmt.Errorf("unknown log output %s", opts.Output)
+	}
+}
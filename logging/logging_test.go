@@ -0,0 +1,57 @@
+package logging_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logger, err := logging.New(logging.Options{Format: "json", Level: "warn", Dir: dir}, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Level != log.WarnLevel {
+		t.Fatalf("unexpected level %v", logger.Level)
+	}
+	ctx := logging.WithRequestID(context.Background(), "abc-123")
+	logging.Entry(ctx, logger).Warn("hello")
+	logged, err := ioutil.ReadFile(dir + "/api_service.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logged), `"request_id":"abc-123"`) {
+		t.Fatalf("expected request id in %s", logged)
+	}
+	if _, err = logging.New(logging.Options{Output: "carrier-pigeon"}, "api"); err == nil {
+		t.Fatal("expected error for unknown output")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{logging.NewRequestID(), true},
+		{"", false},
+		{"has space", false},
+		{"new\nline", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		if got := logging.ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
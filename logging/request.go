@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDField is the field our log lines record request ids in
+const RequestIDField = "request_id"
+
+// maxRequestIDLength bounds the request ids we accept from clients
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// NewRequestID is used to generate an id for a request
+func NewRequestID() string {
+	return uuid.New().String()
+}
+
+// ValidRequestID is used to check a request id given to us by a client, which we
+// only accept when it is short and made of characters that are safe to log
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID is used to attach the id of the request which caused some work to ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is used to get the request id attached to ctx, which is empty when there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Entry is used to generate a log entry recording the request id attached to ctx, if any
+func Entry(ctx context.Context, logger *log.Logger) *log.Entry {
+	entry := log.NewEntry(logger)
+	if id := RequestID(ctx); id != "" {
+		return entry.WithField(RequestIDField, id)
+	}
+	return entry
+}
@@ -2,12 +2,11 @@ package mail
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/RTradeLtd/Temporal/config"
 	"github.com/RTradeLtd/Temporal/database"
 	"github.com/RTradeLtd/Temporal/models"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 /*
@@ -15,39 +14,61 @@ This package is used to handle sending email messages to
 TEMPORAL users
 */
 
+const (
+	// TransportSendGrid sends emails through the sendgrid api, and is the default transport
+	TransportSendGrid = "sendgrid"
+	// TransportSMTP sends emails through a plain smtp server
+	TransportSMTP = "smtp"
+	// TransportSink stores emails instead of sending them
+	TransportSink = "sink"
+)
+
+// ErrEmailDisabled is returned when a user has opted out of the email being sent
+var ErrEmailDisabled = errors.New("user has disabled this email")
+
 type MailManager struct {
-	APIKey       string              `json:"api_key"`
-	EmailAddress string              `json:"email_address"` // EmailAddress is the address from which messages will be sent from
-	EmailName    string              `json:"email_name"`    // EmailName is the name of the email address
-	Client       *sendgrid.Client    `json:"client"`
-	UserManager  *models.UserManager `json:"user_manager"`
+	Mailer      Mailer              `json:"mailer"`
+	UserManager *models.UserManager `json:"user_manager"`
 }
 
 func GenerateMailManager(tCfg *config.TemporalConfig) (*MailManager, error) {
-	apiKey := tCfg.Sendgrid.APIKey
-	emailAddress := tCfg.Sendgrid.EmailAddress
-	emailName := tCfg.Sendgrid.EmailName
-	client := sendgrid.NewSendClient(apiKey)
-
-	dbPass := tCfg.Database.Password
-	dbURL := tCfg.Database.URL
-	dbUser := tCfg.Database.Username
-	db, err := database.OpenDBConnection(database.DBOptions{
-		User: dbUser, Password: dbPass, Address: dbURL})
+	mailer, err := NewMailer(tCfg)
+	if err != nil {
+		return nil, err
+	}
+	dbm, err := database.Initialize(tCfg, false)
 	if err != nil {
 		return nil, err
 	}
-	um := models.NewUserManager(db)
+	um := models.NewUserManager(dbm.DB)
 	mm := MailManager{
-		APIKey:       apiKey,
-		EmailAddress: emailAddress,
-		EmailName:    emailName,
-		Client:       client,
-		UserManager:  um,
+		Mailer:      mailer,
+		UserManager: um,
 	}
 	return &mm, nil
 }
 
+// NewMailer is used to generate the mail transport selected in our configuration
+func NewMailer(tCfg *config.TemporalConfig) (Mailer, error) {
+	fromName := tCfg.Mail.FromName
+	fromAddress := tCfg.Mail.FromAddress
+	if fromAddress == "" {
+		fromName = tCfg.Sendgrid.EmailName
+		fromAddress = tCfg.Sendgrid.EmailAddress
+	}
+	switch tCfg.Mail.Transport {
+	case TransportSendGrid, "":
+		return NewSendGridMailer(tCfg.Sendgrid.APIKey, fromName, fromAddress), nil
+	case TransportSMTP:
+		smtpCfg := tCfg.Mail.SMTP
+		return NewSMTPMailer(smtpCfg.Host, smtpCfg.Port, smtpCfg.Username, smtpCfg.Password, fromName, fromAddress), nil
+	case TransportSink:
+		return NewSinkMailer(tCfg.Mail.SinkDirectory)
+	default:
+		return nil, fmt.Errorf("unsupported mail transport %s", tCfg.Mail.Transport)
+	}
+}
+
 func (mm *MailManager) BulkSend(subject, content, contentType string, recipientNames, recipientEmails []string) error {
 	if len(recipientNames) != len(recipientEmails) {
 		return errors.New("recipientNames and recipientEmails must be fo equal length")
@@ -63,21 +84,47 @@ func (mm *MailManager) BulkSend(subject, content, contentType string, recipientN
 
 // SendEmail is used to send an email to temporal users
 func (mm *MailManager) SendEmail(subject, content, contentType, recipientName, recipientEmail string) (int, error) {
-	if contentType == "" {
-		contentType = "text/html"
+	email := &Email{
+		Subject:        subject,
+		RecipientName:  recipientName,
+		RecipientEmail: recipientEmail,
+	}
+	if contentType == "text/plain" {
+		email.Text = content
+	} else {
+		email.HTML = content
 	}
-	// 	content := fmt.Sprintf("<br>Eth Mined: %v<br>USD Value: %v<br>CAD Value: %v", ethMined, usdValue, cadValue)
-	from := mail.NewEmail(mm.EmailName, mm.EmailAddress)
-	to := mail.NewEmail(recipientName, recipientEmail)
+	if err := mm.Mailer.Send(email); err != nil {
+		return 0, err
+	}
+	// kept for compatibility with callers that expect a http status
+	return 202, nil
+}
 
-	mContent := mail.NewContent(contentType, content)
-	mail := mail.NewV3MailInit(from, subject, to, mContent)
+// Notify is used to render a notification and send it to a user, provided they
+// haven't opted out of it. ErrEmailDisabled is returned if they have
+func (mm *MailManager) Notify(username, notification string, data map[string]string) error {
+	email, err := Render(notification, data)
+	if err != nil {
+		return err
+	}
+	return mm.SendToUser(username, notification, email)
+}
 
-	response, err := mm.Client.Send(mail)
+// SendToUser is used to send an email to a user by their user name, honouring their
+// email preferences unless it's an account email. notification may be empty for emails
+// not tied to a notification type
+func (mm *MailManager) SendToUser(username, notification string, email *Email) error {
+	user, err := mm.UserManager.FindByUserName(username)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if !accountEmails[notification] && !user.NotificationEnabled(notification) {
+		return ErrEmailDisabled
 	}
-	return response.StatusCode, nil
+	email.RecipientName = user.UserName
+	email.RecipientEmail = user.EmailAddress
+	return mm.Mailer.Send(email)
 }
 
 type Message struct {
@@ -0,0 +1,191 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sendgrid/sendgrid-go"
+	sgmail "github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// Email is a single message addressed to one recipient. At least one of
+// Text and HTML must be set, and when both are set the message is sent
+// as multipart/alternative
+type Email struct {
+	Subject        string `json:"subject"`
+	Text           string `json:"text"`
+	HTML           string `json:"html"`
+	RecipientName  string `json:"recipient_name"`
+	RecipientEmail string `json:"recipient_email"`
+}
+
+// Mailer is a transport used to deliver emails
+type Mailer interface {
+	Send(email *Email) error
+}
+
+// SendGridMailer delivers emails through the SendGrid api
+type SendGridMailer struct {
+	FromName    string
+	FromAddress string
+	Client      *sendgrid.Client
+}
+
+// NewSendGridMailer is used to generate a sendgrid backed mailer
+func NewSendGridMailer(apiKey, fromName, fromAddress string) *SendGridMailer {
+	return &SendGridMailer{
+		FromName:    fromName,
+		FromAddress: fromAddress,
+		Client:      sendgrid.NewSendClient(apiKey),
+	}
+}
+
+// Send is used to send an email through sendgrid
+func (sm *SendGridMailer) Send(email *Email) error {
+	from := sgmail.NewEmail(sm.FromName, sm.FromAddress)
+	to := sgmail.NewEmail(email.RecipientName, email.RecipientEmail)
+	m := sgmail.NewV3Mail()
+	m.SetFrom(from)
+	m.Subject = email.Subject
+	p := sgmail.NewPersonalization()
+	p.AddTos(to)
+	m.AddPersonalizations(p)
+	// sendgrid requires text/plain to come before text/html
+	if email.Text != "" {
+		m.AddContent(sgmail.NewContent("text/plain", email.Text))
+	}
+	if email.HTML != "" {
+		m.AddContent(sgmail.NewContent("text/html", email.HTML))
+	}
+	response, err := sm.Client.Send(m)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned status code %v", response.StatusCode)
+	}
+	return nil
+}
+
+// SMTPMailer delivers emails through a plain smtp server
+type SMTPMailer struct {
+	FromName    string
+	FromAddress string
+	Address     string
+	Auth        smtp.Auth
+}
+
+// NewSMTPMailer is used to generate a smtp backed mailer. When username
+// is empty no authentication is performed
+func NewSMTPMailer(host, port, username, password, fromName, fromAddress string) *SMTPMailer {
+	sm := &SMTPMailer{
+		FromName:    fromName,
+		FromAddress: fromAddress,
+		Address:     fmt.Sprintf("%s:%s", host, port),
+	}
+	if username != "" {
+		sm.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sm
+}
+
+// Send is used to send an email through our smtp server
+func (sm *SMTPMailer) Send(email *Email) error {
+	msg, err := buildMIMEMessage(fmt.Sprintf("%s <%s>", sm.FromName, sm.FromAddress), email)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(sm.Address, sm.Auth, sm.FromAddress, []string{email.RecipientEmail}, msg)
+}
+
+// buildMIMEMessage is used to format an email as a multipart/alternative message
+func buildMIMEMessage(from string, email *Email) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", email.Text},
+		{"text/html", email.HTML},
+	}
+	for _, p := range parts {
+		if p.content == "" {
+			continue
+		}
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {p.contentType + "; charset=UTF-8"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = pw.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s <%s>\r\n", email.RecipientName, email.RecipientEmail)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", email.Subject)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// SinkMailer keeps every email it is asked to send in memory, and optionally
+// writes them to a directory. It is used for tests and local development
+type SinkMailer struct {
+	Directory string
+	mux       sync.Mutex
+	sent      []Email
+}
+
+// NewSinkMailer is used to generate a sink mailer. When directory is empty
+// emails are only kept in memory
+func NewSinkMailer(directory string) (*SinkMailer, error) {
+	if directory != "" {
+		if err := os.MkdirAll(directory, 0750); err != nil {
+			return nil, err
+		}
+	}
+	return &SinkMailer{Directory: directory}, nil
+}
+
+// Send is used to store an email in the sink
+func (sm *SinkMailer) Send(email *Email) error {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	sm.sent = append(sm.sent, *email)
+	if sm.Directory == "" {
+		return nil
+	}
+	marshaled, err := json.MarshalIndent(email, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%s.json", time.Now().UnixNano(), strings.Replace(email.RecipientEmail, "/", "_", -1))
+	return ioutil.WriteFile(filepath.Join(sm.Directory, name), marshaled, 0640)
+}
+
+// Sent returns a copy of every email stored in the sink
+func (sm *SinkMailer) Sent() []Email {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	sent := make([]Email, len(sm.sent))
+	copy(sent, sm.sent)
+	return sent
+}
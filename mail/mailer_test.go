@@ -0,0 +1,81 @@
+package mail_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/mail"
+)
+
+func TestRender(t *testing.T) {
+	for _, v := range mail.Notifications() {
+		if !mail.IsNotification(v) {
+			t.Fatalf("%s should be a notification", v)
+		}
+	}
+	email, err := mail.Render(mail.NotificationPinFailed, map[string]string{
+		"cid":     "QmHash",
+		"network": "public",
+		"reason":  "<script>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "IPFS Pin Failed" {
+		t.Fatalf("unexpected subject %s", email.Subject)
+	}
+	if !strings.Contains(email.Text, "QmHash") || !strings.Contains(email.Text, "<script>") {
+		t.Fatalf("unexpected text content %s", email.Text)
+	}
+	if strings.Contains(email.HTML, "<script>") {
+		t.Fatal("html content should be escaped")
+	}
+	if _, err = mail.Render(mail.NotificationPinFailed, map[string]string{"cid": "QmHash"}); err == nil {
+		t.Fatal("expected error rendering with missing data")
+	}
+	if _, err = mail.Render("not-a-notification", nil); err == nil {
+		t.Fatal("expected error rendering unknown notification")
+	}
+	if mail.IsNotification(mail.NotificationPasswordReset) {
+		t.Fatal("account emails should not be opted out of")
+	}
+	email, err = mail.Render(mail.NotificationPasswordReset, map[string]string{"user": "testuser", "token": "abc", "link": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(email.Text, "with the token abc") {
+		t.Fatalf("expected token without a link, got %s", email.Text)
+	}
+}
+
+func TestSinkMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink, err := mail.NewSinkMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := &mail.MailManager{Mailer: sink}
+	if _, err = mm.SendEmail("testEmail", "WowSuchEmail", "text/plain", "wowmuchemail", "test@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	sent := sink.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 sent email, got %v", len(sent))
+	}
+	if sent[0].Text != "WowSuchEmail" || sent[0].HTML != "" {
+		t.Fatal("plain text content not sent as text")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file in sink directory, got %v", len(files))
+	}
+}
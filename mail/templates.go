@@ -0,0 +1,144 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	texttemplate "text/template"
+)
+
+// Notification types which can be sent to users, and which users can opt out of
+const (
+	NotificationPinFailed                  = "pin-failed"
+	NotificationPinRemovalFailed           = "pin-removal-failed"
+	NotificationFileAddFailed              = "file-add-failed"
+	NotificationIPNSEntryFailed            = "ipns-entry-failed"
+	NotificationIPFSConnectionFailed       = "ipfs-connection-failed"
+	NotificationPrivateNetworkUnauthorized = "private-network-unauthorized"
+	NotificationPaymentConfirmationFailed  = "payment-confirmation-failed"
+	NotificationPinPaymentProcessingFailed = "pin-payment-processing-failed"
+)
+
+// Account emails, which are sent regardless of a user's email preferences
+const (
+	NotificationEmailVerification = "email-verification"
+	NotificationPasswordReset     = "password-reset"
+)
+
+// accountEmails can't be opted out of, since users rely on them to access their account
+var accountEmails = map[string]bool{
+	NotificationEmailVerification: true,
+	NotificationPasswordReset:     true,
+}
+
+type notificationTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// newNotificationTemplate parses the subject, text and html templates of a notification.
+// Templates are parsed with missingkey=error so a typo in the data keys fails loudly
+func newNotificationTemplate(name, subject, text, html string) notificationTemplate {
+	return notificationTemplate{
+		subject: texttemplate.Must(texttemplate.New(name).Option("missingkey=error").Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New(name).Option("missingkey=error").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(name).Option("missingkey=error").Parse(html)),
+	}
+}
+
+var notificationTemplates = map[string]notificationTemplate{
+	NotificationPinFailed: newNotificationTemplate(NotificationPinFailed,
+		"IPFS Pin Failed",
+		"Pin failed for content hash {{.cid}} on IPFS network {{.network}}, for reason {{.reason}}",
+		"<p>Pin failed for content hash <b>{{.cid}}</b> on IPFS network <b>{{.network}}</b>, for reason {{.reason}}</p>",
+	),
+	NotificationPinRemovalFailed: newNotificationTemplate(NotificationPinRemovalFailed,
+		"Pin Removal Failed",
+		"Pin removal failed for content hash {{.cid}} on IPFS network {{.network}}, for reason {{.reason}}",
+		"<p>Pin removal failed for content hash <b>{{.cid}}</b> on IPFS network <b>{{.network}}</b>, for reason {{.reason}}</p>",
+	),
+	NotificationFileAddFailed: newNotificationTemplate(NotificationFileAddFailed,
+		"IPFS File Add Failed",
+		"IPFS file add failed for object name {{.object_name}} on IPFS network {{.network}}",
+		"<p>IPFS file add failed for object name <b>{{.object_name}}</b> on IPFS network <b>{{.network}}</b></p>",
+	),
+	NotificationIPNSEntryFailed: newNotificationTemplate(NotificationIPNSEntryFailed,
+		"IPNS Entry Creation Failed",
+		"IPNS entry creation failed for content hash {{.cid}} using key {{.key}} for reason {{.reason}}",
+		"<p>IPNS entry creation failed for content hash <b>{{.cid}}</b> using key <b>{{.key}}</b> for reason {{.reason}}</p>",
+	),
+	NotificationIPFSConnectionFailed: newNotificationTemplate(NotificationIPFSConnectionFailed,
+		"Connection to IPFS failed",
+		"Connection to IPFS network {{.network}} failed due to the following error {{.reason}}",
+		"<p>Connection to IPFS network <b>{{.network}}</b> failed due to the following error {{.reason}}</p>",
+	),
+	NotificationPrivateNetworkUnauthorized: newNotificationTemplate(NotificationPrivateNetworkUnauthorized,
+		"Unauthorized access to IPFS private network",
+		"Unauthorized access to IPFS private network {{.network}}",
+		"<p>Unauthorized access to IPFS private network <b>{{.network}}</b></p>",
+	),
+	NotificationPaymentConfirmationFailed: newNotificationTemplate(NotificationPaymentConfirmationFailed,
+		"Payment Confirmation Failed",
+		"Payment failed for content hash {{.cid}} with error {{.reason}}",
+		"<p>Payment failed for content hash <b>{{.cid}}</b> with error {{.reason}}</p>",
+	),
+	NotificationPinPaymentProcessingFailed: newNotificationTemplate(NotificationPinPaymentProcessingFailed,
+		"Critical Error: Unable to process IPFS Pin confirmation for content hash {{.cid}}",
+		"Please contact us at admin@rtradetechnologies.com and we will resolve this",
+		"<p>Please contact us at <a href=\"mailto:admin@rtradetechnologies.com\">admin@rtradetechnologies.com</a> and we will resolve this</p>",
+	),
+	NotificationEmailVerification: newNotificationTemplate(NotificationEmailVerification,
+		"Verify your Temporal email address",
+		"Verify the email address of your Temporal account {{.user}}{{if .link}} by visiting {{.link}}{{else}} with the token {{.token}}{{end}}",
+		"<p>Verify the email address of your Temporal account <b>{{.user}}</b>{{if .link}} by visiting <a href=\"{{.link}}\">{{.link}}</a>{{else}} with the token <b>{{.token}}</b>{{end}}</p>",
+	),
+	NotificationPasswordReset: newNotificationTemplate(NotificationPasswordReset,
+		"Reset your Temporal password",
+		"A password reset was requested for your Temporal account {{.user}}. Reset your password{{if .link}} by visiting {{.link}}{{else}} with the token {{.token}}{{end}}, or ignore this email if you didn't request it",
+		"<p>A password reset was requested for your Temporal account <b>{{.user}}</b>. Reset your password{{if .link}} by visiting <a href=\"{{.link}}\">{{.link}}</a>{{else}} with the token <b>{{.token}}</b>{{end}}, or ignore this email if you didn't request it</p>",
+	),
+}
+
+// Notifications returns the names of every notification type users can opt out of
+func Notifications() []string {
+	names := []string{}
+	for k := range notificationTemplates {
+		if !accountEmails[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsNotification is used to check whether notification is a known notification type users can opt out of
+func IsNotification(notification string) bool {
+	_, ok := notificationTemplates[notification]
+	return ok && !accountEmails[notification]
+}
+
+// Render is used to render the subject, text and html content of a notification.
+// The returned email has no recipient set
+func Render(notification string, data map[string]string) (*Email, error) {
+	tmpl, ok := notificationTemplates[notification]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification %s", notification)
+	}
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return &Email{
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,126 @@
+// Package metrics holds the prometheus metrics recorded by our queue workers and
+// backend clients. They are registered with the default registry, which the api
+// already serves alongside its gin metrics, and which workers serve with Serve
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "temporal"
+
+// Backends whose calls we record
+const (
+	IPFS     = "ipfs"
+	Cluster  = "ipfs_cluster"
+	Minio    = "minio"
+	Ethereum = "ethereum"
+)
+
+var (
+	// MessagesConsumed counts the deliveries each queue's workers have received
+	MessagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "messages_consumed_total",
+		Help:      "Messages received by queue workers",
+	}, []string{"queue"})
+	// MessagesAcked counts the deliveries acknowledged once processed
+	MessagesAcked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "messages_acked_total",
+		Help:      "Messages acknowledged by queue workers",
+	}, []string{"queue"})
+	// MessagesFailed counts the deliveries rejected without being requeued
+	MessagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "messages_failed_total",
+		Help:      "Messages rejected by queue workers without being requeued",
+	}, []string{"queue"})
+	// MessagesRetried counts the deliveries requeued to be processed again
+	MessagesRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "messages_retried_total",
+		Help:      "Messages requeued by queue workers",
+	}, []string{"queue"})
+	// HandlerDuration records how long each queue's handler takes to process a message
+	HandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "handler_duration_seconds",
+		Help:      "Time taken by queue handlers to process a message",
+		// from 50ms up to around 7 minutes, since adding large files to ipfs is slow
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"queue"})
+	// BackendCallDuration records the latency of calls to ipfs, cluster, minio and ethereum
+	BackendCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of calls to our storage and blockchain backends",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"backend", "operation"})
+	// BackendCallErrors counts the calls to our backends which failed
+	BackendCallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "call_errors_total",
+		Help:      "Failed calls to our storage and blockchain backends",
+	}, []string{"backend", "operation"})
+	// PinnedBytes tracks the bytes pinned, less the bytes unpinned, by this process for each network
+	PinnedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ipfs",
+		Name:      "pinned_bytes",
+		Help:      "Bytes pinned less bytes unpinned by this process, per ipfs network",
+	}, []string{"network"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		MessagesConsumed,
+		MessagesAcked,
+		MessagesFailed,
+		MessagesRetried,
+		HandlerDuration,
+		BackendCallDuration,
+		BackendCallErrors,
+		PinnedBytes,
+	)
+}
+
+// ObserveCall is used to record the latency of a call to one of our backends started at
+// start, and whether it failed. It returns err so calls can be wrapped in place
+func ObserveCall(backend, operation string, start time.Time, err error) error {
+	BackendCallDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		BackendCallErrors.WithLabelValues(backend, operation).Inc()
+	}
+	return err
+}
+
+// Serve is used to serve our metrics at /metrics on addr until ctx is done. Handlers already
+// registered on mux, such as health probes, are served alongside them. A new mux is used when nil
+func Serve(ctx context.Context, addr string, mux *http.ServeMux) error {
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
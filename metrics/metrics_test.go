@@ -0,0 +1,38 @@
+package metrics_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestObserveCall(t *testing.T) {
+	failure := errors.New("unavailable")
+	if err := metrics.ObserveCall(metrics.IPFS, "test", time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := metrics.ObserveCall(metrics.IPFS, "test", time.Now(), failure); err != failure {
+		t.Fatalf("expected error to be returned, got %v", err)
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls, errs float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			switch family.GetName() {
+			case "temporal_backend_call_duration_seconds":
+				calls += float64(m.GetHistogram().GetSampleCount())
+			case "temporal_backend_call_errors_total":
+				errs += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if calls != 2 || errs != 1 {
+		t.Fatalf("expected 2 calls and 1 error, got %v and %v", calls, errs)
+	}
+}
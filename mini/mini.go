@@ -1,9 +1,13 @@
 package mini
 
 import (
+	"context"
 	"errors"
 	"io"
+	"time"
 
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/RTradeLtd/Temporal/tracing"
 	minio "github.com/minio/minio-go"
 )
 
@@ -68,6 +72,11 @@ func (mm *MinioManager) MakeBucket(args map[string]string) error {
 
 // PutObject is a wrapper for the minio PutObject method, returning the number of bytes put or an error
 func (mm *MinioManager) PutObject(bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error) {
+	return mm.PutObjectWithContext(context.Background(), bucketName, objectName, reader, objectSize, opts)
+}
+
+// PutObjectWithContext is used to put an object like PutObject, giving up once ctx expires
+func (mm *MinioManager) PutObjectWithContext(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error) {
 	bucketExists, err := mm.CheckIfBucketExists(bucketName)
 	if err != nil {
 		return 0, err
@@ -75,11 +84,21 @@ func (mm *MinioManager) PutObject(bucketName, objectName string, reader io.Reade
 	if !bucketExists {
 		return 0, errors.New("bucket does not exist")
 	}
-	return mm.Client.PutObject(bucketName, objectName, reader, objectSize, opts)
+	ctx, span := tracing.Start(ctx, "minio put_object", tracing.Client)
+	span.SetAttribute("minio.bucket", bucketName)
+	span.SetAttribute("minio.object", objectName)
+	start := time.Now()
+	n, err := mm.Client.PutObjectWithContext(ctx, bucketName, objectName, reader, objectSize, opts)
+	return n, span.End(metrics.ObserveCall(metrics.Minio, "put_object", start, err))
 }
 
 // GetObject is a wrapper for the minio GetObject method
 func (mm *MinioManager) GetObject(bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
+	return mm.GetObjectWithContext(context.Background(), bucketName, objectName, opts)
+}
+
+// GetObjectWithContext is used to get an object like GetObject. Reads from the object fail once ctx expires
+func (mm *MinioManager) GetObjectWithContext(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
 	exists, err := mm.CheckIfBucketExists(bucketName)
 	if err != nil {
 		return nil, err
@@ -87,11 +106,22 @@ func (mm *MinioManager) GetObject(bucketName, objectName string, opts minio.GetO
 	if !exists {
 		return nil, errors.New("bucket does not exist")
 	}
-	return mm.Client.GetObject(bucketName, objectName, opts)
+	ctx, span := tracing.Start(ctx, "minio get_object", tracing.Client)
+	span.SetAttribute("minio.bucket", bucketName)
+	span.SetAttribute("minio.object", objectName)
+	start := time.Now()
+	obj, err := mm.Client.GetObjectWithContext(ctx, bucketName, objectName, opts)
+	return obj, span.End(metrics.ObserveCall(metrics.Minio, "get_object", start, err))
 }
 
 // RemoveObject is used to remove an object from minio
 func (mm *MinioManager) RemoveObject(bucketName, objectName string) error {
+	return mm.RemoveObjectWithContext(context.Background(), bucketName, objectName)
+}
+
+// RemoveObjectWithContext is used to remove an object from minio, recording the call as a child
+// of the span in ctx. Our minio client can't abandon removals, so ctx isn't used to cancel it
+func (mm *MinioManager) RemoveObjectWithContext(ctx context.Context, bucketName, objectName string) error {
 	exists, err := mm.CheckIfBucketExists(bucketName)
 	if err != nil {
 		return err
@@ -99,7 +129,11 @@ func (mm *MinioManager) RemoveObject(bucketName, objectName string) error {
 	if !exists {
 		return errors.New("bucket does not exist")
 	}
-	return mm.Client.RemoveObject(bucketName, objectName)
+	_, span := tracing.Start(ctx, "minio remove_object", tracing.Client)
+	span.SetAttribute("minio.bucket", bucketName)
+	span.SetAttribute("minio.object", objectName)
+	start := time.Now()
+	return span.End(metrics.ObserveCall(metrics.Minio, "remove_object", start, mm.Client.RemoveObject(bucketName, objectName)))
 }
 
 // CheckIfBucketExists is used to check if a bucket exists
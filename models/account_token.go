@@ -0,0 +1,88 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Purposes account tokens are issued for
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+// ErrInvalidToken is returned when redeeming a token which doesn't exist, has expired or has already been used
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// AccountToken is a single use token emailed to a user, to verify their email address or reset
+// their password. Only a hash of the token is stored, so tokens can't be recovered from the database
+type AccountToken struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"not null"`
+	Purpose   string    `gorm:"type:varchar(255);not null"`
+	TokenHash string    `gorm:"type:varchar(64);not null;unique"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// NewAccountToken is used to issue a token to a user which is valid for ttl. Unused tokens issued
+// earlier for the same purpose are revoked, so only the latest emailed token can be redeemed
+func (um *UserManager) NewAccountToken(username, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		if check := tx.Model(&AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()); check.Error != nil {
+			return check.Error
+		}
+		return tx.Create(&AccountToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemAccountToken is used to use up a token, returning the id of the user it was issued to.
+// The token is marked used by the same statement checking it, so it can only be redeemed once
+func redeemAccountToken(tx *gorm.DB, purpose, token string) (uint, error) {
+	now := time.Now()
+	check := tx.Model(&AccountToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, now).
+		Update("used_at", now)
+	if check.Error != nil {
+		return 0, check.Error
+	}
+	if check.RowsAffected == 0 {
+		return 0, ErrInvalidToken
+	}
+	redeemed := AccountToken{}
+	if check = tx.Where("token_hash = ?", hashToken(token)).First(&redeemed); check.Error != nil {
+		return 0, check.Error
+	}
+	return redeemed.UserID, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
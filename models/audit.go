@@ -0,0 +1,187 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+	AuditFailure = "failure"
+)
+
+// auditLock is the postgres advisory lock held while appending to an actor's chain of events,
+// so each event is chained to the one before it. Each actor has their own lock, so recording
+// events for different users doesn't wait on a single lock
+const auditLock = 7281953
+
+// maxAuditFieldLength is the longest value our audit event columns hold
+const maxAuditFieldLength = 255
+
+// AuditEvent records a security relevant or administrative action. Events are append-only, and
+// each holds an HMAC of itself and the actor's event before it, keyed with a secret kept out of our
+// database, so editing or removing any event but an actor's latest breaks their chain from that point
+// on, and chains can't be rewritten without the key. Our migrations also refuse updates and deletes
+type AuditEvent struct {
+	ID          uint `gorm:"primary_key"`
+	CreatedAt   time.Time
+	Actor       string `gorm:"type:varchar(255)"`
+	Action      string `gorm:"type:varchar(255);not null"`
+	Target      string `gorm:"type:varchar(255)"`
+	NetworkName string `gorm:"type:varchar(255)"`
+	IP          string `gorm:"type:varchar(64);column:ip"`
+	RequestID   string `gorm:"type:varchar(255)"`
+	Outcome     string `gorm:"type:varchar(16);not null"`
+	Status      int
+	PrevHash    string `gorm:"type:varchar(64)"`
+	Hash        string `gorm:"type:varchar(64);not null;unique"`
+}
+
+// AuditFilter narrows a listing of audit events. Fields left empty aren't filtered on
+type AuditFilter struct {
+	Actor         string
+	Action        string
+	Target        string
+	NetworkName   string
+	Outcome       string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ChainError is returned when an audit event doesn't match the hash chain, because it or
+// the event before it was changed or removed
+type ChainError struct {
+	ID uint
+}
+
+func (ce *ChainError) Error() string {
+	return fmt.Sprintf("audit log hash chain is broken at event %v", ce.ID)
+}
+
+// AuditManager is used to record and read our audit log
+type AuditManager struct {
+	DB *gorm.DB
+	// Key is the secret our events are chained with
+	Key []byte
+}
+
+// NewAuditManager is used to generate our audit manager, chaining events with key
+func NewAuditManager(db *gorm.DB, key []byte) *AuditManager {
+	return &AuditManager{DB: db, Key: key}
+}
+
+// Record is used to append an event to the audit log, chaining it to the actor's latest event.
+// Values too long for our columns are shortened, so the event is still recorded
+func (am *AuditManager) Record(event *AuditEvent) error {
+	event.Actor = fitAuditField(event.Actor)
+	event.Target = fitAuditField(event.Target)
+	event.NetworkName = fitAuditField(event.NetworkName)
+	event.RequestID = fitAuditField(event.RequestID)
+	return transaction(am.DB, func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, hashtext(?))", auditLock, event.Actor).Error; err != nil {
+			return err
+		}
+		latest := AuditEvent{}
+		if check := tx.Select("hash").Where("actor = ?", event.Actor).Order("id desc").First(&latest); check.Error != nil && !check.RecordNotFound() {
+			return check.Error
+		}
+		// the time is stored to the microsecond, so it is hashed as it will be read back
+		event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		event.PrevHash = latest.Hash
+		event.Hash = event.computeHash(am.Key)
+		return tx.Create(event).Error
+	})
+}
+
+// ListEvents is used to find a page of the events matching filter
+func (am *AuditManager) ListEvents(filter AuditFilter, page Page) (*[]AuditEvent, *PageInfo, error) {
+	query := am.DB.Model(&AuditEvent{})
+	for _, f := range []struct{ column, value string }{
+		{"actor", filter.Actor},
+		{"action", filter.Action},
+		{"target", filter.Target},
+		{"network_name", filter.NetworkName},
+		{"outcome", filter.Outcome},
+	} {
+		if f.value != "" {
+			query = query.Where(fmt.Sprintf("audit_events.%s = ?", f.column), f.value)
+		}
+	}
+	events := []AuditEvent{}
+	info, err := paginate(createdBetween(query, "audit_events", filter.CreatedAfter, filter.CreatedBefore), page, &events)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &events, info, nil
+}
+
+// VerifyChain is used to check every event against its actor's hash chain, returning the number
+// of events checked. A *ChainError is returned for the first event which doesn't match
+func (am *AuditManager) VerifyChain() (int, error) {
+	var (
+		checked    int
+		lastID     uint
+		prevHashes = make(map[string]string)
+	)
+	for {
+		events := []AuditEvent{}
+		if check := am.DB.Where("id > ?", lastID).Order("id asc").Limit(500).Find(&events); check.Error != nil {
+			return checked, check.Error
+		}
+		if len(events) == 0 {
+			return checked, nil
+		}
+		for _, event := range events {
+			if event.PrevHash != prevHashes[event.Actor] || !hmac.Equal([]byte(event.computeHash(am.Key)), []byte(event.Hash)) {
+				return checked, &ChainError{ID: event.ID}
+			}
+			prevHashes[event.Actor] = event.Hash
+			lastID = event.ID
+			checked++
+		}
+	}
+}
+
+// computeHash is used to hash an event's fields along with the hash of the event before it
+func (e *AuditEvent) computeHash(key []byte) string {
+	fields, _ := json.Marshal([]interface{}{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.NetworkName,
+		e.IP,
+		e.RequestID,
+		e.Outcome,
+		e.Status,
+	})
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fields)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fitAuditField is used to shorten values too long for our columns, such as long request paths.
+// The end of the value is replaced with its hash, so shortened values can still be told apart
+func fitAuditField(value string) string {
+	if len(value) <= maxAuditFieldLength {
+		return value
+	}
+	digest := sha256.Sum256([]byte(value))
+	suffix := " sha256=" + hex.EncodeToString(digest[:])
+	cut := maxAuditFieldLength - len(suffix)
+	// the value is cut at the start of a character, so it remains valid utf-8
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut] + suffix
+}
@@ -0,0 +1,86 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/utils"
+)
+
+func TestAuditManager(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabaseConnection(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := models.NewAuditManager(db, []byte("audit test key"))
+
+	actor := utils.GenerateRandomUtils().GenerateString(10, utils.LetterBytes)
+	var prevHash string
+	for _, outcome := range []string{models.AuditSuccess, models.AuditDenied} {
+		event := &models.AuditEvent{
+			Actor:   actor,
+			Action:  "createIPFSKey",
+			Target:  "key_name=test",
+			IP:      "127.0.0.1",
+			Outcome: outcome,
+			Status:  200,
+		}
+		if err := am.Record(event); err != nil {
+			t.Fatal(err)
+		}
+		// each actor's events are chained to their own previous event
+		if event.Hash == "" || event.PrevHash != prevHash {
+			t.Fatalf("event %v wasn't chained", event.ID)
+		}
+		prevHash = event.Hash
+	}
+	// targets too long for our columns are shortened rather than failing to be recorded
+	long := &models.AuditEvent{
+		Actor:   actor,
+		Action:  "pinHashLocally",
+		Target:  "hash=" + strings.Repeat("a", 300),
+		Outcome: models.AuditFailure,
+		Status:  400,
+	}
+	if err := am.Record(long); err != nil {
+		t.Fatal(err)
+	}
+	if len(long.Target) > 255 || !strings.HasPrefix(long.Target, "hash=aaa") {
+		t.Fatalf("unexpected shortened target %q", long.Target)
+	}
+
+	events, _, err := am.ListEvents(models.AuditFilter{Actor: actor, Outcome: models.AuditDenied}, models.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 || (*events)[0].Outcome != models.AuditDenied {
+		t.Fatalf("expected one denied event, got %+v", *events)
+	}
+
+	checked, err := am.VerifyChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked < 3 {
+		t.Fatalf("expected at least 3 events checked, got %v", checked)
+	}
+	// a chain can't be verified, or extended, without its key
+	if _, err := models.NewAuditManager(db, []byte("another key")).VerifyChain(); err == nil {
+		t.Fatal("expected chain to be broken for another key")
+	}
+	// events can't be changed once recorded
+	if err := db.Model(&(*events)[0]).Update("outcome", models.AuditSuccess).Error; err == nil {
+		t.Fatal("expected updating an audit event to fail")
+	}
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// IPFSKey is an IPFS keystore key created by a user, which they can publish IPNS records with.
+// Key names are prefixed with the user name, as the keystore is shared by all users
+type IPFSKey struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `gorm:"not null" json:"-"`
+	Name      string    `gorm:"type:varchar(255);not null;unique" json:"name"`
+	KeyID     string    `gorm:"type:varchar(255);not null" json:"key_id"`
+}
+
+// TableName is used to keep gorm from splitting IPFS into ip_fs
+func (IPFSKey) TableName() string {
+	return "ipfs_keys"
+}
+
+// ErrKeyInUse is returned when removing a key which an IPNS record that hasn't expired is published with
+var ErrKeyInUse = errors.New("key is used by an active ipns record")
+
+// ListKeysForUser is used to find a page of a user's keys
+func (um *UserManager) ListKeysForUser(username string, page Page) (*[]IPFSKey, *PageInfo, error) {
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := []IPFSKey{}
+	info, err := paginate(um.DB.Where("user_id = ?", userID), page, &keys, "name")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &keys, info, nil
+}
+
+// RenameIPFSKeyForUser is used to rename one of a user's keys, along with the IPNS records
+// published with it so they can still be updated
+func (um *UserManager) RenameIPFSKeyForUser(username, keyName, newKeyName string) error {
+	return transaction(um.DB, func(tx *gorm.DB) error {
+		key, err := findKey(tx, username, keyName)
+		if err != nil {
+			return err
+		}
+		if check := tx.Model(key).Update("name", newKeyName); check.Error != nil {
+			return check.Error
+		}
+		// the records' updated_at is left alone, as it's when they were last published
+		return tx.Model(&IPNS{}).Where("key = ?", keyName).UpdateColumn("key", newKeyName).Error
+	})
+}
+
+// RemoveIPFSKeyForUser is used to remove one of a user's keys. Keys used by an IPNS record
+// which hasn't expired can't be removed, since the record could no longer be updated
+func (um *UserManager) RemoveIPFSKeyForUser(username, keyName string) error {
+	return transaction(um.DB, func(tx *gorm.DB) error {
+		key, err := findKey(tx, username, keyName)
+		if err != nil {
+			return err
+		}
+		entries := []IPNS{}
+		if check := tx.Where("key = ?", keyName).Find(&entries); check.Error != nil {
+			return check.Error
+		}
+		for _, entry := range entries {
+			// records without a lifetime we can read are treated as active
+			lifetime, err := time.ParseDuration(entry.LifeTime)
+			if err != nil || entry.UpdatedAt.Add(lifetime).After(time.Now()) {
+				return ErrKeyInUse
+			}
+		}
+		return tx.Delete(key).Error
+	})
+}
+
+// findKey is used to find one of a user's keys
+func findKey(tx *gorm.DB, username, keyName string) (*IPFSKey, error) {
+	userID, err := findUserID(tx, username)
+	if err != nil {
+		return nil, err
+	}
+	key := &IPFSKey{}
+	if check := tx.Where("user_id = ? AND name = ?", userID, keyName).First(key); check.RecordNotFound() {
+		return nil, errors.New("key not found")
+	} else if check.Error != nil {
+		return nil, check.Error
+	}
+	return key, nil
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/jinzhu/gorm"
@@ -13,13 +15,20 @@ type HostedIPFSPrivateNetwork struct {
 	Name                   string         `gorm:"type:varchar(255)"`
 	APIURL                 string         `gorm:"type:varchar(255)"`
 	SwarmKey               string         `gorm:"type:varchar(255)"`
-	Users                  pq.StringArray `gorm:"type:text[]"` // these are the users to which this IPFS network connection applies to specified by eth address
+	Users                  []string       `gorm:"-"`           // these are the names of the network's members, loaded from network_members
 	LocalNodePeerAddresses pq.StringArray `gorm:"type:text[]"` // these are the nodes whichwe run, and can connect to
 	LocalNodePeerIDs       pq.StringArray `gorm:"type:text[];column:local_node_peer_ids"`
 	BootstrapPeerAddresses pq.StringArray `gorm:"type:text[]"`
 	BootstrapPeerIDs       pq.StringArray `gorm:"type:text[];column:bootstrap_peer_ids"`
 }
 
+// NetworkMember records a user with access to a private network
+type NetworkMember struct {
+	NetworkID uint `gorm:"primary_key;auto_increment:false"`
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	CreatedAt time.Time
+}
+
 type IPFSNetworkManager struct {
 	DB *gorm.DB
 }
@@ -33,9 +42,42 @@ func (im *IPFSNetworkManager) GetNetworkByName(name string) (*HostedIPFSPrivateN
 	if check := im.DB.Model(&pnet).Where("name = ?", name).First(&pnet); check.Error != nil {
 		return nil, check.Error
 	}
+	users, err := im.GetNetworkMembers(pnet.ID)
+	if err != nil {
+		return nil, err
+	}
+	pnet.Users = users
 	return &pnet, nil
 }
 
+// GetNetworkMembers is used to get the names of the users with access to a private network
+func (im *IPFSNetworkManager) GetNetworkMembers(networkID uint) ([]string, error) {
+	users := []string{}
+	if check := im.DB.Table("users").
+		Joins("JOIN network_members ON network_members.user_id = users.id").
+		Where("network_members.network_id = ? AND users.deleted_at IS NULL", networkID).
+		Order("network_members.created_at").
+		Pluck("users.user_name", &users); check.Error != nil {
+		return nil, check.Error
+	}
+	return users, nil
+}
+
+// DeleteHostedPrivateNetwork is used to delete a private network and revoke its members' access.
+// Uploads to the network are left in place, and the network's nodes aren't touched
+func (im *IPFSNetworkManager) DeleteHostedPrivateNetwork(name string) error {
+	return transaction(im.DB, func(tx *gorm.DB) error {
+		pnet := HostedIPFSPrivateNetwork{}
+		if check := tx.Where("name = ?", name).First(&pnet); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Where("network_id = ?", pnet.ID).Delete(&NetworkMember{}); check.Error != nil {
+			return check.Error
+		}
+		return tx.Delete(&pnet).Error
+	})
+}
+
 func (im *IPFSNetworkManager) GetAPIURLByName(name string) (string, error) {
 	pnet, err := im.GetNetworkByName(name)
 	if err != nil {
@@ -44,6 +86,9 @@ func (im *IPFSNetworkManager) GetAPIURLByName(name string) (string, error) {
 	return pnet.APIURL, nil
 }
 
+// CreateHostedPrivateNetwork is used to create a private network along with its members, which
+// default to the admin account. Creation fails without leaving a network behind if any member
+// doesn't exist
 // TODO: Validate swarm key and API url
 func (im *IPFSNetworkManager) CreateHostedPrivateNetwork(name, apiURL, swarmKey string, arrayParameters map[string][]string, users []string) (*HostedIPFSPrivateNetwork, error) {
 	pnet := &HostedIPFSPrivateNetwork{}
@@ -82,19 +127,32 @@ func (im *IPFSNetworkManager) CreateHostedPrivateNetwork(name, apiURL, swarmKey
 		}
 		pnet.LocalNodePeerIDs = append(pnet.LocalNodePeerIDs, parsedNPeerID)
 	}
-	if len(users) > 0 {
-		for _, v := range users {
-			pnet.Users = append(pnet.Users, v)
-		}
-	} else {
-		pnet.Users = append(pnet.Users, AdminAddress)
+	if len(users) == 0 {
+		users = []string{AdminAddress}
 	}
 
 	pnet.Name = name
 	pnet.APIURL = apiURL
 	pnet.SwarmKey = swarmKey
-	if check := im.DB.Create(pnet); check.Error != nil {
-		return nil, check.Error
+	err := transaction(im.DB, func(tx *gorm.DB) error {
+		if check := tx.Create(pnet); check.Error != nil {
+			return check.Error
+		}
+		for _, username := range users {
+			userID, err := findUserID(tx, username)
+			if err != nil {
+				return fmt.Errorf("failed to find network member %s: %s", username, err)
+			}
+			if check := tx.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").
+				Create(&NetworkMember{NetworkID: pnet.ID, UserID: userID}); check.Error != nil {
+				return check.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	pnet.Users = users
 	return pnet, nil
 }
@@ -23,6 +23,13 @@ type IPNS struct {
 	NetworkName     string         `gorm:"type:varchar(255)" json:"network_name"`
 }
 
+// IPNSFilter narrows a listing of IPNS records. Fields left empty aren't filtered on
+type IPNSFilter struct {
+	NetworkName   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
 type IpnsManager struct {
 	DB *gorm.DB
 }
@@ -104,3 +111,24 @@ func (im *IpnsManager) CreateEntry(ipnsHash, ipfsHash, key, networkName string,
 	}
 	return &entry, nil
 }
+
+// ListEntriesForUser is used to list a page of the IPNS records matching filter
+// which were published with a user's keys
+func (im *IpnsManager) ListEntriesForUser(username string, filter IPNSFilter, page Page) (*[]IPNS, *PageInfo, error) {
+	userID, err := findUserID(im.DB, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := im.DB.
+		Joins("JOIN ipfs_keys ON ipfs_keys.name = ip_ns.key").
+		Where("ipfs_keys.user_id = ?", userID)
+	if filter.NetworkName != "" {
+		query = query.Where("ip_ns.network_name = ?", filter.NetworkName)
+	}
+	entries := []IPNS{}
+	info, err := paginate(createdBetween(query, "ip_ns", filter.CreatedAfter, filter.CreatedBefore), page, &entries, "updated_at", "sequence")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &entries, info, nil
+}
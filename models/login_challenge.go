@@ -0,0 +1,66 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidChallenge is returned when signing in with a challenge which doesn't exist, has expired or has already been used
+var ErrInvalidChallenge = errors.New("invalid or expired sign in challenge")
+
+// LoginChallenge is a message issued to an ethereum address, which is signed with the address's
+// key to sign in as the user it is linked to. Each challenge can only be used once
+type LoginChallenge struct {
+	ID         uint `gorm:"primary_key"`
+	CreatedAt  time.Time
+	EthAddress string    `gorm:"type:varchar(42);not null"`
+	Nonce      string    `gorm:"type:varchar(64);not null;unique"`
+	Message    string    `gorm:"type:text;not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+}
+
+// NewLoginChallenge is used to store a challenge issued to an ethereum address
+func (um *UserManager) NewLoginChallenge(challenge *LoginChallenge) error {
+	return um.DB.Create(challenge).Error
+}
+
+// FindLoginChallenge is used to find an unused, unexpired challenge issued to an ethereum address
+func (um *UserManager) FindLoginChallenge(ethAddress, nonce string) (*LoginChallenge, error) {
+	challenge := LoginChallenge{}
+	check := um.DB.Where("lower(eth_address) = lower(?) AND nonce = ? AND used_at IS NULL AND expires_at > ?",
+		ethAddress, nonce, time.Now()).First(&challenge)
+	if check.RecordNotFound() {
+		return nil, ErrInvalidChallenge
+	} else if check.Error != nil {
+		return nil, check.Error
+	}
+	return &challenge, nil
+}
+
+// RedeemLoginChallenge is used to use up a challenge once its signature has been checked, returning
+// the user the address is linked to. Addresses which aren't linked to an enabled account can't sign in
+func (um *UserManager) RedeemLoginChallenge(ethAddress, nonce string) (*User, error) {
+	u := User{}
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		now := time.Now()
+		check := tx.Model(&LoginChallenge{}).
+			Where("lower(eth_address) = lower(?) AND nonce = ? AND used_at IS NULL AND expires_at > ?", ethAddress, nonce, now).
+			Update("used_at", now)
+		if check.Error != nil {
+			return check.Error
+		}
+		if check.RowsAffected == 0 {
+			return ErrInvalidChallenge
+		}
+		return tx.Where("lower(eth_address) = lower(?) AND account_enabled = ?", ethAddress, true).First(&u).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidChallenge
+	} else if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
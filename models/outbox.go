@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OutboxMessage is a queue message waiting to be published. Messages are written in the
+// same transaction as the database changes they describe, and published afterwards, so a
+// request can't commit without its message or publish a message for changes that rolled back
+type OutboxMessage struct {
+	gorm.Model
+	QueueName   string     `gorm:"type:varchar(255)" json:"queue_name"`
+	Body        string     `gorm:"type:text" json:"body"`
+	RequestID   string     `gorm:"type:varchar(255)" json:"request_id"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `gorm:"type:text" json:"last_error"`
+	PublishedAt *time.Time `json:"published_at"`
+	// NextAttemptAt is when a message which failed to publish is retried
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	// DeadLetteredAt is set once a message has failed too often, or can never be published,
+	// and it's no longer retried. These are left for the admin to inspect
+	DeadLetteredAt *time.Time `json:"dead_lettered_at"`
+}
+
+// OutboxManager is used to manipulate outbox messages. To write messages atomically with
+// other changes, generate the manager with the transaction being used for those changes
+type OutboxManager struct {
+	DB *gorm.DB
+}
+
+// NewOutboxManager is used to generate our outbox manager
+func NewOutboxManager(db *gorm.DB) *OutboxManager {
+	return &OutboxManager{DB: db}
+}
+
+// Enqueue is used to store a message for publishing to the given queue, recording the id
+// of the api request which caused it so it can be traced once published
+func (om *OutboxManager) Enqueue(queueName, requestID string, body interface{}) (*OutboxMessage, error) {
+	marshaled, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	msg := OutboxMessage{
+		QueueName: queueName,
+		Body:      string(marshaled),
+		RequestID: requestID,
+	}
+	if check := om.DB.Create(&msg); check.Error != nil {
+		return nil, check.Error
+	}
+	return &msg, nil
+}
+
+// ClaimUnpublished is used to find up to limit messages which are due to be published, oldest
+// first. The messages are locked until om's transaction ends, and messages locked by another
+// transaction are skipped, so api replicas don't publish the same messages
+func (om *OutboxManager) ClaimUnpublished(limit int) (*[]OutboxMessage, error) {
+	msgs := []OutboxMessage{}
+	if check := om.DB.
+		Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Where("published_at IS NULL AND dead_lettered_at IS NULL").
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", time.Now()).
+		Order("id asc").
+		Limit(limit).
+		Find(&msgs); check.Error != nil {
+		return nil, check.Error
+	}
+	return &msgs, nil
+}
+
+// MarkPublished is used to record that a message has been published. The body is
+// cleared, since messages may carry sensitive data which rabbitmq is now responsible for
+func (om *OutboxManager) MarkPublished(id uint) error {
+	return om.DB.Model(&OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"published_at": time.Now(),
+		"body":         "",
+	}).Error
+}
+
+// MarkFailed is used to record a failed attempt to publish a message, which is retried at nextAttempt
+func (om *OutboxManager) MarkFailed(id uint, publishErr error, nextAttempt time.Time) error {
+	return om.DB.Model(&OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_error":      publishErr.Error(),
+		"next_attempt_at": nextAttempt,
+	}).Error
+}
+
+// MarkDeadLettered is used to record the failure of a message which won't be retried
+func (om *OutboxManager) MarkDeadLettered(id uint, publishErr error) error {
+	return om.DB.Model(&OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":         gorm.Expr("attempts + 1"),
+		"last_error":       publishErr.Error(),
+		"dead_lettered_at": time.Now(),
+	}).Error
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// DefaultPageLimit is the number of rows in a page when no limit is requested
+	DefaultPageLimit = 50
+	// MaxPageLimit is the largest page which can be requested
+	MaxPageLimit = 200
+)
+
+// Page is used to request a page of a listing. Listings are paginated by a cursor
+// marking where the previous page ended rather than an offset, so pages stay
+// consistent while rows are added, and later pages are as cheap as the first
+type Page struct {
+	// Cursor is the NextCursor of the previous page, and is empty for the first page
+	Cursor string
+	Limit  int
+	// SortBy is the column to sort by, defaulting to created_at
+	SortBy string
+	// Ascending sorts oldest or smallest first, rather than newest or largest first
+	Ascending bool
+}
+
+// PageInfo describes a returned page. NextCursor is empty on the last page
+type PageInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	Limit      int    `json:"limit"`
+	SortBy     string `json:"sort_by"`
+	Ascending  bool   `json:"ascending"`
+}
+
+// cursor is the position of the last row of a page: the value of the sorted
+// column, and the row's id to order rows sharing that value
+type cursor struct {
+	SortBy string `json:"s"`
+	Value  string `json:"v"`
+	ID     uint   `json:"id"`
+}
+
+// ErrInvalidCursor is returned when a cursor wasn't returned by the listing it's used with
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// paginate is used to find a page of the rows matched by query into out, a pointer to
+// a slice of models. Only the columns in sortable, which must not be null, can be sorted by
+func paginate(query *gorm.DB, page Page, out interface{}, sortable ...string) (*PageInfo, error) {
+	if page.Limit == 0 {
+		page.Limit = DefaultPageLimit
+	}
+	if page.Limit < 1 || page.Limit > MaxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %v", MaxPageLimit)
+	}
+	if page.SortBy == "" {
+		page.SortBy = "created_at"
+	}
+	if page.SortBy != "created_at" && !contains(sortable, page.SortBy) {
+		return nil, fmt.Errorf("can't sort by %s", page.SortBy)
+	}
+	table := query.NewScope(out).TableName()
+	column := fmt.Sprintf("%s.%s", table, page.SortBy)
+	id := fmt.Sprintf("%s.id", table)
+	direction, comparison := "desc", "<"
+	if page.Ascending {
+		direction, comparison = "asc", ">"
+	}
+	if page.Cursor != "" {
+		after, err := decodeCursor(page.Cursor)
+		if err != nil || after.SortBy != page.SortBy {
+			return nil, ErrInvalidCursor
+		}
+		query = query.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", column, id, comparison), after.Value, after.ID)
+	}
+	// one more row than requested is fetched, to tell whether there is another page
+	if check := query.
+		Order(fmt.Sprintf("%s %s", column, direction)).
+		Order(fmt.Sprintf("%s %s", id, direction)).
+		Limit(page.Limit + 1).
+		Find(out); check.Error != nil {
+		return nil, check.Error
+	}
+	info := &PageInfo{Limit: page.Limit, SortBy: page.SortBy, Ascending: page.Ascending}
+	rows := reflect.ValueOf(out).Elem()
+	if rows.Len() <= page.Limit {
+		return info, nil
+	}
+	rows.Set(rows.Slice(0, page.Limit))
+	scope := query.NewScope(rows.Index(page.Limit - 1).Addr().Interface())
+	field, ok := scope.FieldByName(page.SortBy)
+	if !ok {
+		return nil, fmt.Errorf("can't sort by %s", page.SortBy)
+	}
+	next, err := encodeCursor(page.SortBy, field.Field.Interface(), scope.PrimaryKeyValue())
+	if err != nil {
+		return nil, err
+	}
+	info.NextCursor = next
+	return info, nil
+}
+
+func encodeCursor(sortBy string, value, id interface{}) (string, error) {
+	c := cursor{SortBy: sortBy, Value: fmt.Sprint(value)}
+	if t, ok := value.(time.Time); ok {
+		c.Value = t.UTC().Format(time.RFC3339Nano)
+	}
+	switch id := id.(type) {
+	case uint:
+		c.ID = id
+	default:
+		return "", fmt.Errorf("unsupported primary key %v", id)
+	}
+	marshaled, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(marshaled), nil
+}
+
+func decodeCursor(encoded string) (*cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(decoded, c); err != nil || c.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// createdBetween is used to filter a query to rows of table created within a date range,
+// where either end of the range is ignored when zero
+func createdBetween(query *gorm.DB, table string, after, before time.Time) *gorm.DB {
+	if !after.IsZero() {
+		query = query.Where(fmt.Sprintf("%s.created_at >= ?", table), after)
+	}
+	if !before.IsZero() {
+		query = query.Where(fmt.Sprintf("%s.created_at < ?", table), before)
+	}
+	return query
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"math/big"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -34,6 +35,15 @@ type Payment struct {
 	Type             string `json:"time"`
 	HoldTimeInMonths int64  `json:"hold_time_in_months"`
 }
+
+// PaymentFilter narrows a listing of payments. Fields left empty aren't filtered on
+type PaymentFilter struct {
+	NetworkName   string
+	Type          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
 type PaymentManager struct {
 	DB *gorm.DB
 }
@@ -93,3 +103,20 @@ func (pm *PaymentManager) RetrieveLatestPaymentNumberForUser(username string) (*
 	}
 	return num, nil
 }
+
+// ListPaymentsForUser is used to list a page of a user's payments matching filter
+func (pm *PaymentManager) ListPaymentsForUser(username string, filter PaymentFilter, page Page) (*[]Payment, *PageInfo, error) {
+	query := pm.DB.Where("payments.user_name = ?", username)
+	if filter.NetworkName != "" {
+		query = query.Where("payments.network_name = ?", filter.NetworkName)
+	}
+	if filter.Type != "" {
+		query = query.Where("payments.type = ?", filter.Type)
+	}
+	payments := []Payment{}
+	info, err := paginate(createdBetween(query, "payments", filter.CreatedAfter, filter.CreatedBefore), page, &payments, "hold_time_in_months")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &payments, info, nil
+}
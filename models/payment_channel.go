@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ChannelStateOpen is a channel that is accepting vouchers
+	ChannelStateOpen = "open"
+	// ChannelStateClosed is a channel that has been settled on-chain
+	ChannelStateClosed = "closed"
+)
+
+// PaymentChannel is our database model for off-chain payment channels.
+// LatestAmount and LatestSignature hold the largest voucher we have received,
+// which is the only voucher that needs to be submitted when settling.
+type PaymentChannel struct {
+	gorm.Model
+	ChannelID       string    `gorm:"type:varchar(255);unique" json:"channel_id"`
+	UserName        string    `gorm:"type:varchar(255)" json:"user_name"`
+	SenderAddress   string    `gorm:"type:varchar(255)" json:"sender_address"`
+	Deposit         string    `gorm:"type:varchar(255)" json:"deposit"`
+	LatestAmount    string    `gorm:"type:varchar(255)" json:"latest_amount"`
+	LatestSignature string    `gorm:"type:varchar(255)" json:"latest_signature"`
+	Expiration      time.Time `json:"expiration"`
+	State           string    `gorm:"type:varchar(255)" json:"state"`
+	SettlementTx    string    `gorm:"type:varchar(255)" json:"settlement_tx"`
+}
+
+// PaymentChannelManager is used to manipulate payment channel models
+type PaymentChannelManager struct {
+	DB *gorm.DB
+}
+
+// NewPaymentChannelManager is used to generate our payment channel manager
+func NewPaymentChannelManager(db *gorm.DB) *PaymentChannelManager {
+	return &PaymentChannelManager{DB: db}
+}
+
+// NewChannel is used to record a channel which has been opened on-chain
+func (pcm *PaymentChannelManager) NewChannel(channelID, username, senderAddress string, deposit *big.Int, expiration time.Time) (*PaymentChannel, error) {
+	pc := PaymentChannel{}
+	check := pcm.DB.Where("channel_id = ?", channelID).First(&pc)
+	if check.Error == nil {
+		return nil, errors.New("payment channel already exists")
+	}
+	if check.Error != gorm.ErrRecordNotFound {
+		return nil, check.Error
+	}
+	pc.ChannelID = channelID
+	pc.UserName = username
+	pc.SenderAddress = senderAddress
+	pc.Deposit = deposit.String()
+	pc.LatestAmount = "0"
+	pc.Expiration = expiration
+	pc.State = ChannelStateOpen
+	if check = pcm.DB.Create(&pc); check.Error != nil {
+		return nil, check.Error
+	}
+	return &pc, nil
+}
+
+// FindChannelByChannelID is used to find a payment channel by its on-chain identifier
+func (pcm *PaymentChannelManager) FindChannelByChannelID(channelID string) (*PaymentChannel, error) {
+	pc := PaymentChannel{}
+	if check := pcm.DB.Where("channel_id = ?", channelID).First(&pc); check.Error != nil {
+		return nil, check.Error
+	}
+	return &pc, nil
+}
+
+// FindChannelsByUserName is used to find all payment channels opened by a user
+func (pcm *PaymentChannelManager) FindChannelsByUserName(username string) (*[]PaymentChannel, error) {
+	channels := []PaymentChannel{}
+	if check := pcm.DB.Where("user_name = ?", username).Find(&channels); check.Error != nil {
+		return nil, check.Error
+	}
+	return &channels, nil
+}
+
+// RecordVoucher is used to store a new latest voucher for a channel. The update only succeeds
+// if the stored amount still matches previousAmount, so concurrent requests can't overwrite
+// a larger voucher with a smaller one
+func (pcm *PaymentChannelManager) RecordVoucher(channelID string, previousAmount, amount *big.Int, signature string) error {
+	check := pcm.DB.Model(&PaymentChannel{}).
+		Where("channel_id = ? AND latest_amount = ? AND state = ?", channelID, previousAmount.String(), ChannelStateOpen).
+		Updates(map[string]interface{}{
+			"latest_amount":    amount.String(),
+			"latest_signature": signature,
+		})
+	if check.Error != nil {
+		return check.Error
+	}
+	if check.RowsAffected == 0 {
+		return errors.New("payment channel voucher is stale")
+	}
+	return nil
+}
+
+// FindChannelsForSettlement is used to find open channels which hold an unsettled voucher and
+// expire before the given time, oldest expiration first. Channels in skip aren't returned
+func (pcm *PaymentChannelManager) FindChannelsForSettlement(before time.Time, limit int, skip ...string) (*[]PaymentChannel, error) {
+	channels := []PaymentChannel{}
+	query := pcm.DB.Where("state = ? AND latest_amount <> ? AND expiration < ?", ChannelStateOpen, "0", before)
+	if len(skip) > 0 {
+		query = query.Where("channel_id NOT IN (?)", skip)
+	}
+	if check := query.
+		Order("expiration asc").
+		Limit(limit).
+		Find(&channels); check.Error != nil {
+		return nil, check.Error
+	}
+	return &channels, nil
+}
+
+// MarkChannelClosed is used to mark a channel as settled on-chain
+func (pcm *PaymentChannelManager) MarkChannelClosed(channelID, txHash string) error {
+	check := pcm.DB.Model(&PaymentChannel{}).Where("channel_id = ?", channelID).Updates(map[string]interface{}{
+		"state":         ChannelStateClosed,
+		"settlement_tx": txHash,
+	})
+	return check.Error
+}
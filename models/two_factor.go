@@ -0,0 +1,224 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/totp"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes issued when enabling two factor authentication
+	recoveryCodeCount = 10
+	// maxSecondFactorFailures is the number of invalid codes a user may give before they're locked out
+	maxSecondFactorFailures = 5
+	// secondFactorLockout is how long a user's second factor isn't checked once they're locked out
+	secondFactorLockout = time.Minute * 15
+)
+
+var (
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has two factor authentication enabled
+	ErrTwoFactorEnabled = errors.New("two factor authentication is already enabled")
+	// ErrTwoFactorNotEnrolled is returned when enabling two factor authentication before enrolling
+	ErrTwoFactorNotEnrolled = errors.New("two factor authentication enrollment has not been started")
+	// ErrInvalidSecondFactor is returned when a one-time password or recovery code is missing, wrong or already used
+	ErrInvalidSecondFactor = errors.New("invalid two factor authentication code")
+	// ErrSecondFactorLocked is returned when a user has given too many invalid codes, until their lockout expires
+	ErrSecondFactorLocked = errors.New("too many invalid two factor authentication codes, try again later")
+)
+
+// RecoveryCode is a single use code which can be given in place of a one-time password, for users
+// who have lost their authenticator. Only a hash of the code is stored
+type RecoveryCode struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null"`
+	CodeHash  string `gorm:"type:varchar(64);not null;unique"`
+	UsedAt    *time.Time
+}
+
+// EnrollTwoFactor is used to generate a new secret for a user's authenticator. It isn't required
+// at login until the user confirms it with EnableTwoFactor, so a failed enrollment can be restarted
+func (um *UserManager) EnrollTwoFactor(username string) (string, error) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	check := um.DB.Model(&User{}).
+		Where("user_name = ? AND two_factor_enabled = ?", username, false).
+		Updates(map[string]interface{}{"two_factor_secret": secret, "two_factor_last_step": 0})
+	if check.Error != nil {
+		return "", check.Error
+	}
+	if check.RowsAffected == 0 {
+		if _, err := findUserID(um.DB, username); err != nil {
+			return "", err
+		}
+		return "", ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor is used to require a second factor at login, once the user proves their
+// authenticator holds the enrolled secret. The user's recovery codes are returned
+func (um *UserManager) EnableTwoFactor(username, code string) ([]string, error) {
+	var codes []string
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		u := User{}
+		if check := tx.Where("user_name = ?", username).First(&u); check.Error != nil {
+			return check.Error
+		}
+		if u.TwoFactorEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if u.TwoFactorSecret == "" {
+			return ErrTwoFactorNotEnrolled
+		}
+		step, ok := totp.Verify(u.TwoFactorSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidSecondFactor
+		}
+		if check := tx.Model(&u).Updates(map[string]interface{}{
+			"two_factor_enabled":   true,
+			"two_factor_last_step": step,
+		}); check.Error != nil {
+			return check.Error
+		}
+		var err error
+		codes, err = newRecoveryCodes(tx, u.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor is used to stop requiring a second factor, removing the user's secret and recovery codes
+func (um *UserManager) DisableTwoFactor(username string) error {
+	return transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		if check := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"two_factor_enabled":      false,
+			"two_factor_secret":       "",
+			"two_factor_last_step":    0,
+			"two_factor_failures":     0,
+			"two_factor_locked_until": nil,
+		}); check.Error != nil {
+			return check.Error
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes is used to replace a user's recovery codes with new ones
+func (um *UserManager) RegenerateRecoveryCodes(username string) ([]string, error) {
+	var codes []string
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		u := User{}
+		if check := tx.Where("user_name = ?", username).First(&u); check.Error != nil {
+			return check.Error
+		}
+		if !u.TwoFactorEnabled {
+			return ErrTwoFactorNotEnrolled
+		}
+		var err error
+		codes, err = newRecoveryCodes(tx, u.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CheckSecondFactor is used to check the one-time password or recovery code given by a user,
+// which passes when the user doesn't have two factor authentication enabled. Each one-time
+// password and recovery code is only accepted once, so intercepted codes can't be replayed.
+// Users who give too many invalid codes are locked out for a while, so codes can't be guessed
+func (um *UserManager) CheckSecondFactor(username, code string) error {
+	u := User{}
+	if check := um.DB.Where("user_name = ?", username).First(&u); check.Error != nil {
+		return check.Error
+	}
+	if !u.TwoFactorEnabled {
+		return nil
+	}
+	if u.TwoFactorLockedUntil != nil && time.Now().Before(*u.TwoFactorLockedUntil) {
+		return ErrSecondFactorLocked
+	}
+	if code == "" {
+		return ErrInvalidSecondFactor
+	}
+	err := um.checkSecondFactor(&u, code)
+	if err == ErrInvalidSecondFactor {
+		if err := um.recordSecondFactorFailure(u.ID); err != nil {
+			return err
+		}
+	} else if err == nil && u.TwoFactorFailures > 0 {
+		err = um.DB.Model(&User{}).Where("id = ?", u.ID).Update("two_factor_failures", 0).Error
+	}
+	return err
+}
+
+// checkSecondFactor is used to redeem a one-time password or recovery code given by a user
+func (um *UserManager) checkSecondFactor(u *User, code string) error {
+	if step, ok := totp.Verify(u.TwoFactorSecret, code, time.Now()); ok {
+		check := um.DB.Model(&User{}).
+			Where("id = ? AND two_factor_last_step < ?", u.ID, step).
+			Update("two_factor_last_step", step)
+		if check.Error != nil {
+			return check.Error
+		}
+		if check.RowsAffected == 0 {
+			return ErrInvalidSecondFactor
+		}
+		return nil
+	}
+	check := um.DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", u.ID, hashToken(code)).
+		Update("used_at", time.Now())
+	if check.Error != nil {
+		return check.Error
+	}
+	if check.RowsAffected == 0 {
+		return ErrInvalidSecondFactor
+	}
+	return nil
+}
+
+// recordSecondFactorFailure is used to count an invalid code, locking the user out once they've
+// given too many. The count is restarted when they're locked out
+func (um *UserManager) recordSecondFactorFailure(userID uint) error {
+	return um.DB.Exec(`UPDATE users SET
+		two_factor_failures = CASE WHEN two_factor_failures + 1 >= ? THEN 0 ELSE two_factor_failures + 1 END,
+		two_factor_locked_until = CASE WHEN two_factor_failures + 1 >= ? THEN ? ELSE two_factor_locked_until END
+		WHERE id = ?`,
+		maxSecondFactorFailures, maxSecondFactorFailures, time.Now().Add(secondFactorLockout), userID,
+	).Error
+}
+
+// newRecoveryCodes is used to replace a user's recovery codes, returning the new codes
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		codes[i] = hex.EncodeToString(raw)
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(codes[i])}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
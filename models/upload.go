@@ -8,7 +8,6 @@ import (
 
 	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/jinzhu/gorm"
-	"github.com/lib/pq"
 )
 
 type Upload struct {
@@ -19,9 +18,40 @@ type Upload struct {
 	HoldTimeInMonths   int64  `gorm:"type:integer;not null;"`
 	UserName           string `gorm:"type:varchar(255);not null;"`
 	GarbageCollectDate time.Time
-	UserNames          pq.StringArray `gorm:"type:text[];not null;"`
 }
 
+// UserUpload records a user who has uploaded, or pinned, an upload's content
+type UserUpload struct {
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	UploadID  uint `gorm:"primary_key;auto_increment:false"`
+	CreatedAt time.Time
+}
+
+// UploadFilter narrows a listing of uploads. Fields left empty aren't filtered on
+type UploadFilter struct {
+	NetworkName    string
+	Type           string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	ExpiringBefore time.Time
+}
+
+func (f UploadFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.NetworkName != "" {
+		query = query.Where("uploads.network_name = ?", f.NetworkName)
+	}
+	if f.Type != "" {
+		query = query.Where("uploads.type = ?", f.Type)
+	}
+	if !f.ExpiringBefore.IsZero() {
+		query = query.Where("uploads.garbage_collect_date < ?", f.ExpiringBefore)
+	}
+	return createdBetween(query, "uploads", f.CreatedAfter, f.CreatedBefore)
+}
+
+// uploadSortable are the columns uploads can be sorted by, besides created_at
+var uploadSortable = []string{"garbage_collect_date", "hold_time_in_months"}
+
 const dev = true
 
 type UploadManager struct {
@@ -33,7 +63,7 @@ func NewUploadManager(db *gorm.DB) *UploadManager {
 	return &UploadManager{DB: db}
 }
 
-// NewUpload is used to create a new upload in the database
+// NewUpload is used to create a new upload in the database, along with the user who uploaded it
 func (um *UploadManager) NewUpload(contentHash, uploadType, networkName, username string, holdTimeInMonths int64) (*Upload, error) {
 	_, err := um.FindUploadByHashAndNetwork(contentHash, networkName)
 	if err == nil {
@@ -51,47 +81,76 @@ func (um *UploadManager) NewUpload(contentHash, uploadType, networkName, usernam
 		HoldTimeInMonths:   holdTimeInMonths,
 		UserName:           username,
 		GarbageCollectDate: utils.CalculateGarbageCollectDate(holdInt),
-		UserNames:          []string{username},
 	}
-	if check := um.DB.Create(&upload); check.Error != nil {
-		return nil, check.Error
+	err = transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		if check := tx.Create(&upload); check.Error != nil {
+			return check.Error
+		}
+		return tx.Create(&UserUpload{UserID: userID, UploadID: upload.ID}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 	return &upload, nil
 }
 
-// UpdateUpload is used to upadte an already existing upload
+// UpdateUpload is used to upadte an already existing upload, adding the user to its uploaders.
+// The hold time is only ever extended, so concurrent updates can't shorten it
 func (um *UploadManager) UpdateUpload(holdTimeInMonths int64, username, contentHash, networkName string) (*Upload, error) {
 	upload, err := um.FindUploadByHashAndNetwork(contentHash, networkName)
 	if err != nil {
 		return nil, err
 	}
-	isUploader := false
-	upload.UserName = username
-	for _, v := range upload.UserNames {
-		if username == v {
-			isUploader = true
-			break
-		}
-	}
-	if !isUploader {
-		upload.UserNames = append(upload.UserNames, username)
-	}
 	holdInt, err := strconv.Atoi(fmt.Sprintf("%v", holdTimeInMonths))
 	if err != nil {
 		return nil, err
 	}
-	oldGcd := upload.GarbageCollectDate
 	newGcd := utils.CalculateGarbageCollectDate(holdInt)
-	if newGcd.Unix() > oldGcd.Unix() {
-		upload.HoldTimeInMonths = holdTimeInMonths
-		upload.GarbageCollectDate = oldGcd
-	}
-	if check := um.DB.Save(upload); check.Error != nil {
+	err = transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		if check := tx.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").
+			Create(&UserUpload{UserID: userID, UploadID: upload.ID}); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Model(upload).Update("user_name", username); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Model(&Upload{}).
+			Where("id = ? AND garbage_collect_date < ?", upload.ID, newGcd).
+			Updates(map[string]interface{}{
+				"hold_time_in_months":  holdTimeInMonths,
+				"garbage_collect_date": newGcd,
+			}); check.Error != nil {
+			return check.Error
+		}
+		return tx.First(upload, upload.ID).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 	return upload, nil
 }
 
+// GetUserNamesForUpload is used to get the names of the users who have uploaded an upload's content
+func (um *UploadManager) GetUserNamesForUpload(uploadID uint) ([]string, error) {
+	names := []string{}
+	if check := um.DB.Table("users").
+		Joins("JOIN user_uploads ON user_uploads.user_id = users.id").
+		Where("user_uploads.upload_id = ? AND users.deleted_at IS NULL", uploadID).
+		Order("user_uploads.created_at").
+		Pluck("users.user_name", &names); check.Error != nil {
+		return nil, check.Error
+	}
+	return names, nil
+}
+
 // RunDatabaseGarbageCollection is used to parse through the database
 // and delete all objects whose GCD has passed
 // TODO: Maybe move this to the database file?
@@ -134,6 +193,15 @@ func (um *UploadManager) RunTestDatabaseGarbageCollection() (*[]Upload, error) {
 	return &deletedUploads, nil
 }
 
+// FindUploadsExpiringBetween is used to find uploads which will be garbage collected within the given window
+func (um *UploadManager) FindUploadsExpiringBetween(from, to time.Time) (*[]Upload, error) {
+	uploads := []Upload{}
+	if check := um.DB.Where("garbage_collect_date >= ? AND garbage_collect_date < ?", from, to).Find(&uploads); check.Error != nil {
+		return nil, check.Error
+	}
+	return &uploads, nil
+}
+
 func (um *UploadManager) FindUploadsByNetwork(networkName string) (*[]Upload, error) {
 	uploads := &[]Upload{}
 	if check := um.DB.Where("network_name = ?", networkName).Find(uploads); check.Error != nil {
@@ -160,10 +228,10 @@ func (um *UploadManager) FindUploadsByHash(hash string) *[]Upload {
 	return &uploads
 }
 
-// GetUploadByHashForUser is used to retrieve the last (most recent) upload for a user
+// GetUploadByHashForUser is used to retrieve the uploads of a hash which a user has uploaded
 func (um *UploadManager) GetUploadByHashForUser(hash string, username string) []*Upload {
 	var uploads []*Upload
-	um.DB.Find(&uploads).Where("hash = ? AND user_name = ?", hash, username)
+	um.forUser(username).Where("uploads.hash = ?", hash).Find(&uploads)
 	return uploads
 }
 
@@ -179,8 +247,36 @@ func (um *UploadManager) GetUploads() (*[]Upload, error) {
 // GetUploadsForUser is used to retrieve all uploads by a user name
 func (um *UploadManager) GetUploadsForUser(username string) (*[]Upload, error) {
 	uploads := []Upload{}
-	if check := um.DB.Where("user_name = ?", username).Find(&uploads); check.Error != nil {
+	if check := um.forUser(username).Find(&uploads); check.Error != nil {
 		return nil, check.Error
 	}
 	return &uploads, nil
 }
+
+// ListUploads is used to list a page of every upload matching filter
+func (um *UploadManager) ListUploads(filter UploadFilter, page Page) (*[]Upload, *PageInfo, error) {
+	uploads := []Upload{}
+	info, err := paginate(filter.apply(um.DB), page, &uploads, uploadSortable...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &uploads, info, nil
+}
+
+// ListUploadsForUser is used to list a page of the uploads matching filter which a user has uploaded
+func (um *UploadManager) ListUploadsForUser(username string, filter UploadFilter, page Page) (*[]Upload, *PageInfo, error) {
+	uploads := []Upload{}
+	info, err := paginate(filter.apply(um.forUser(username)), page, &uploads, uploadSortable...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &uploads, info, nil
+}
+
+// forUser is used to scope a query of uploads to those a user has uploaded
+func (um *UploadManager) forUser(username string) *gorm.DB {
+	return um.DB.
+		Joins("JOIN user_uploads ON user_uploads.upload_id = uploads.id").
+		Joins("JOIN users ON users.id = user_uploads.user_id").
+		Where("users.user_name = ?", username)
+}
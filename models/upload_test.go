@@ -0,0 +1,75 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/utils"
+)
+
+func TestUploadManager_ListUploadsForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabaseConnection(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userManager := models.NewUserManager(db)
+	uploadManager := models.NewUploadManager(db)
+
+	var (
+		randUtils  = utils.GenerateRandomUtils()
+		username   = randUtils.GenerateString(10, utils.LetterBytes)
+		ethAddress = randUtils.GenerateString(10, utils.LetterBytes)
+		email      = randUtils.GenerateString(10, utils.LetterBytes)
+	)
+	if _, err := userManager.NewUserAccount(ethAddress, username, "password123", email, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		hash := randUtils.GenerateString(46, utils.LetterBytes)
+		if _, err := uploadManager.NewUpload(hash, "pin", "public", username, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[uint]bool)
+	page := models.Page{Limit: 2}
+	for pages := 1; ; pages++ {
+		uploads, info, err := uploadManager.ListUploadsForUser(username, models.UploadFilter{Type: "pin"}, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, upload := range *uploads {
+			if seen[upload.ID] {
+				t.Fatalf("upload %v listed twice", upload.ID)
+			}
+			seen[upload.ID] = true
+		}
+		if info.NextCursor == "" {
+			if pages != 3 {
+				t.Fatalf("expected 3 pages, got %v", pages)
+			}
+			break
+		}
+		page.Cursor = info.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 uploads, got %v", len(seen))
+	}
+
+	if _, _, err := uploadManager.ListUploadsForUser(username, models.UploadFilter{}, models.Page{Cursor: "invalid"}); err != models.ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+	if _, _, err := uploadManager.ListUploadsForUser(username, models.UploadFilter{}, models.Page{SortBy: "hash"}); err == nil {
+		t.Fatal("expected unsortable column to fail")
+	}
+}
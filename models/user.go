@@ -3,7 +3,7 @@ package models
 import (
 	"encoding/hex"
 	"errors"
-	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
@@ -22,11 +22,53 @@ type User struct {
 	AccountEnabled    bool   `gorm:"type:boolean"`
 	APIAccess         bool   `gorm:"type:boolean"`
 	EmailEnabled      bool   `gorm:"type:boolean"`
-	HashedPassword    string `gorm:"type:varchar(255)"`
-	// IPFSKeyNames is an array of IPFS keys this user has created
-	IPFSKeyNames     pq.StringArray `gorm:"type:text[];column:ipfs_key_names"`
-	IPFSKeyIDs       pq.StringArray `gorm:"type:text[];column:ipfs_key_ids"`
-	IPFSNetworkNames pq.StringArray `gorm:"type:text[];column:ipfs_network_names"`
+	// EmailVerified is set once the user has redeemed an email verification token,
+	// which also grants them api access unless the admin has revoked it
+	EmailVerified  bool   `gorm:"type:boolean"`
+	HashedPassword string `gorm:"type:varchar(255)"`
+	// APIAccessRevoked is set while the admin has revoked the user's api access
+	APIAccessRevoked bool `gorm:"type:boolean"`
+	// TwoFactorEnabled requires a one-time password from the user's authenticator, or a recovery
+	// code, at login and for sensitive operations
+	TwoFactorEnabled bool   `gorm:"type:boolean"`
+	TwoFactorSecret  string `gorm:"type:varchar(255)" json:"-"`
+	// TwoFactorLastStep is the period of the last one-time password accepted, so it can't be reused
+	TwoFactorLastStep int64 `json:"-"`
+	// TwoFactorFailures counts the invalid codes given since the last valid one, and the user's
+	// second factor isn't checked until TwoFactorLockedUntil once there are too many
+	TwoFactorFailures    int        `json:"-"`
+	TwoFactorLockedUntil *time.Time `json:"-"`
+	// DisabledNotifications is an array of email notification types this user has opted out of
+	DisabledNotifications pq.StringArray `gorm:"type:text[];column:disabled_notifications"`
+}
+
+// Account tiers, which are given their own rate limits
+const (
+	TierStandard   = "standard"
+	TierEnterprise = "enterprise"
+)
+
+// Tier is used to find the user's account tier
+func (u *User) Tier() string {
+	if u.EnterpriseEnabled {
+		return TierEnterprise
+	}
+	return TierStandard
+}
+
+// NotificationEnabled is used to check whether the user wants to receive an email notification.
+// EmailEnabled turns all emails on or off, while DisabledNotifications opts out of individual
+// notification types. An empty notification only checks EmailEnabled
+func (u *User) NotificationEnabled(notification string) bool {
+	if !u.EmailEnabled {
+		return false
+	}
+	for _, v := range u.DisabledNotifications {
+		if v == notification {
+			return false
+		}
+	}
+	return true
 }
 
 type UserManager struct {
@@ -39,121 +81,121 @@ func NewUserManager(db *gorm.DB) *UserManager {
 	return &um
 }
 
+// GetPrivateIPFSNetworksForUser is used to get the names of the private networks a user is a member of
 func (um *UserManager) GetPrivateIPFSNetworksForUser(username string) ([]string, error) {
-	u := &User{}
-	if check := um.DB.Where("user_name = ?", username).First(u); check.Error != nil {
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if check := um.DB.Table("hosted_ip_fs_private_networks").
+		Joins("JOIN network_members ON network_members.network_id = hosted_ip_fs_private_networks.id").
+		Where("network_members.user_id = ? AND hosted_ip_fs_private_networks.deleted_at IS NULL", userID).
+		Order("hosted_ip_fs_private_networks.name").
+		Pluck("DISTINCT hosted_ip_fs_private_networks.name", &names); check.Error != nil {
 		return nil, check.Error
 	}
-	return u.IPFSNetworkNames, nil
+	return names, nil
 }
 
+// CheckIfUserHasAccessToNetwork is used to check if a user is a member of the named private network
 func (um *UserManager) CheckIfUserHasAccessToNetwork(username, networkName string) (bool, error) {
-	u := &User{}
-	if check := um.DB.Where("user_name = ?", username).First(u); check.Error != nil {
-		return false, check.Error
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return false, err
 	}
-	for _, v := range u.IPFSNetworkNames {
-		if v == networkName {
-			return true, nil
-		}
+	var count int
+	if check := um.DB.Model(&NetworkMember{}).
+		Joins("JOIN hosted_ip_fs_private_networks ON hosted_ip_fs_private_networks.id = network_members.network_id").
+		Where("network_members.user_id = ? AND hosted_ip_fs_private_networks.name = ? AND hosted_ip_fs_private_networks.deleted_at IS NULL", userID, networkName).
+		Count(&count); check.Error != nil {
+		return false, check.Error
 	}
-	return false, nil
+	return count > 0, nil
 }
+
+// AddIPFSNetworkForUser is used to make a user a member of the named private network
 func (um *UserManager) AddIPFSNetworkForUser(username, networkName string) error {
-	u := &User{}
-	if check := um.DB.Where("user_name = ?", username).First(u); check.Error != nil {
-		return check.Error
-	}
-	for _, v := range u.IPFSNetworkNames {
-		if v == networkName {
+	return transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		pnet, err := NewHostedIPFSNetworkManager(tx).GetNetworkByName(networkName)
+		if err != nil {
+			return err
+		}
+		var count int
+		if check := tx.Model(&NetworkMember{}).Where("network_id = ? AND user_id = ?", pnet.ID, userID).Count(&count); check.Error != nil {
+			return check.Error
+		}
+		if count > 0 {
 			return errors.New("network already configured for user")
 		}
-	}
-	u.IPFSNetworkNames = append(u.IPFSNetworkNames, networkName)
-	if check := um.DB.Model(u).Update("ipfs_network_names", u.IPFSNetworkNames); check.Error != nil {
-		return check.Error
-	}
-
-	return nil
+		return tx.Create(&NetworkMember{NetworkID: pnet.ID, UserID: userID}).Error
+	})
 }
 
+// AddIPFSKeyForUser is used to record a keystore key created by a user. Keys which
+// have already been recorded are skipped
 func (um *UserManager) AddIPFSKeyForUser(username, keyName, keyID string) error {
-	var user User
-	if errCheck := um.DB.Where("user_name = ?", username).First(&user); errCheck.Error != nil {
-		return errCheck.Error
-	}
-
-	if user.CreatedAt == nilTime {
-		return errors.New("user account does not exist")
-	}
-
-	for _, v := range user.IPFSKeyNames {
-		if v == keyName {
-			fmt.Println("key already exists in db, skipping")
-			return nil
-		}
-	}
-	user.IPFSKeyNames = append(user.IPFSKeyNames, keyName)
-	user.IPFSKeyIDs = append(user.IPFSKeyIDs, keyID)
-	// The following only updates the specified column for the given model
-	if errCheck := um.DB.Model(&user).Updates(map[string]interface{}{
-		"ipfs_key_names": user.IPFSKeyNames,
-		"ipfs_key_ids":   user.IPFSKeyIDs,
-	}); errCheck.Error != nil {
-		return errCheck.Error
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return err
 	}
-	return nil
+	key := IPFSKey{UserID: userID, Name: keyName, KeyID: keyID}
+	// the unique key name makes this safe against the same key being recorded concurrently
+	return um.DB.Set("gorm:insert_option", "ON CONFLICT (name) DO NOTHING").Create(&key).Error
 }
 
+// GetKeysForUser is used to get the names and ids of a user's keys, in the order they were created.
+// The returned map holds the names under key_names, and the ids under key_ids
 func (um *UserManager) GetKeysForUser(username string) (map[string][]string, error) {
-	var user User
-	keys := make(map[string][]string)
-	if errCheck := um.DB.Where("user_name = ?", username).First(&user); errCheck.Error != nil {
-		return nil, errCheck.Error
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return nil, err
 	}
-
-	if user.CreatedAt == nilTime {
-		return nil, errors.New("user account does not exist")
+	ipfsKeys := []IPFSKey{}
+	if check := um.DB.Where("user_id = ?", userID).Order("id asc").Find(&ipfsKeys); check.Error != nil {
+		return nil, check.Error
+	}
+	keys := map[string][]string{
+		"key_names": make([]string, 0, len(ipfsKeys)),
+		"key_ids":   make([]string, 0, len(ipfsKeys)),
+	}
+	for _, key := range ipfsKeys {
+		keys["key_names"] = append(keys["key_names"], key.Name)
+		keys["key_ids"] = append(keys["key_ids"], key.KeyID)
 	}
-
-	keys["key_names"] = user.IPFSKeyNames
-	keys["key_ids"] = user.IPFSKeyIDs
 	return keys, nil
 }
 
+// GetKeyIDByName is used to get the id of one of a user's keys
 func (um *UserManager) GetKeyIDByName(username, keyName string) (string, error) {
-	var user User
-	if errCheck := um.DB.Where("user_name = ?", username).First(&user); errCheck.Error != nil {
-		return "", errCheck.Error
-	}
-
-	if user.CreatedAt == nilTime {
-		return "", errors.New("user account does not exist")
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return "", err
 	}
-	for k, v := range user.IPFSKeyNames {
-		if v == keyName {
-			return user.IPFSKeyIDs[k], nil
-		}
+	key := IPFSKey{}
+	if check := um.DB.Where("user_id = ? AND name = ?", userID, keyName).First(&key); check.RecordNotFound() {
+		return "", errors.New("key not found")
+	} else if check.Error != nil {
+		return "", check.Error
 	}
-	return "", errors.New("key not found")
+	return key.KeyID, nil
 }
 
+// CheckIfKeyOwnedByUser is used to check if the named key was created by a user
 func (um *UserManager) CheckIfKeyOwnedByUser(username, keyName string) (bool, error) {
-	var user User
-	if errCheck := um.DB.Where("user_name = ?", username).First(&user); errCheck.Error != nil {
-		return false, errCheck.Error
-	}
-
-	if user.CreatedAt == nilTime {
-		return false, errors.New("user account does not exist")
+	userID, err := findUserID(um.DB, username)
+	if err != nil {
+		return false, err
 	}
-
-	for _, v := range user.IPFSKeyNames {
-		if v == keyName {
-			return true, nil
-		}
+	var count int
+	if check := um.DB.Model(&IPFSKey{}).Where("user_id = ? AND name = ?", userID, keyName).Count(&count); check.Error != nil {
+		return false, check.Error
 	}
-	return false, nil
+	return count > 0, nil
 }
 
 func (um *UserManager) CheckIfUserAccountEnabled(username string, db *gorm.DB) (bool, error) {
@@ -211,6 +253,7 @@ func (um *UserManager) NewUserAccount(ethAddress, username, password, email stri
 	user.HashedPassword = hex.EncodeToString(hashedPass)
 	user.EmailAddress = email
 	user.AccountEnabled = true
+	user.EmailEnabled = true
 	if check := um.DB.Create(&user); check.Error != nil {
 		return nil, check.Error
 	}
@@ -285,6 +328,42 @@ func (um *UserManager) FindEmailByUserName(username string) (map[string]string,
 	return emails, nil
 }
 
+// FindByUserName is used to find a user by their user name
+func (um *UserManager) FindByUserName(username string) (*User, error) {
+	u := User{}
+	if check := um.DB.Where("user_name = ?", username).First(&u); check.Error != nil {
+		return nil, check.Error
+	}
+	return &u, nil
+}
+
+// SetEmailEnabled is used to turn all emails for a user on or off
+func (um *UserManager) SetEmailEnabled(username string, enabled bool) error {
+	u, err := um.FindByUserName(username)
+	if err != nil {
+		return err
+	}
+	return um.DB.Model(u).Update("email_enabled", enabled).Error
+}
+
+// SetNotificationEnabled is used to opt a user in to, or out of, a single email notification type
+func (um *UserManager) SetNotificationEnabled(username, notification string, enabled bool) error {
+	u, err := um.FindByUserName(username)
+	if err != nil {
+		return err
+	}
+	disabled := pq.StringArray{}
+	for _, v := range u.DisabledNotifications {
+		if v != notification {
+			disabled = append(disabled, v)
+		}
+	}
+	if !enabled {
+		disabled = append(disabled, notification)
+	}
+	return um.DB.Model(u).Update("disabled_notifications", disabled).Error
+}
+
 // ChangeEthereumAddress is used to change a user's ethereum address
 func (um *UserManager) ChangeEthereumAddress(username, ethAddress string) (*User, error) {
 	u := User{}
@@ -297,3 +376,129 @@ func (um *UserManager) ChangeEthereumAddress(username, ethAddress string) (*User
 	}
 	return &u, nil
 }
+
+// FindByEmailAddress is used to find a user by their email address
+func (um *UserManager) FindByEmailAddress(email string) (*User, error) {
+	u := User{}
+	if check := um.DB.Where("email_address = ?", email).First(&u); check.Error != nil {
+		return nil, check.Error
+	}
+	return &u, nil
+}
+
+// VerifyEmail is used to redeem an email verification token, verifying the email address
+// of the user it was issued to and granting them api access, unless the admin revoked it
+func (um *UserManager) VerifyEmail(token string) (*User, error) {
+	u := User{}
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := redeemAccountToken(tx, TokenEmailVerification, token)
+		if err != nil {
+			return err
+		}
+		if check := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"email_verified": true,
+			"api_access":     gorm.Expr("NOT api_access_revoked"),
+		}); check.Error != nil {
+			return check.Error
+		}
+		return tx.First(&u, userID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ResetPassword is used to redeem a password reset token, changing the password of the user it was issued to
+func (um *UserManager) ResetPassword(token, newPassword string) (*User, error) {
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	u := User{}
+	err = transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := redeemAccountToken(tx, TokenPasswordReset, token)
+		if err != nil {
+			return err
+		}
+		if check := tx.Model(&User{}).Where("id = ?", userID).
+			Update("hashed_password", hex.EncodeToString(hashedPass)); check.Error != nil {
+			return check.Error
+		}
+		return tx.First(&u, userID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// SetAccountEnabled is used to enable or disable a user's account. Disabled users can't sign in or use the api
+func (um *UserManager) SetAccountEnabled(username string, enabled bool) error {
+	u, err := um.FindByUserName(username)
+	if err != nil {
+		return err
+	}
+	return um.DB.Model(u).Update("account_enabled", enabled).Error
+}
+
+// SetAPIAccess is used to grant or revoke a user's api access. Revoked access isn't
+// granted again by verifying an email address
+func (um *UserManager) SetAPIAccess(username string, enabled bool) error {
+	u, err := um.FindByUserName(username)
+	if err != nil {
+		return err
+	}
+	return um.DB.Model(u).Updates(map[string]interface{}{
+		"api_access":         enabled,
+		"api_access_revoked": !enabled,
+	}).Error
+}
+
+// DeleteAccount is used to delete a user's account, returning the uploads nobody else holds, which
+// are deleted along with it and whose content should be unpinned. The user is disabled and soft
+// deleted, so their user name and keys can't be taken over by a new account. Use a transaction
+// to schedule the removals atomically with the deletion
+func (um *UserManager) DeleteAccount(username string) (*[]Upload, error) {
+	uploads := []Upload{}
+	err := transaction(um.DB, func(tx *gorm.DB) error {
+		userID, err := findUserID(tx, username)
+		if err != nil {
+			return err
+		}
+		if check := tx.
+			Joins("JOIN user_uploads ON user_uploads.upload_id = uploads.id").
+			Where("user_uploads.user_id = ?", userID).
+			Where("NOT EXISTS (SELECT 1 FROM user_uploads AS others WHERE others.upload_id = uploads.id AND others.user_id <> ?)", userID).
+			Find(&uploads); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Where("user_id = ?", userID).Delete(&UserUpload{}); check.Error != nil {
+			return check.Error
+		}
+		for _, upload := range uploads {
+			if check := tx.Delete(&upload); check.Error != nil {
+				return check.Error
+			}
+		}
+		if check := tx.Where("user_id = ?", userID).Delete(&NetworkMember{}); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Model(&AccountToken{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()); check.Error != nil {
+			return check.Error
+		}
+		if check := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"account_enabled": false,
+			"api_access":      false,
+		}); check.Error != nil {
+			return check.Error
+		}
+		return tx.Where("id = ?", userID).Delete(&User{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &uploads, nil
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/config"
 	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/totp"
 	"github.com/RTradeLtd/Temporal/utils"
 	"github.com/jinzhu/gorm"
 )
@@ -199,6 +201,269 @@ func TestUserManager_SignIn(t *testing.T) {
 	}
 }
 
+func TestUserManager_IPFSKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabaseConnection(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := models.NewUserManager(db)
+
+	var (
+		randUtils  = utils.GenerateRandomUtils()
+		username   = randUtils.GenerateString(10, utils.LetterBytes)
+		ethAddress = randUtils.GenerateString(10, utils.LetterBytes)
+		email      = randUtils.GenerateString(10, utils.LetterBytes)
+		keyName    = fmt.Sprintf("%s-%s", username, "key")
+	)
+	if _, err := um.NewUserAccount(ethAddress, username, "password123", email, false); err != nil {
+		t.Fatal(err)
+	}
+	// recording a key twice is a no-op
+	for i := 0; i < 2; i++ {
+		if err := um.AddIPFSKeyForUser(username, keyName, "QmKeyID"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := um.GetKeysForUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys["key_names"]) != 1 || keys["key_names"][0] != keyName || keys["key_ids"][0] != "QmKeyID" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if owned, err := um.CheckIfKeyOwnedByUser(username, keyName); err != nil || !owned {
+		t.Fatalf("expected key to be owned by user, got %v %v", owned, err)
+	}
+	if id, err := um.GetKeyIDByName(username, keyName); err != nil || id != "QmKeyID" {
+		t.Fatalf("unexpected key id %s %v", id, err)
+	}
+	if err := um.AddIPFSKeyForUser("notauser"+username, keyName+"2", "QmKeyID"); err == nil {
+		t.Fatal("expected key for missing user to fail")
+	}
+
+	// renaming a key moves the ipns records published with it
+	renamed := keyName + "renamed"
+	entry, err := models.NewIPNSManager(db).CreateEntry("Qm"+username, "QmContent", keyName, "public", time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := um.RenameIPFSKeyForUser(username, keyName, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if owned, err := um.CheckIfKeyOwnedByUser(username, renamed); err != nil || !owned {
+		t.Fatalf("expected renamed key to be owned by user, got %v %v", owned, err)
+	}
+	if found, err := models.NewIPNSManager(db).FindByIPNSHash(entry.IPNSHash); err != nil || found.Key != renamed {
+		t.Fatalf("expected ipns record to use renamed key, got %+v %v", found, err)
+	}
+	page, _, err := um.ListKeysForUser(username, models.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*page) != 1 || (*page)[0].Name != renamed {
+		t.Fatalf("unexpected keys %+v", *page)
+	}
+	// keys can't be removed while a record published with them is active
+	if err := um.RemoveIPFSKeyForUser(username, renamed); err != models.ErrKeyInUse {
+		t.Fatalf("expected key in use error, got %v", err)
+	}
+	if err := db.Model(entry).UpdateColumn("life_time", time.Nanosecond.String()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := um.RemoveIPFSKeyForUser(username, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if owned, err := um.CheckIfKeyOwnedByUser(username, renamed); err != nil || owned {
+		t.Fatalf("expected key to be removed, got %v %v", owned, err)
+	}
+}
+
+func TestUserManager_AccountLifecycle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabaseConnection(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := models.NewUserManager(db)
+
+	var (
+		randUtils  = utils.GenerateRandomUtils()
+		username   = randUtils.GenerateString(10, utils.LetterBytes)
+		ethAddress = randUtils.GenerateString(10, utils.LetterBytes)
+		email      = randUtils.GenerateString(10, utils.LetterBytes)
+	)
+	if _, err := um.NewUserAccount(ethAddress, username, "password123", email, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the latest token issued for a purpose can be redeemed, and only once
+	stale, err := um.NewAccountToken(username, models.TokenEmailVerification, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := um.NewAccountToken(username, models.TokenEmailVerification, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.VerifyEmail(stale); err != models.ErrInvalidToken {
+		t.Fatalf("expected revoked token to be invalid, got %v", err)
+	}
+	user, err := um.VerifyEmail(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified || !user.APIAccess {
+		t.Fatal("expected verified user to have api access")
+	}
+	if _, err := um.VerifyEmail(token); err != models.ErrInvalidToken {
+		t.Fatalf("expected used token to be invalid, got %v", err)
+	}
+
+	// access revoked by the admin isn't granted again by verifying
+	if err := um.SetAPIAccess(username, false); err != nil {
+		t.Fatal(err)
+	}
+	if token, err = um.NewAccountToken(username, models.TokenEmailVerification, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = um.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	} else if user.APIAccess {
+		t.Fatal("expected revoked api access to stay revoked")
+	}
+
+	// tokens can't be redeemed for another purpose, or once expired
+	token, err = um.NewAccountToken(username, models.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.VerifyEmail(token); err != models.ErrInvalidToken {
+		t.Fatalf("expected reset token to be invalid for verification, got %v", err)
+	}
+	if _, err := um.ResetPassword(token, "newpassword123"); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := um.ComparePlaintextPasswordToHash(username, "newpassword123"); err != nil || !valid {
+		t.Fatalf("expected password to be reset, got %v %v", valid, err)
+	}
+	expired, err := um.NewAccountToken(username, models.TokenPasswordReset, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.ResetPassword(expired, "password123"); err != models.ErrInvalidToken {
+		t.Fatalf("expected expired token to be invalid, got %v", err)
+	}
+
+	if err := um.SetAccountEnabled(username, false); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := um.CheckIfUserAccountEnabled(username, db); err != nil || enabled {
+		t.Fatalf("expected account to be disabled, got %v %v", enabled, err)
+	}
+	if _, err := um.DeleteAccount(username); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.FindByUserName(username); err == nil {
+		t.Fatal("expected deleted user to be gone")
+	}
+}
+
+func TestUserManager_TwoFactor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabaseConnection(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := models.NewUserManager(db)
+
+	var (
+		randUtils  = utils.GenerateRandomUtils()
+		username   = randUtils.GenerateString(10, utils.LetterBytes)
+		ethAddress = randUtils.GenerateString(10, utils.LetterBytes)
+		email      = randUtils.GenerateString(10, utils.LetterBytes)
+	)
+	if _, err := um.NewUserAccount(ethAddress, username, "password123", email, false); err != nil {
+		t.Fatal(err)
+	}
+	// users without two factor authentication aren't asked for a code
+	if err := um.CheckSecondFactor(username, ""); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := um.EnrollTwoFactor(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := um.EnableTwoFactor(username, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.EnrollTwoFactor(username); err != models.ErrTwoFactorEnabled {
+		t.Fatalf("expected enrollment to be refused once enabled, got %v", err)
+	}
+	// the code used to enable two factor authentication can't be replayed
+	if err := um.CheckSecondFactor(username, code); err != models.ErrInvalidSecondFactor {
+		t.Fatalf("expected used code to be invalid, got %v", err)
+	}
+	if code, err = totp.Code(secret, step); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.CheckSecondFactor(username, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.CheckSecondFactor(username, recoveryCodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.CheckSecondFactor(username, recoveryCodes[0]); err != models.ErrInvalidSecondFactor {
+		t.Fatalf("expected used recovery code to be invalid, got %v", err)
+	}
+	// with the used recovery code, these are five invalid codes in a row, which lock the
+	// user out even once they give a valid one
+	for i := 0; i < 4; i++ {
+		if err := um.CheckSecondFactor(username, "notacode"); err != models.ErrInvalidSecondFactor {
+			t.Fatalf("expected invalid code to be refused, got %v", err)
+		}
+	}
+	if err := um.CheckSecondFactor(username, recoveryCodes[1]); err != models.ErrSecondFactorLocked {
+		t.Fatalf("expected user to be locked out, got %v", err)
+	}
+	if err := um.DisableTwoFactor(username); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.CheckSecondFactor(username, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func openDatabaseConnection(t *testing.T, cfg *config.TemporalConfig) (*gorm.DB, error) {
 	if !travis {
 		dbPass = cfg.Database.Password
@@ -213,3 +478,25 @@ func openDatabaseConnection(t *testing.T, cfg *config.TemporalConfig) (*gorm.DB,
 	}
 	return db, nil
 }
+
+func TestUser_NotificationEnabled(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         models.User
+		notification string
+		want         bool
+	}{
+		{"EmailDisabled", models.User{EmailEnabled: false}, "pin-failed", false},
+		{"EmailDisabledNoNotification", models.User{EmailEnabled: false}, "", false},
+		{"Enabled", models.User{EmailEnabled: true}, "pin-failed", true},
+		{"OptedOut", models.User{EmailEnabled: true, DisabledNotifications: []string{"pin-failed"}}, "pin-failed", false},
+		{"OptedOutOfOther", models.User{EmailEnabled: true, DisabledNotifications: []string{"pin-failed"}}, "file-add-failed", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.NotificationEnabled(tt.notification); got != tt.want {
+				t.Fatalf("NotificationEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
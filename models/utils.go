@@ -1,8 +1,40 @@
 package models
 
-import "time"
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 var nilTime time.Time
 
 // AdminAddress is the eth address of the admin account
 var AdminAddress = "0xC6C35f43fDD71f86a2D8D4e3cA1Ce32564c38bd9"
+
+// transaction is used to run fn in a transaction, which is committed when fn succeeds
+// and rolled back when it returns an error. When db is already a transaction, fn is run
+// as part of it, leaving the caller to commit
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return fn(db)
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// findUserID is used to find the id of the user with the given user name
+func findUserID(db *gorm.DB, username string) (uint, error) {
+	user := User{}
+	if check := db.Select("id").Where("user_name = ?", username).First(&user); check.Error != nil {
+		return 0, check.Error
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Webhook is an endpoint a user has registered to receive event notifications
+type Webhook struct {
+	gorm.Model
+	UserName string         `gorm:"type:varchar(255)" json:"user_name"`
+	URL      string         `gorm:"type:varchar(2048)" json:"url"`
+	Secret   string         `gorm:"type:varchar(255)" json:"-"`
+	Events   pq.StringArray `gorm:"type:text[]" json:"events"`
+}
+
+// WebhookDelivery is a record of an attempt to deliver an event to a webhook.
+// A single delivery is updated in place as it is retried
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID  uint   `json:"webhook_id"`
+	UserName   string `gorm:"type:varchar(255)" json:"user_name"`
+	EventID    string `gorm:"type:varchar(255)" json:"event_id"`
+	Event      string `gorm:"type:varchar(255)" json:"event"`
+	Payload    string `gorm:"type:text" json:"payload"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"`
+	Error      string `gorm:"type:text" json:"error"`
+	Delivered  bool   `json:"delivered"`
+	// NextAttemptAt is when the delivery is next retried, and is nil once it has been
+	// delivered or has run out of attempts
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// WebhookManager is used to manipulate webhook models
+type WebhookManager struct {
+	DB *gorm.DB
+}
+
+// NewWebhookManager is used to generate our webhook manager
+func NewWebhookManager(db *gorm.DB) *WebhookManager {
+	return &WebhookManager{DB: db}
+}
+
+// NewWebhook is used to register a webhook for a user
+func (wm *WebhookManager) NewWebhook(username, url, secret string, events []string) (*Webhook, error) {
+	if len(events) == 0 {
+		return nil, errors.New("webhook must subscribe to at least one event")
+	}
+	webhook := Webhook{
+		UserName: username,
+		URL:      url,
+		Secret:   secret,
+		Events:   events,
+	}
+	if check := wm.DB.Create(&webhook); check.Error != nil {
+		return nil, check.Error
+	}
+	return &webhook, nil
+}
+
+// FindWebhooksByUserName is used to find all webhooks registered by a user
+func (wm *WebhookManager) FindWebhooksByUserName(username string) (*[]Webhook, error) {
+	webhooks := []Webhook{}
+	if check := wm.DB.Where("user_name = ?", username).Find(&webhooks); check.Error != nil {
+		return nil, check.Error
+	}
+	return &webhooks, nil
+}
+
+// FindWebhooksForEvent is used to find the webhooks of a user which are subscribed to an event
+func (wm *WebhookManager) FindWebhooksForEvent(username, event string) (*[]Webhook, error) {
+	webhooks := []Webhook{}
+	if check := wm.DB.Where("user_name = ? AND ? = ANY(events)", username, event).Find(&webhooks); check.Error != nil {
+		return nil, check.Error
+	}
+	return &webhooks, nil
+}
+
+// DeleteWebhook is used to remove one of a user's webhooks
+func (wm *WebhookManager) DeleteWebhook(username string, id uint) error {
+	check := wm.DB.Where("user_name = ? AND id = ?", username, id).Delete(&Webhook{})
+	if check.Error != nil {
+		return check.Error
+	}
+	if check.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// NewDelivery is used to record a pending delivery of an event to a webhook
+func (wm *WebhookManager) NewDelivery(webhook *Webhook, eventID, event, payload string) (*WebhookDelivery, error) {
+	now := time.Now()
+	delivery := WebhookDelivery{
+		WebhookID: webhook.ID,
+		UserName:  webhook.UserName,
+		EventID:   eventID,
+		Event:     event,
+		Payload:   payload,
+		// the first attempt is made straight away, and picked up by our retries if it never finishes
+		NextAttemptAt: &now,
+	}
+	if check := wm.DB.Create(&delivery); check.Error != nil {
+		return nil, check.Error
+	}
+	return &delivery, nil
+}
+
+// RecordAttempt is used to store the outcome of a delivery attempt, and when it should next be
+// retried. A nil nextAttempt stops the delivery from being retried
+func (wm *WebhookManager) RecordAttempt(delivery *WebhookDelivery, statusCode int, deliveryErr error, nextAttempt *time.Time) error {
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.Delivered = deliveryErr == nil
+	delivery.Error = ""
+	if deliveryErr != nil {
+		delivery.Error = deliveryErr.Error()
+	}
+	delivery.NextAttemptAt = nextAttempt
+	if delivery.Delivered {
+		delivery.NextAttemptAt = nil
+	}
+	return wm.DB.Model(delivery).Updates(map[string]interface{}{
+		"attempts":        delivery.Attempts,
+		"status_code":     delivery.StatusCode,
+		"delivered":       delivery.Delivered,
+		"error":           delivery.Error,
+		"next_attempt_at": delivery.NextAttemptAt,
+	}).Error
+}
+
+// FindDelivery is used to find a delivery along with the webhook it's delivered to. Deliveries
+// to deleted webhooks aren't found
+func (wm *WebhookManager) FindDelivery(id uint) (*WebhookDelivery, *Webhook, error) {
+	delivery := &WebhookDelivery{}
+	if check := wm.DB.First(delivery, id); check.Error != nil {
+		return nil, nil, check.Error
+	}
+	hook := &Webhook{}
+	if check := wm.DB.First(hook, delivery.WebhookID); check.Error != nil {
+		return delivery, nil, check.Error
+	}
+	return delivery, hook, nil
+}
+
+// StopRetrying is used to stop retrying a delivery, such as one to a deleted webhook
+func (wm *WebhookManager) StopRetrying(delivery *WebhookDelivery) error {
+	delivery.NextAttemptAt = nil
+	return wm.DB.Model(delivery).Update("next_attempt_at", nil).Error
+}
+
+// ClaimDueDeliveries is used to find up to limit deliveries which are due to be retried. Their
+// next attempt is pushed back by lease, so other workers don't claim them while they're retried,
+// and a retry which is lost is claimed again once the lease expires
+func (wm *WebhookManager) ClaimDueDeliveries(lease time.Duration, limit int) ([]uint, error) {
+	rows, err := wm.DB.Raw(`UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= ? AND delivered = false AND deleted_at IS NULL
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		) RETURNING id`, time.Now().Add(lease), time.Now(), limit).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// FindDeliveriesByUserName is used to retrieve the most recent deliveries for a user,
+// optionally limited to a single webhook when webhookID is non zero
+func (wm *WebhookManager) FindDeliveriesByUserName(username string, webhookID uint, limit int) (*[]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	query := wm.DB.Where("user_name = ?", username)
+	if webhookID != 0 {
+		query = query.Where("webhook_id = ?", webhookID)
+	}
+	if check := query.Order("created_at desc").Limit(limit).Find(&deliveries); check.Error != nil {
+		return nil, check.Error
+	}
+	return &deliveries, nil
+}
@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/jinzhu/gorm"
+)
+
+// PaymentChannelsABI is the ABI of the subset of the payment channel contract that we interact with
+const PaymentChannelsABI = `[{"constant":true,"inputs":[{"name":"","type":"bytes32"}],"name":"channels","outputs":[{"name":"sender","type":"address"},{"name":"recipient","type":"address"},{"name":"deposit","type":"uint256"},{"name":"expiration","type":"uint256"},{"name":"open","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"_channelID","type":"bytes32"},{"name":"_amount","type":"uint256"},{"name":"_sig","type":"bytes"}],"name":"closeChannel","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+
+// ChannelContractClient is used to interact with the payment channel contract on-chain
+type ChannelContractClient struct {
+	address  common.Address
+	auth     *bind.TransactOpts
+	contract *bind.BoundContract
+}
+
+// NewChannelContractClient is used to bind to a deployed payment channel contract.
+// auth is only required when settling channels, and may be nil for read only use
+func NewChannelContractClient(address common.Address, backend bind.ContractBackend, auth *bind.TransactOpts) (*ChannelContractClient, error) {
+	parsed, err := abi.JSON(strings.NewReader(PaymentChannelsABI))
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelContractClient{
+		address:  address,
+		auth:     auth,
+		contract: bind.NewBoundContract(address, parsed, backend, backend, backend),
+	}, nil
+}
+
+// Address returns the address of the payment channel contract
+func (cc *ChannelContractClient) Address() common.Address {
+	return cc.address
+}
+
+// Channel is used to retrieve the on-chain state of a payment channel
+func (cc *ChannelContractClient) Channel(ctx context.Context, channelID common.Hash) (*ChannelState, error) {
+	ret := new(struct {
+		Sender     common.Address
+		Recipient  common.Address
+		Deposit    *big.Int
+		Expiration *big.Int
+		Open       bool
+	})
+	if err := cc.contract.Call(&bind.CallOpts{Context: ctx}, ret, "channels", channelID); err != nil {
+		return nil, err
+	}
+	return &ChannelState{
+		Sender:     ret.Sender,
+		Recipient:  ret.Recipient,
+		Deposit:    ret.Deposit,
+		Expiration: ret.Expiration,
+		Open:       ret.Open,
+	}, nil
+}
+
+// CloseChannel is used to settle a payment channel with its latest voucher
+func (cc *ChannelContractClient) CloseChannel(ctx context.Context, channelID common.Hash, amount *big.Int, sig []byte) (common.Hash, error) {
+	if cc.auth == nil {
+		return common.Hash{}, errors.New("channel contract client is read only")
+	}
+	opts := *cc.auth
+	opts.Context = ctx
+	tx, err := cc.contract.Transact(&opts, "closeChannel", channelID, amount, sig)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// GenerateChannelManager is used to generate a channel manager for the payment channel contract
+// in our configuration. When settle is true our ethereum account is unlocked so channels can be closed
+func GenerateChannelManager(db *gorm.DB, cfg *config.TemporalConfig, settle bool) (*ChannelManager, error) {
+	if cfg.Ethereum.Contracts.PaymentChannelContractAddress == "" {
+		return nil, errors.New("payment channel contract address not configured")
+	}
+	client, err := ethclient.Dial(cfg.Ethereum.Connection.INFURA.URL)
+	if err != nil {
+		return nil, err
+	}
+	var auth *bind.TransactOpts
+	if settle {
+		ps := PaymentService{}
+		if err = ps.unlockAccount(cfg); err != nil {
+			return nil, err
+		}
+		auth = ps.Auth
+	}
+	contract, err := NewChannelContractClient(
+		common.HexToAddress(cfg.Ethereum.Contracts.PaymentChannelContractAddress),
+		client,
+		auth)
+	if err != nil {
+		return nil, err
+	}
+	return NewChannelManager(contract, common.HexToAddress(cfg.Ethereum.Account.Address), db), nil
+}
@@ -0,0 +1,256 @@
+package payments
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+Payment channels let regular API usage be paid for without an on-chain transaction per request.
+A user opens a channel on-chain with a deposit made out to us, and signs a voucher for the cumulative
+amount owed with each request. Vouchers are validated off-chain, and the latest one for each channel
+is submitted to the contract in batches when settling.
+*/
+
+// SettlementWindow is how long before a channel expires it is settled. Settlement is batched,
+// so vouchers aren't accepted once a channel is within this window, since they may never be submitted
+const SettlementWindow = 24 * time.Hour
+
+// ChannelState is the on-chain state of a payment channel
+type ChannelState struct {
+	Sender     common.Address
+	Recipient  common.Address
+	Deposit    *big.Int
+	Expiration *big.Int
+	Open       bool
+}
+
+// ChannelContract is the interface to the payment channel contract. It is satisfied by
+// both the on-chain contract client, and the simulated chain used for testing
+type ChannelContract interface {
+	// Address is the address of the contract, which is included in every voucher hash
+	Address() common.Address
+	// Channel is used to retrieve the on-chain state of a channel
+	Channel(ctx context.Context, channelID common.Hash) (*ChannelState, error)
+	// CloseChannel is used to settle a channel with its latest voucher
+	CloseChannel(ctx context.Context, channelID common.Hash, amount *big.Int, sig []byte) (common.Hash, error)
+}
+
+// ChannelManager is used to open, validate, and settle payment channels
+type ChannelManager struct {
+	Contract  ChannelContract
+	Recipient common.Address
+	Channels  *models.PaymentChannelManager
+}
+
+// NewChannelManager is used to generate our payment channel manager
+func NewChannelManager(contract ChannelContract, recipient common.Address, db *gorm.DB) *ChannelManager {
+	return &ChannelManager{
+		Contract:  contract,
+		Recipient: recipient,
+		Channels:  models.NewPaymentChannelManager(db),
+	}
+}
+
+// ParseChannelID is used to parse a hex encoded channel id
+func ParseChannelID(channelID string) (common.Hash, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(channelID, "0x"))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(b) != common.HashLength {
+		return common.Hash{}, errors.New("channel id must be 32 bytes")
+	}
+	return common.BytesToHash(b), nil
+}
+
+// OpenChannel is used to register a channel which the user has opened on-chain
+func (cm *ChannelManager) OpenChannel(ctx context.Context, username, senderAddress string, channelID common.Hash) (*models.PaymentChannel, error) {
+	state, err := cm.Contract.Channel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateChannelState(state, cm.Recipient, time.Now()); err != nil {
+		return nil, err
+	}
+	if state.Sender != common.HexToAddress(senderAddress) {
+		return nil, errors.New("channel sender does not match account ethereum address")
+	}
+	return cm.Channels.NewChannel(
+		channelID.String(),
+		username,
+		state.Sender.String(),
+		state.Deposit,
+		time.Unix(state.Expiration.Int64(), 0),
+	)
+}
+
+// AcceptVoucher is used to validate a voucher presented with a request, and store it as
+// the latest voucher for its channel
+func (cm *ChannelManager) AcceptVoucher(username string, v *signer.Voucher, cost *big.Int) error {
+	channel, err := cm.Channels.FindChannelByChannelID(v.ChannelID.String())
+	if err != nil {
+		return err
+	}
+	if channel.UserName != username {
+		return errors.New("payment channel not owned by user")
+	}
+	if err = ValidateVoucher(cm.Contract.Address(), channel, v, cost, time.Now()); err != nil {
+		return err
+	}
+	previous, _ := new(big.Int).SetString(channel.LatestAmount, 10)
+	return cm.Channels.RecordVoucher(
+		channel.ChannelID,
+		previous,
+		v.Amount,
+		"0x"+hex.EncodeToString(v.Sig),
+	)
+}
+
+// SettlementError is returned when some channels of a batch fail to settle, holding the
+// error of each failed channel by its id
+type SettlementError struct {
+	Failed map[string]error
+}
+
+func (se *SettlementError) Error() string {
+	failed := make([]string, 0, len(se.Failed))
+	for channelID, err := range se.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %s", channelID, err))
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("failed to settle %v channels: %s", len(failed), strings.Join(failed, "; "))
+}
+
+// Settle is used to close channels expiring within the given window, up to batchSize channels at a time.
+// Channels in skip, such as those which failed in an earlier batch, aren't settled. Channels which fail
+// are reported by a *SettlementError, and don't stop the rest of the batch
+func (cm *ChannelManager) Settle(ctx context.Context, window time.Duration, batchSize int, skip ...string) ([]models.PaymentChannel, error) {
+	channels, err := cm.Channels.FindChannelsForSettlement(time.Now().Add(window), batchSize, skip...)
+	if err != nil {
+		return nil, err
+	}
+	settled, err := SettleChannels(ctx, cm.Contract, *channels)
+	settleErr, ok := err.(*SettlementError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	if settleErr == nil {
+		settleErr = &SettlementError{Failed: map[string]error{}}
+	}
+	marked := settled[:0]
+	for _, v := range settled {
+		// the channel is closed on-chain, so it's reported as failed rather than settled again
+		if err = cm.Channels.MarkChannelClosed(v.ChannelID, v.SettlementTx); err != nil {
+			settleErr.Failed[v.ChannelID] = fmt.Errorf("settled in transaction %s but not marked closed: %s", v.SettlementTx, err)
+			continue
+		}
+		marked = append(marked, v)
+	}
+	if len(settleErr.Failed) > 0 {
+		return marked, settleErr
+	}
+	return marked, nil
+}
+
+// SettleChannels is used to submit the latest voucher of each channel to the contract.
+// Channels which settle successfully are returned with their settlement transaction set,
+// and settlement continues past individual failures so one bad channel doesn't block the batch.
+// Failed channels are reported by a *SettlementError
+func SettleChannels(ctx context.Context, contract ChannelContract, channels []models.PaymentChannel) ([]models.PaymentChannel, error) {
+	var (
+		settled []models.PaymentChannel
+		failed  = map[string]error{}
+	)
+	for _, v := range channels {
+		channelID, err := ParseChannelID(v.ChannelID)
+		if err != nil {
+			failed[v.ChannelID] = err
+			continue
+		}
+		amount, ok := new(big.Int).SetString(v.LatestAmount, 10)
+		if !ok {
+			failed[v.ChannelID] = fmt.Errorf("invalid amount %s", v.LatestAmount)
+			continue
+		}
+		sig, err := hex.DecodeString(strings.TrimPrefix(v.LatestSignature, "0x"))
+		if err != nil {
+			failed[v.ChannelID] = err
+			continue
+		}
+		tx, err := contract.CloseChannel(ctx, channelID, amount, sig)
+		if err != nil {
+			failed[v.ChannelID] = err
+			continue
+		}
+		v.SettlementTx = tx.String()
+		v.State = models.ChannelStateClosed
+		settled = append(settled, v)
+	}
+	if len(failed) > 0 {
+		return settled, &SettlementError{Failed: failed}
+	}
+	return settled, nil
+}
+
+// ValidateChannelState is used to check that an on-chain channel can be used to pay us
+func ValidateChannelState(state *ChannelState, recipient common.Address, now time.Time) error {
+	if !state.Open {
+		return errors.New("payment channel is not open")
+	}
+	if state.Recipient != recipient {
+		return errors.New("payment channel recipient is not temporal")
+	}
+	if state.Expiration.Int64() <= now.Add(SettlementWindow).Unix() {
+		return errors.New("payment channel expires too soon to be used")
+	}
+	if state.Deposit.Sign() <= 0 {
+		return errors.New("payment channel has no deposit")
+	}
+	return nil
+}
+
+// ValidateVoucher is used to check a voucher against our record of its channel. A valid voucher
+// is for a channel outside of the settlement window, is signed by the channel sender, does not
+// exceed the deposit, and increases the amount owed by at least cost
+func ValidateVoucher(contractAddress common.Address, channel *models.PaymentChannel, v *signer.Voucher, cost *big.Int, now time.Time) error {
+	if channel.State != models.ChannelStateOpen {
+		return errors.New("payment channel is not open")
+	}
+	if !channel.Expiration.After(now.Add(SettlementWindow)) {
+		return errors.New("payment channel is being settled, and no longer accepts vouchers")
+	}
+	signerAddress, err := signer.RecoverVoucherSigner(contractAddress, v)
+	if err != nil {
+		return err
+	}
+	if signerAddress != common.HexToAddress(channel.SenderAddress) {
+		return errors.New("voucher not signed by channel sender")
+	}
+	deposit, ok := new(big.Int).SetString(channel.Deposit, 10)
+	if !ok {
+		return errors.New("failed to parse channel deposit")
+	}
+	if v.Amount.Cmp(deposit) > 0 {
+		return errors.New("voucher amount exceeds channel deposit")
+	}
+	previous, ok := new(big.Int).SetString(channel.LatestAmount, 10)
+	if !ok {
+		return errors.New("failed to parse channel latest amount")
+	}
+	if new(big.Int).Sub(v.Amount, previous).Cmp(cost) < 0 {
+		return fmt.Errorf("voucher must increase the channel amount by at least %s wei", cost.String())
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package payments_test
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/payments"
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	contractAddress  = common.HexToAddress("0x3b2fD241378a326Af998E4243aA76fE8b8414dEe")
+	recipientAddress = common.HexToAddress("0x7E4A2359c745A982a54653128085eAC69E446DE1")
+)
+
+func TestPaymentChannels(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	chain := payments.NewSimulatedChannels(contractAddress)
+	expiration := time.Now().Add(payments.SettlementWindow + time.Hour)
+	channelID := chain.OpenChannel(sender, recipientAddress, big.NewInt(1000), expiration.Unix())
+
+	state, err := chain.Channel(context.Background(), channelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = payments.ValidateChannelState(state, recipientAddress, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err = payments.ValidateChannelState(state, sender, time.Now()); err == nil {
+		t.Fatal("expected error validating channel made out to another recipient")
+	}
+
+	channel := &models.PaymentChannel{
+		ChannelID:     channelID.String(),
+		SenderAddress: sender.String(),
+		Deposit:       "1000",
+		LatestAmount:  "0",
+		Expiration:    expiration,
+		State:         models.ChannelStateOpen,
+	}
+	cost := big.NewInt(100)
+
+	// each request signs for the cumulative amount owed
+	for _, amount := range []int64{100, 200, 350} {
+		v, err := signer.SignVoucher(key, contractAddress, channelID, big.NewInt(amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = payments.ValidateVoucher(contractAddress, channel, v, cost, time.Now()); err != nil {
+			t.Fatalf("voucher for %v rejected: %s", amount, err)
+		}
+		channel.LatestAmount = v.Amount.String()
+		channel.LatestSignature = "0x" + hex.EncodeToString(v.Sig)
+	}
+
+	invalid := []struct {
+		name    string
+		voucher func() *signer.Voucher
+		reason  string
+	}{
+		{"Replay", func() *signer.Voucher {
+			v, _ := signer.SignVoucher(key, contractAddress, channelID, big.NewInt(350))
+			return v
+		}, "replayed voucher"},
+		{"TooSmall", func() *signer.Voucher {
+			v, _ := signer.SignVoucher(key, contractAddress, channelID, big.NewInt(400))
+			return v
+		}, "voucher below request cost"},
+		{"OverDeposit", func() *signer.Voucher {
+			v, _ := signer.SignVoucher(key, contractAddress, channelID, big.NewInt(1001))
+			return v
+		}, "voucher exceeding deposit"},
+		{"WrongSigner", func() *signer.Voucher {
+			other, _ := crypto.GenerateKey()
+			v, _ := signer.SignVoucher(other, contractAddress, channelID, big.NewInt(500))
+			return v
+		}, "voucher signed by another key"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := payments.ValidateVoucher(contractAddress, channel, tt.voucher(), cost, time.Now()); err == nil {
+				t.Fatalf("expected %s to be rejected", tt.reason)
+			}
+		})
+	}
+	// vouchers stop being accepted once the channel may be settled
+	v, err := signer.SignVoucher(key, contractAddress, channelID, big.NewInt(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = payments.ValidateVoucher(contractAddress, channel, v, cost, time.Now().Add(time.Hour*2)); err == nil {
+		t.Fatal("expected voucher within the settlement window to be rejected")
+	}
+	if err = payments.ValidateChannelState(state, recipientAddress, time.Now().Add(time.Hour*2)); err == nil {
+		t.Fatal("expected channel within the settlement window to be rejected")
+	}
+
+	settled, err := payments.SettleChannels(context.Background(), chain, []models.PaymentChannel{*channel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settled) != 1 || settled[0].SettlementTx == "" {
+		t.Fatal("expected channel to be settled")
+	}
+	if chain.Balances[recipientAddress].Cmp(big.NewInt(350)) != 0 {
+		t.Fatalf("recipient received %s, expected 350", chain.Balances[recipientAddress].String())
+	}
+	if chain.Balances[sender].Cmp(big.NewInt(650)) != 0 {
+		t.Fatalf("sender refunded %s, expected 650", chain.Balances[sender].String())
+	}
+	// channels which fail are reported by id, rather than failing the batch
+	bad := models.PaymentChannel{ChannelID: "notachannel", LatestAmount: "1"}
+	settled, err = payments.SettleChannels(context.Background(), chain, []models.PaymentChannel{*channel, bad})
+	settleErr, ok := err.(*payments.SettlementError)
+	if !ok {
+		t.Fatalf("expected settlement error, got %v", err)
+	}
+	if len(settled) != 0 || len(settleErr.Failed) != 2 || settleErr.Failed[channel.ChannelID] == nil || settleErr.Failed["notachannel"] == nil {
+		t.Fatalf("expected closed and invalid channels to fail, got %v", err)
+	}
+}
+
+func TestParseChannelID(t *testing.T) {
+	id := common.BytesToHash(crypto.Keccak256([]byte("channel")))
+	parsed, err := payments.ParseChannelID(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != id {
+		t.Fatal("parsed channel id does not match")
+	}
+	if _, err = payments.ParseChannelID("0x1234"); err == nil {
+		t.Fatal("expected error parsing short channel id")
+	}
+}
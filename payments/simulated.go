@@ -0,0 +1,102 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SimulatedChannels is an in-memory payment channel contract. It enforces the same rules
+// as the on-chain contract, and is used to exercise channels without a live chain
+type SimulatedChannels struct {
+	address  common.Address
+	mux      sync.Mutex
+	nonce    int64
+	channels map[common.Hash]*ChannelState
+	// Balances holds the wei paid out to each address on settlement
+	Balances map[common.Address]*big.Int
+}
+
+// NewSimulatedChannels is used to create a simulated payment channel contract
+func NewSimulatedChannels(address common.Address) *SimulatedChannels {
+	return &SimulatedChannels{
+		address:  address,
+		channels: make(map[common.Hash]*ChannelState),
+		Balances: make(map[common.Address]*big.Int),
+	}
+}
+
+// Address returns the address of the simulated contract
+func (sc *SimulatedChannels) Address() common.Address {
+	return sc.address
+}
+
+// OpenChannel simulates a user opening a channel with a deposit, returning the channel id
+func (sc *SimulatedChannels) OpenChannel(sender, recipient common.Address, deposit *big.Int, expiration int64) common.Hash {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	sc.nonce++
+	id := common.BytesToHash(crypto.Keccak256(
+		sender.Bytes(), recipient.Bytes(), big.NewInt(sc.nonce).Bytes()))
+	sc.channels[id] = &ChannelState{
+		Sender:     sender,
+		Recipient:  recipient,
+		Deposit:    new(big.Int).Set(deposit),
+		Expiration: big.NewInt(expiration),
+		Open:       true,
+	}
+	return id
+}
+
+// Channel is used to retrieve the state of a simulated channel
+func (sc *SimulatedChannels) Channel(ctx context.Context, channelID common.Hash) (*ChannelState, error) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	state, ok := sc.channels[channelID]
+	if !ok {
+		// the contract returns a zero value struct for unknown channels
+		return &ChannelState{Deposit: big.NewInt(0), Expiration: big.NewInt(0)}, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+// CloseChannel pays amount to the recipient, refunds the remainder to the sender and closes the channel
+func (sc *SimulatedChannels) CloseChannel(ctx context.Context, channelID common.Hash, amount *big.Int, sig []byte) (common.Hash, error) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	state, ok := sc.channels[channelID]
+	if !ok || !state.Open {
+		return common.Hash{}, errors.New("channel is not open")
+	}
+	if amount.Cmp(state.Deposit) > 0 {
+		return common.Hash{}, errors.New("amount exceeds deposit")
+	}
+	signerAddress, err := signer.RecoverVoucherSigner(sc.address, &signer.Voucher{
+		ChannelID: channelID,
+		Amount:    amount,
+		Sig:       sig,
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if signerAddress != state.Sender {
+		return common.Hash{}, errors.New("invalid voucher signature")
+	}
+	state.Open = false
+	sc.credit(state.Recipient, amount)
+	sc.credit(state.Sender, new(big.Int).Sub(state.Deposit, amount))
+	return common.BytesToHash(crypto.Keccak256(channelID.Bytes(), sig)), nil
+}
+
+func (sc *SimulatedChannels) credit(address common.Address, amount *big.Int) {
+	if _, ok := sc.Balances[address]; !ok {
+		sc.Balances[address] = big.NewInt(0)
+	}
+	sc.Balances[address].Add(sc.Balances[address], amount)
+}
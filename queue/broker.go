@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/RTradeLtd/Temporal/tracing"
+)
+
+// ErrPublisherClosed is returned when publishing after a publisher or broker has been closed
+var ErrPublisherClosed = errors.New("publisher is closed")
+
+// MemoryURL is the broker url used to run every service against a single MemoryBroker.
+// It can't be dialed, since an in-process broker isn't shared between processes
+const MemoryURL = "memory://"
+
+const (
+	// PublishUnroutable is used when no queue is bound to receive a message
+	PublishUnroutable = "message could not be routed to a queue"
+	// PublishRejected is used when the broker negatively acknowledges a message
+	PublishRejected = "message was rejected by the broker"
+	// PublishUnconfirmed is used when the channel closes or times out before a message is confirmed
+	PublishUnconfirmed = "message was not confirmed by the broker"
+)
+
+// PublishError is returned when the broker did not take responsibility for a message,
+// so the work it describes will not be processed
+type PublishError struct {
+	Queue  string
+	Reason string
+}
+
+func (pe *PublishError) Error() string {
+	return fmt.Sprintf("failed to publish to %s: %s", pe.Queue, pe.Reason)
+}
+
+// Broker is a message broker which queues are published to and consumed from.
+// RabbitMQ is used in production, and MemoryBroker when running in a single process
+type Broker interface {
+	// Publish is used to send a message to a queue, returning once the broker has taken
+	// responsibility for it. Failures after the message was sent are returned as a *PublishError
+	Publish(queueName string, env *Envelope) error
+	// Fanout is used to send a message to every queue bound to an exchange
+	Fanout(exchangeName string, env *Envelope) error
+	// Consume is used to receive deliveries from a queue, which is bound to exchangeName when set.
+	// At most prefetch deliveries are left unacknowledged at once
+	Consume(queueName, exchangeName, consumer string, prefetch int) (<-chan Delivery, error)
+	// Cancel is used to stop a consumer. Its deliveries channel is closed once the
+	// deliveries already sent to it have been received
+	Cancel(consumer string) error
+	// Ping is used to check the broker can be reached
+	Ping() error
+	Close() error
+}
+
+// Acknowledger is implemented by brokers to settle their deliveries
+type Acknowledger interface {
+	Ack(tag uint64) error
+	Nack(tag uint64, requeue bool) error
+}
+
+// Delivery is a message received from a broker, which must be acknowledged once processed
+type Delivery struct {
+	Body      []byte
+	MessageID string
+	// Headers are the string headers the message was published with
+	Headers map[string]string
+
+	tag          uint64
+	acknowledger Acknowledger
+}
+
+// Ack is used to tell the broker a delivery has been processed
+func (d Delivery) Ack() error {
+	return d.acknowledger.Ack(d.tag)
+}
+
+// Nack is used to tell the broker a delivery wasn't processed, and whether it should be redelivered
+func (d Delivery) Nack(requeue bool) error {
+	return d.acknowledger.Nack(d.tag, requeue)
+}
+
+// publishTraced is used to publish env within a producer span, passing the span to consumers in env's headers
+func publishTraced(ctx context.Context, b Broker, queueName string, env *Envelope) error {
+	ctx, span := tracing.Start(ctx, "publish "+queueName, tracing.Producer)
+	span.SetAttribute("messaging.destination", queueName)
+	span.SetAttribute("messaging.message_id", env.ID)
+	if traceParent := tracing.Inject(ctx); traceParent != "" {
+		if env.Headers == nil {
+			env.Headers = make(map[string]string)
+		}
+		env.Headers[tracing.TraceParentHeader] = traceParent
+	}
+	err := publishEnvelope(b, queueName, env)
+	span.End(err)
+	return err
+}
+
+// publishEnvelope is used to send a message through the exchange of queueName if it has one,
+// or directly to the queue otherwise
+func publishEnvelope(b Broker, queueName string, env *Envelope) error {
+	route, err := lookupRoute(queueName)
+	if err != nil {
+		return err
+	}
+	if route.Exchange != "" {
+		return b.Fanout(route.Exchange, env)
+	}
+	return b.Publish(queueName, env)
+}
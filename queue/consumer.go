@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/RTradeLtd/Temporal/tracing"
+	log "github.com/sirupsen/logrus"
+)
+
+// HandlerFunc processes a single delivery, and is responsible for acknowledging it.
+// ctx expires once the delivery has been processing for longer than our message timeout
+type HandlerFunc func(ctx context.Context, d Delivery)
+
+// newerVersionDelay is how long we wait before requeueing messages we can't process yet
+var newerVersionDelay = time.Second * 5
+
+// consumerSettings controls how deliveries are processed by a queue's workers
+type consumerSettings struct {
+	workers        int
+	prefetch       int
+	messageTimeout time.Duration
+}
+
+// newConsumerSettings is used to read consumer settings from our configuration,
+// falling back to a single worker when they are unset
+func newConsumerSettings(cfg *config.TemporalConfig) consumerSettings {
+	cs := consumerSettings{
+		workers:        cfg.RabbitMQ.Consumer.Workers,
+		prefetch:       cfg.RabbitMQ.Consumer.Prefetch,
+		messageTimeout: time.Duration(cfg.RabbitMQ.Consumer.MessageTimeoutSeconds) * time.Second,
+	}
+	if cs.workers < 1 {
+		cs.workers = 1
+	}
+	if cs.prefetch < cs.workers {
+		// each worker needs at least one unacknowledged delivery to work on
+		cs.prefetch = cs.workers
+	}
+	if cs.messageTimeout <= 0 {
+		cs.messageTimeout = time.Minute * 10
+	}
+	return cs
+}
+
+// consume is used to open the envelope of each delivery and process them with a pool of workers, returning once msgs
+// is closed and every in-flight delivery has been handled. Message contexts are not
+// derived from the consumer's context, so shutting down lets in-flight messages finish
+func (qm *QueueManager) consume(msgs <-chan Delivery, handler HandlerFunc) {
+	cs := qm.consumer
+	if cs.workers < 1 {
+		cs = newConsumerSettings(&config.TemporalConfig{})
+	}
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+		"workers": cs.workers,
+	}).Info("starting workers")
+	wg := &sync.WaitGroup{}
+	for i := 0; i < cs.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range msgs {
+				metrics.MessagesConsumed.WithLabelValues(qm.Service).Inc()
+				d.acknowledger = countingAcknowledger{d.acknowledger, qm.Service}
+				env, err := OpenEnvelope(qm.Service, d.Body)
+				if err != nil {
+					qm.rejectDelivery(d, err)
+					continue
+				}
+				// handlers decode the payload, which has been upgraded to our current version
+				d.Body = env.Payload
+				ctx := withEnvelope(context.Background(), env)
+				if env.RequestID != "" {
+					ctx = logging.WithRequestID(ctx, env.RequestID)
+				}
+				ctx = tracing.Extract(ctx, d.Headers[tracing.TraceParentHeader])
+				ctx, span := tracing.Start(ctx, "process "+qm.Service, tracing.Consumer)
+				span.SetAttribute("messaging.destination", qm.Service)
+				span.SetAttribute("messaging.message_id", env.ID)
+				ctx, cancel := context.WithTimeout(ctx, cs.messageTimeout)
+				start := time.Now()
+				handler(ctx, d)
+				metrics.HandlerDuration.WithLabelValues(qm.Service).Observe(time.Since(start).Seconds())
+				cancel()
+				span.End(nil)
+			}
+		}()
+	}
+	wg.Wait()
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+	}).Info("workers stopped")
+}
+
+// rejectDelivery is used to handle deliveries we can't open. Messages published with a newer
+// schema version are requeued after a short delay, so consumers running the newer release can
+// process them during a rolling deploy. The delay is waited out by a timer rather than the
+// worker, so other messages keep being processed. Other messages are invalid, and are dropped
+func (qm *QueueManager) rejectDelivery(d Delivery, err error) {
+	if err == ErrNewerVersion {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"id":      d.MessageID,
+		}).Warn("message has a newer schema version, requeueing")
+		// if the consumer stops first, the broker requeues the message itself
+		time.AfterFunc(newerVersionDelay, func() { d.Nack(true) })
+		return
+	}
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+		"id":      d.MessageID,
+		"error":   err.Error(),
+	}).Error("failed to open message envelope")
+	d.Nack(false)
+}
+
+// countingAcknowledger is used to record how a queue's deliveries are settled
+type countingAcknowledger struct {
+	Acknowledger
+	queue string
+}
+
+func (ca countingAcknowledger) Ack(tag uint64) error {
+	metrics.MessagesAcked.WithLabelValues(ca.queue).Inc()
+	return ca.Acknowledger.Ack(tag)
+}
+
+func (ca countingAcknowledger) Nack(tag uint64, requeue bool) error {
+	if requeue {
+		metrics.MessagesRetried.WithLabelValues(ca.queue).Inc()
+	} else {
+		metrics.MessagesFailed.WithLabelValues(ca.queue).Inc()
+	}
+	return ca.Acknowledger.Nack(tag, requeue)
+}
@@ -1,26 +1,26 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
 )
 
 var nilTime time.Time
 
 // ProcessDatabaseFileAdds is used to process database file add messages
-func (qm *QueueManager) ProcessDatabaseFileAdds(msgs <-chan amqp.Delivery, db *gorm.DB) {
+func (qm *QueueManager) ProcessDatabaseFileAdds(msgs <-chan Delivery, db *gorm.DB) {
 	uploadManager := models.NewUploadManager(db)
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("processing database file adds")
 
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("detected new message")
 
@@ -28,15 +28,15 @@ func (qm *QueueManager) ProcessDatabaseFileAdds(msgs <-chan amqp.Delivery, db *g
 		// unmarshal the message body into the dfa struct
 		err := json.Unmarshal(d.Body, &dfa)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    dfa.UserName,
 		}).Info("message successfully unmarshaled")
@@ -44,19 +44,19 @@ func (qm *QueueManager) ProcessDatabaseFileAdds(msgs <-chan amqp.Delivery, db *g
 		_, err = uploadManager.FindUploadByHashAndNetwork(dfa.Hash, dfa.NetworkName)
 		if err != nil && err != gorm.ErrRecordNotFound {
 
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    dfa.UserName,
 				"error":   err.Error(),
 			}).Error("database check for upload failed")
 
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if err != nil && err == gorm.ErrRecordNotFound {
 			_, err = uploadManager.NewUpload(dfa.Hash, "file", dfa.NetworkName, dfa.UserName, dfa.HoldTimeInMonths)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    dfa.UserName,
 					"error":   err.Error(),
@@ -66,19 +66,19 @@ func (qm *QueueManager) ProcessDatabaseFileAdds(msgs <-chan amqp.Delivery, db *g
 			// this isn't a new upload so we shall upload the database
 			_, err = uploadManager.UpdateUpload(dfa.HoldTimeInMonths, dfa.UserName, dfa.Hash, dfa.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    dfa.UserName,
 					"error":   err.Error(),
 				}).Error("failed to update upload in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    dfa.UserName,
 		}).Infof("database file add for hash %s successfully processed", dfa.Hash)
-		d.Ack(false)
-	}
+		d.Ack()
+	})
 }
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// EnvelopeVersion is the schema version of the messages we publish
+	EnvelopeVersion = 2
+	// legacyVersion is the schema version of messages published as bare payloads, before envelopes
+	legacyVersion = 1
+)
+
+// ErrNewerVersion is returned when a message was published with a schema version we don't
+// understand yet, which happens while a rolling deploy has updated publishers before consumers
+var ErrNewerVersion = errors.New("message was published with a newer schema version")
+
+// Envelope wraps every queue message, describing the payload it carries
+type Envelope struct {
+	Version int `json:"version"`
+	// Type is the name of the payload's message type, ie IPFSPin
+	Type string `json:"type"`
+	// ID identifies the job the message describes, and is used to correlate logs
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Origin is the service and host which published the message
+	Origin string `json:"origin"`
+	// RequestID is the id of the api request which caused the message, if any,
+	// so that one user action can be traced through every service it touches
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	// Headers are sent alongside the message rather than in its body, and carry the trace context
+	Headers map[string]string `json:"-"`
+}
+
+// Upgrader is used to convert a payload published with one schema version to the next
+type Upgrader func(payload []byte) ([]byte, error)
+
+type envelopeKey struct{}
+
+// EnvelopeFromContext is used to get the envelope of the message being processed
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return env, ok
+}
+
+// withEnvelope is used to attach the envelope of the message being processed to ctx
+func withEnvelope(ctx context.Context, env *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, env)
+}
+
+// hostOrigin is used to describe the service publishing a message, along with our hostname
+func hostOrigin(service string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if service == "" {
+		return host
+	}
+	return fmt.Sprintf("%s@%s", service, host)
+}
+
+// NewEnvelope is used to validate body against the message type of queueName, and wrap it
+// in an envelope with a new job ID
+func NewEnvelope(queueName, origin string, body interface{}) (*Envelope, error) {
+	route, err := lookupRoute(queueName)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if err = route.Validate(payload); err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Version:   EnvelopeVersion,
+		Type:      route.messageTypeName(),
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		Origin:    origin,
+		Payload:   payload,
+	}, nil
+}
+
+// OpenEnvelope is used to unwrap a message delivered to queueName, upgrading payloads published
+// with older schema versions to the current one. Bare payloads published before we used envelopes
+// are treated as the legacy version
+func OpenEnvelope(queueName string, body []byte) (*Envelope, error) {
+	route, err := lookupRoute(queueName)
+	if err != nil {
+		return nil, err
+	}
+	env := &Envelope{}
+	if err = json.Unmarshal(body, env); err != nil {
+		return nil, &MessageError{Queue: queueName, Reason: err.Error()}
+	}
+	if env.Version == 0 && env.Payload == nil {
+		env = &Envelope{
+			Version: legacyVersion,
+			Type:    route.messageTypeName(),
+			Payload: body,
+		}
+	}
+	switch {
+	case env.Version > EnvelopeVersion:
+		return nil, ErrNewerVersion
+	case env.Version < legacyVersion:
+		return nil, &MessageError{Queue: queueName, Reason: "invalid schema version " + strconv.Itoa(env.Version)}
+	case env.Type != route.messageTypeName():
+		return nil, &MessageError{Queue: queueName, Reason: "unexpected message type " + env.Type}
+	}
+	for ; env.Version < EnvelopeVersion; env.Version++ {
+		upgrade, ok := route.Upgrades[env.Version]
+		if !ok {
+			continue
+		}
+		if env.Payload, err = upgrade(env.Payload); err != nil {
+			return nil, &MessageError{
+				Queue:  queueName,
+				Reason: fmt.Sprintf("failed to upgrade from version %v: %s", env.Version, err),
+			}
+		}
+	}
+	if err = route.Validate(env.Payload); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// publishing is used to generate the rabbitmq message for an envelope. We use a persistent
+// delivery mode to combine with our durable queues
+func (env *Envelope) publishing() (amqp.Publishing, error) {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	return amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   "application/json",
+		MessageId:     env.ID,
+		Timestamp:     env.Timestamp,
+		Type:          env.Type,
+		AppId:         env.Origin,
+		CorrelationId: env.RequestID,
+		Headers:       env.amqpHeaders(),
+		Body:          body,
+	}, nil
+}
+
+// amqpHeaders is used to convert our headers to an amqp table, which is nil when there are none
+func (env *Envelope) amqpHeaders() amqp.Table {
+	if len(env.Headers) == 0 {
+		return nil
+	}
+	headers := make(amqp.Table, len(env.Headers))
+	for k, v := range env.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// upgradeIPFSFileV1 is used to convert the hold time of version 1 file messages, which was a string
+func upgradeIPFSFileV1(payload []byte) ([]byte, error) {
+	msg := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, err
+	}
+	if holdTime, ok := msg["hold_time_in_months"].(string); ok {
+		holdTimeInt, err := strconv.ParseInt(holdTime, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		msg["hold_time_in_months"] = holdTimeInt
+	}
+	return json.Marshal(msg)
+}
@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestEnvelope(t *testing.T) {
+	pin := queue.IPFSPin{
+		CID:              testCID,
+		NetworkName:      "public",
+		HoldTimeInMonths: 10,
+	}
+	env, err := queue.NewEnvelope(queue.IpfsPinQueue, "test", pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != queue.EnvelopeVersion || env.Type != "IPFSPin" || env.ID == "" {
+		t.Fatalf("unexpected envelope %+v", env)
+	}
+	body, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := queue.OpenEnvelope(queue.IpfsPinQueue, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.ID != env.ID || opened.Origin != "test" {
+		t.Fatalf("unexpected envelope %+v", opened)
+	}
+	// a pin can't be published to the file queue
+	if _, err = queue.OpenEnvelope(queue.IpfsFileQueue, body); err == nil {
+		t.Fatal("expected error for mismatched message type")
+	}
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    string
+		body     string
+		wantErr  bool
+		wantBody string
+	}{
+		{"LegacyFile", queue.IpfsFileQueue,
+			`{"bucket_name":"files","object_name":"foo","user_name":"bar","network_name":"public","hold_time_in_months":"5"}`,
+			false,
+			`{"bucket_name":"files","object_name":"foo","user_name":"bar","network_name":"public","hold_time_in_months":5}`},
+		{"LegacyPin", queue.IpfsPinQueue,
+			`{"cid":"` + testCID + `","network_name":"public","user_name":"bar","hold_time_in_months":5}`,
+			false,
+			`{"cid":"` + testCID + `","network_name":"public","user_name":"bar","hold_time_in_months":5}`},
+		{"LegacyFileBadHoldTime", queue.IpfsFileQueue, `{"hold_time_in_months":"five"}`, true, ""},
+		{"NewerVersion", queue.IpfsPinQueue, `{"version":99,"type":"IPFSPin","payload":{}}`, true, ""},
+		{"InvalidVersion", queue.IpfsPinQueue, `{"version":-1,"type":"IPFSPin","payload":{}}`, true, ""},
+		{"NotJSON", queue.IpfsPinQueue, `not json`, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := queue.OpenEnvelope(tt.queue, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenEnvelope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if env.Version != queue.EnvelopeVersion {
+				t.Fatalf("expected message to be upgraded, got version %v", env.Version)
+			}
+			var got, want interface{}
+			json.Unmarshal(env.Payload, &got)
+			json.Unmarshal([]byte(tt.wantBody), &want)
+			gotBytes, _ := json.Marshal(got)
+			wantBytes, _ := json.Marshal(want)
+			if string(gotBytes) != string(wantBytes) {
+				t.Fatalf("unexpected payload %s, want %s", gotBytes, wantBytes)
+			}
+		})
+	}
+}
@@ -13,43 +13,6 @@ const (
 	IpfsKeyExchange = "ipfs-key-exchange"
 	// IpfsKeyExchangeKey is the exchange key used for key creation requests
 	IpfsKeyExchangeKey = "ipfs-key-exchange-key"
+	// IpfsKeyUpdateExchange is the fanout exchange used for key imports, renames and deletions
+	IpfsKeyUpdateExchange = "ipfs-key-update"
 )
-
-// DeclareIPFSPinRemovalExchange is used to declare the exchange used to handle ipfs pins
-func (qm *QueueManager) DeclareIPFSPinRemovalExchange() error {
-	return qm.Channel.ExchangeDeclare(
-		PinRemovalExchange, // name
-		"fanout",           // type
-		true,               // durable
-		false,              // auto-delete
-		false,              // internal
-		false,              // no wait
-		nil,                // args
-	)
-}
-
-// DeclareIPFSPinExchange is used to declare the exchange used to handle ipfs pins
-func (qm *QueueManager) DeclareIPFSPinExchange() error {
-	return qm.Channel.ExchangeDeclare(
-		PinExchange, // name
-		"fanout",    // type
-		true,        // durable
-		false,       // auto-delete
-		false,       // internal
-		false,       // no wait
-		nil,         // args
-	)
-}
-
-// DeclareIPFSKeyExchange is used to declare the exchange used to handle ipfs key creation requests
-func (qm *QueueManager) DeclareIPFSKeyExchange() error {
-	return qm.Channel.ExchangeDeclare(
-		IpfsKeyExchange, // name
-		"fanout",        // type
-		true,            // durable
-		false,           // auto-delete
-		false,           // internal
-		false,           // no wait
-		nil,             // args
-	)
-}
@@ -1,10 +1,10 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 
 	"github.com/minio/minio-go"
 	log "github.com/sirupsen/logrus"
@@ -12,18 +12,20 @@ import (
 	"github.com/RTradeLtd/Temporal/mini"
 
 	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/mail"
+	"github.com/RTradeLtd/Temporal/metrics"
 	"github.com/RTradeLtd/Temporal/rtfs"
+	"github.com/RTradeLtd/Temporal/webhooks"
 
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/jinzhu/gorm"
-	"github.com/streadway/amqp"
 
 	ci "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 // ProcessIPFSKeyCreation is used to create IPFS keys
-func (qm *QueueManager) ProcessIPFSKeyCreation(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+func (qm *QueueManager) ProcessIPFSKeyCreation(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
 	manager, err := rtfs.Initialize("", "")
 	if err != nil {
 		return err
@@ -39,29 +41,29 @@ func (qm *QueueManager) ProcessIPFSKeyCreation(msgs <-chan amqp.Delivery, db *go
 		"service": qm.QueueName,
 	}).Info("processing ipfs key creation requests")
 
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("new message detected")
 
 		key := IPFSKeyCreation{}
-		err = json.Unmarshal(d.Body, &key)
+		err := json.Unmarshal(d.Body, &key)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if key.NetworkName != "public" {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    key.UserName,
 				"error":   errors.New("private network key creation not yet supported"),
 			}).Error("private network key creation not yet supported")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		var keyTypeInt int
 		var bitsInt int
@@ -69,87 +71,186 @@ func (qm *QueueManager) ProcessIPFSKeyCreation(msgs <-chan amqp.Delivery, db *go
 		case "rsa":
 			keyTypeInt = ci.RSA
 			if key.Size > 4096 {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    key.UserName,
 					"error":   "key size error",
 				}).Error("rsa key generation larger than 4096 bits not supported")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			bitsInt = key.Size
 		case "ed25519":
 			keyTypeInt = ci.Ed25519
 			bitsInt = 256
 		default:
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    key.UserName,
 				"error":   "unsupported key type",
 			}).Errorf("%s is not a valid key type, only ed25519 and rsa are supported", key.Type)
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		keyName := fmt.Sprintf("%s-%s", key.UserName, key.Name)
 		pk, err := manager.KeystoreManager.CreateAndSaveKey(keyName, keyTypeInt, bitsInt)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    key.UserName,
 				"error":   err.Error(),
 			}).Error("failed to create and save key")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 
 		id, err := peer.IDFromPrivateKey(pk)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    key.UserName,
 				"error":   err.Error(),
 			}).Error("failed to get id from private key")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if err := userManager.AddIPFSKeyForUser(key.UserName, keyName, id.Pretty()); err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    key.UserName,
 				"error":   err.Error(),
 			}).Error("failed to add ipfs key to database")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    key.UserName,
 		}).Info("successfully processed ipfs key creation")
-		d.Ack(false)
+		d.Ack()
+	})
+	return nil
+}
+
+// ProcessIPFSKeyUpdates is used to import, rename and delete keys in our keystore.
+// Renames and deletions are already recorded by the api, while imports are recorded here
+// as with key creation. Updates which were already applied are acknowledged, so redeliveries are safe
+func (qm *QueueManager) ProcessIPFSKeyUpdates(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+	km, err := rtfs.GenerateKeystoreManager()
+	if err != nil {
+		return err
 	}
+	userManager := models.NewUserManager(db)
+
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+	}).Info("processing ipfs key updates")
+
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service": qm.QueueName,
+		}).Info("new message detected")
+
+		update := IPFSKeyUpdate{}
+		if err := json.Unmarshal(d.Body, &update); err != nil {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service": qm.QueueName,
+				"error":   err.Error(),
+			}).Error("failed to unmarshal message")
+			d.Ack()
+			return
+		}
+		if err := updateIPFSKey(km, userManager, update); err != nil {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service":   qm.QueueName,
+				"user":      update.UserName,
+				"key":       update.Name,
+				"operation": update.Operation,
+				"error":     err.Error(),
+			}).Error("failed to update ipfs key")
+			d.Ack()
+			return
+		}
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":   qm.QueueName,
+			"user":      update.UserName,
+			"key":       update.Name,
+			"operation": update.Operation,
+		}).Info("successfully processed ipfs key update")
+		d.Ack()
+	})
 	return nil
 }
 
+// updateIPFSKey is used to apply a key update to our keystore
+func updateIPFSKey(km *rtfs.KeystoreManager, um *models.UserManager, update IPFSKeyUpdate) error {
+	present, err := km.CheckIfKeyExists(update.Name)
+	if err != nil {
+		return err
+	}
+	switch update.Operation {
+	case KeyImport:
+		var pk ci.PrivKey
+		if present {
+			pk, err = km.GetPrivateKeyByName(update.Name)
+		} else {
+			pk, err = km.ImportKey(update.Name, []byte(update.Key), update.Password)
+		}
+		if err != nil {
+			return err
+		}
+		id, err := peer.IDFromPrivateKey(pk)
+		if err != nil {
+			return err
+		}
+		return um.AddIPFSKeyForUser(update.UserName, update.Name, id.Pretty())
+	case KeyRename:
+		if !present {
+			// the key was already moved by an earlier delivery of this update
+			if renamed, err := km.CheckIfKeyExists(update.NewName); err != nil || renamed {
+				return err
+			}
+			return fmt.Errorf("key %s does not exist", update.Name)
+		}
+		return km.RenameKey(update.Name, update.NewName)
+	case KeyDelete:
+		if !present {
+			return nil
+		}
+		return km.DeleteKey(update.Name)
+	default:
+		return fmt.Errorf("%s is not a valid key update operation", update.Operation)
+	}
+}
+
 // ProccessIPFSPins is used to process IPFS pin requests
-func (qm *QueueManager) ProccessIPFSPins(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+func (qm *QueueManager) ProccessIPFSPins(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
 	userManager := models.NewUserManager(db)
 	//uploadManager := models.NewUploadManager(db)
 	networkManager := models.NewHostedIPFSNetworkManager(db)
 	uploadManager := models.NewUploadManager(db)
-	qmEmail, err := Initialize(EmailSendQueue, cfg.RabbitMQ.URL, true, false)
+	qmEmail, err := qm.ForQueue(EmailSendQueue)
+	if err != nil {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"error":   err.Error(),
+		}).Error("failed to initialize email queue")
+		return err
+	}
+	qmWebhook, err := qm.ForQueue(WebhookDeliveryQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize email queue connection")
+		}).Error("failed to initialize webhook delivery queue")
 		return err
 	}
-	qmCluster, err := Initialize(IpfsClusterPinQueue, cfg.RabbitMQ.URL, true, false)
+	qmCluster, err := qm.ForQueue(IpfsClusterPinQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize cluster pin queue connection")
+		}).Error("failed to initialize cluster pin queue")
 		return err
 	}
 
@@ -157,69 +258,68 @@ func (qm *QueueManager) ProccessIPFSPins(msgs <-chan amqp.Delivery, db *gorm.DB,
 		"service": qm.QueueName,
 	}).Info("processing ipfs pins")
 
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("new message detected")
 
 		pin := &IPFSPin{}
 		err := json.Unmarshal(d.Body, pin)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		apiURL := ""
 		if pin.NetworkName != "public" {
 			canAccess, err := userManager.CheckIfUserHasAccessToNetwork(pin.UserName, pin.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    pin.UserName,
 					"error":   err.Error(),
 				}).Error("error looking up private network in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			if !canAccess {
 				usernames := []string{}
 				usernames = append(usernames, pin.UserName)
 				es := EmailSend{
-					Subject:     IpfsPrivateNetworkUnauthorizedSubject,
-					Content:     fmt.Sprintf("Unauthorized access to IPFS private network %s", pin.NetworkName),
-					ContentType: "",
-					UserNames:   usernames,
+					Notification: mail.NotificationPrivateNetworkUnauthorized,
+					Data:         map[string]string{"network": pin.NetworkName},
+					UserNames:    usernames,
 				}
-				err = qmEmail.PublishMessage(es)
+				err = qmEmail.PublishMessageContext(ctx, es)
 				if err != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   err.Error(),
 					}).Error("failed to publish email send to queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    pin.UserName,
 				}).Warn("user does not have access to private network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			url, err := networkManager.GetAPIURLByName(pin.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    pin.UserName,
 					"error":   err.Error(),
 				}).Error("failed to lookup api url by name in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			apiURL = url
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    pin.UserName,
 		}).Info("initializing connection to IPFS")
@@ -228,76 +328,82 @@ func (qm *QueueManager) ProccessIPFSPins(msgs <-chan amqp.Delivery, db *gorm.DB,
 			addresses := []string{}
 			addresses = append(addresses, pin.UserName)
 			es := EmailSend{
-				Subject:     IpfsInitializationFailedSubject,
-				Content:     fmt.Sprintf("Connection to IPFS failed due to the following error %s", err),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationIPFSConnectionFailed,
+				Data:         map[string]string{"network": pin.NetworkName, "reason": err.Error()},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   err.Error(),
 				}).Error("failed to publish email send to queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    pin.UserName,
 				"error":   err.Error(),
 			}).Error("failed to initialize connection to IPFS")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    pin.UserName,
 			"network": pin.NetworkName,
 		}).Infof("pinning %s to ipfs", pin.CID)
-		err = ipfsManager.Pin(pin.CID)
+		err = ipfsManager.PinWithContext(ctx, pin.CID)
 		if err != nil {
 			addresses := []string{}
 			addresses = append(addresses, pin.UserName)
 			es := EmailSend{
-				Subject:     IpfsPinFailedSubject,
-				Content:     fmt.Sprintf(IpfsPinFailedContent, pin.CID, pin.NetworkName, err),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationPinFailed,
+				Data:         map[string]string{"cid": pin.CID, "network": pin.NetworkName, "reason": err.Error()},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   err.Error(),
 				}).Error("failed to publish email send to queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.publishWebhookEvent(qmWebhook, pin.UserName, webhooks.EventPinFailed, map[string]string{
+				"cid":     pin.CID,
+				"network": pin.NetworkName,
+				"reason":  err.Error(),
+			})
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    pin.UserName,
 				"network": pin.NetworkName,
 				"error":   err.Error(),
 			}).Errorf("failed to pin %s to ipfs", pin.CID)
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    pin.UserName,
 			"network": pin.NetworkName,
 		}).Infof("successfully pinned %s to ipfs", pin.CID)
+		if stat, err := ipfsManager.ObjectStatWithContext(ctx, pin.CID); err == nil {
+			metrics.PinnedBytes.WithLabelValues(pin.NetworkName).Add(float64(stat.CumulativeSize))
+		}
 		clusterAddMsg := IPFSClusterPin{
 			CID:              pin.CID,
 			NetworkName:      pin.NetworkName,
 			HoldTimeInMonths: pin.HoldTimeInMonths,
 			UserName:         pin.UserName,
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    pin.UserName,
 			"network": pin.NetworkName,
 		}).Infof("publishing cluster pin request for %s", pin.CID)
-		err = qmCluster.PublishMessage(clusterAddMsg)
+		err = qmCluster.PublishMessageContext(ctx, clusterAddMsg)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    pin.UserName,
 				"network": pin.NetworkName,
@@ -305,63 +411,67 @@ func (qm *QueueManager) ProccessIPFSPins(msgs <-chan amqp.Delivery, db *gorm.DB,
 		}
 		_, err = uploadManager.FindUploadByHashAndNetwork(pin.CID, pin.NetworkName)
 		if err != nil && err != gorm.ErrRecordNotFound {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    pin.UserName,
 				"network": pin.NetworkName,
 				"error":   err.Error(),
 			}).Error("failed to find model from database")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if err == gorm.ErrRecordNotFound {
 			_, err = uploadManager.NewUpload(pin.CID, "pin", pin.NetworkName, pin.UserName, pin.HoldTimeInMonths)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    pin.UserName,
 					"network": pin.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to create upload in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		} else {
 			// the record already exists so we will update
 			_, err = uploadManager.UpdateUpload(pin.HoldTimeInMonths, pin.UserName, pin.CID, pin.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    pin.UserName,
 					"network": pin.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to update upload in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    pin.UserName,
 			"network": pin.NetworkName,
 		}).Infof("successfully processed pin for %s", pin.CID)
-		d.Ack(false)
-	}
+		qm.publishWebhookEvent(qmWebhook, pin.UserName, webhooks.EventPinCompleted, map[string]string{
+			"cid":     pin.CID,
+			"network": pin.NetworkName,
+		})
+		d.Ack()
+	})
 	return nil
 }
 
 // ProcessIPFSPinRemovals is used to listen for and process any IPFS pin removals.
 // This queue must be running on each of the IPFS nodes, and we must eventually run checks
 // to ensure that pins were actually removed
-func (qm *QueueManager) ProcessIPFSPinRemovals(msgs <-chan amqp.Delivery, cfg *config.TemporalConfig, db *gorm.DB) error {
+func (qm *QueueManager) ProcessIPFSPinRemovals(msgs <-chan Delivery, cfg *config.TemporalConfig, db *gorm.DB) error {
 	userManager := models.NewUserManager(db)
 	networkManager := models.NewHostedIPFSNetworkManager(db)
-	qmEmail, err := Initialize(EmailSendQueue, cfg.RabbitMQ.URL, true, false)
+	qmEmail, err := qm.ForQueue(EmailSendQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize email queue connection")
+		}).Error("failed to initialize email queue")
 		return err
 	}
 
@@ -369,71 +479,72 @@ func (qm *QueueManager) ProcessIPFSPinRemovals(msgs <-chan amqp.Delivery, cfg *c
 		"service": qm.QueueName,
 	}).Info("processing ipfs pin removals")
 
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("detected new message")
 
 		rm := IPFSPinRemoval{}
 		err := json.Unmarshal(d.Body, &rm)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		apiURL := ""
-		if rm.NetworkName != "public" {
+		if rm.NetworkName != "public" && !rm.AccountDeleted {
 			canAccess, err := userManager.CheckIfUserHasAccessToNetwork(rm.UserName, rm.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    rm.UserName,
 					"network": rm.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to check database for user network access")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			if !canAccess {
 				addresses := []string{}
 				addresses = append(addresses, rm.UserName)
 				es := EmailSend{
-					Subject:     IpfsPrivateNetworkUnauthorizedSubject,
-					Content:     fmt.Sprintf("Unauthorized access to IPFS private network %s", rm.NetworkName),
-					ContentType: "",
-					UserNames:   addresses,
+					Notification: mail.NotificationPrivateNetworkUnauthorized,
+					Data:         map[string]string{"network": rm.NetworkName},
+					UserNames:    addresses,
 				}
-				err = qmEmail.PublishMessage(es)
+				err = qmEmail.PublishMessageContext(ctx, es)
 				if err != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   err.Error(),
 					}).Error("failed to publish message to email send queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    rm.UserName,
 					"network": rm.NetworkName,
 				}).Error("unauthorized access to private network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
+		}
+		apiURL := ""
+		if rm.NetworkName != "public" {
 			apiURL, err = networkManager.GetAPIURLByName(rm.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    rm.UserName,
 					"network": rm.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to look for api url by name")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    rm.UserName,
 			"network": rm.NetworkName,
@@ -442,77 +553,80 @@ func (qm *QueueManager) ProcessIPFSPinRemovals(msgs <-chan amqp.Delivery, cfg *c
 		if err != nil {
 			addresses := []string{rm.UserName}
 			es := EmailSend{
-				Subject:     IpfsInitializationFailedSubject,
-				Content:     fmt.Sprintf("Failed to connect to IPFS network %s for reason %s", rm.NetworkName, err),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationIPFSConnectionFailed,
+				Data:         map[string]string{"network": rm.NetworkName, "reason": err.Error()},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   errOne.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    rm.UserName,
 				"network": rm.NetworkName,
 				"error":   err.Error(),
 			}).Error("failed to initialize connection to ipfs")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    rm.UserName,
 			"network": rm.NetworkName,
 		}).Infof("unpinning %s from ipfs", rm.ContentHash)
-		err = ipfsManager.Shell.Unpin(rm.ContentHash)
+		// the size is looked up first, since the content may be garbage collected once unpinned
+		stat, statErr := ipfsManager.ObjectStatWithContext(ctx, rm.ContentHash)
+		err = ipfsManager.UnpinWithContext(ctx, rm.ContentHash)
 		if err != nil {
 			addresses := []string{rm.UserName}
 			es := EmailSend{
-				Subject:     "Pin removal failed",
-				Content:     fmt.Sprintf("Pin removal failed for ipfs network %s due to reason %s", rm.NetworkName, err),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationPinRemovalFailed,
+				Data:         map[string]string{"cid": rm.ContentHash, "network": rm.NetworkName, "reason": err.Error()},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   errOne.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    rm.UserName,
 				"network": rm.NetworkName,
 				"error":   err.Error(),
 			}).Errorf("failed to unpin %s", rm.ContentHash)
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    rm.UserName,
 			"network": rm.NetworkName,
 		}).Infof("successfully unpinned %s", rm.ContentHash)
-		d.Ack(false)
-	}
+		if statErr == nil {
+			metrics.PinnedBytes.WithLabelValues(rm.NetworkName).Sub(float64(stat.CumulativeSize))
+		}
+		d.Ack()
+	})
 	return nil
 }
 
 // ProccessIPFSFiles is used to process messages sent to rabbitmq to upload files to IPFS.
 // This function is invoked with the advanced method of file uploads, and is significantly more resilient than
 // the simple file upload method.
-func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan amqp.Delivery, cfg *config.TemporalConfig, db *gorm.DB) error {
+func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan Delivery, cfg *config.TemporalConfig, db *gorm.DB) error {
 	// construct the endpoint url to access our minio server
 	endpoint := fmt.Sprintf("%s:%s", cfg.MINIO.Connection.IP, cfg.MINIO.Connection.Port)
 	// grab our credentials for minio
 	accessKey := cfg.MINIO.AccessKey
 	secretKey := cfg.MINIO.SecretKey
-	ipfsManager, err := rtfs.Initialize("", "")
+	publicManager, err := rtfs.Initialize("", "")
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
@@ -529,20 +643,28 @@ func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan amqp.Delivery, cfg *config
 		}).Error("failed to initialize connection to minio")
 		return err
 	}
-	qmEmail, err := Initialize(EmailSendQueue, cfg.RabbitMQ.URL, true, false)
+	qmEmail, err := qm.ForQueue(EmailSendQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize email send queue connection")
+		}).Error("failed to initialize email send queue")
 		return err
 	}
-	qmPin, err := Initialize(IpfsPinQueue, cfg.RabbitMQ.URL, true, false)
+	qmWebhook, err := qm.ForQueue(WebhookDeliveryQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize pin queue connection")
+		}).Error("failed to initialize webhook delivery queue")
+		return err
+	}
+	qmPin, err := qm.ForQueue(IpfsPinQueue)
+	if err != nil {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"error":   err.Error(),
+		}).Error("failed to initialize pin queue")
 		return err
 	}
 	userManager := models.NewUserManager(db)
@@ -551,71 +673,72 @@ func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan amqp.Delivery, cfg *config
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("processing ipfs files")
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		// private network messages replace this with a connection to their network
+		ipfsManager := publicManager
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("new message detected")
 
 		ipfsFile := IPFSFile{}
 		// unmarshal the messagee
-		err = json.Unmarshal(d.Body, &ipfsFile)
+		err := json.Unmarshal(d.Body, &ipfsFile)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if ipfsFile.NetworkName != "public" {
 			canAccess, err := userManager.CheckIfUserHasAccessToNetwork(ipfsFile.UserName, ipfsFile.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to check database for user network access")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			if !canAccess {
 				addresses := []string{}
 				addresses = append(addresses, ipfsFile.UserName)
 				es := EmailSend{
-					Subject:     IpfsPrivateNetworkUnauthorizedSubject,
-					Content:     fmt.Sprintf("Unauthorized access to IPFS private network %s", ipfsFile.NetworkName),
-					ContentType: "",
-					UserNames:   addresses,
+					Notification: mail.NotificationPrivateNetworkUnauthorized,
+					Data:         map[string]string{"network": ipfsFile.NetworkName},
+					UserNames:    addresses,
 				}
-				err = qmEmail.PublishMessage(es)
+				err = qmEmail.PublishMessageContext(ctx, es)
 				if err != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   err.Error(),
 					}).Error("failed to publish message to email send queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 				}).Error("unauthorized access to private network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			apiURLName, err := networkManager.GetAPIURLByName(ipfsFile.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to look for api url by name")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			apiURL := apiURLName
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
@@ -625,111 +748,104 @@ func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan amqp.Delivery, cfg *config
 				addresses := []string{}
 				addresses = append(addresses, ipfsFile.UserName)
 				es := EmailSend{
-					Subject:     IpfsInitializationFailedSubject,
-					Content:     fmt.Sprintf("Connection to IPFS failed due to the following error %s", err),
-					ContentType: "",
-					UserNames:   addresses,
+					Notification: mail.NotificationIPFSConnectionFailed,
+					Data:         map[string]string{"network": ipfsFile.NetworkName, "reason": err.Error()},
+					UserNames:    addresses,
 				}
-				errOne := qmEmail.PublishMessage(es)
+				errOne := qmEmail.PublishMessageContext(ctx, es)
 				if errOne != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   errOne.Error(),
 					}).Error("failed to publish message to email send queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to initialize connection to private ipfs network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
 
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("retrieving object from minio")
 
-		obj, err := minioManager.GetObject(ipfsFile.BucketName, ipfsFile.ObjectName, minio.GetObjectOptions{})
+		obj, err := minioManager.GetObjectWithContext(ctx, ipfsFile.BucketName, ipfsFile.ObjectName, minio.GetObjectOptions{})
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
 				"error":   err.Error(),
 			}).Info("failed to retrieve object from minio")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("successfully retrieved object from minio")
 
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("adding file to ipfs")
-		resp, err := ipfsManager.Add(obj)
+		resp, err := ipfsManager.AddWithContext(ctx, obj)
 		if err != nil {
 			//TODO: decide how to handle email failures
 			addresses := []string{}
 			addresses = append(addresses, ipfsFile.UserName)
 			es := EmailSend{
-				Subject:     IpfsFileFailedSubject,
-				Content:     fmt.Sprintf(IpfsFileFailedContent, ipfsFile.ObjectName, ipfsFile.NetworkName),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationFileAddFailed,
+				Data:         map[string]string{"object_name": ipfsFile.ObjectName, "network": ipfsFile.NetworkName},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   errOne.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
 				"error":   err.Error(),
 			}).Info("failed to add file to ipfs")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("file successfully added to IPFS, forwarding pin request")
+		qm.publishWebhookEvent(qmWebhook, ipfsFile.UserName, webhooks.EventUploadCompleted, map[string]string{
+			"cid":         resp,
+			"object_name": ipfsFile.ObjectName,
+			"network":     ipfsFile.NetworkName,
+		})
 
-		holdTimeInt, err := strconv.ParseInt(ipfsFile.HoldTimeInMonths, 10, 64)
-		if err != nil {
-			qm.Logger.WithFields(log.Fields{
-				"service": qm.QueueName,
-				"user":    ipfsFile.UserName,
-				"network": ipfsFile.NetworkName,
-				"error":   err.Error(),
-			}).Warn("failed to parse string to int, using default of 1 month")
-			holdTimeInt = 1
-		}
 		pin := IPFSPin{
 			CID:              resp,
 			NetworkName:      ipfsFile.NetworkName,
 			UserName:         ipfsFile.UserName,
-			HoldTimeInMonths: holdTimeInt,
+			HoldTimeInMonths: ipfsFile.HoldTimeInMonths,
 		}
 
 		err = qmPin.PublishMessageWithExchange(pin, PinExchange)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
@@ -739,67 +855,67 @@ func (qm *QueueManager) ProccessIPFSFiles(msgs <-chan amqp.Delivery, cfg *config
 
 		_, err = uploadManager.FindUploadByHashAndNetwork(resp, ipfsFile.NetworkName)
 		if err != nil && err != gorm.ErrRecordNotFound {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
 				"error":   err.Error(),
 			}).Error("failed to look for upload in database")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if err == gorm.ErrRecordNotFound {
-			_, err = uploadManager.NewUpload(resp, "file", ipfsFile.NetworkName, ipfsFile.UserName, holdTimeInt)
+			_, err = uploadManager.NewUpload(resp, "file", ipfsFile.NetworkName, ipfsFile.UserName, ipfsFile.HoldTimeInMonths)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to create new upload in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		} else {
-			_, err = uploadManager.UpdateUpload(holdTimeInt, ipfsFile.UserName, resp, ipfsFile.NetworkName)
+			_, err = uploadManager.UpdateUpload(ipfsFile.HoldTimeInMonths, ipfsFile.UserName, resp, ipfsFile.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ipfsFile.UserName,
 					"network": ipfsFile.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to update upload in database")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("removing object from minio")
-		err = minioManager.RemoveObject(ipfsFile.BucketName, ipfsFile.ObjectName)
+		err = minioManager.RemoveObjectWithContext(ctx, ipfsFile.BucketName, ipfsFile.ObjectName)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ipfsFile.UserName,
 				"network": ipfsFile.NetworkName,
 				"error":   err.Error(),
 			}).Info("failed to remove object from minio")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("object removed from minio")
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ipfsFile.UserName,
 			"network": ipfsFile.NetworkName,
 		}).Info("succesfully added file into ipfs")
-		d.Ack(false)
-	}
+		d.Ack()
+	})
 	return nil
 }
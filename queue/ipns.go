@@ -1,17 +1,18 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/mail"
 	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/webhooks"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/RTradeLtd/Temporal/rtfs"
 	"github.com/jinzhu/gorm"
-	"github.com/streadway/amqp"
 )
 
 // IPNSEntry is used to hold relevant information needed to process IPNS entry creation requests
@@ -26,8 +27,8 @@ type IPNSEntry struct {
 }
 
 // ProcessIPNSEntryCreationRequests is used to process IPNS entry creation requests
-func (qm *QueueManager) ProcessIPNSEntryCreationRequests(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
-	ipfsManager, err := rtfs.Initialize("", "")
+func (qm *QueueManager) ProcessIPNSEntryCreationRequests(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+	publicManager, err := rtfs.Initialize("", "")
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
@@ -35,7 +36,7 @@ func (qm *QueueManager) ProcessIPNSEntryCreationRequests(msgs <-chan amqp.Delive
 		}).Error("failed to initialize connection to ipfs")
 		return err
 	}
-	err = ipfsManager.CreateKeystoreManager()
+	err = publicManager.CreateKeystoreManager()
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
@@ -46,81 +47,90 @@ func (qm *QueueManager) ProcessIPNSEntryCreationRequests(msgs <-chan amqp.Delive
 	ipnsManager := models.NewIPNSManager(db)
 	userManager := models.NewUserManager(db)
 	networkManager := models.NewHostedIPFSNetworkManager(db)
-	qmEmail, err := Initialize(IpnsEntryQueue, cfg.RabbitMQ.URL, true, false)
+	qmEmail, err := qm.ForQueue(EmailSendQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize connection to email send queue")
+		}).Error("failed to initialize email send queue")
+		return err
+	}
+	qmWebhook, err := qm.ForQueue(WebhookDeliveryQueue)
+	if err != nil {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"error":   err.Error(),
+		}).Error("failed to initialize webhook delivery queue")
 		return err
 	}
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("Processing ipns entry requests")
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		// private network messages replace this with a connection to their network
+		ipfsManager := publicManager
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("new message detected")
 		ie := IPNSEntry{}
-		err = json.Unmarshal(d.Body, &ie)
+		err := json.Unmarshal(d.Body, &ie)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		apiURL := ""
 		if ie.NetworkName != "public" {
 			canAccess, err := userManager.CheckIfUserHasAccessToNetwork(ie.UserName, ie.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ie.UserName,
 					"network": ie.NetworkName,
 					"error":   err.Error(),
 				}).Error("error checking for private network access")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			if !canAccess {
 				addresses := []string{}
 				addresses = append(addresses, ie.UserName)
 				es := EmailSend{
-					Subject:     IpfsPrivateNetworkUnauthorizedSubject,
-					Content:     fmt.Sprintf("Unauthorized access to IPFS private network %s", ie.NetworkName),
-					ContentType: "",
-					UserNames:   addresses,
+					Notification: mail.NotificationPrivateNetworkUnauthorized,
+					Data:         map[string]string{"network": ie.NetworkName},
+					UserNames:    addresses,
 				}
-				err = qmEmail.PublishMessage(es)
+				err = qmEmail.PublishMessageContext(ctx, es)
 				if err != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   err.Error(),
 					}).Error("failed to publish message to email send queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ie.UserName,
 					"network": ie.NetworkName,
 				}).Error("unauthorized access to private network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			apiURLName, err := networkManager.GetAPIURLByName(ie.NetworkName)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ie.UserName,
 					"network": ie.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to get ipfs api url by name")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 			apiURL = apiURLName
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ie.UserName,
 				"network": ie.NetworkName,
@@ -130,76 +140,83 @@ func (qm *QueueManager) ProcessIPNSEntryCreationRequests(msgs <-chan amqp.Delive
 				addresses := []string{}
 				addresses = append(addresses, ie.UserName)
 				es := EmailSend{
-					Subject:     IpfsInitializationFailedSubject,
-					Content:     fmt.Sprintf("Connection to IPFS failed due to the following error %s", err),
-					ContentType: "",
-					UserNames:   addresses,
+					Notification: mail.NotificationIPFSConnectionFailed,
+					Data:         map[string]string{"network": ie.NetworkName, "reason": err.Error()},
+					UserNames:    addresses,
 				}
-				errOne := qmEmail.PublishMessage(es)
+				errOne := qmEmail.PublishMessageContext(ctx, es)
 				if errOne != nil {
-					qm.Logger.WithFields(log.Fields{
+					qm.logger(ctx).WithFields(log.Fields{
 						"service": qm.QueueName,
 						"error":   errOne.Error(),
 					}).Error("failed to publish message to email send queue")
 				}
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    ie.UserName,
 					"network": ie.NetworkName,
 					"error":   err.Error(),
 				}).Error("failed to initialize conenction to private ipfs network")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ie.UserName,
 			"network": ie.NetworkName,
 		}).Info("publishing ipns entry")
-		response, err := ipfsManager.PublishToIPNSDetails(ie.CID, ie.Key, ie.LifeTime, ie.TTL, ie.Resolve)
+		response, err := ipfsManager.PublishToIPNSDetailsWithContext(ctx, ie.CID, ie.Key, ie.LifeTime, ie.TTL, ie.Resolve)
 		if err != nil {
-			fmt.Println("error publishing response")
-			formattedContent := fmt.Sprintf(IpnsEntryFailedContent, ie.CID, ie.Key, err)
+			qm.logger(ctx).WithFields(log.Fields{
+				"service": qm.QueueName,
+				"user":    ie.UserName,
+				"error":   err.Error(),
+			}).Error("failed to publish ipns entry")
 			addresses := []string{}
 			addresses = append(addresses, ie.UserName)
 			es := EmailSend{
-				Subject:     IpnsEntryFailedSubject,
-				Content:     formattedContent,
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationIPNSEntryFailed,
+				Data:         map[string]string{"cid": ie.CID, "key": ie.Key, "reason": err.Error()},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   errOne.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ie.UserName,
 				"network": ie.NetworkName,
 				"error":   err.Error(),
 			}).Error("failed to publish entry to ipns")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		_, err = ipnsManager.UpdateIPNSEntry(response.Name, ie.CID, ie.Key, ie.NetworkName, ie.LifeTime, ie.TTL)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"user":    ie.UserName,
 				"network": ie.NetworkName,
 				"error":   err.Error(),
 			}).Error("failed to update IPNS entry in database")
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 			"user":    ie.UserName,
 			"network": ie.NetworkName,
 		}).Info("successfully published entry to ipns")
-		d.Ack(false)
-	}
+		qm.publishWebhookEvent(qmWebhook, ie.UserName, webhooks.EventIPNSPublished, map[string]string{
+			"cid":       ie.CID,
+			"ipns_hash": response.Name,
+			"key":       ie.Key,
+			"network":   ie.NetworkName,
+		})
+		d.Ack()
+	})
 	return nil
 }
@@ -1,47 +1,28 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/RTradeLtd/Temporal/config"
 	"github.com/RTradeLtd/Temporal/mail"
 	log "github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
 )
 
-var (
-	// IpfsPinFailedContent is a to-be formatted message sent on IPFS pin failures
-	IpfsPinFailedContent = "Pin failed for content hash %s on IPFS network %s, for reason %s"
-	// IpfsPinFailedSubject is a subject for IPFS pin failed messages
-	IpfsPinFailedSubject = "IPFS Pin Failed"
-	// IpfsFileFailedContent is a to be formatted message sent on ipfs add failures
-	IpfsFileFailedContent = "IPFS File Add Failed for object name %s on IPFS network %s"
-	// IpfsFileFailedSubject is a subject for ipfs file add fails
-	IpfsFileFailedSubject = "IPFS File Add Failed"
-	// IpfsPrivateNetworkUnauthorizedSubject is a subject whenever someone tries to access a bad private network
-	IpfsPrivateNetworkUnauthorizedSubject = "Unauthorized access to IPFS private network"
-	// IpfsInitializationFailedSubject is a subject used when connecting to ipfs fails
-	IpfsInitializationFailedSubject = "Connection to IPFS failed"
-	// IpnsEntryFailedSubject is a subject sent upon IPNS failures
-	IpnsEntryFailedSubject = "IPNS Entry Creation Failed"
-	// IpnsEntryFailedContent is the content used when sending an email for IPNS entry creation failures
-	IpnsEntryFailedContent = "IPNS Entry creation failed for content hash %s using key %s for reason %s"
-	// PaymentConfirmationFailedSubject is a subject used when payment confirmations fail
-	PaymentConfirmationFailedSubject = "Payment Confirmation Failed"
-	// PaymentConfirmationFailedContent is a content used when a payment confirmation failure occurs
-	PaymentConfirmationFailedContent = "Payment failed for content hash %s with error %s"
-)
-
-// EmailSend is a helper struct used to contained formatted content ot send as an email
+// EmailSend is a helper struct used to contained formatted content ot send as an email.
+// When Notification is set the email is rendered from the notification's templates using
+// Data, otherwise Subject and Content are sent as is
 type EmailSend struct {
-	Subject     string   `json:"subject"`
-	Content     string   `json:"content"`
-	ContentType string   `json:"content_type"`
-	UserNames   []string `json:"user_names"`
+	Notification string            `json:"notification,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Subject      string            `json:"subject,omitempty"`
+	Content      string            `json:"content,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	UserNames    []string          `json:"user_names"`
 }
 
 // ProcessMailSends is a function used to process mail send queue messages
-func (qm *QueueManager) ProcessMailSends(msgs <-chan amqp.Delivery, tCfg *config.TemporalConfig) error {
+func (qm *QueueManager) ProcessMailSends(msgs <-chan Delivery, tCfg *config.TemporalConfig) error {
 	mm, err := mail.GenerateMailManager(tCfg)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
@@ -53,49 +34,58 @@ func (qm *QueueManager) ProcessMailSends(msgs <-chan amqp.Delivery, tCfg *config
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("process email sends")
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("detected new message")
 		es := EmailSend{}
-		err = json.Unmarshal(d.Body, &es)
+		err := json.Unmarshal(d.Body, &es)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		emails := make(map[string]string)
 		for _, v := range es.UserNames {
-			resp, err := mm.UserManager.FindEmailByUserName(v)
-			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+			err = sendEmail(mm, v, es)
+			switch err {
+			case nil:
+				qm.logger(ctx).WithFields(log.Fields{
+					"service":      qm.QueueName,
+					"user":         v,
+					"notification": es.Notification,
+				}).Info("successfully sent email")
+			case mail.ErrEmailDisabled:
+				qm.logger(ctx).WithFields(log.Fields{
+					"service":      qm.QueueName,
+					"user":         v,
+					"notification": es.Notification,
+				}).Info("user has disabled email, skipping")
+			default:
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"user":    v,
 					"error":   err.Error(),
-				}).Error("failed to find email by user name")
-				d.Ack(false)
-				continue
-			}
-			emails[v] = resp[v]
-		}
-		for k, v := range emails {
-			_, err = mm.SendEmail(es.Subject, es.Content, es.ContentType, k, v)
-			if err != nil {
-				qm.Logger.WithFields(log.Fields{
-					"service": qm.QueueName,
-					"user":    k,
-					"error":   err.Error(),
 				}).Error("failed to send email")
 			}
 		}
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-			"users":   es.UserNames,
-		}).Info("successfully sent emails")
-		d.Ack(false)
-	}
+		d.Ack()
+	})
 	return nil
 }
+
+// sendEmail is used to send a single email send message to one user
+func sendEmail(mm *mail.MailManager, username string, es EmailSend) error {
+	if es.Notification != "" {
+		return mm.Notify(username, es.Notification, es.Data)
+	}
+	email := &mail.Email{Subject: es.Subject}
+	if es.ContentType == "text/plain" {
+		email.Text = es.Content
+	} else {
+		email.HTML = es.Content
+	}
+	return mm.SendToUser(username, "", email)
+}
@@ -0,0 +1,331 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryBroker is an in-process broker, used to run every service in a single process
+// and to test message flows without rabbitmq. Like rabbitmq, messages which aren't
+// acknowledged are requeued, but every message is lost when the process exits
+type MemoryBroker struct {
+	mux       sync.Mutex
+	queues    map[string]*memoryQueue
+	bindings  map[string][]string
+	consumers map[string]*memoryConsumer
+	// cancelled holds consumers which were cancelled with deliveries still to settle
+	cancelled map[*memoryConsumer]bool
+	closed    bool
+}
+
+// memoryQueue holds the messages waiting to be delivered to a queue's consumers
+type memoryQueue struct {
+	pending   []memoryMessage
+	consumers []*memoryConsumer
+	// next is the consumer which receives the next message, so messages are shared round robin
+	next int
+}
+
+type memoryMessage struct {
+	id      string
+	body    []byte
+	headers map[string]string
+}
+
+// memoryConsumer receives deliveries from a queue, holding up to prefetch unacknowledged deliveries
+type memoryConsumer struct {
+	broker     *MemoryBroker
+	queue      *memoryQueue
+	prefetch   int
+	deliveries chan Delivery
+	unacked    map[uint64]memoryMessage
+	lastTag    uint64
+	cancelled  bool
+}
+
+// NewMemoryBroker is used to generate an in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:    make(map[string]*memoryQueue),
+		bindings:  make(map[string][]string),
+		consumers: make(map[string]*memoryConsumer),
+		cancelled: make(map[*memoryConsumer]bool),
+	}
+}
+
+// queue is used to get a queue, declaring it on first use. The caller must hold the lock
+func (mb *MemoryBroker) queue(queueName string) *memoryQueue {
+	q, ok := mb.queues[queueName]
+	if !ok {
+		q = &memoryQueue{}
+		mb.queues[queueName] = q
+	}
+	return q
+}
+
+// Publish is used to add a message to a queue
+func (mb *MemoryBroker) Publish(queueName string, env *Envelope) error {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	if mb.closed {
+		return ErrPublisherClosed
+	}
+	q := mb.queue(queueName)
+	q.pending = append(q.pending, memoryMessage{id: env.ID, body: body, headers: env.Headers})
+	q.dispatch()
+	return nil
+}
+
+// Fanout is used to add a message to every queue bound to an exchange
+func (mb *MemoryBroker) Fanout(exchangeName string, env *Envelope) error {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	if mb.closed {
+		return ErrPublisherClosed
+	}
+	// mirror rabbitmq's handling of mandatory messages
+	if len(mb.bindings[exchangeName]) == 0 {
+		return &PublishError{Queue: exchangeName, Reason: PublishUnroutable}
+	}
+	for _, queueName := range mb.bindings[exchangeName] {
+		q := mb.queue(queueName)
+		q.pending = append(q.pending, memoryMessage{id: env.ID, body: body, headers: env.Headers})
+		q.dispatch()
+	}
+	return nil
+}
+
+// Consume is used to receive deliveries from a queue, binding it to exchangeName when set
+func (mb *MemoryBroker) Consume(queueName, exchangeName, consumer string, prefetch int) (<-chan Delivery, error) {
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	if mb.closed {
+		return nil, ErrPublisherClosed
+	}
+	if _, ok := mb.consumers[consumer]; ok {
+		return nil, fmt.Errorf("consumer %s already exists", consumer)
+	}
+	if exchangeName != "" && !mb.bound(exchangeName, queueName) {
+		mb.bindings[exchangeName] = append(mb.bindings[exchangeName], queueName)
+	}
+	q := mb.queue(queueName)
+	mc := &memoryConsumer{
+		broker:   mb,
+		queue:    q,
+		prefetch: prefetch,
+		// never more than prefetch deliveries are outstanding, so sends can't block
+		deliveries: make(chan Delivery, prefetch),
+		unacked:    make(map[uint64]memoryMessage),
+	}
+	mb.consumers[consumer] = mc
+	q.consumers = append(q.consumers, mc)
+	q.dispatch()
+	return mc.deliveries, nil
+}
+
+// bound is used to check if a queue is bound to an exchange. The caller must hold the lock
+func (mb *MemoryBroker) bound(exchangeName, queueName string) bool {
+	for _, name := range mb.bindings[exchangeName] {
+		if name == queueName {
+			return true
+		}
+	}
+	return false
+}
+
+// Cancel is used to stop a consumer. Deliveries the consumer hasn't received yet are
+// requeued for other consumers, while those it has received can still be settled
+func (mb *MemoryBroker) Cancel(consumer string) error {
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	mc, ok := mb.consumers[consumer]
+	if !ok {
+		return nil
+	}
+	delete(mb.consumers, consumer)
+	mc.cancel()
+	if len(mc.unacked) > 0 {
+		mb.cancelled[mc] = true
+	}
+	mc.queue.dispatch()
+	return nil
+}
+
+// Close is used to cancel every consumer. As when a rabbitmq connection closes, deliveries
+// which haven't been settled are requeued, and can no longer be settled
+func (mb *MemoryBroker) Close() error {
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	if mb.closed {
+		return nil
+	}
+	mb.closed = true
+	for name, mc := range mb.consumers {
+		delete(mb.consumers, name)
+		mc.cancel()
+		mc.requeue(mc.tags()...)
+	}
+	for mc := range mb.cancelled {
+		delete(mb.cancelled, mc)
+		mc.requeue(mc.tags()...)
+	}
+	return nil
+}
+
+// Outstanding is used to count the messages in a queue which haven't been acknowledged,
+// whether or not they've been delivered
+func (mb *MemoryBroker) Outstanding(queueName string) int {
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	q, ok := mb.queues[queueName]
+	if !ok {
+		return 0
+	}
+	count := len(q.pending)
+	for _, mc := range q.consumers {
+		count += len(mc.unacked)
+	}
+	for mc := range mb.cancelled {
+		if mc.queue == q {
+			count += len(mc.unacked)
+		}
+	}
+	return count
+}
+
+// Ping is used to check the broker hasn't been closed
+func (mb *MemoryBroker) Ping() error {
+	mb.mux.Lock()
+	defer mb.mux.Unlock()
+	if mb.closed {
+		return ErrPublisherClosed
+	}
+	return nil
+}
+
+// dispatch is used to hand pending messages to consumers with room for them. The caller must hold the lock
+func (q *memoryQueue) dispatch() {
+	for len(q.pending) > 0 {
+		mc := q.available()
+		if mc == nil {
+			return
+		}
+		msg := q.pending[0]
+		q.pending = q.pending[1:]
+		mc.lastTag++
+		mc.unacked[mc.lastTag] = msg
+		mc.deliveries <- Delivery{
+			Body:         msg.body,
+			MessageID:    msg.id,
+			Headers:      msg.headers,
+			tag:          mc.lastTag,
+			acknowledger: mc,
+		}
+	}
+}
+
+// available is used to find the next consumer able to receive a delivery
+func (q *memoryQueue) available() *memoryConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		mc := q.consumers[(q.next+i)%len(q.consumers)]
+		if len(mc.unacked) < mc.prefetch {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return mc
+		}
+	}
+	return nil
+}
+
+// cancel is used to stop delivering to the consumer, requeuing deliveries the consumer
+// hasn't received. The caller must hold the broker's lock
+func (mc *memoryConsumer) cancel() {
+	if mc.cancelled {
+		return
+	}
+	mc.cancelled = true
+	for i, c := range mc.queue.consumers {
+		if c == mc {
+			mc.queue.consumers = append(mc.queue.consumers[:i], mc.queue.consumers[i+1:]...)
+			break
+		}
+	}
+	mc.queue.next = 0
+	close(mc.deliveries)
+	undelivered := []uint64{}
+	for d := range mc.deliveries {
+		undelivered = append(undelivered, d.tag)
+	}
+	mc.requeue(undelivered...)
+}
+
+// tags is used to list the consumer's unacknowledged deliveries, oldest first
+func (mc *memoryConsumer) tags() []uint64 {
+	tags := make([]uint64, 0, len(mc.unacked))
+	for tag := range mc.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
+// requeue is used to return deliveries to the front of the consumer's queue, in the
+// order they were delivered. The caller must hold the broker's lock
+func (mc *memoryConsumer) requeue(tags ...uint64) {
+	msgs := make([]memoryMessage, 0, len(tags))
+	for _, tag := range tags {
+		msgs = append(msgs, mc.unacked[tag])
+		delete(mc.unacked, tag)
+	}
+	mc.queue.pending = append(msgs, mc.queue.pending...)
+}
+
+// settled is used to forget a cancelled consumer once it has no deliveries left to
+// settle. The caller must hold the broker's lock
+func (mc *memoryConsumer) settled() {
+	if mc.cancelled && len(mc.unacked) == 0 {
+		delete(mc.broker.cancelled, mc)
+	}
+}
+
+// Ack is used to settle a delivery, making room for the next one
+func (mc *memoryConsumer) Ack(tag uint64) error {
+	mc.broker.mux.Lock()
+	defer mc.broker.mux.Unlock()
+	if _, ok := mc.unacked[tag]; !ok {
+		return fmt.Errorf("unknown delivery tag %v", tag)
+	}
+	delete(mc.unacked, tag)
+	mc.settled()
+	mc.queue.dispatch()
+	return nil
+}
+
+// Nack is used to reject a delivery, returning it to the front of its queue when requeue is set
+func (mc *memoryConsumer) Nack(tag uint64, requeue bool) error {
+	mc.broker.mux.Lock()
+	defer mc.broker.mux.Unlock()
+	msg, ok := mc.unacked[tag]
+	if !ok {
+		return fmt.Errorf("unknown delivery tag %v", tag)
+	}
+	delete(mc.unacked, tag)
+	mc.settled()
+	if requeue {
+		mc.queue.pending = append([]memoryMessage{msg}, mc.queue.pending...)
+	}
+	mc.queue.dispatch()
+	return nil
+}
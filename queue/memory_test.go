@@ -0,0 +1,383 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/database"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/RTradeLtd/Temporal/mail"
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/tracing"
+	"github.com/RTradeLtd/Temporal/utils"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	for i := 0; i < 3; i++ {
+		env, err := queue.NewEnvelope(queue.DatabaseFileAddQueue, "test", queue.DatabaseFileAdd{Hash: testCID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = mb.Publish(queue.DatabaseFileAddQueue, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := mb.Consume(queue.DatabaseFileAddQueue, "", "test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := receive(t, msgs), receive(t, msgs)
+	// only prefetch deliveries are outstanding until one is settled
+	select {
+	case <-msgs:
+		t.Fatal("received more deliveries than prefetch")
+	default:
+	}
+	if err = first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	if redelivered := receive(t, msgs); redelivered.MessageID != first.MessageID {
+		t.Fatal("expected requeued delivery to be redelivered first")
+	}
+	if err = second.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, msgs)
+	if err = mb.Cancel("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-msgs; ok {
+		t.Fatal("expected deliveries to be closed after cancelling")
+	}
+}
+
+func TestMemoryBrokerRequeue(t *testing.T) {
+	mb := queue.NewMemoryBroker()
+	for i := 0; i < 2; i++ {
+		env, err := queue.NewEnvelope(queue.DatabaseFileAddQueue, "test", queue.DatabaseFileAdd{Hash: testCID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = mb.Publish(queue.DatabaseFileAddQueue, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	one, err := mb.Consume(queue.DatabaseFileAddQueue, "", "one", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := receive(t, one)
+	// the second delivery was never received, so it goes to the next consumer
+	if err = mb.Cancel("one"); err != nil {
+		t.Fatal(err)
+	}
+	two, err := mb.Consume(queue.DatabaseFileAddQueue, "", "two", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := receive(t, two)
+	if second.MessageID == first.MessageID {
+		t.Fatal("expected the undelivered message to be requeued")
+	}
+	if n := mb.Outstanding(queue.DatabaseFileAddQueue); n != 2 {
+		t.Fatalf("expected 2 outstanding messages, got %v", n)
+	}
+	// unsettled deliveries are requeued on close, and can't be settled afterwards
+	if err = mb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Ack(); err == nil {
+		t.Fatal("expected settling after close to fail")
+	}
+	if n := mb.Outstanding(queue.DatabaseFileAddQueue); n != 2 {
+		t.Fatalf("expected 2 outstanding messages, got %v", n)
+	}
+}
+
+func TestMemoryBrokerFanout(t *testing.T) {
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	env, err := queue.NewEnvelope(queue.IpfsPinQueue, "test", queue.IPFSPin{CID: testCID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mb.Fanout(queue.PinExchange, env); err == nil {
+		t.Fatal("expected publishing to an exchange without queues to fail")
+	} else if _, ok := err.(*queue.PublishError); !ok {
+		t.Fatalf("expected a publish error, got %v", err)
+	}
+	one, err := mb.Consume("one+"+queue.IpfsPinQueue, queue.PinExchange, "one", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := mb.Consume("two+"+queue.IpfsPinQueue, queue.PinExchange, "two", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mb.Fanout(queue.PinExchange, env); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, one)
+	receive(t, two)
+}
+
+// TestMemoryPipeline forwards a pin to the cluster and then email queues through a single
+// in-memory broker, with stub handlers standing in for our services. Each stub acknowledges
+// its delivery only once it has been forwarded, as our services do
+func TestMemoryPipeline(t *testing.T) {
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	pins, err := queue.NewQueueManager(queue.IpfsPinQueue, mb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinMsgs, err := mb.Consume(pins.QueueName, pins.ExchangeName, "pins", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterMsgs, err := mb.Consume(queue.IpfsClusterPinQueue, "", "cluster", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailMsgs, err := mb.Consume(queue.EmailSendQueue, "", "email", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qmCluster, err := pins.ForQueue(queue.IpfsClusterPinQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qmEmail, err := pins.ForQueue(queue.EmailSendQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	emails := make(chan queue.EmailSend, 1)
+	go func() {
+		for d := range pinMsgs {
+			pin := queue.IPFSPin{}
+			if err := decode(queue.IpfsPinQueue, d, &pin); err != nil {
+				errs <- err
+				return
+			}
+			if err := qmCluster.PublishMessage(queue.IPFSClusterPin{CID: pin.CID, UserName: pin.UserName}); err != nil {
+				errs <- err
+				return
+			}
+			d.Ack()
+		}
+	}()
+	go func() {
+		for d := range clusterMsgs {
+			clusterPin := queue.IPFSClusterPin{}
+			if err := decode(queue.IpfsClusterPinQueue, d, &clusterPin); err != nil {
+				errs <- err
+				return
+			}
+			if err := qmEmail.PublishMessage(queue.EmailSend{Subject: clusterPin.CID, UserNames: []string{clusterPin.UserName}}); err != nil {
+				errs <- err
+				return
+			}
+			d.Ack()
+		}
+	}()
+	go func() {
+		for d := range emailMsgs {
+			es := queue.EmailSend{}
+			if err := decode(queue.EmailSendQueue, d, &es); err != nil {
+				errs <- err
+				return
+			}
+			d.Ack()
+			emails <- es
+		}
+	}()
+
+	if err = queue.NewBrokerPublisher(mb).Publish(queue.IpfsPinQueue, queue.IPFSPin{CID: testCID, UserName: "testuser"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-errs:
+		t.Fatal(err)
+	case es := <-emails:
+		if es.Subject != testCID || len(es.UserNames) != 1 || es.UserNames[0] != "testuser" {
+			t.Fatalf("unexpected email %+v", es)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for the pin to be emailed")
+	}
+	// earlier stubs may still be acknowledging once the email arrives
+	deadline := time.Now().Add(time.Second)
+	for mb.Outstanding(pins.QueueName)+mb.Outstanding(queue.IpfsClusterPinQueue)+mb.Outstanding(queue.EmailSendQueue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected every message to be acknowledged")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// TestMemoryPipelineIntegration runs the pin, cluster pin and email services against a single
+// in-memory broker, checking a pin reaches the cluster and a refused pin is emailed
+func TestMemoryPipelineIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	cfg, err := config.LoadConfig(defaultConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := ioutil.TempDir("", "temporal-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sink)
+	cfg.Mail.Transport = mail.TransportSink
+	cfg.Mail.SinkDirectory = sink
+	dbm, err := database.Initialize(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dbm.DB
+	defer db.Close()
+	randUtils := utils.GenerateRandomUtils()
+	username := randUtils.GenerateString(10, utils.LetterBytes)
+	if _, err = models.NewUserManager(db).NewUserAccount(
+		randUtils.GenerateString(10, utils.LetterBytes), username, "password123",
+		randUtils.GenerateString(10, utils.LetterBytes)+"@example.com", false); err != nil {
+		t.Fatal(err)
+	}
+
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	services := []string{queue.IpfsPinQueue, queue.IpfsClusterPinQueue, queue.EmailSendQueue}
+	errs := make(chan error, len(services))
+	for _, service := range services {
+		qm, err := queue.NewQueueManager(service, mb, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { errs <- qm.Consume(ctx, "", db, cfg) }()
+	}
+	publisher := queue.NewBrokerPublisher(mb)
+	if err = publisher.Publish(queue.IpfsPinQueue, queue.IPFSPin{
+		CID: testCID, NetworkName: "public", UserName: username, HoldTimeInMonths: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// the user isn't a member of this network, so they're emailed instead
+	if err = publisher.Publish(queue.IpfsPinQueue, queue.IPFSPin{
+		CID: testCID, NetworkName: username, UserName: username, HoldTimeInMonths: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// pins are only acknowledged once they've been forwarded, so once every queue is
+	// empty each message has been through all of the services it's forwarded to
+	pins, err := queue.NewQueueManager(queue.IpfsPinQueue, mb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Minute * 2)
+	for mb.Outstanding(pins.QueueName)+mb.Outstanding(queue.IpfsClusterPinQueue)+mb.Outstanding(queue.EmailSendQueue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for messages to be processed")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	cancel()
+	for range services {
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upload, err := models.NewUploadManager(db).FindUploadByHashAndNetwork(testCID, "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.UserName != username {
+		t.Fatalf("expected upload for %s, got %s", username, upload.UserName)
+	}
+	emails, err := ioutil.ReadDir(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("expected 1 email, got %v", len(emails))
+	}
+}
+
+func TestMemoryRequestID(t *testing.T) {
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	msgs, err := mb.Consume(queue.IpfsClusterPinQueue, "", "cluster", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logging.WithRequestID(context.Background(), "request-1")
+	if err = queue.NewBrokerPublisher(mb).PublishContext(ctx, queue.IpfsClusterPinQueue, queue.IPFSClusterPin{CID: testCID}); err != nil {
+		t.Fatal(err)
+	}
+	env, err := queue.OpenEnvelope(queue.IpfsClusterPinQueue, receive(t, msgs).Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.RequestID != "request-1" {
+		t.Fatalf("expected request id to be published, got %q", env.RequestID)
+	}
+}
+
+func TestMemoryTraceParent(t *testing.T) {
+	tracing.SetTracer(tracing.NewTracer("test", tracing.NewStdoutExporter(ioutil.Discard)))
+	defer tracing.SetTracer(nil)
+	mb := queue.NewMemoryBroker()
+	defer mb.Close()
+	msgs, err := mb.Consume(queue.IpfsClusterPinQueue, "", "cluster", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := tracing.Start(context.Background(), "request", tracing.Server)
+	defer span.End(nil)
+	if err = queue.NewBrokerPublisher(mb).PublishContext(ctx, queue.IpfsClusterPinQueue, queue.IPFSClusterPin{CID: testCID}); err != nil {
+		t.Fatal(err)
+	}
+	traceParent := receive(t, msgs).Headers[tracing.TraceParentHeader]
+	// the header names the publish span, which is a child of our request span
+	trace := strings.Split(tracing.Inject(ctx), "-")[1]
+	if !strings.HasPrefix(traceParent, "00-"+trace+"-") || traceParent == tracing.Inject(ctx) {
+		t.Fatalf("expected publish span of trace %s, got %q", trace, traceParent)
+	}
+}
+
+func receive(t *testing.T, msgs <-chan queue.Delivery) queue.Delivery {
+	select {
+	case d, ok := <-msgs:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+	return queue.Delivery{}
+}
+
+// decode is used to open a delivery's envelope and unmarshal its payload into msg
+func decode(queueName string, d queue.Delivery, msg interface{}) error {
+	env, err := queue.OpenEnvelope(queueName, d.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(env.Payload, msg)
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/jinzhu/gorm"
+)
+
+// OutboxRelay publishes the messages written to the outbox table. A message is marked
+// published only after rabbitmq confirms it, so delivery is at least once: consumers may
+// see a message twice if we stop between publishing and marking it. Messages which fail
+// are retried with an increasing backoff, until they've failed MaxAttempts times
+type OutboxRelay struct {
+	Outbox    *models.OutboxManager
+	Publisher *Publisher
+	BatchSize int
+	Interval  time.Duration
+	// MaxAttempts is the number of times a message is published before it's dead-lettered
+	MaxAttempts int
+	// OnError is called when a message fails to publish
+	OnError func(msg models.OutboxMessage, err error)
+	// OnRelayError is called when a batch can't be claimed or committed
+	OnRelayError func(err error)
+
+	wake chan struct{}
+}
+
+// NewOutboxRelay is used to generate a relay publishing from the outbox in db
+func NewOutboxRelay(db *gorm.DB, publisher *Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		Outbox:    models.NewOutboxManager(db),
+		Publisher: publisher,
+		BatchSize: 100,
+		Interval:  time.Second * 5,
+		// with our backoff, messages are retried for around half a day
+		MaxAttempts:  20,
+		OnError:      func(models.OutboxMessage, error) {},
+		OnRelayError: func(error) {},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake is used to have the relay publish immediately, rather than at its next interval.
+// Handlers call this once the transaction holding their messages has committed
+func (r *OutboxRelay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays messages until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+		// keep going while there is a backlog
+		for {
+			sent, err := r.Relay()
+			if err != nil {
+				r.OnRelayError(err)
+				break
+			}
+			if sent < r.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// publish is used to send an outbox message, keeping its job ID and timestamp the same
+// each time it is sent so consumers can recognise duplicates
+func (r *OutboxRelay) publish(msg models.OutboxMessage) error {
+	env, err := NewEnvelope(msg.QueueName, r.Publisher.Origin, json.RawMessage(msg.Body))
+	if err != nil {
+		return err
+	}
+	env.ID = fmt.Sprintf("outbox-%v", msg.ID)
+	env.Timestamp = msg.CreatedAt.UTC()
+	env.RequestID = msg.RequestID
+	return r.Publisher.PublishEnvelope(msg.QueueName, env)
+}
+
+// Relay is used to publish a single batch of messages, returning how many were published.
+// Messages which fail don't hold up the rest of the batch. The batch is locked while it's
+// published, so each message is published by a single relay
+func (r *OutboxRelay) Relay() (int, error) {
+	tx := r.Outbox.DB.Begin()
+	outbox := models.NewOutboxManager(tx)
+	msgs, err := outbox.ClaimUnpublished(r.BatchSize)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	sent := 0
+	for _, msg := range *msgs {
+		if err = r.publish(msg); err != nil {
+			r.OnError(msg, err)
+			// messages which don't match their queue can never be published
+			if _, invalid := err.(*MessageError); invalid || msg.Attempts+1 >= r.MaxAttempts {
+				err = outbox.MarkDeadLettered(msg.ID, err)
+			} else {
+				err = outbox.MarkFailed(msg.ID, err, time.Now().Add(r.backoff(msg.Attempts+1)))
+			}
+		} else {
+			sent++
+			err = outbox.MarkPublished(msg.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return sent, tx.Commit().Error
+}
+
+// backoff is used to find how long to wait before retrying a message which has failed attempts times
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	wait := r.Interval
+	for i := 1; i < attempts && wait < maxOutboxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxOutboxBackoff {
+		wait = maxOutboxBackoff
+	}
+	return wait
+}
+
+// maxOutboxBackoff is the longest we wait before retrying a message
+var maxOutboxBackoff = time.Hour
@@ -3,20 +3,22 @@ package queue
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/bindings"
 	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/mail"
+	"github.com/RTradeLtd/Temporal/metrics"
 	"github.com/RTradeLtd/Temporal/models"
 	"github.com/RTradeLtd/Temporal/rtfs"
+	"github.com/RTradeLtd/Temporal/webhooks"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
 )
 
 type PinPaymentConfirmation struct {
@@ -44,7 +46,7 @@ type PinPaymentSubmission struct {
 }
 
 // ProcessPinPaymentConfirmation is used to process pin payment confirmations to inject content into TEMPORAL
-func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
 	paymentContractAddress := cfg.Ethereum.Contracts.PaymentContractAddress
 	client, err := ethclient.Dial(cfg.Ethereum.Connection.INFURA.URL)
 	if err != nil {
@@ -62,62 +64,74 @@ func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan amqp.Delivery,
 		}).Error("failed to generate payment contract handler")
 		return err
 	}
-	qmEmail, err := Initialize(EmailSendQueue, cfg.RabbitMQ.URL, true, false)
+	qmEmail, err := qm.ForQueue(EmailSendQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize connection to email send queue")
+		}).Error("failed to initialize email send queue")
 		return err
 	}
-	qmIpfs, err := Initialize(IpfsPinQueue, cfg.RabbitMQ.URL, true, false)
+	qmWebhook, err := qm.ForQueue(WebhookDeliveryQueue)
 	if err != nil {
 		qm.Logger.WithFields(log.Fields{
 			"service": qm.QueueName,
 			"error":   err.Error(),
-		}).Error("failed to initialize connection to ipfs pin queue")
+		}).Error("failed to initialize webhook delivery queue")
+		return err
+	}
+	qmIpfs, err := qm.ForQueue(IpfsPinQueue)
+	if err != nil {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"error":   err.Error(),
+		}).Error("failed to initialize ipfs pin queue")
 		return err
 	}
 	paymentManager := models.NewPaymentManager(db)
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("processing pin payment confirmations")
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("new message detected")
 		ppc := &PinPaymentConfirmation{}
-		err = json.Unmarshal(d.Body, ppc)
+		err := json.Unmarshal(d.Body, ppc)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
+		start := time.Now()
 		tx, isPending, err := client.TransactionByHash(context.Background(), common.HexToHash(ppc.TxHash))
+		err = metrics.ObserveCall(metrics.Ethereum, "transaction_by_hash", start, err)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":     qm.QueueName,
 				"eth_address": ppc.EthAddress,
 				"tx_hash":     ppc.TxHash,
 				"error":       err.Error(),
 			}).Error("failed to get transaction hash")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if isPending {
+			start := time.Now()
 			_, err := bind.WaitMined(context.Background(), client, tx)
+			err = metrics.ObserveCall(metrics.Ethereum, "wait_mined", start, err)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service":     qm.QueueName,
 					"eth_address": ppc.EthAddress,
 					"tx_hash":     ppc.TxHash,
 					"error":       err.Error(),
 				}).Error("failed to wait for transaction to be mined")
-				d.Ack(false)
-				continue
+				d.Ack()
+				return
 			}
 		}
 		numberBig, valid := new(big.Int).SetString(ppc.PaymentNumber, 10)
@@ -125,74 +139,79 @@ func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan amqp.Delivery,
 			addresses := []string{}
 			addresses = append(addresses, ppc.EthAddress)
 			es := EmailSend{
-				Subject:     PaymentConfirmationFailedSubject,
-				Content:     fmt.Sprintf(PaymentConfirmationFailedContent, ppc.ContentHash, "unable to convert string to big int"),
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationPaymentConfirmationFailed,
+				Data:         map[string]string{"cid": ppc.ContentHash, "reason": "unable to convert string to big int"},
+				UserNames:    addresses,
 			}
-			err = qmEmail.PublishMessage(es)
+			err = qmEmail.PublishMessageContext(ctx, es)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   err.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    ppc.EthAddress,
 				"payment_number": ppc.PaymentNumber,
 				"error":          err.Error(),
 			}).Error("failed to convert paymnet number to big int")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
+		start = time.Now()
 		payment, err := contract.Payments(nil, common.HexToAddress(ppc.EthAddress), numberBig)
+		err = metrics.ObserveCall(metrics.Ethereum, "get_payment", start, err)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    ppc.EthAddress,
 				"payment_number": ppc.PaymentNumber,
 				"error":          err.Error(),
 			}).Error("failed to retrieve payment information from contract")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		fmt.Printf("Payment struct \n%+v\n", payment)
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":        qm.QueueName,
+			"eth_address":    ppc.EthAddress,
+			"payment_number": ppc.PaymentNumber,
+			"state":          payment.State,
+		}).Debug("retrieved payment from contract")
 		// now lets verify that the payment was indeed processed
 		if payment.State != uint8(1) {
 			addresses := []string{}
 			addresses = append(addresses, ppc.EthAddress)
 			es := EmailSend{
-				Subject:     PaymentConfirmationFailedSubject,
-				Content:     "payment unable to be processed, likely due to transaction failure or other contract runtime issue",
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationPaymentConfirmationFailed,
+				Data:         map[string]string{"cid": ppc.ContentHash, "reason": "payment unable to be processed, likely due to transaction failure or other contract runtime issue"},
+				UserNames:    addresses,
 			}
-			err = qmEmail.PublishMessage(es)
+			err = qmEmail.PublishMessageContext(ctx, es)
 			if err != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   err.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    ppc.EthAddress,
 				"payment_number": ppc.PaymentNumber,
 				"error":          "unspecified transaction error",
 			}).Error("transaction was mined, but contract code execution failed")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		paymentFromDatabase, err := paymentManager.FindPaymentByNumberAndAddress(ppc.PaymentNumber, ppc.EthAddress)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":     qm.QueueName,
 				"eth_address": ppc.EthAddress,
 				"error":       err.Error(),
 			}).Error("failed to find payment in database")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		// decide whether or not this should be handled here, or injected into the pin queue...
 		// probably injected into the pin queue
@@ -208,33 +227,36 @@ func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan amqp.Delivery,
 			addresses := []string{}
 			addresses = append(addresses, ppc.EthAddress)
 			es := EmailSend{
-				Subject:     fmt.Sprintf("Critical Error: Unable to process IPFS Pin confirmation for content hash %s", ppc.ContentHash),
-				Content:     "Please contact us at admin@rtradetechnologies.com and we will resolve this",
-				ContentType: "",
-				UserNames:   addresses,
+				Notification: mail.NotificationPinPaymentProcessingFailed,
+				Data:         map[string]string{"cid": ppc.ContentHash},
+				UserNames:    addresses,
 			}
-			errOne := qmEmail.PublishMessage(es)
+			errOne := qmEmail.PublishMessageContext(ctx, es)
 			if errOne != nil {
-				qm.Logger.WithFields(log.Fields{
+				qm.logger(ctx).WithFields(log.Fields{
 					"service": qm.QueueName,
 					"error":   errOne.Error(),
 				}).Error("failed to publish message to email send queue")
 			}
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":     qm.QueueName,
 				"eth_address": ppc.EthAddress,
 				"error":       err.Error(),
 			}).Error("critical error, failed to publish ipfs pin request for payment")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service":        qm.QueueName,
 			"eth_address":    ppc.EthAddress,
 			"payment_number": ppc.PaymentNumber,
 		}).Info("payment successfully processed")
-		d.Ack(false)
-	}
+		qm.publishWebhookEvent(qmWebhook, ppc.UserName, webhooks.EventPaymentConfirmed, map[string]string{
+			"cid":            ppc.ContentHash,
+			"payment_number": ppc.PaymentNumber,
+		})
+		d.Ack()
+	})
 	return nil
 }
 
@@ -242,7 +264,7 @@ func (qm *QueueManager) ProcessPinPaymentConfirmation(msgs <-chan amqp.Delivery,
 // while functional, this route isn't recommended as there are security risks involved. This will be upgraded over time so we can try
 // to implement a more secure method. However keep in mind, this will always be "insecure". We may transition
 // to letting the user sign the transactino, and we can broadcast the signed transaction
-func (qm *QueueManager) ProcessPinPaymentSubmissions(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+func (qm *QueueManager) ProcessPinPaymentSubmissions(msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
 	paymentContractAddress := cfg.Ethereum.Contracts.PaymentContractAddress
 	client, err := ethclient.Dial(cfg.Ethereum.Connection.INFURA.URL)
 	if err != nil {
@@ -272,29 +294,29 @@ func (qm *QueueManager) ProcessPinPaymentSubmissions(msgs <-chan amqp.Delivery,
 	qm.Logger.WithFields(log.Fields{
 		"service": qm.QueueName,
 	}).Info("processing pin payment submissions")
-	for d := range msgs {
-		qm.Logger.WithFields(log.Fields{
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
 			"service": qm.QueueName,
 		}).Info("detected new message")
 		pps := PinPaymentSubmission{}
-		err = json.Unmarshal(d.Body, &pps)
+		err := json.Unmarshal(d.Body, &pps)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal message")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		k := keystore.Key{}
 		err = k.UnmarshalJSON(pps.PrivateKey)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to unmarshal private key")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		auth := bind.NewKeyedTransactor(k.PrivateKey)
 		h := pps.H
@@ -305,95 +327,107 @@ func (qm *QueueManager) ProcessPinPaymentSubmissions(msgs <-chan amqp.Delivery,
 		prefixed := pps.Prefixed
 		num, valid := new(big.Int).SetString(pps.Number, 10)
 		if !valid {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   "bad type conversion",
 			}).Error("failed to convert string to big int")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		amount, valid := new(big.Int).SetString(pps.ChargeAmount, 10)
 		if !valid {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   "bad type conversion",
 			}).Error("failed to convert string to big int")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		auth.GasLimit = 275000
+		start := time.Now()
 		tx, err := contract.MakePayment(auth, h, v, r, s, num, method, amount, prefixed)
+		err = metrics.ObserveCall(metrics.Ethereum, "make_payment", start, err)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service": qm.QueueName,
 				"error":   err.Error(),
 			}).Error("failed to submit payment to contract")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		fmt.Println("successfully sent payment transaction, waiting for it to be mined")
+		qm.logger(ctx).WithFields(log.Fields{
+			"service": qm.QueueName,
+			"tx_hash": tx.Hash().String(),
+		}).Info("sent payment transaction, waiting for it to be mined")
+		start = time.Now()
 		_, err = bind.WaitMined(context.Background(), client, tx)
+		err = metrics.ObserveCall(metrics.Ethereum, "wait_mined", start, err)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":     qm.QueueName,
 				"eth_address": auth.From,
 				"tx_hash":     tx.Hash().String(),
 				"error":       err.Error(),
 			}).Error("failed to wait for transaction to be mined")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
+		start = time.Now()
 		paymentStruct, err := contract.Payments(nil, auth.From, num)
+		err = metrics.ObserveCall(metrics.Ethereum, "get_payment", start, err)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    auth.From,
 				"payment_number": num.String(),
 				"error":          err.Error(),
 			}).Error("failed to get payment from contract")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		if paymentStruct.State != 1 {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    auth.From,
 				"payment_number": num.String(),
 				"tx_hash":        tx.Hash().String(),
 				"error":          "unspecifeid payment failure",
 			}).Error("transaction was mined but payment failed to be processed")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		paymentFromDB, err := ppm.FindPaymentByNumberAndAddress(num.String(), auth.From.String())
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    auth.From,
 				"payment_number": num.String(),
 				"error":          err.Error(),
 			}).Error("failed to find payment in database")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
 		contentHash := paymentFromDB.ObjectName
-		err = manager.Pin(contentHash)
+		err = manager.PinWithContext(ctx, contentHash)
 		if err != nil {
-			qm.Logger.WithFields(log.Fields{
+			qm.logger(ctx).WithFields(log.Fields{
 				"service":        qm.QueueName,
 				"eth_address":    auth.From,
 				"payment_number": num.String(),
 				"error":          err.Error(),
 			}).Error("failed to pin content to ipfs")
-			d.Ack(false)
-			continue
+			d.Ack()
+			return
 		}
-		qm.Logger.WithFields(log.Fields{
+		qm.logger(ctx).WithFields(log.Fields{
 			"service":        qm.QueueName,
 			"eth_address":    auth.From,
 			"payment_number": num.String(),
 		}).Info("payment successfully processed and content pinned to ipfs")
-		d.Ack(false)
-	}
+		if stat, err := manager.ObjectStatWithContext(ctx, contentHash); err == nil {
+			metrics.PinnedBytes.WithLabelValues("public").Add(float64(stat.CumulativeSize))
+		}
+		d.Ack()
+	})
 	return nil
 }
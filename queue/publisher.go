@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/RTradeLtd/Temporal/logging"
+)
+
+// Publisher is used to validate messages, wrap them in envelopes and send them to a broker.
+// It is safe for concurrent use when its broker is
+type Publisher struct {
+	Broker Broker
+	// Origin is recorded in the envelope of messages we publish
+	Origin string
+}
+
+// NewPublisher is used to connect a publisher to rabbitmq, keeping up to poolSize idle channels open
+func NewPublisher(connectionURL string, poolSize int) (*Publisher, error) {
+	broker, err := NewRabbitMQ(connectionURL, poolSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrokerPublisher(broker), nil
+}
+
+// NewBrokerPublisher is used to generate a publisher sending messages to broker
+func NewBrokerPublisher(broker Broker) *Publisher {
+	return &Publisher{
+		Broker: broker,
+		Origin: hostOrigin(""),
+	}
+}
+
+// Publish is used to wrap body in an envelope and send it to the given queue, through its exchange
+// if it has one. Messages which don't match the queue's registered message type are rejected before publishing
+func (p *Publisher) Publish(queueName string, body interface{}) error {
+	return p.PublishContext(context.Background(), queueName, body)
+}
+
+// PublishContext is used to publish like Publish, recording the request id attached to ctx
+func (p *Publisher) PublishContext(ctx context.Context, queueName string, body interface{}) error {
+	env, err := NewEnvelope(queueName, p.Origin, body)
+	if err != nil {
+		return err
+	}
+	env.RequestID = logging.RequestID(ctx)
+	return publishTraced(ctx, p.Broker, queueName, env)
+}
+
+// PublishEnvelope is used to send an enveloped message to the given queue, through its exchange if it has one.
+// Failures after the message was sent are returned as a *PublishError
+func (p *Publisher) PublishEnvelope(queueName string, env *Envelope) error {
+	return publishEnvelope(p.Broker, queueName, env)
+}
+
+// Close is used to close our broker
+func (p *Publisher) Close() error {
+	return p.Broker.Close()
+}
@@ -1,7 +1,7 @@
 package queue
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -10,7 +10,8 @@ import (
 
 	"github.com/RTradeLtd/Temporal/config"
 	"github.com/RTradeLtd/Temporal/database"
-	"github.com/streadway/amqp"
+	"github.com/RTradeLtd/Temporal/logging"
+	"github.com/jinzhu/gorm"
 )
 
 var DatabaseFileAddQueue = "dfa-queue"
@@ -23,18 +24,23 @@ var EmailSendQueue = "email-send-queue"
 var IpnsEntryQueue = "ipns-entry-queue"
 var IpfsPinRemovalQueue = "ipns-pin-removal-queue"
 var IpfsKeyCreationQueue = "ipfs-key-creation-queue"
+var IpfsKeyUpdateQueue = "ipfs-key-update-queue"
+var WebhookDeliveryQueue = "webhook-delivery-queue"
 
 var AdminEmail = "temporal.reports@rtradetechnologies.com"
 
-// QueueManager is a helper struct to interact with rabbitmq
+// LogOptions controls how queue services log, set from our configuration
+var LogOptions = logging.Options{Dir: "/var/log/temporal"}
+
+// QueueManager is a helper struct to publish to and consume from a queue through our broker
 type QueueManager struct {
-	Connection   *amqp.Connection
-	Channel      *amqp.Channel
-	Queue        *amqp.Queue
+	Broker       Broker
 	Logger       *log.Logger
 	QueueName    string
 	Service      string
 	ExchangeName string
+
+	consumer consumerSettings
 }
 
 // IPFSKeyCreation is a message used for processing key creation
@@ -47,6 +53,24 @@ type IPFSKeyCreation struct {
 	NetworkName string `json:"network_name"`
 }
 
+// Key update operations, set as the Operation of an IPFSKeyUpdate
+const (
+	KeyImport = "import"
+	KeyRename = "rename"
+	KeyDelete = "delete"
+)
+
+// IPFSKeyUpdate is a message used to change a key already in our keystores.
+// Imports carry the key encrypted by rtfs.EncryptKey, along with its password
+type IPFSKeyUpdate struct {
+	Operation string `json:"operation"`
+	UserName  string `json:"user_name"`
+	Name      string `json:"name"`
+	NewName   string `json:"new_name,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Password  string `json:"password,omitempty"`
+}
+
 // IPFSPin is a struct used when sending pin request
 type IPFSPin struct {
 	CID              string `json:"cid"`
@@ -60,7 +84,7 @@ type IPFSFile struct {
 	ObjectName       string `json:"object_name"`
 	UserName         string `json:"user_name"`
 	NetworkName      string `json:"network_name"`
-	HoldTimeInMonths string `json:"hold_time_in_months"`
+	HoldTimeInMonths int64  `json:"hold_time_in_months"`
 }
 
 // IPFSClusterPin is a queue message used when sending a message to the cluster to pin content
@@ -71,10 +95,14 @@ type IPFSClusterPin struct {
 	HoldTimeInMonths int64  `json:"hold_time_in_months"`
 }
 
+// IPFSPinRemoval is a queue message used to unpin content from an ipfs network
 type IPFSPinRemoval struct {
 	ContentHash string `json:"content_hash"`
 	NetworkName string `json:"network_name"`
 	UserName    string `json:"user_name"`
+	// AccountDeleted is set for removals scheduled when deleting the user's account. Their access
+	// to private networks was checked when scheduling, as it can't be checked once they're deleted
+	AccountDeleted bool `json:"account_deleted,omitempty"`
 }
 
 // DatabaseFileAdd is a struct used when sending data to rabbitmq
@@ -85,30 +113,21 @@ type DatabaseFileAdd struct {
 	NetworkName      string `json:"network_name"`
 }
 
-type IPNSUpdate struct {
-	CID         string `json:"content_hash"`
-	IPNSHash    string `json:"ipns_hash"`
-	LifeTime    string `json:"life_time"`
-	TTL         string `json:"ttl"`
-	Key         string `json:"key"`
-	Resolve     bool   `json:"resolve"`
-	UserName    string `json:"user_name"`
-	NetworkName string `json:"network_name"`
-}
-
 func (qm *QueueManager) setupLogging() error {
-	logFileName := fmt.Sprintf("/var/log/temporal/%s_serice.log", qm.QueueName)
-	logFile, err := os.OpenFile(logFileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0640)
+	logger, err := logging.New(LogOptions, qm.Service)
 	if err != nil {
 		return err
 	}
-	logger := log.New()
-	logger.Out = logFile
 	qm.Logger = logger
 	qm.Logger.Info("Logging initialized")
 	return nil
 }
 
+// logger is used to generate a log entry recording the request which caused the message being processed
+func (qm *QueueManager) logger(ctx context.Context) *log.Entry {
+	return logging.Entry(ctx, qm.Logger)
+}
+
 func (qm *QueueManager) parseQueueName(queueName string) error {
 	host, err := os.Hostname()
 	if err != nil {
@@ -118,305 +137,144 @@ func (qm *QueueManager) parseQueueName(queueName string) error {
 	return nil
 }
 
-// Initialize is used to connect to the given queue, for publishing or consuming purposes
-func Initialize(queueName, connectionURL string, publish, service bool) (*QueueManager, error) {
-	conn, err := setupConnection(connectionURL)
+// Initialize is used to connect to the given queue through rabbitmq, for publishing or consuming purposes.
+// When service is set, we log as configured by LogOptions
+func Initialize(queueName, connectionURL string, service bool) (*QueueManager, error) {
+	if _, err := lookupRoute(queueName); err != nil {
+		return nil, err
+	}
+	broker, err := NewRabbitMQ(connectionURL, 1)
 	if err != nil {
 		return nil, err
 	}
-	qm := QueueManager{Connection: conn}
-	if err := qm.OpenChannel(); err != nil {
+	qm, err := NewQueueManager(queueName, broker, service)
+	if err != nil {
+		broker.Close()
 		return nil, err
 	}
+	return qm, nil
+}
 
-	qm.QueueName = queueName
-	qm.Service = queueName
-
+// NewQueueManager is used to generate a queue manager for the given queue, using broker.
+// When service is set, we log as configured by LogOptions
+func NewQueueManager(queueName string, broker Broker, service bool) (*QueueManager, error) {
+	route, err := lookupRoute(queueName)
+	if err != nil {
+		return nil, err
+	}
+	qm := QueueManager{
+		Broker:    broker,
+		Logger:    log.New(),
+		QueueName: queueName,
+		Service:   queueName,
+	}
 	if service {
 		err = qm.setupLogging()
 		if err != nil {
 			return nil, err
 		}
 	}
-	// Declare Non Default exchanges for the particular queue
-	switch queueName {
-	case IpfsPinRemovalQueue:
-		err = qm.parseQueueName(queueName)
-		if err != nil {
-			return nil, err
-		}
-		err = qm.DeclareIPFSPinRemovalExchange()
-		if err != nil {
-			return nil, err
-		}
-		qm.ExchangeName = PinRemovalExchange
-		if publish {
-			return &qm, nil
-		}
-	case IpfsPinQueue:
-		err = qm.parseQueueName(queueName)
-		if err != nil {
-			return nil, err
-		}
-		err = qm.DeclareIPFSPinExchange()
-		if err != nil {
-			return nil, err
-		}
-		qm.ExchangeName = PinExchange
-		if publish {
-			return &qm, nil
-		}
-	case IpfsKeyCreationQueue:
+	// queues fed through an exchange publish to the exchange, and each consumer binds its own queue
+	if route.Exchange != "" {
 		err = qm.parseQueueName(queueName)
 		if err != nil {
 			return nil, err
 		}
-		err = qm.DeclareIPFSKeyExchange()
-		if err != nil {
-			return nil, err
-		}
-		qm.ExchangeName = IpfsKeyExchange
-		if publish {
-			return &qm, nil
-		}
-	}
-	if err := qm.DeclareQueue(); err != nil {
-		return nil, err
+		qm.ExchangeName = route.Exchange
 	}
 	return &qm, nil
 }
 
-func setupConnection(connectionURL string) (*amqp.Connection, error) {
-	conn, err := amqp.Dial(connectionURL)
+// ForQueue is used to generate a queue manager for publishing to another queue. It shares
+// our broker and logger, so closing either queue manager closes the other
+func (qm *QueueManager) ForQueue(queueName string) (*QueueManager, error) {
+	other, err := NewQueueManager(queueName, qm.Broker, false)
 	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+	other.Logger = qm.Logger
+	return other, nil
 }
 
-func (qm *QueueManager) OpenChannel() error {
-	ch, err := qm.Connection.Channel()
+// ConsumeMessage is used to connect to our database and consume messages that are sent to the queue.
+// Question, do we really want to ack messages that fail to be processed?
+// Perhaps the error was temporary, and we allow it to be retried?
+func (qm *QueueManager) ConsumeMessage(ctx context.Context, consumer, dbPass, dbURL, dbUser string, cfg *config.TemporalConfig) error {
+	db, err := database.OpenDBConnection(database.DBOptions{
+		User: dbUser, Password: dbPass, Address: dbURL,
+		Port: cfg.Database.Port, Name: cfg.Database.Name})
 	if err != nil {
 		return err
 	}
-	if qm.Logger != nil {
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-		}).Info("channel opened")
-	}
-	qm.Channel = ch
-	return nil
+	defer db.Close()
+	return qm.Consume(ctx, consumer, db, cfg)
 }
 
-// DeclareQueue is used to declare a queue for which messages will be sent to
-func (qm *QueueManager) DeclareQueue() error {
-	// we declare the queue as durable so that even if rabbitmq server stops
-	// our messages won't be lost
-	q, err := qm.Channel.QueueDeclare(
-		qm.QueueName, // name
-		true,         // durable
-		false,        // delete when unused
-		false,        // exclusive
-		false,        // no-wait
-		nil,          // arguments
-	)
+// Consume is used to consume messages that are sent to the queue, with a pool of workers
+// configured by cfg. When ctx is cancelled we stop receiving new deliveries, and return
+// once the deliveries already received have been processed
+func (qm *QueueManager) Consume(ctx context.Context, consumer string, db *gorm.DB, cfg *config.TemporalConfig) error {
+	route, err := lookupRoute(qm.Service)
 	if err != nil {
 		return err
 	}
-	if qm.Logger != nil {
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-		}).Info("queue declared")
+	qm.consumer = newConsumerSettings(cfg)
+	// we need to know our consumer tag to cancel deliveries when shutting down
+	if consumer == "" {
+		consumer = fmt.Sprintf("%s-%v", qm.Service, os.Getpid())
 	}
-	qm.Queue = &q
-	return nil
-}
-
-// ConsumeMessage is used to consume messages that are sent to the queue
-// Question, do we really want to ack messages that fail to be processed?
-// Perhaps the error was temporary, and we allow it to be retried?
-func (qm *QueueManager) ConsumeMessage(consumer, dbPass, dbURL, dbUser string, cfg *config.TemporalConfig) error {
-	db, err := database.OpenDBConnection(database.DBOptions{
-		User: dbUser, Password: dbPass, Address: dbURL})
+	msgs, err := qm.Broker.Consume(qm.QueueName, qm.ExchangeName, consumer, qm.consumer.prefetch)
 	if err != nil {
 		return err
 	}
-
-	// ifs the queue is using an exchange, we will need to bind the queue to the exchange
-	switch qm.ExchangeName {
-	case PinRemovalExchange:
-		err = qm.Channel.QueueBind(
-			qm.QueueName,       // name of the queue
-			"",                 // routing key
-			PinRemovalExchange, // exchange
-			false,              // noWait
-			nil,                // arguments
-		)
-		if err != nil {
-			return err
-		}
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-		}).Info("queue bound")
-	case PinExchange:
-		err = qm.Channel.QueueBind(
-			qm.QueueName, // name of the queue
-			"",           // routing key
-			PinExchange,  // exchange
-			false,        // noWait
-			nil,          // arguments
-		)
-		if err != nil {
-			return err
-		}
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-		}).Info("queue bound")
-	case IpfsKeyExchange:
-		err = qm.Channel.QueueBind(
-			qm.QueueName,    // name of the queue
-			"",              // routing key
-			IpfsKeyExchange, // exchange
-			false,           // no wait
-			nil,             // arguments
-		)
-		if err != nil {
-			return err
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+	}).Info("consuming messages")
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			qm.Logger.WithFields(log.Fields{
+				"service": qm.QueueName,
+			}).Info("shutting down, finishing in-flight messages")
+			// the deliveries channel is closed once messages already sent to us are flushed
+			qm.Broker.Cancel(consumer)
+		case <-stopped:
 		}
-		qm.Logger.WithFields(log.Fields{
-			"service": qm.QueueName,
-		}).Info("queue bound")
-	default:
-		break
-	}
-
-	// consider moving to true for auto-ack
-	msgs, err := qm.Channel.Consume(
-		qm.QueueName, // queue
-		consumer,     // consumer
-		false,        // auto-ack
-		false,        // exclusive
-		false,        // no-local
-		false,        // no-wait
-		nil,          // args
-	)
-	if err != nil {
-		return err
-	}
+	}()
 
-	// check the queue name
-	switch qm.Service {
-	// only parse datbase file requests
-	case DatabaseFileAddQueue:
-		qm.ProcessDatabaseFileAdds(msgs, db)
-	case IpfsPinQueue:
-		err = qm.ProccessIPFSPins(msgs, db, cfg)
-		if err != nil {
-			return err
-		}
-	case IpfsFileQueue:
-		err = qm.ProccessIPFSFiles(msgs, cfg, db)
-		if err != nil {
-			return err
-		}
-	case PinPaymentConfirmationQueue:
-		err = qm.ProcessPinPaymentConfirmation(msgs, db, cfg)
-		if err != nil {
-			return err
-		}
-	case PinPaymentSubmissionQueue:
-		err = qm.ProcessPinPaymentSubmissions(msgs, db, cfg)
-		if err != nil {
-			return err
-		}
-	case EmailSendQueue:
-		err = qm.ProcessMailSends(msgs, cfg)
-		if err != nil {
-			return err
-		}
-	case IpnsEntryQueue:
-		err = qm.ProcessIPNSEntryCreationRequests(msgs, db, cfg)
-		if err != nil {
-			return err
-		}
-	case IpfsPinRemovalQueue:
-		err = qm.ProcessIPFSPinRemovals(msgs, cfg, db)
-		if err != nil {
-			return err
-		}
-	case IpfsKeyCreationQueue:
-		err = qm.ProcessIPFSKeyCreation(msgs, db, cfg)
-		if err != nil {
-			return err
-		}
-	case IpfsClusterPinQueue:
-		err = qm.ProcessIPFSClusterPins(msgs, cfg, db)
-		if err != nil {
-			return err
-		}
-	default:
-		log.Fatal("invalid queue name")
-	}
-	return nil
+	return route.Process(qm, msgs, db, cfg)
 }
 
 //PublishMessageWithExchange is used to publish a message to a given exchange
 func (qm *QueueManager) PublishMessageWithExchange(body interface{}, exchangeName string) error {
-	switch exchangeName {
-	case PinExchange:
-		break
-	case PinRemovalExchange:
-		break
-	case IpfsKeyExchange:
-		break
-	default:
+	if !isExchange(exchangeName) {
 		return errors.New("invalid exchange name provided")
 	}
-	bodyMarshaled, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
-	err = qm.Channel.Publish(
-		exchangeName, // exchange
-		"",           // routing key
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         bodyMarshaled,
-		},
-	)
+	env, err := NewEnvelope(qm.Service, hostOrigin(qm.Service), body)
 	if err != nil {
 		return err
 	}
-	return nil
+	return qm.Broker.Fanout(exchangeName, env)
 }
 
-// PublishMessage is used to produce messages that are sent to the queue, with a worker queue (one consumer)
+// PublishMessage is used to produce messages that are sent to the queue, through its exchange if it has one
 func (qm *QueueManager) PublishMessage(body interface{}) error {
-	// we use a persistent delivery mode to combine with the durable queue
-	bodyMarshaled, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
-	err = qm.Channel.Publish(
-		"",            // exchange
-		qm.Queue.Name, // routing key
-		false,         // mandatory
-		false,         //immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         bodyMarshaled,
-		},
-	)
+	return qm.PublishMessageContext(context.Background(), body)
+}
+
+// PublishMessageContext is used to publish like PublishMessage, recording the request id attached to ctx
+func (qm *QueueManager) PublishMessageContext(ctx context.Context, body interface{}) error {
+	env, err := NewEnvelope(qm.Service, hostOrigin(qm.Service), body)
 	if err != nil {
 		return err
 	}
-	return nil
+	env.RequestID = logging.RequestID(ctx)
+	return publishTraced(ctx, qm.Broker, qm.Service, env)
 }
 
+// Close is used to close our broker
 func (qm *QueueManager) Close() error {
-	return qm.Connection.Close()
+	return qm.Broker.Close()
 }
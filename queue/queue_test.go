@@ -16,26 +16,26 @@ const (
 func TestInitialize(t *testing.T) {
 	type args struct {
 		queueName string
-		publish   bool
 		service   bool
 	}
 	tests := []struct {
 		name string
 		args args
 	}{
-		{"DFAQ", args{queue.DatabaseFileAddQueue, false, false}},
-		{"IPQ", args{queue.IpfsPinQueue, false, false}},
-		{"IFQ", args{queue.IpfsFileQueue, false, false}},
-		{"PPCQ", args{queue.PinPaymentConfirmationQueue, false, false}},
-		{"PPSQ", args{queue.PinPaymentSubmissionQueue, false, false}},
-		{"ESQ", args{queue.EmailSendQueue, false, false}},
-		{"IEQ", args{queue.IpnsEntryQueue, false, false}},
-		{"IPRQ", args{queue.IpfsPinRemovalQueue, false, false}},
+		{"DFAQ", args{queue.DatabaseFileAddQueue, false}},
+		{"IPQ", args{queue.IpfsPinQueue, false}},
+		{"IFQ", args{queue.IpfsFileQueue, false}},
+		{"PPCQ", args{queue.PinPaymentConfirmationQueue, false}},
+		{"PPSQ", args{queue.PinPaymentSubmissionQueue, false}},
+		{"ESQ", args{queue.EmailSendQueue, false}},
+		{"IEQ", args{queue.IpnsEntryQueue, false}},
+		{"IPRQ", args{queue.IpfsPinRemovalQueue, false}},
+		{"WDQ", args{queue.WebhookDeliveryQueue, false}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if _, err := queue.Initialize(tt.args.queueName,
-				testRabbitAddress, tt.args.publish, tt.args.service); err != nil {
+				testRabbitAddress, tt.args.service); err != nil {
 				t.Fatal(err)
 			}
 		})
@@ -43,7 +43,7 @@ func TestInitialize(t *testing.T) {
 }
 
 func TestQueues(t *testing.T) {
-	qm, err := queue.Initialize(queue.IpfsPinQueue, testRabbitAddress, false, false)
+	qm, err := queue.Initialize(queue.IpfsPinQueue, testRabbitAddress, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,3 +59,36 @@ func TestQueues(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPublisher(t *testing.T) {
+	p, err := queue.NewPublisher(testRabbitAddress, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := queue.IPFSPin{
+		CID:              testCID,
+		NetworkName:      "public",
+		HoldTimeInMonths: 10,
+	}
+	// publish concurrently to exercise the channel pool
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			errs <- p.Publish(queue.IpfsPinQueue, pin)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err = <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = p.Publish(queue.DatabaseFileAddQueue, queue.DatabaseFileAdd{Hash: testCID}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Publish(queue.IpfsPinQueue, pin); err != queue.ErrPublisherClosed {
+		t.Fatal("expected publishing after close to fail")
+	}
+}
@@ -0,0 +1,442 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultConfirmTimeout is how long publishes wait for rabbitmq to confirm a message
+var DefaultConfirmTimeout = time.Second * 10
+
+// RabbitMQ is our production broker, which is safe for concurrent use.
+// It shares a single connection between a pool of channels in confirm mode, so a
+// publish only succeeds once rabbitmq has taken responsibility for the message.
+// When the connection drops, the next publish redials it.
+type RabbitMQ struct {
+	URL string
+	// ConfirmTimeout is how long to wait for rabbitmq to confirm a message
+	ConfirmTimeout time.Duration
+
+	mux        sync.Mutex
+	conn       *amqp.Connection
+	generation int
+	declared   map[string]bool
+	pool       chan *publishChannel
+	consumers  map[string]*amqp.Channel
+	closed     bool
+}
+
+// publishChannel is a channel in confirm mode, tagged with the connection it was opened on
+type publishChannel struct {
+	generation int
+	channel    *amqp.Channel
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+}
+
+// rabbitAcknowledger is used to settle deliveries on the channel they were received on
+type rabbitAcknowledger struct {
+	channel *amqp.Channel
+}
+
+func (ra rabbitAcknowledger) Ack(tag uint64) error {
+	return ra.channel.Ack(tag, false)
+}
+
+func (ra rabbitAcknowledger) Nack(tag uint64, requeue bool) error {
+	return ra.channel.Nack(tag, false, requeue)
+}
+
+// NewRabbitMQ is used to connect to rabbitmq, keeping up to poolSize idle publishing channels open
+func NewRabbitMQ(connectionURL string, poolSize int) (*RabbitMQ, error) {
+	if connectionURL == MemoryURL {
+		return nil, errors.New("the in-memory broker can only be used when every service runs in one process")
+	}
+	r := &RabbitMQ{
+		URL:            connectionURL,
+		ConfirmTimeout: DefaultConfirmTimeout,
+		pool:           make(chan *publishChannel, poolSize),
+		consumers:      make(map[string]*amqp.Channel),
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func setupConnection(connectionURL string) (*amqp.Connection, error) {
+	conn, err := amqp.Dial(connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// declareQueue is used to declare a durable queue, so that even if
+// rabbitmq server stops our messages won't be lost
+func declareQueue(ch *amqp.Channel, queueName string) error {
+	_, err := ch.QueueDeclare(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	return err
+}
+
+// declareExchange is used to declare a durable fanout exchange, which broadcasts
+// messages to every queue bound to it
+func declareExchange(ch *amqp.Channel, exchangeName string) error {
+	return ch.ExchangeDeclare(
+		exchangeName, // name
+		"fanout",     // type
+		true,         // durable
+		false,        // auto-delete
+		false,        // internal
+		false,        // no wait
+		nil,          // args
+	)
+}
+
+// enableConfirms is used to put a channel in confirm mode, listening for confirmations
+// and returned messages
+func enableConfirms(ch *amqp.Channel) (chan amqp.Confirmation, chan amqp.Return, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, nil, err
+	}
+	// rabbitmq sends a return before the confirmation of the same message,
+	// so a buffer of one for each is enough while we publish one message at a time
+	return ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		ch.NotifyReturn(make(chan amqp.Return, 1)),
+		nil
+}
+
+// publishConfirmed is used to publish a mandatory message and wait for rabbitmq to confirm it.
+// broken reports that the channel can no longer be trusted, and should be closed
+func publishConfirmed(ch *amqp.Channel, confirms chan amqp.Confirmation, returns chan amqp.Return,
+	queueName, exchange, key string, msg amqp.Publishing, timeout time.Duration) (broken bool, err error) {
+	if err = ch.Publish(exchange, key, true, false, msg); err != nil {
+		return true, err
+	}
+	select {
+	case confirm, ok := <-confirms:
+		if !ok {
+			return true, &PublishError{Queue: queueName, Reason: PublishUnconfirmed}
+		}
+		select {
+		case <-returns:
+			return false, &PublishError{Queue: queueName, Reason: PublishUnroutable}
+		default:
+		}
+		if !confirm.Ack {
+			return false, &PublishError{Queue: queueName, Reason: PublishRejected}
+		}
+		return false, nil
+	case <-time.After(timeout):
+		// an outstanding confirmation would be mistaken for the next message's
+		return true, &PublishError{Queue: queueName, Reason: PublishUnconfirmed}
+	}
+}
+
+// connect dials a new connection, which is dropped once rabbitmq reports it closed.
+// The caller must hold the lock
+func (r *RabbitMQ) connect() error {
+	conn, err := setupConnection(r.URL)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.generation++
+	r.declared = make(map[string]bool)
+	generation := r.generation
+	closes := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-closes
+		r.mux.Lock()
+		if r.generation == generation {
+			r.conn = nil
+		}
+		r.mux.Unlock()
+	}()
+	return nil
+}
+
+// connection is used to get our current connection, redialing it if it has dropped
+func (r *RabbitMQ) connection() (*amqp.Connection, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return nil, ErrPublisherClosed
+	}
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return r.conn, nil
+}
+
+// Ping is used to check rabbitmq can be reached, by opening a channel on our connection
+func (r *RabbitMQ) Ping() error {
+	conn, err := r.connection()
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	return ch.Close()
+}
+
+// acquire is used to take an idle channel from the pool, or open a new one
+func (r *RabbitMQ) acquire() (*publishChannel, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return nil, ErrPublisherClosed
+	}
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	// the pool is only filled while holding the lock, so this can't block
+	for len(r.pool) > 0 {
+		pc := <-r.pool
+		if pc.generation == r.generation {
+			return pc, nil
+		}
+		// opened on a connection which has since dropped
+		pc.channel.Close()
+	}
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	confirms, returns, err := enableConfirms(ch)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return &publishChannel{
+		generation: r.generation,
+		channel:    ch,
+		confirms:   confirms,
+		returns:    returns,
+	}, nil
+}
+
+// release is used to return a healthy channel to the pool, closing broken or surplus channels
+func (r *RabbitMQ) release(pc *publishChannel, broken bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !broken && !r.closed && pc.generation == r.generation {
+		select {
+		case r.pool <- pc:
+			return
+		default:
+		}
+	}
+	pc.channel.Close()
+}
+
+// declare is used to declare a queue, or an exchange when exchange is set, once per connection
+func (r *RabbitMQ) declare(pc *publishChannel, name string, exchange bool) error {
+	key := "queue/" + name
+	if exchange {
+		key = "exchange/" + name
+	}
+	r.mux.Lock()
+	done := r.declared[key] && pc.generation == r.generation
+	r.mux.Unlock()
+	if done {
+		return nil
+	}
+	var err error
+	if exchange {
+		err = declareExchange(pc.channel, name)
+	} else {
+		err = declareQueue(pc.channel, name)
+	}
+	if err != nil {
+		return err
+	}
+	r.mux.Lock()
+	if pc.generation == r.generation {
+		r.declared[key] = true
+	}
+	r.mux.Unlock()
+	return nil
+}
+
+// Publish is used to send a message to a queue.
+// If the connection had dropped before the message was sent, it is retried once on a new connection.
+// Failures after the message was sent are returned as a *PublishError
+func (r *RabbitMQ) Publish(queueName string, env *Envelope) error {
+	return r.send(queueName, false, env)
+}
+
+// Fanout is used to send a message to every queue bound to an exchange, retrying like Publish
+func (r *RabbitMQ) Fanout(exchangeName string, env *Envelope) error {
+	return r.send(exchangeName, true, env)
+}
+
+// send is used to publish to a queue or exchange, retrying once if the connection had dropped
+func (r *RabbitMQ) send(name string, exchange bool, env *Envelope) error {
+	msg, err := env.publishing()
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = r.publish(name, exchange, msg); err == nil || !retry || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// publish is used to make a single publish attempt, reporting whether a failure may be retried
+func (r *RabbitMQ) publish(name string, exchange bool, msg amqp.Publishing) (bool, error) {
+	pc, err := r.acquire()
+	if err != nil {
+		return false, err
+	}
+	if err = r.declare(pc, name, exchange); err != nil {
+		r.release(pc, true)
+		return true, err
+	}
+	exchangeName, key := "", name
+	if exchange {
+		exchangeName, key = name, ""
+	}
+	broken, err := publishConfirmed(pc.channel, pc.confirms, pc.returns, name, exchangeName, key, msg, r.ConfirmTimeout)
+	r.release(pc, broken)
+	if _, ok := err.(*PublishError); ok {
+		// the message may have reached rabbitmq, so retrying could deliver it twice
+		return false, err
+	}
+	return broken, err
+}
+
+// Consume is used to receive deliveries on a dedicated channel, declaring the queue
+// and binding it to exchangeName when set
+func (r *RabbitMQ) Consume(queueName, exchangeName, consumer string, prefetch int) (<-chan Delivery, error) {
+	conn, err := r.connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := r.consume(ch, queueName, exchangeName, consumer, prefetch)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	r.mux.Lock()
+	r.consumers[consumer] = ch
+	r.mux.Unlock()
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for d := range msgs {
+			deliveries <- Delivery{
+				Body:         d.Body,
+				MessageID:    d.MessageId,
+				Headers:      stringHeaders(d.Headers),
+				tag:          d.DeliveryTag,
+				acknowledger: rabbitAcknowledger{ch},
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// consume is used to set up a channel for consuming, and start receiving deliveries
+func (r *RabbitMQ) consume(ch *amqp.Channel, queueName, exchangeName, consumer string, prefetch int) (<-chan amqp.Delivery, error) {
+	// limit the unacknowledged deliveries held by this consumer, so a backlog is shared
+	// with other consumers rather than buffered behind our slowest message
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return nil, err
+	}
+	if err := declareQueue(ch, queueName); err != nil {
+		return nil, err
+	}
+	// ifs the queue is using an exchange, we will need to bind the queue to the exchange
+	if exchangeName != "" {
+		if err := declareExchange(ch, exchangeName); err != nil {
+			return nil, err
+		}
+		err := ch.QueueBind(
+			queueName,    // name of the queue
+			"",           // routing key
+			exchangeName, // exchange
+			false,        // noWait
+			nil,          // arguments
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// consider moving to true for auto-ack
+	return ch.Consume(
+		queueName, // queue
+		consumer,  // consumer
+		false,     // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
+	)
+}
+
+// Cancel is used to stop a consumer. Its channel is left open, so deliveries
+// which are still being processed can be acknowledged
+func (r *RabbitMQ) Cancel(consumer string) error {
+	r.mux.Lock()
+	ch, ok := r.consumers[consumer]
+	delete(r.consumers, consumer)
+	r.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	return ch.Cancel(consumer, false)
+}
+
+// Close is used to close all channels and the underlying connection
+func (r *RabbitMQ) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.pool)
+	for pc := range r.pool {
+		pc.channel.Close()
+	}
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// stringHeaders is used to read the string values of an amqp header table
+func stringHeaders(table amqp.Table) map[string]string {
+	headers := make(map[string]string, len(table))
+	for k, v := range table {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+	return headers
+}
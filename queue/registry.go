@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/jinzhu/gorm"
+)
+
+// ProcessFunc is used to consume the deliveries for a queue until msgs is closed
+type ProcessFunc func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error
+
+// Route declares a queue, how messages reach it, what they contain and how they are processed
+type Route struct {
+	// Queue is the name of the queue
+	Queue string
+	// Exchange is the fanout exchange messages are published to. Each consumer of a queue
+	// with an exchange binds its own queue, so every consumer receives every message
+	Exchange string
+	// Command is the path of the queue sub command used to run a consumer, ie "ipfs pin"
+	Command     []string
+	Blurb       string
+	Description string
+	// Message is a value of the type carried by the queue, used to validate message bodies
+	Message interface{}
+	// Upgrades convert payloads published with older schema versions, keyed by the version they upgrade from
+	Upgrades map[int]Upgrader
+	// Dependencies are the services our handlers call besides postgres and the broker,
+	// which are checked by the readiness probes of the queue's workers
+	Dependencies []string
+	Process      ProcessFunc
+}
+
+var routes = make(map[string]Route)
+
+// Register is used to add a queue to our registry, and panics on invalid or duplicate routes
+func Register(r Route) {
+	if r.Queue == "" || r.Message == nil || r.Process == nil {
+		panic("queue route must have a queue name, message and process function")
+	}
+	if _, ok := routes[r.Queue]; ok {
+		panic(fmt.Sprintf("queue %s is already registered", r.Queue))
+	}
+	routes[r.Queue] = r
+}
+
+// Lookup is used to find the route for a queue
+func Lookup(queueName string) (Route, bool) {
+	r, ok := routes[queueName]
+	return r, ok
+}
+
+// lookupRoute is used to find the route for a queue, returning an error for unregistered queues
+func lookupRoute(queueName string) (Route, error) {
+	r, ok := routes[queueName]
+	if !ok {
+		return Route{}, fmt.Errorf("%s is not a registered queue", queueName)
+	}
+	return r, nil
+}
+
+// Routes is used to list every registered queue, ordered by name
+func Routes() []Route {
+	list := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Queue < list[j].Queue })
+	return list
+}
+
+// isExchange is used to check if any queue is published through the given exchange
+func isExchange(exchangeName string) bool {
+	for _, r := range routes {
+		if r.Exchange != "" && r.Exchange == exchangeName {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageError is returned when a message body does not match the type carried by its queue
+type MessageError struct {
+	Queue  string
+	Reason string
+}
+
+func (me *MessageError) Error() string {
+	return fmt.Sprintf("invalid message for %s: %s", me.Queue, me.Reason)
+}
+
+// Validate is used to check that body decodes into the route's message type, without
+// any fields the message type doesn't declare
+func (r Route) Validate(body []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return &MessageError{Queue: r.Queue, Reason: "message must be a json object"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	msg := reflect.New(messageType(r.Message)).Interface()
+	if err := decoder.Decode(msg); err != nil {
+		return &MessageError{Queue: r.Queue, Reason: err.Error()}
+	}
+	return nil
+}
+
+// messageType is used to get the struct type of a message, whether given a value or pointer
+func messageType(msg interface{}) reflect.Type {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// messageTypeName is used to get the name of the route's message type, as used in envelopes
+func (r Route) messageTypeName() string {
+	return messageType(r.Message).Name()
+}
+
+// ValidateMessage is used to check a marshaled message against the route for queueName
+func ValidateMessage(queueName string, body []byte) error {
+	r, err := lookupRoute(queueName)
+	if err != nil {
+		return err
+	}
+	return r.Validate(body)
+}
@@ -0,0 +1,43 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestRoutes(t *testing.T) {
+	for _, r := range queue.Routes() {
+		if len(r.Command) == 0 || r.Blurb == "" {
+			t.Fatalf("route for %s has no command", r.Queue)
+		}
+		if got, ok := queue.Lookup(r.Queue); !ok || got.Queue != r.Queue {
+			t.Fatalf("failed to lookup %s", r.Queue)
+		}
+	}
+	if _, ok := queue.Lookup("not-a-queue"); ok {
+		t.Fatal("expected unregistered queue not to be found")
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		queue   string
+		body    string
+		wantErr bool
+	}{
+		{"Valid", queue.IpfsPinQueue, `{"cid":"` + testCID + `","network_name":"public","hold_time_in_months":10}`, false},
+		{"UnknownField", queue.IpfsPinQueue, `{"cid":"` + testCID + `","bucket_name":"foo"}`, true},
+		{"WrongType", queue.IpfsPinQueue, `{"hold_time_in_months":"10"}`, true},
+		{"NotObject", queue.EmailSendQueue, `["foo"]`, true},
+		{"UnregisteredQueue", "not-a-queue", `{}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := queue.ValidateMessage(tt.queue, []byte(tt.body)); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"github.com/RTradeLtd/Temporal/config"
+	"github.com/RTradeLtd/Temporal/health"
+	"github.com/jinzhu/gorm"
+)
+
+// routes are registered in init, since processing messages publishes to other queues
+// which would otherwise be an initialization loop
+func init() {
+	Register(Route{
+		Queue:        IpnsEntryQueue,
+		Command:      []string{"ipfs", "ipns-entry"},
+		Blurb:        "IPNS entry creation queue",
+		Description:  "Listens to requests to create IPNS records",
+		Message:      IPNSEntry{},
+		Dependencies: []string{health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessIPNSEntryCreationRequests(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsPinQueue,
+		Exchange:     PinExchange,
+		Command:      []string{"ipfs", "pin"},
+		Blurb:        "Pin addition queue",
+		Description:  "Listens to pin requests",
+		Message:      IPFSPin{},
+		Dependencies: []string{health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProccessIPFSPins(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsPinRemovalQueue,
+		Exchange:     PinRemovalExchange,
+		Command:      []string{"ipfs", "pin-removal"},
+		Blurb:        "Pin removal queue",
+		Description:  "Listens to pin removal requests",
+		Message:      IPFSPinRemoval{},
+		Dependencies: []string{health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessIPFSPinRemovals(msgs, cfg, db)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsFileQueue,
+		Command:      []string{"ipfs", "file"},
+		Blurb:        "File upload queue",
+		Description:  "Listens to file upload requests. Only applies to advanced uploads",
+		Message:      IPFSFile{},
+		Upgrades:     map[int]Upgrader{legacyVersion: upgradeIPFSFileV1},
+		Dependencies: []string{health.IPFS, health.Minio},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProccessIPFSFiles(msgs, cfg, db)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsKeyCreationQueue,
+		Exchange:     IpfsKeyExchange,
+		Command:      []string{"ipfs", "key-creation"},
+		Blurb:        "Key creation queue",
+		Description:  "Listen to key creation requests.\nMessages to this queue are broadcasted to all nodes",
+		Message:      IPFSKeyCreation{},
+		Dependencies: []string{health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessIPFSKeyCreation(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsKeyUpdateQueue,
+		Exchange:     IpfsKeyUpdateExchange,
+		Command:      []string{"ipfs", "key-update"},
+		Blurb:        "Key update queue",
+		Description:  "Listen to key import, rename and deletion requests.\nMessages to this queue are broadcasted to all nodes",
+		Message:      IPFSKeyUpdate{},
+		Dependencies: []string{health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessIPFSKeyUpdates(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:        IpfsClusterPinQueue,
+		Command:      []string{"ipfs", "cluster"},
+		Blurb:        "Cluster pin queue",
+		Description:  "Listens to requests to pin content to the cluster",
+		Message:      IPFSClusterPin{},
+		Dependencies: []string{health.Cluster},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessIPFSClusterPins(msgs, cfg, db)
+		},
+	})
+	Register(Route{
+		Queue:       DatabaseFileAddQueue,
+		Command:     []string{"dfa"},
+		Blurb:       "Database file add queue",
+		Description: "Listens to file uploads requests. Only applies to simple upload route",
+		Message:     DatabaseFileAdd{},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			qm.ProcessDatabaseFileAdds(msgs, db)
+			return nil
+		},
+	})
+	Register(Route{
+		Queue:        PinPaymentConfirmationQueue,
+		Command:      []string{"payment", "pin-confirmation"},
+		Blurb:        "Pin payment confirmation queue",
+		Description:  "Listens to pin payment confirmations and stores the pins in our system",
+		Message:      PinPaymentConfirmation{},
+		Dependencies: []string{health.Ethereum},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessPinPaymentConfirmation(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:        PinPaymentSubmissionQueue,
+		Command:      []string{"payment", "pin-submission"},
+		Blurb:        "Pin payment submission queue",
+		Description:  "Listen to pin payment submissions and stores the information in our database",
+		Message:      PinPaymentSubmission{},
+		Dependencies: []string{health.Ethereum, health.IPFS},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessPinPaymentSubmissions(msgs, db, cfg)
+		},
+	})
+	Register(Route{
+		Queue:       WebhookDeliveryQueue,
+		Command:     []string{"webhook-delivery"},
+		Blurb:       "Webhook delivery queue",
+		Description: "Listens to events and delivers them to the webhooks users have registered",
+		Message:     WebhookEvent{},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessWebhookDeliveries(msgs, db)
+		},
+	})
+	Register(Route{
+		Queue:       EmailSendQueue,
+		Command:     []string{"email-send"},
+		Blurb:       "Email send queue",
+		Description: "Listens to requests to send emails",
+		Message:     EmailSend{},
+		Process: func(qm *QueueManager, msgs <-chan Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+			return qm.ProcessMailSends(msgs, cfg)
+		},
+	})
+}
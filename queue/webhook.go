@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/models"
+	"github.com/RTradeLtd/Temporal/webhooks"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// WebhookEvent is a queue message used to deliver an event to a user's webhooks, or to
+// retry a delivery we've recorded when DeliveryID is set
+type WebhookEvent struct {
+	UserName   string          `json:"user_name"`
+	Event      *webhooks.Event `json:"event"`
+	DeliveryID uint            `json:"delivery_id,omitempty"`
+}
+
+var (
+	// webhookRetryInterval is how often we look for deliveries which are due to be retried
+	webhookRetryInterval = time.Second * 10
+	// webhookRetryLease is how long a claimed retry has to be processed before it's claimed again
+	webhookRetryLease = time.Minute * 5
+	// webhookRetryBatch is the most retries claimed at once
+	webhookRetryBatch = 100
+)
+
+// ProcessWebhookDeliveries is used to deliver events to the webhooks users have subscribed to them.
+// Each delivery is attempted once before its message is acknowledged, and failed deliveries are
+// retried with an increasing backoff by publishing them back to the queue once they're due.
+// Every attempt is recorded so users can inspect the delivery log
+func (qm *QueueManager) ProcessWebhookDeliveries(msgs <-chan Delivery, db *gorm.DB) error {
+	webhookManager := models.NewWebhookManager(db)
+	deliverer := webhooks.NewDeliverer()
+	qm.Logger.WithFields(log.Fields{
+		"service": qm.QueueName,
+	}).Info("processing webhook deliveries")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.scheduleWebhookRetries(ctx, webhookManager)
+	qm.consume(msgs, func(ctx context.Context, d Delivery) {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service": qm.QueueName,
+		}).Info("new message detected")
+		we := WebhookEvent{}
+		err := json.Unmarshal(d.Body, &we)
+		if err != nil || (we.Event == nil && we.DeliveryID == 0) {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service": qm.QueueName,
+			}).Error("failed to unmarshal message")
+			d.Ack()
+			return
+		}
+		if we.DeliveryID != 0 {
+			qm.retryWebhookDelivery(ctx, webhookManager, deliverer, we.DeliveryID)
+			d.Ack()
+			return
+		}
+		hooks, err := webhookManager.FindWebhooksForEvent(we.UserName, we.Event.Type)
+		if err != nil {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service": qm.QueueName,
+				"user":    we.UserName,
+				"error":   err.Error(),
+			}).Error("failed to search for webhooks")
+			d.Ack()
+			return
+		}
+		payload, err := json.Marshal(we.Event)
+		if err != nil {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service": qm.QueueName,
+				"user":    we.UserName,
+				"error":   err.Error(),
+			}).Error("failed to marshal event")
+			d.Ack()
+			return
+		}
+		for _, hook := range *hooks {
+			delivery, err := webhookManager.NewDelivery(&hook, we.Event.ID, we.Event.Type, string(payload))
+			if err != nil {
+				qm.logger(ctx).WithFields(log.Fields{
+					"service": qm.QueueName,
+					"user":    we.UserName,
+					"webhook": hook.ID,
+					"error":   err.Error(),
+				}).Error("failed to record webhook delivery")
+				continue
+			}
+			qm.attemptWebhookDelivery(ctx, webhookManager, deliverer, hook, delivery, we.Event)
+		}
+		d.Ack()
+	})
+	return nil
+}
+
+// retryWebhookDelivery is used to make the next attempt of a recorded delivery
+func (qm *QueueManager) retryWebhookDelivery(ctx context.Context, wm *models.WebhookManager, dl *webhooks.Deliverer, deliveryID uint) {
+	delivery, hook, err := wm.FindDelivery(deliveryID)
+	if err == gorm.ErrRecordNotFound && delivery != nil {
+		// the webhook has been deleted since the delivery failed
+		err = wm.StopRetrying(delivery)
+	}
+	if err != nil {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":  qm.QueueName,
+			"delivery": deliveryID,
+			"error":    err.Error(),
+		}).Error("failed to find webhook delivery")
+		return
+	}
+	// a retry can be published twice if its lease expired before it was processed
+	if delivery.Delivered || delivery.Attempts >= webhooks.MaxAttempts {
+		return
+	}
+	event := &webhooks.Event{}
+	if err = json.Unmarshal([]byte(delivery.Payload), event); err != nil {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":  qm.QueueName,
+			"delivery": deliveryID,
+			"error":    err.Error(),
+		}).Error("failed to unmarshal webhook delivery payload")
+		if err = wm.StopRetrying(delivery); err != nil {
+			qm.logger(ctx).WithFields(log.Fields{
+				"service":  qm.QueueName,
+				"delivery": deliveryID,
+				"error":    err.Error(),
+			}).Error("failed to stop retrying webhook delivery")
+		}
+		return
+	}
+	qm.attemptWebhookDelivery(ctx, wm, dl, *hook, delivery, event)
+}
+
+// attemptWebhookDelivery is used to attempt a delivery once, scheduling its next attempt when it
+// fails and has attempts remaining
+func (qm *QueueManager) attemptWebhookDelivery(ctx context.Context, wm *models.WebhookManager, dl *webhooks.Deliverer, hook models.Webhook, delivery *models.WebhookDelivery, event *webhooks.Event) {
+	status, err := dl.Deliver(hook.URL, hook.Secret, fmt.Sprint(delivery.ID), event)
+	var nextAttempt *time.Time
+	if attempt := delivery.Attempts + 1; err != nil && attempt < webhooks.MaxAttempts {
+		next := time.Now().Add(webhooks.Backoff(attempt))
+		nextAttempt = &next
+	}
+	if errRecord := wm.RecordAttempt(delivery, status, err, nextAttempt); errRecord != nil {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":  qm.QueueName,
+			"delivery": delivery.ID,
+			"error":    errRecord.Error(),
+		}).Error("failed to record webhook delivery attempt")
+	}
+	if err == nil {
+		qm.logger(ctx).WithFields(log.Fields{
+			"service":  qm.QueueName,
+			"user":     hook.UserName,
+			"delivery": delivery.ID,
+			"event":    event.Type,
+		}).Info("webhook delivered")
+		return
+	}
+	qm.logger(ctx).WithFields(log.Fields{
+		"service":  qm.QueueName,
+		"user":     hook.UserName,
+		"delivery": delivery.ID,
+		"attempt":  delivery.Attempts,
+		"error":    err.Error(),
+	}).Warn("webhook delivery failed")
+}
+
+// scheduleWebhookRetries is used to publish the deliveries which are due to be retried until
+// ctx is cancelled. Deliveries are claimed first, so each is published by a single worker
+func (qm *QueueManager) scheduleWebhookRetries(ctx context.Context, wm *models.WebhookManager) {
+	ticker := time.NewTicker(webhookRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ids, err := wm.ClaimDueDeliveries(webhookRetryLease, webhookRetryBatch)
+		if err != nil {
+			qm.Logger.WithFields(log.Fields{
+				"service": qm.QueueName,
+				"error":   err.Error(),
+			}).Error("failed to claim webhook deliveries for retry")
+			continue
+		}
+		for _, id := range ids {
+			// retries which fail to publish are claimed again once their lease expires
+			if err = qm.PublishMessage(WebhookEvent{DeliveryID: id}); err != nil {
+				qm.Logger.WithFields(log.Fields{
+					"service":  qm.QueueName,
+					"delivery": id,
+					"error":    err.Error(),
+				}).Error("failed to publish webhook delivery retry")
+			}
+		}
+	}
+}
+
+// publishWebhookEvent is used to queue an event for delivery to a user's webhooks
+func (qm *QueueManager) publishWebhookEvent(qmWebhook *QueueManager, username, event string, data map[string]string) {
+	err := qmWebhook.PublishMessage(WebhookEvent{
+		UserName: username,
+		Event:    webhooks.NewEvent(event, data),
+	})
+	if err != nil {
+		qm.Logger.WithFields(log.Fields{
+			"service": qm.QueueName,
+			"user":    username,
+			"event":   event,
+			"error":   err.Error(),
+		}).Error("failed to publish message to webhook delivery queue")
+	}
+}
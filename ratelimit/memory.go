@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are removed from a MemoryStore, since a full
+// bucket is the same as one which doesn't exist
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	limit  Limit
+}
+
+// MemoryStore keeps buckets in memory. Each api replica has its own buckets, so clients
+// spreading their requests between replicas get the limit of each
+type MemoryStore struct {
+	mux       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore is used to create an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take is used to take a token from the bucket for key
+func (ms *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	now := time.Now()
+	if now.Sub(ms.lastSweep) > sweepInterval {
+		ms.sweep(now)
+	}
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), at: now}
+		ms.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, now.Sub(b.at), limit)
+	b.at = now
+	b.limit = limit
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return result(allowed, b.tokens, limit), nil
+}
+
+// sweep is used to remove the buckets which have refilled
+func (ms *MemoryStore) sweep(now time.Time) {
+	for key, b := range ms.buckets {
+		if refill(b.tokens, now.Sub(b.at), b.limit) >= float64(b.limit.Burst) {
+			delete(ms.buckets, key)
+		}
+	}
+	ms.lastSweep = now
+}
+
+// Ping is used to check the store, which is always reachable
+func (ms *MemoryStore) Ping() error {
+	return nil
+}
+
+// Close is used to release the store's buckets
+func (ms *MemoryStore) Close() error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	ms.buckets = make(map[string]*bucket)
+	return nil
+}
@@ -0,0 +1,86 @@
+// Package ratelimit implements token bucket rate limits, whose buckets are kept in memory
+// or in a redis compatible server shared by every api replica
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
+
+// Limit is a token bucket holding up to Burst tokens, refilled at Rate tokens a second.
+// Each request takes a token, and requests are refused while the bucket is empty
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute is used to generate a limit of requests a minute, allowing bursts of burst requests
+func PerMinute(requests, burst int) Limit {
+	return Limit{Rate: float64(requests) / 60, Burst: burst}
+}
+
+// Result describes whether a request was allowed, and the state of its bucket afterwards
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is the number of requests which can be made immediately
+	Remaining int
+	// RetryAfter is how long until a request will be allowed, and is zero when one is allowed now
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets of each key limited
+type Store interface {
+	// Take is used to take a token from the bucket for key, creating a full bucket for unseen keys
+	Take(key string, limit Limit) (Result, error)
+	// Ping is used to check the store can be reached
+	Ping() error
+	Close() error
+}
+
+// New is used to create the store named, which is memory or redis. The redis store connects to redisURL
+func New(store, redisURL string) (Store, error) {
+	switch store {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		u, err := url.Parse(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStore(u)
+	default:
+		return nil, fmt.Errorf("%s is not a rate limit store", store)
+	}
+}
+
+// refill is used to add the tokens accrued over elapsed to a bucket holding tokens
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * limit.Rate
+	}
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// result is used to describe a bucket left holding tokens
+func result(allowed bool, tokens float64, limit Limit) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if tokens < 1 {
+		r.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,126 @@
+package ratelimit_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/ratelimit"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	defer store.Close()
+	// a bucket which barely refills is emptied by its burst
+	slow := ratelimit.PerMinute(1, 3)
+	for i := 2; i >= 0; i-- {
+		r, err := store.Take("user", slow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Allowed || r.Remaining != i || r.Limit != 3 {
+			t.Fatalf("expected request to be allowed with %v remaining, got %+v", i, r)
+		}
+	}
+	r, err := store.Take("user", slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allowed || r.RetryAfter <= 0 || r.RetryAfter > time.Minute || r.Reset < 2*time.Minute {
+		t.Fatalf("expected request to be refused, got %+v", r)
+	}
+	// other keys have their own buckets
+	if r, err = store.Take("other", slow); err != nil || !r.Allowed {
+		t.Fatalf("expected other key to be allowed, got %+v %v", r, err)
+	}
+	// and buckets refill over time
+	fast := ratelimit.Limit{Rate: 1000, Burst: 1}
+	if r, err = store.Take("fast", fast); err != nil || !r.Allowed {
+		t.Fatalf("expected request to be allowed, got %+v %v", r, err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	if r, err = store.Take("fast", fast); err != nil || !r.Allowed {
+		t.Fatalf("expected bucket to have refilled, got %+v %v", r, err)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan []string, 10)
+	go fakeRedis(listener, commands)
+
+	u, _ := url.Parse(fmt.Sprintf("redis://:secret@%s/2", listener.Addr().String()))
+	store, err := ratelimit.NewRedisStore(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	r, err := store.Take("user", ratelimit.PerMinute(60, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Allowed || r.Remaining != 4 || r.Limit != 10 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	for _, expected := range []string{"AUTH secret", "SELECT 2", "EVAL"} {
+		if command := strings.Join(<-commands, " "); !strings.HasPrefix(command, expected) {
+			t.Fatalf("expected %s, got %s", expected, command)
+		}
+	}
+	// the connection is reused
+	if err = store.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if command := <-commands; command[0] != "PING" {
+		t.Fatalf("expected PING, got %v", command)
+	}
+	if _, err = ratelimit.NewRedisStore(&url.URL{Scheme: "http", Host: "localhost"}); err == nil {
+		t.Fatal("expected non redis url to fail")
+	}
+}
+
+// fakeRedis is used to answer the commands sent by our store, recording them
+func fakeRedis(listener net.Listener, commands chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		commands <- args
+		switch args[0] {
+		case "EVAL":
+			fmt.Fprint(conn, "*2\r\n:1\r\n$3\r\n4.5\r\n")
+		case "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		default:
+			fmt.Fprint(conn, "+OK\r\n")
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisPoolSize is the number of idle connections kept open to redis
+	redisPoolSize = 10
+	// redisTimeout is how long redis is given to respond, before the request is let through
+	redisTimeout = time.Second
+)
+
+// takeScript is the token bucket of MemoryStore.Take, run atomically by redis. The server's
+// clock is used so replicas agree, and buckets expire once they would have refilled
+const takeScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil(burst / rate) + 1)
+return {allowed, tostring(tokens)}
+`
+
+// keyPrefix namespaces our buckets, in case the server is shared
+const keyPrefix = "temporal:ratelimit:"
+
+// RedisStore keeps buckets in a redis compatible server, so every api replica shares them.
+// Only the few commands we need are implemented, rather than depending on a client library
+type RedisStore struct {
+	address  string
+	host     string
+	password string
+	db       int
+	tls      bool
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore is used to create a store using the server at u, a redis:// or rediss:// url
+// which may hold a password and a database number, such as redis://:password@host:6379/1
+func NewRedisStore(u *url.URL) (*RedisStore, error) {
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("%s is not a redis url", u.String())
+	}
+	rs := &RedisStore{
+		address: u.Host,
+		host:    u.Hostname(),
+		tls:     u.Scheme == "rediss",
+		pool:    make(chan *redisConn, redisPoolSize),
+	}
+	if u.Port() == "" {
+		rs.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rs.password, _ = u.User.Password()
+	}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		db, err := strconv.Atoi(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %s", path)
+		}
+		rs.db = db
+	}
+	return rs, nil
+}
+
+// Take is used to take a token from the bucket for key
+func (rs *RedisStore) Take(key string, limit Limit) (Result, error) {
+	reply, err := rs.do("EVAL", takeScript, "1", keyPrefix+key,
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), strconv.Itoa(limit.Burst))
+	if err != nil {
+		return Result{}, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected reply from redis: %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(allowed == 1, tokens, limit), nil
+}
+
+// Ping is used to check the server can be reached
+func (rs *RedisStore) Ping() error {
+	_, err := rs.do("PING")
+	return err
+}
+
+// Close is used to close our idle connections
+func (rs *RedisStore) Close() error {
+	for {
+		select {
+		case conn := <-rs.pool:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do is used to send a command over an idle connection, returning the connection to the
+// pool once the reply has been read. Connections which fail are discarded
+func (rs *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := rs.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	if _, isReply := err.(redisError); err != nil && !isReply {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case rs.pool <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// conn is used to take an idle connection from the pool, or dial a new one
+func (rs *RedisStore) conn() (*redisConn, error) {
+	select {
+	case conn := <-rs.pool:
+		return conn, nil
+	default:
+	}
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var (
+		c   net.Conn
+		err error
+	)
+	if rs.tls {
+		c, err = tls.DialWithDialer(dialer, "tcp", rs.address, &tls.Config{ServerName: rs.host})
+	} else {
+		c, err = dialer.Dial("tcp", rs.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	if rs.password != "" {
+		if _, err = conn.do("AUTH", rs.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if rs.db != 0 {
+		if _, err = conn.do("SELECT", strconv.Itoa(rs.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply, after which the connection can still be used
+type redisError string
+
+func (re redisError) Error() string {
+	return string(re)
+}
+
+// do is used to send a command as an array of bulk strings, and read its reply
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	rc.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(rc.reader)
+}
+
+// readReply is used to read a reply in the redis serialization protocol. Bulk strings are
+// returned as strings, integers as int64 and arrays as []interface{}
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("malformed reply from redis")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply type %q from redis", kind)
+	}
+}
@@ -0,0 +1,162 @@
+package rtfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EncryptedKeyVersion is the version of the encrypted key format written by EncryptKey
+	EncryptedKeyVersion = 1
+	keyCipher           = "aes-256-gcm"
+	keyKDF              = "scrypt"
+	// keys are derived with the same cost as a light ethereum keystore
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrWrongKeyPassword is returned when an encrypted key can't be decrypted with the password given
+var ErrWrongKeyPassword = errors.New("could not decrypt key, the password may be incorrect")
+
+// EncryptedKey is a private key encrypted with a password, used to export keys from
+// our keystore and import keys into it. The key is protobuf encoded before encryption,
+// as done by the ipfs keystore
+type EncryptedKey struct {
+	Version int `json:"version"`
+	// ID is the peer id of the key, and the ipns hash of records it publishes
+	ID     string          `json:"id"`
+	Crypto EncryptedKeyBox `json:"crypto"`
+}
+
+// EncryptedKeyBox holds the ciphertext of an encrypted key and how to decrypt it
+type EncryptedKeyBox struct {
+	Cipher     string          `json:"cipher"`
+	CipherText string          `json:"ciphertext"`
+	Nonce      string          `json:"nonce"`
+	KDF        string          `json:"kdf"`
+	KDFParams  EncryptedKeyKDF `json:"kdfparams"`
+}
+
+// EncryptedKeyKDF are the scrypt parameters used to derive the encryption key from the password
+type EncryptedKeyKDF struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+// EncryptKey is used to encrypt a private key with a password, returning it as json
+func EncryptKey(pk ci.PrivKey, password string) ([]byte, error) {
+	id, err := peer.IDFromPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := ci.MarshalPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kdf := EncryptedKeyKDF{N: scryptN, R: scryptR, P: scryptP, Salt: hex.EncodeToString(salt)}
+	gcm, err := keyAEAD(password, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(EncryptedKey{
+		Version: EncryptedKeyVersion,
+		ID:      id.Pretty(),
+		Crypto: EncryptedKeyBox{
+			Cipher:     keyCipher,
+			CipherText: hex.EncodeToString(gcm.Seal(nil, nonce, plainText, nil)),
+			Nonce:      hex.EncodeToString(nonce),
+			KDF:        keyKDF,
+			KDFParams:  kdf,
+		},
+	})
+}
+
+// DecryptKey is used to decrypt a private key encrypted by EncryptKey
+func DecryptKey(data []byte, password string) (ci.PrivKey, error) {
+	var key EncryptedKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %s", err)
+	}
+	if key.Version != EncryptedKeyVersion {
+		return nil, fmt.Errorf("unsupported encrypted key version %v", key.Version)
+	}
+	if key.Crypto.Cipher != keyCipher || key.Crypto.KDF != keyKDF {
+		return nil, fmt.Errorf("unsupported encrypted key cipher %s with kdf %s", key.Crypto.Cipher, key.Crypto.KDF)
+	}
+	// the parameters come from the user, so only our own are accepted to keep imports from exhausting memory
+	kdf := key.Crypto.KDFParams
+	if kdf.N != scryptN || kdf.R != scryptR || kdf.P != scryptP {
+		return nil, errors.New("unsupported encrypted key kdf parameters")
+	}
+	salt, err := hex.DecodeString(kdf.Salt)
+	if err != nil {
+		return nil, errors.New("invalid encrypted key salt")
+	}
+	nonce, err := hex.DecodeString(key.Crypto.Nonce)
+	if err != nil {
+		return nil, errors.New("invalid encrypted key nonce")
+	}
+	cipherText, err := hex.DecodeString(key.Crypto.CipherText)
+	if err != nil {
+		return nil, errors.New("invalid encrypted key ciphertext")
+	}
+	gcm, err := keyAEAD(password, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted key nonce")
+	}
+	plainText, err := gcm.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return nil, ErrWrongKeyPassword
+	}
+	pk, err := ci.UnmarshalPrivateKey(plainText)
+	if err != nil {
+		return nil, err
+	}
+	// the id isn't encrypted, so a key which doesn't match it has been tampered with
+	if key.ID != "" {
+		id, err := peer.IDFromPrivateKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		if id.Pretty() != key.ID {
+			return nil, errors.New("encrypted key does not match its id")
+		}
+	}
+	return pk, nil
+}
+
+// keyAEAD is used to derive the cipher encrypting a key from a password
+func keyAEAD(password string, salt []byte, kdf EncryptedKeyKDF) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(password), salt, kdf.N, kdf.R, kdf.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
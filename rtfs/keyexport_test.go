@@ -0,0 +1,101 @@
+package rtfs_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/rtfs"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	ci "github.com/libp2p/go-libp2p-crypto"
+)
+
+func TestEncryptKey(t *testing.T) {
+	pk, _, err := ci.GenerateKeyPair(ci.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rtfs.EncryptKey(pk, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := rtfs.DecryptKey(data, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decrypted.Equals(pk) {
+		t.Fatal("decrypted key does not match")
+	}
+	if _, err = rtfs.DecryptKey(data, "password1234"); err != rtfs.ErrWrongKeyPassword {
+		t.Fatalf("expected wrong password error, got %v", err)
+	}
+
+	var tests = []struct {
+		name   string
+		modify func(key *rtfs.EncryptedKey)
+	}{
+		{"Version", func(key *rtfs.EncryptedKey) { key.Version = 2 }},
+		{"Cipher", func(key *rtfs.EncryptedKey) { key.Crypto.Cipher = "aes-128-ctr" }},
+		{"Cost", func(key *rtfs.EncryptedKey) { key.Crypto.KDFParams.N = 1 << 30 }},
+		{"StandardCost", func(key *rtfs.EncryptedKey) { key.Crypto.KDFParams.N = 1 << 18 }},
+		{"Salt", func(key *rtfs.EncryptedKey) { key.Crypto.KDFParams.Salt = "zz" }},
+		{"ID", func(key *rtfs.EncryptedKey) { key.ID = "QmNotTheKey" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key rtfs.EncryptedKey
+			if err := json.Unmarshal(data, &key); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(&key)
+			modified, err := json.Marshal(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = rtfs.DecryptKey(modified, "password123"); err == nil {
+				t.Fatal("expected error decrypting modified key")
+			}
+		})
+	}
+}
+
+func TestKeystoreManager_Lifecycle(t *testing.T) {
+	defer func() {
+		if err := os.RemoveAll("temp"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	km, err := rtfs.GenerateKeystoreManager("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := km.CreateAndSaveKey("lifecycle1", ci.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := km.ExportKey("lifecycle1", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = km.RenameKey("lifecycle1", "lifecycle2"); err != nil {
+		t.Fatal(err)
+	}
+	if present, err := km.CheckIfKeyExists("lifecycle1"); err != nil || present {
+		t.Fatalf("expected renamed key to be moved, present %v error %v", present, err)
+	}
+	// keys can't be imported over an existing key
+	if _, err = km.ImportKey("lifecycle2", exported, "password123"); err != keystore.ErrKeyExists {
+		t.Fatalf("expected key exists error, got %v", err)
+	}
+	if err = km.DeleteKey("lifecycle2"); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := km.ImportKey("lifecycle3", exported, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !imported.Equals(pk) {
+		t.Fatal("imported key does not match")
+	}
+}
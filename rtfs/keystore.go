@@ -102,3 +102,41 @@ func (km *KeystoreManager) CreateAndSaveKey(keyName string, keyType, bits int) (
 
 	return pk, nil
 }
+
+// DeleteKey is used to remove a key from the keystore
+func (km *KeystoreManager) DeleteKey(keyName string) error {
+	return km.FSKeystore.Delete(keyName)
+}
+
+// RenameKey is used to move a key to a new name, failing if the new name is taken
+func (km *KeystoreManager) RenameKey(oldName, newName string) error {
+	pk, err := km.FSKeystore.Get(oldName)
+	if err != nil {
+		return err
+	}
+	if err = km.FSKeystore.Put(newName, pk); err != nil {
+		return err
+	}
+	return km.FSKeystore.Delete(oldName)
+}
+
+// ExportKey is used to encrypt a key with a password, so it can be held outside of the keystore
+func (km *KeystoreManager) ExportKey(keyName, password string) ([]byte, error) {
+	pk, err := km.FSKeystore.Get(keyName)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptKey(pk, password)
+}
+
+// ImportKey is used to decrypt a key exported by ExportKey, and save it under keyName
+func (km *KeystoreManager) ImportKey(keyName string, data []byte, password string) (ci.PrivKey, error) {
+	pk, err := DecryptKey(data, password)
+	if err != nil {
+		return nil, err
+	}
+	if err = km.SavePrivateKey(keyName, pk); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
@@ -3,12 +3,15 @@ package rtfs
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/RTradeLtd/Temporal/tracing"
 	ipfsapi "github.com/RTradeLtd/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-cmdkit/files"
 )
 
 // IpfsManager is our helper wrapper for IPFS
@@ -33,10 +36,13 @@ func Initialize(pubTopic, connectionURL string) (*IpfsManager, error) {
 	return &manager, nil
 }
 
+// DefaultURL is the api address of the ipfs node used when no url is given, set from our configuration
+var DefaultURL = "localhost:5001"
+
 // EstablishShellWithNode is used to establish our api shell for the ipfs node
 func EstablishShellWithNode(url string) *ipfsapi.Shell {
 	if url == "" {
-		shell := ipfsapi.NewShell("localhost:5001")
+		shell := ipfsapi.NewShell(DefaultURL)
 		return shell
 	}
 	shell := ipfsapi.NewShell(url)
@@ -62,6 +68,11 @@ func (im *IpfsManager) CreateKeystoreManager() error {
 
 // PublishToIPNSDetails is used for fine grained control over IPNS record publishing
 func (im *IpfsManager) PublishToIPNSDetails(contentHash, keyName string, lifetime, ttl time.Duration, resolve bool) (*ipfsapi.PublishResponse, error) {
+	return im.PublishToIPNSDetailsWithContext(context.Background(), contentHash, keyName, lifetime, ttl, resolve)
+}
+
+// PublishToIPNSDetailsWithContext is used to publish like PublishToIPNSDetails, giving up once ctx expires
+func (im *IpfsManager) PublishToIPNSDetailsWithContext(ctx context.Context, contentHash, keyName string, lifetime, ttl time.Duration, resolve bool) (*ipfsapi.PublishResponse, error) {
 	if !im.KeystoreEnabled {
 		return nil, errors.New("attempting to create ipns entry with dynamic keys keystore is not enabled/generated yet")
 	}
@@ -72,8 +83,21 @@ func (im *IpfsManager) PublishToIPNSDetails(contentHash, keyName string, lifetim
 	if !keyPresent {
 		return nil, errors.New("attempting to sign with non existent key")
 	}
-	resp, err := im.Shell.PublishWithDetails(contentHash, keyName, lifetime, ttl, resolve)
-	if err != nil {
+	ctx, span := tracing.Start(ctx, "ipfs name_publish", tracing.Client)
+	span.SetAttribute("ipfs.hash", contentHash)
+	req := im.Shell.Request("name/publish", contentHash).
+		Option("resolve", resolve).
+		Option("key", keyName)
+	if lifetime != 0 {
+		req.Option("lifetime", lifetime)
+	}
+	if ttl.Seconds() > 0 {
+		req.Option("ttl", ttl)
+	}
+	resp := &ipfsapi.PublishResponse{}
+	start := time.Now()
+	err = req.Exec(ctx, resp)
+	if err = span.End(metrics.ObserveCall(metrics.IPFS, "name_publish", start, err)); err != nil {
 		return nil, err
 	}
 	return resp, nil
@@ -83,29 +107,69 @@ func (im *IpfsManager) PublishToIPNSDetails(contentHash, keyName string, lifetim
 // but also alert the rest of the local nodes to pin
 // after which the pin will be sent to the cluster
 func (im *IpfsManager) Pin(hash string) error {
-	err := im.Shell.Pin(hash)
-	if err != nil {
-		// TODO: add error reporting
-		fmt.Println(err)
-		return err
-	}
-	return nil
+	return im.PinWithContext(context.Background(), hash)
 }
 
 // Add is a wrapper used to add a file to IPFS
 // currently until https://github.com/ipfs/go-ipfs/issues/5376 it is added with no pin
 // thus a manual pin must be triggered afterwards
 func (im *IpfsManager) Add(r io.Reader) (string, error) {
-	hash, err := im.Shell.AddNoPin(r)
-	if err != nil {
+	return im.AddWithContext(context.Background(), r)
+}
+
+// PinWithContext is used to pin a hash to the node, giving up once ctx expires
+func (im *IpfsManager) PinWithContext(ctx context.Context, hash string) error {
+	ctx, span := tracing.Start(ctx, "ipfs pin", tracing.Client)
+	span.SetAttribute("ipfs.hash", hash)
+	start := time.Now()
+	err := im.Shell.Request("pin/add", hash).
+		Option("recursive", true).
+		Exec(ctx, nil)
+	return span.End(metrics.ObserveCall(metrics.IPFS, "pin", start, err))
+}
+
+// UnpinWithContext is used to remove the recursive pin of a hash from the node, giving up once ctx expires
+func (im *IpfsManager) UnpinWithContext(ctx context.Context, hash string) error {
+	ctx, span := tracing.Start(ctx, "ipfs unpin", tracing.Client)
+	span.SetAttribute("ipfs.hash", hash)
+	start := time.Now()
+	err := im.Shell.Request("pin/rm", hash).
+		Option("recursive", true).
+		Exec(ctx, nil)
+	return span.End(metrics.ObserveCall(metrics.IPFS, "unpin", start, err))
+}
+
+// AddWithContext is used to add a file to ipfs without pinning it, giving up once ctx expires
+func (im *IpfsManager) AddWithContext(ctx context.Context, r io.Reader) (string, error) {
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(r)
+	}
+	// the api expects an array of files
+	slf := files.NewSliceFile("", "", []files.File{files.NewReaderFile("", "", rc, nil)})
+	out := struct{ Hash string }{}
+	ctx, span := tracing.Start(ctx, "ipfs add", tracing.Client)
+	start := time.Now()
+	err := im.Shell.Request("add").
+		Option("progress", false).
+		Option("pin", false).
+		Body(files.NewMultiFileReader(slf, true)).
+		Exec(ctx, &out)
+	span.SetAttribute("ipfs.hash", out.Hash)
+	if err = span.End(metrics.ObserveCall(metrics.IPFS, "add", start, err)); err != nil {
 		return "", err
 	}
-	return hash, nil
+	return out.Hash, nil
 }
 
 // GetObjectFileSizeInBytes is used to retrieve the cumulative byte size of an object
 func (im *IpfsManager) GetObjectFileSizeInBytes(key string) (int, error) {
-	stat, err := im.Shell.ObjectStat(key)
+	return im.GetObjectFileSizeInBytesWithContext(context.Background(), key)
+}
+
+// GetObjectFileSizeInBytesWithContext is used to retrieve the cumulative byte size of an object, giving up once ctx expires
+func (im *IpfsManager) GetObjectFileSizeInBytesWithContext(ctx context.Context, key string) (int, error) {
+	stat, err := im.ObjectStatWithContext(ctx, key)
 	if err != nil {
 		return 0, err
 	}
@@ -114,13 +178,36 @@ func (im *IpfsManager) GetObjectFileSizeInBytes(key string) (int, error) {
 
 // ObjectStat is used to retrieve the stats about an object
 func (im *IpfsManager) ObjectStat(key string) (*ipfsapi.ObjectStats, error) {
-	stat, err := im.Shell.ObjectStat(key)
-	if err != nil {
+	return im.ObjectStatWithContext(context.Background(), key)
+}
+
+// ObjectStatWithContext is used to retrieve the stats about an object, giving up once ctx expires
+func (im *IpfsManager) ObjectStatWithContext(ctx context.Context, key string) (*ipfsapi.ObjectStats, error) {
+	ctx, span := tracing.Start(ctx, "ipfs object_stat", tracing.Client)
+	span.SetAttribute("ipfs.hash", key)
+	stat := &ipfsapi.ObjectStats{}
+	start := time.Now()
+	err := im.Shell.Request("object/stat", key).Exec(ctx, stat)
+	if err = span.End(metrics.ObserveCall(metrics.IPFS, "object_stat", start, err)); err != nil {
 		return nil, err
 	}
 	return stat, nil
 }
 
+// PinsWithContext is used to list the node's pins of the given type, which is one of
+// direct, indirect, recursive or all, giving up once ctx expires
+func (im *IpfsManager) PinsWithContext(ctx context.Context, pinType string) (map[string]ipfsapi.PinInfo, error) {
+	ctx, span := tracing.Start(ctx, "ipfs pin_ls", tracing.Client)
+	span.SetAttribute("ipfs.pin_type", pinType)
+	out := struct{ Keys map[string]ipfsapi.PinInfo }{}
+	start := time.Now()
+	err := im.Shell.Request("pin/ls").Option("type", pinType).Exec(ctx, &out)
+	if err = span.End(metrics.ObserveCall(metrics.IPFS, "pin_ls", start, err)); err != nil {
+		return nil, err
+	}
+	return out.Keys, nil
+}
+
 // ParseLocalPinsForHash checks whether or not a pin is present
 func (im *IpfsManager) ParseLocalPinsForHash(hash string) (bool, error) {
 	pins, err := im.Shell.Pins()
@@ -137,17 +224,10 @@ func (im *IpfsManager) ParseLocalPinsForHash(hash string) (bool, error) {
 
 // PublishPubSubMessage is used to publish a message to the given topic
 func (im *IpfsManager) PublishPubSubMessage(topic string, data string) error {
-	fmt.Println("publishing data")
 	if topic == "" && data == "" {
 		return errors.New("invalid topic and data")
 	}
-	err := im.Shell.PubSubPublish(topic, data)
-	if err != nil {
-		fmt.Println("error publishing data ", err.Error())
-		return err
-	}
-	fmt.Println("published data")
-	return nil
+	return im.Shell.PubSubPublish(topic, data)
 }
 
 // BuildCustomRequest is used to build a custom request
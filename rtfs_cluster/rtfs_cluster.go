@@ -1,9 +1,12 @@
 package rtfs_cluster
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"time"
 
+	"github.com/RTradeLtd/Temporal/metrics"
+	"github.com/RTradeLtd/Temporal/tracing"
 	gocid "github.com/ipfs/go-cid"
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/api/rest/client"
@@ -90,15 +93,20 @@ func (cm *ClusterManager) ParseLocalStatusAllAndSync() ([]*gocid.Cid, error) {
 
 // RemovePinFromCluster is used to remove a pin from the cluster
 func (cm *ClusterManager) RemovePinFromCluster(cidString string) error {
+	return cm.RemovePinFromClusterWithContext(context.Background(), cidString)
+}
+
+// RemovePinFromClusterWithContext is used to remove a pin from the cluster, recording the call
+// as a child of the span in ctx. Our cluster client can't abandon requests, so ctx isn't used to cancel it
+func (cm *ClusterManager) RemovePinFromClusterWithContext(ctx context.Context, cidString string) error {
 	decoded, err := cm.DecodeHashString(cidString)
 	if err != nil {
 		return err
 	}
-	err = cm.Client.Unpin(decoded)
-	if err != nil {
-		return err
-	}
-	return nil
+	_, span := tracing.Start(ctx, "ipfs_cluster unpin", tracing.Client)
+	span.SetAttribute("ipfs.hash", cidString)
+	start := time.Now()
+	return span.End(metrics.ObserveCall(metrics.Cluster, "unpin", start, cm.Client.Unpin(decoded)))
 }
 
 // FetchLocalStatus is used to fetch the local status of all pins
@@ -168,16 +176,19 @@ func (cm *ClusterManager) DecodeHashString(cidString string) (*gocid.Cid, error)
 
 // Pin is used to add a pin to the cluster
 func (cm *ClusterManager) Pin(cid *gocid.Cid) error {
+	return cm.PinWithContext(context.Background(), cid)
+}
+
+// PinWithContext is used to add a pin to the cluster, recording the call as a child of the span in ctx
+func (cm *ClusterManager) PinWithContext(ctx context.Context, cid *gocid.Cid) error {
+	_, span := tracing.Start(ctx, "ipfs_cluster pin", tracing.Client)
+	span.SetAttribute("ipfs.hash", cid.String())
+	start := time.Now()
 	err := cm.Client.Pin(cid, -1, -1, cid.String())
-	if err != nil {
-		return err
+	if err = metrics.ObserveCall(metrics.Cluster, "pin", start, err); err != nil {
+		return span.End(err)
 	}
-	status, err := cm.Client.Status(cid, true)
-	if err != nil {
-		fmt.Println("error pinning hash to cluster")
-		return err
-	}
-	fmt.Println("status")
-	fmt.Println(status)
-	return nil
+	start = time.Now()
+	_, err = cm.Client.Status(cid, true)
+	return span.End(metrics.ObserveCall(metrics.Cluster, "status", start, err))
 }
@@ -39,6 +39,9 @@ case "$1" in
     ipfs-key-creation-queue)
         temporal queue ipfs key-creation
         ;;
+    ipfs-key-update-queue)
+        temporal queue ipfs key-update
+        ;;
     ipfs-cluster-queue)
         temporal queue ipfs cluster
         ;;
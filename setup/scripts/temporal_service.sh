@@ -11,4 +11,5 @@
 /boot_scripts/temporal_manager.sh ipns-entry-queue &
 # /boot_scripts/temporal_manager.sh ipfs-pin-removal-queue &
 /boot_scripts/temporal_manager.sh ipfs-key-creation-queue &
+/boot_scripts/temporal_manager.sh ipfs-key-update-queue &
 /boot_scripts/temporal_manager.sh ipfs-cluster-queue &
@@ -67,6 +67,14 @@ case "$1" in
             echo 1
         fi
         ;;
+    ipfs-key-update-queue)
+        PID=$(pgrep -ax temporal | awk '{print $2" "$3" "$4}' | grep "temporal queue ipfs-key-update" | grep -iv grep | awk '{print $2}')
+        if [[ "$PID" == "" ]]; then
+            echo 0
+        else
+            echo 1
+        fi
+        ;;
     ipfs-cluster-queue)
         PID=$(pgrep -ax temporal | awk '{print $2" "$3" "$4}' | grep "temporal queue ipfs-cluster" | grep -iv grep | awk '{print $2}')
         if [[ "$PID" == "" ]]; then
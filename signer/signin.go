@@ -0,0 +1,93 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+Sign-in messages let a user prove they hold the key of their linked ethereum address, by signing a
+challenge we issue with their wallet (personal_sign). Messages follow EIP-4361 (Sign-In With Ethereum),
+so wallets can recognise and display them.
+*/
+
+// SignInMessage is the challenge a user signs to sign in with their ethereum address
+type SignInMessage struct {
+	Domain    string
+	Address   common.Address
+	URI       string
+	Nonce     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// String is used to render the message in the EIP-4361 format, which is the text that is signed
+func (m *SignInMessage) String() string {
+	lines := []string{
+		fmt.Sprintf("%s wants you to sign in with your Ethereum account:", m.Domain),
+		m.Address.Hex(),
+		"",
+		"Sign in to Temporal.",
+		"",
+		fmt.Sprintf("URI: %s", m.URI),
+		"Version: 1",
+		"Chain ID: 1",
+		fmt.Sprintf("Nonce: %s", m.Nonce),
+		fmt.Sprintf("Issued At: %s", m.IssuedAt.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("Expiration Time: %s", m.ExpiresAt.UTC().Format(time.RFC3339)),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NewNonce is used to generate a random nonce for a sign-in message
+func NewNonce() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// PersonalHash is used to generate the hash wallets sign for personal_sign, as described in EIP-191
+func PersonalHash(message []byte) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))), message)
+}
+
+// SignPersonal is used to sign a message the way wallets do for personal_sign
+func SignPersonal(key *ecdsa.PrivateKey, message []byte) ([]byte, error) {
+	sig, err := crypto.Sign(PersonalHash(message), key)
+	if err != nil {
+		return nil, err
+	}
+	// wallets return v as 27 or 28
+	sig[64] += 27
+	return sig, nil
+}
+
+// RecoverPersonalSigner is used to recover the address which signed a message with personal_sign
+func RecoverPersonalSigner(message, sig []byte) (common.Address, error) {
+	if len(sig) != 65 {
+		return common.Address{}, errors.New("signature must be 65 bytes")
+	}
+	normalized := make([]byte, 65)
+	copy(normalized, sig)
+	if normalized[64] >= 27 {
+		normalized[64] -= 27
+	}
+	if normalized[64] > 1 {
+		return common.Address{}, errors.New("invalid signature recovery id")
+	}
+	pub, err := crypto.SigToPub(PersonalHash(message), normalized)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
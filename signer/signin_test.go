@@ -0,0 +1,49 @@
+package signer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignInMessage(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	now := time.Now()
+	msg := &signer.SignInMessage{
+		Domain:    "temporal.cloud",
+		Address:   address,
+		URI:       "https://temporal.cloud",
+		Nonce:     "abc123",
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Minute),
+	}
+	if !strings.HasPrefix(msg.String(), "temporal.cloud wants you to sign in with your Ethereum account:\n"+address.Hex()) {
+		t.Fatalf("unexpected message %s", msg.String())
+	}
+	sig, err := signer.SignPersonal(key, []byte(msg.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := signer.RecoverPersonalSigner([]byte(msg.String()), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != address {
+		t.Fatalf("recovered %s, expected %s", recovered.Hex(), address.Hex())
+	}
+	// a signature over a different nonce recovers a different address
+	msg.Nonce = "def456"
+	if recovered, err = signer.RecoverPersonalSigner([]byte(msg.String()), sig); err == nil && recovered == address {
+		t.Fatal("expected signature to be bound to the message")
+	}
+	if _, err = signer.RecoverPersonalSigner([]byte(msg.String()), sig[:64]); err == nil {
+		t.Fatal("expected short signature to fail")
+	}
+}
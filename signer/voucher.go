@@ -0,0 +1,81 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/RTradeLtd/Temporal/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+Vouchers are the off-chain half of our payment channels. A user opens a channel on-chain with a deposit,
+and then signs a voucher for the cumulative amount owed with every request they make. Only the latest
+(largest) voucher for a channel needs to be kept, and it is what we submit to the contract when settling.
+*/
+
+// Voucher is a signed claim against a payment channel for a cumulative amount of wei
+type Voucher struct {
+	ChannelID common.Hash `json:"channel_id"`
+	Amount    *big.Int    `json:"amount"`
+	Sig       []byte      `json:"sig"`
+}
+
+// VoucherHash is used to generate the prefixed hash that is signed for a voucher.
+// This mirrors keccak256(abi.encodePacked(address(this), _channelID, _amount)) in the channel contract
+func VoucherHash(contractAddress common.Address, channelID common.Hash, amount *big.Int) []byte {
+	hashToSign := utils.SoliditySHA3(
+		utils.Address(contractAddress),
+		utils.Bytes32(channelID.Bytes()),
+		utils.Uint256(amount),
+	)
+	return utils.SoliditySHA3WithPrefix(hashToSign)
+}
+
+// SignVoucher is used to sign a voucher for the given channel and cumulative amount
+func SignVoucher(key *ecdsa.PrivateKey, contractAddress common.Address, channelID common.Hash, amount *big.Int) (*Voucher, error) {
+	if amount == nil || amount.Sign() <= 0 {
+		return nil, errors.New("voucher amount must be greater than zero")
+	}
+	sig, err := crypto.Sign(VoucherHash(contractAddress, channelID, amount), key)
+	if err != nil {
+		return nil, err
+	}
+	// the contract uses ecrecover which expects v to be 27 or 28
+	sig[64] += 27
+	return &Voucher{
+		ChannelID: channelID,
+		Amount:    amount,
+		Sig:       sig,
+	}, nil
+}
+
+// SignVoucher is used to sign a voucher with the key held by the payment signer
+func (ps *PaymentSigner) SignVoucher(contractAddress common.Address, channelID common.Hash, amount *big.Int) (*Voucher, error) {
+	return SignVoucher(ps.Key, contractAddress, channelID, amount)
+}
+
+// RecoverVoucherSigner is used to recover the address which signed the voucher
+func RecoverVoucherSigner(contractAddress common.Address, v *Voucher) (common.Address, error) {
+	if v == nil || v.Amount == nil {
+		return common.Address{}, errors.New("invalid voucher provided")
+	}
+	if len(v.Sig) != 65 {
+		return common.Address{}, errors.New("voucher signature must be 65 bytes")
+	}
+	sig := make([]byte, 65)
+	copy(sig, v.Sig)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	if sig[64] > 1 {
+		return common.Address{}, errors.New("invalid voucher signature recovery id")
+	}
+	pub, err := crypto.SigToPub(VoucherHash(contractAddress, v.ChannelID, v.Amount), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
@@ -0,0 +1,42 @@
+package signer_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/signer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var channelContract = common.HexToAddress("0x3b2fD241378a326Af998E4243aA76fE8b8414dEe")
+
+func TestVoucher(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelID := common.BytesToHash(crypto.Keccak256([]byte("channel")))
+	v, err := signer.SignVoucher(key, channelContract, channelID, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := signer.RecoverVoucherSigner(channelContract, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("recovered %s, expected %s", recovered.String(), crypto.PubkeyToAddress(key.PublicKey).String())
+	}
+
+	// a tampered amount must not recover to the signer
+	v.Amount = big.NewInt(1000)
+	recovered, err = signer.RecoverVoucherSigner(channelContract, v)
+	if err == nil && recovered == crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatal("tampered voucher recovered to the original signer")
+	}
+
+	if _, err = signer.SignVoucher(key, channelContract, channelID, big.NewInt(0)); err == nil {
+		t.Fatal("expected error signing zero value voucher")
+	}
+}
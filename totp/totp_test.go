@@ -0,0 +1,57 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/totp"
+)
+
+// the sha1 test vectors from RFC 6238, truncated to our 6 digits
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	for _, test := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Fatalf("expected %s at %v, got %s", test.code, test.unix, code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := totp.Code(secret, totp.Step(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the previous period's code is accepted, and reported as that period
+	if step, ok := totp.Verify(secret, code, now); !ok || step != totp.Step(now)-1 {
+		t.Fatalf("expected code to be valid for the previous period, got %v %v", step, ok)
+	}
+	if _, ok := totp.Verify(secret, code, now.Add(totp.Period*3)); ok {
+		t.Fatal("expected code to expire")
+	}
+	if _, ok := totp.Verify(secret, "12345", now); ok {
+		t.Fatal("expected short code to be invalid")
+	}
+	if uri := totp.URI("Temporal", "user", secret); !strings.HasPrefix(uri, "otpauth://totp/Temporal:user?") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
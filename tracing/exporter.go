@@ -0,0 +1,236 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter is used to send finished spans to a tracing backend
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer batches finished spans and hands them to its exporter
+type Tracer struct {
+	// Service names the process our spans are recorded by
+	Service string
+	// OnError is called when a batch of spans fails to export
+	OnError func(err error)
+	// BatchSize is the number of spans exported at once
+	BatchSize int
+	// Interval is how long spans may wait to be exported
+	Interval time.Duration
+
+	exporter Exporter
+	spans    chan SpanData
+	flush    chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewTracer is used to generate a tracer exporting the spans of service. Start must be called before spans are exported
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{
+		Service:   service,
+		OnError:   func(error) {},
+		BatchSize: 512,
+		Interval:  time.Second * 5,
+		exporter:  exporter,
+		// spans are dropped rather than blocking requests when the exporter falls behind
+		spans: make(chan SpanData, 4096),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start is used to export spans in the background until Shutdown is called
+func (t *Tracer) Start() {
+	go t.run()
+}
+
+// record is used to queue a finished span for export
+func (t *Tracer) record(data SpanData) {
+	select {
+	case t.spans <- data:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, t.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			t.OnError(err)
+		}
+		cancel()
+		batch = make([]SpanData, 0, t.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.spans:
+				batch = append(batch, data)
+				if len(batch) >= t.BatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.spans:
+			batch = append(batch, data)
+			if len(batch) >= t.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			drain()
+			close(flushed)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush is used to export every span recorded so far, waiting until ctx is done at most
+func (t *Tracer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown is used to export the remaining spans and stop exporting, waiting until ctx is done at most
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StdoutExporter writes spans as json lines, for local development
+type StdoutExporter struct {
+	mux sync.Mutex
+	out io.Writer
+}
+
+// NewStdoutExporter is used to generate an exporter writing to out, or stdout when out is nil
+func NewStdoutExporter(out io.Writer) *StdoutExporter {
+	if out == nil {
+		out = os.Stdout
+	}
+	return &StdoutExporter{out: out}
+}
+
+// ExportSpans writes each span on its own line
+func (se *StdoutExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	se.mux.Lock()
+	defer se.mux.Unlock()
+	encoder := json.NewEncoder(se.out)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector, using the json encoding of otlp/http
+type OTLPExporter struct {
+	// Endpoint is the collector's traces url, ie http://localhost:4318/v1/traces
+	Endpoint string
+	Client   *http.Client
+
+	resource []otlpAttribute
+}
+
+// NewOTLPExporter is used to generate an exporter posting the spans of service to endpoint
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	attributes := map[string]interface{}{"service.name": "temporal-" + service}
+	if host, err := os.Hostname(); err == nil {
+		attributes["host.name"] = host
+	}
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: time.Second * 10},
+		resource: otlpAttributes(attributes),
+	}
+}
+
+// ExportSpans posts a batch of spans to our collector
+func (oe *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: oe.resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/RTradeLtd/Temporal"}, Spans: otlpSpans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, oe.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := oe.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with status %s", resp.Status)
+	}
+	return nil
+}
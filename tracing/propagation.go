@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TraceParentHeader is the W3C trace context header spans are propagated in,
+// both over http and in the headers of queue messages
+const TraceParentHeader = "traceparent"
+
+// Inject is used to encode the span in ctx as a traceparent header, which is empty when there is none
+func Inject(ctx context.Context) string {
+	sc, ok := ctx.Value(spanKey{}).(spanContext)
+	if !ok {
+		return ""
+	}
+	// we record every trace, so spans are always flagged as sampled
+	return fmt.Sprintf("00-%s-%s-01", sc.traceID, sc.spanID)
+}
+
+// Extract is used to attach the remote span described by a traceparent header to ctx,
+// so spans started with it join the remote trace. Invalid headers are ignored
+func Extract(ctx context.Context, traceParent string) context.Context {
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 || parts[0] != "00" || !validID(parts[1], 32) || !validID(parts[2], 16) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, spanContext{traceID: parts[1], spanID: parts[2]})
+}
+
+// validID is used to check an id is lowercase hex of the given length, and not all zeroes
+func validID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
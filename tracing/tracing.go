@@ -0,0 +1,147 @@
+// Package tracing is used to record spans around our api handlers, queue messages and
+// storage calls, so a slow request can be followed through every service it touches.
+// Trace context is propagated with W3C traceparent headers, and spans are exported in the
+// OpenTelemetry protocol, so any OTLP collector can receive them
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SpanKind describes a span's role in a trace, using the values of the OpenTelemetry protocol
+type SpanKind int
+
+const (
+	// Internal spans are operations within a service
+	Internal SpanKind = 1
+	// Server spans handle requests from remote clients
+	Server SpanKind = 2
+	// Client spans are requests to remote services
+	Client SpanKind = 3
+	// Producer spans publish messages to a queue
+	Producer SpanKind = 4
+	// Consumer spans process messages from a queue
+	Consumer SpanKind = 5
+)
+
+// SpanData is a finished span, as handed to exporters
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	// Error is set when the operation failed
+	Error string
+}
+
+// Span is an operation being traced. A nil span is valid and records nothing,
+// which is what Start returns while tracing is disabled
+type Span struct {
+	mux    sync.Mutex
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// spanContext identifies a span, possibly in another process
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type spanKey struct{}
+
+var (
+	globalMux sync.RWMutex
+	global    *Tracer
+)
+
+// SetTracer is used to set the tracer spans are started with. Tracing is disabled while it is nil
+func SetTracer(t *Tracer) {
+	globalMux.Lock()
+	global = t
+	globalMux.Unlock()
+}
+
+func currentTracer() *Tracer {
+	globalMux.RLock()
+	defer globalMux.RUnlock()
+	return global
+}
+
+// Start is used to start a span which is a child of the span in ctx, or of the
+// remote span extracted into ctx, returning a context carrying the new span
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanID:     newID(8),
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+	if parent, ok := ctx.Value(spanKey{}).(spanContext); ok {
+		span.data.TraceID = parent.traceID
+		span.data.ParentSpanID = parent.spanID
+	} else {
+		span.data.TraceID = newID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span.context()), span
+}
+
+// SetAttribute is used to describe the operation a span records
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	s.data.Attributes[key] = value
+	s.mux.Unlock()
+}
+
+// End is used to finish a span, recording err when the operation failed. Only the first call
+// has any effect. It returns err so calls can be wrapped in place
+func (s *Span) End(err error) error {
+	if s == nil {
+		return err
+	}
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return err
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mux.Unlock()
+	s.tracer.record(data)
+	return err
+}
+
+func (s *Span) context() spanContext {
+	return spanContext{traceID: s.data.TraceID, spanID: s.data.SpanID}
+}
+
+// newID is used to generate a random hex encoded trace or span id of n bytes
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/tracing"
+)
+
+func TestDisabled(t *testing.T) {
+	tracing.SetTracer(nil)
+	ctx, span := tracing.Start(context.Background(), "noop", tracing.Internal)
+	if span != nil {
+		t.Fatal("expected no span while tracing is disabled")
+	}
+	span.SetAttribute("key", "value")
+	failure := errors.New("failed")
+	if err := span.End(failure); err != failure {
+		t.Fatalf("expected error to be returned, got %v", err)
+	}
+	if tracing.Inject(ctx) != "" {
+		t.Fatal("expected no traceparent while tracing is disabled")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	out := &bytes.Buffer{}
+	tracer := tracing.NewTracer("test", tracing.NewStdoutExporter(out))
+	tracer.Start()
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	remote := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.Extract(context.Background(), remote)
+	ctx, parent := tracing.Start(ctx, "parent", tracing.Server)
+	traceParent := tracing.Inject(ctx)
+	if !strings.HasPrefix(traceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Fatalf("expected the remote trace to be joined, got %s", traceParent)
+	}
+	// the child is started as a consumer would, from the propagated header alone
+	_, child := tracing.Start(tracing.Extract(context.Background(), traceParent), "child", tracing.Consumer)
+	child.SetAttribute("attempt", 1)
+	child.End(errors.New("failed"))
+	parent.End(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]tracing.SpanData)
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var span tracing.SpanData
+		if err := decoder.Decode(&span); err != nil {
+			t.Fatal(err)
+		}
+		spans[span.Name] = span
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans to be exported, got %v", len(spans))
+	}
+	if spans["parent"].ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("expected parent of remote span, got %s", spans["parent"].ParentSpanID)
+	}
+	if spans["child"].ParentSpanID != spans["parent"].SpanID || spans["child"].TraceID != spans["parent"].TraceID {
+		t.Fatal("expected child to be linked to parent")
+	}
+	if spans["child"].Error != "failed" {
+		t.Fatalf("expected error to be recorded, got %s", spans["child"].Error)
+	}
+}
+
+func TestExtractInvalid(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if tracing.Inject(tracing.Extract(context.Background(), header)) != "" {
+			t.Fatalf("expected %q to be ignored", header)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+	}))
+	defer server.Close()
+	exporter := tracing.NewOTLPExporter(server.URL, "test")
+	err := exporter.ExportSpans(context.Background(), []tracing.SpanData{{
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Name:       "ipfs pin",
+		Kind:       tracing.Client,
+		Start:      time.Now(),
+		End:        time.Now(),
+		Attributes: map[string]interface{}{"ipfs.hash": "QmHash"},
+		Error:      "timeout",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceSpans, ok := body["resourceSpans"].([]interface{})
+	if !ok || len(resourceSpans) != 1 {
+		t.Fatalf("unexpected request body %v", body)
+	}
+}
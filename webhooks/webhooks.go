@@ -0,0 +1,253 @@
+// Package webhooks is used to sign and deliver event notifications
+// to endpoints registered by Temporal users
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events which users can subscribe their webhooks to
+const (
+	EventPinCompleted     = "pin.completed"
+	EventPinFailed        = "pin.failed"
+	EventUploadCompleted  = "upload.completed"
+	EventIPNSPublished    = "ipns.published"
+	EventPaymentConfirmed = "payment.confirmed"
+	EventContentExpiring  = "content.expiring"
+)
+
+const (
+	// SignatureHeader holds the hmac-sha256 of the request body, keyed with the webhook secret
+	SignatureHeader = "X-Temporal-Signature"
+	// EventHeader holds the type of the event being delivered
+	EventHeader = "X-Temporal-Event"
+	// DeliveryHeader holds the id of the delivery, which is the same across retries
+	DeliveryHeader = "X-Temporal-Delivery"
+	// MaxAttempts is the number of times a delivery is attempted before giving up
+	MaxAttempts = 5
+)
+
+// Events is the list of every event type
+var Events = []string{
+	EventPinCompleted,
+	EventPinFailed,
+	EventUploadCompleted,
+	EventIPNSPublished,
+	EventPaymentConfirmed,
+	EventContentExpiring,
+}
+
+// IsEvent is used to check whether event is a known event type
+func IsEvent(event string) bool {
+	for _, v := range Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the json body posted to webhook endpoints
+type Event struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      map[string]string `json:"data"`
+}
+
+// NewEvent is used to generate a new event with a unique id
+func NewEvent(eventType string, data map[string]string) *Event {
+	return &Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// GenerateSecret is used to generate a random secret for signing webhook payloads
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign is used to generate the signature header value for a payload
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify is used to check a signature header value against a payload, in constant time
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// ValidateURL is used to check that a webhook endpoint is an absolute http(s) url, which doesn't
+// name a loopback, private, link-local or otherwise non-public address. Host names can resolve
+// to anything by the time we deliver to them, so their addresses are checked when dialing
+func ValidateURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("webhook url must be http or https, got %s", u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("webhook url %s has no host", endpoint)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook url %s must not be local", endpoint)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return fmt.Errorf("webhook url %s must not be a non-public address", endpoint)
+	}
+	return nil
+}
+
+// nonPublicNetworks are the address ranges webhooks can't be delivered to, which include our
+// own hosts, private networks and the cloud metadata services at link-local addresses
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // this network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier grade nat
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/4",    // multicast
+		"240.0.0.0/4",    // reserved and broadcast
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+		"64:ff9b::/96",   // ipv4 translation, which could reach any of the above
+		"2002::/16",      // 6to4, likewise
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// IsPublicIP is used to check whether ip is a public unicast address webhooks can be delivered to
+func IsPublicIP(ip net.IP) bool {
+	// ipv4 mapped addresses are checked as the ipv4 address they map to
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic is used to connect to webhook endpoints, refusing hosts which resolve to a
+// non-public address. The address checked is the one dialed, so the host can't resolve
+// to a public address when checked and a private one when connected to
+func dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("webhook host %s has no addresses", host)
+	}
+	for _, a := range addrs {
+		if !IsPublicIP(a.IP) {
+			return nil, fmt.Errorf("webhook host %s resolves to non-public address %s", host, a.IP)
+		}
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// Backoff returns how long to wait before retrying a delivery which has failed attempt times
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return time.Duration(1<<uint(2*(attempt-1))) * time.Second
+}
+
+// Deliverer is used to post events to webhook endpoints
+type Deliverer struct {
+	Client *http.Client
+}
+
+// NewDeliverer is used to generate a deliverer with a sensible request timeout, which only
+// connects to public addresses. Proxies aren't used, since they would connect for us
+func NewDeliverer() *Deliverer {
+	return &Deliverer{Client: &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialPublic,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}}
+}
+
+// Deliver is used to post a signed event to a webhook endpoint. Any non 2xx response is
+// treated as a failure, and the status code is returned alongside the error when available
+func (dl *Deliverer) Deliver(endpoint, secret, deliveryID string, event *Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	resp, err := dl.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint returned status %v", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,94 @@
+package webhooks_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/webhooks"
+)
+
+func TestDeliver(t *testing.T) {
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received webhooks.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !webhooks.Verify(secret, body, r.Header.Get(webhooks.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(webhooks.DeliveryHeader) != "delivery-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = json.Unmarshal(body, &received); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// our deliverer refuses to connect to the test server, as it's on a loopback address
+	event := webhooks.NewEvent(webhooks.EventPinCompleted, nil)
+	if _, err = webhooks.NewDeliverer().Deliver(server.URL, secret, "delivery-1", event); err == nil {
+		t.Fatal("expected error delivering to a loopback address")
+	}
+	dl := &webhooks.Deliverer{Client: server.Client()}
+	event = webhooks.NewEvent(webhooks.EventPinCompleted, map[string]string{"cid": "QmHash"})
+	status, err := dl.Deliver(server.URL, secret, "delivery-1", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if received.ID != event.ID || received.Data["cid"] != "QmHash" {
+		t.Fatal("received event does not match sent event")
+	}
+	if status, err = dl.Deliver(server.URL, "wrongsecret", "delivery-1", event); err == nil {
+		t.Fatal("expected error delivering with wrong secret")
+	}
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %v", status)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://example.com/hook", false},
+		{"http://localhost:8080", true},
+		{"http://api.localhost", true},
+		{"http://127.0.0.1/hook", true},
+		{"http://10.0.0.1/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://[::1]:8080", true},
+		{"http://[fd00:ec2::254]", true},
+		{"http://[::ffff:127.0.0.1]", true},
+		{"https://93.184.216.34/hook", false},
+		{"ftp://example.com", true},
+		{"/relative/path", true},
+		{"https://", true},
+	}
+	for _, tt := range tests {
+		if err := webhooks.ValidateURL(tt.url); (err != nil) != tt.wantErr {
+			t.Fatalf("ValidateURL(%s) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if webhooks.Backoff(1) >= webhooks.Backoff(2) || webhooks.Backoff(2) >= webhooks.Backoff(3) {
+		t.Fatal("backoff should increase with each attempt")
+	}
+}